@@ -0,0 +1,394 @@
+/*
+Package apple1 emulates an Apple-1: a 6502, some RAM, a 6820 PIA
+connecting an ASCII keyboard and a 40x24 uppercase video terminal, and
+a ROM (normally the 256-byte Woz Monitor) at the top of memory.
+
+The PIA's registers appear at $D010-$D013:
+
+	KBD    $D010  keyboard data; bit 7 is always set
+	KBDCR  $D011  keyboard control; bit 7 set when a key is ready
+	DSP    $D012  display data; bit 7 reads as set while busy
+	DSPCR  $D013  display control
+
+The ROM is not included: it is loaded from a user-supplied image.
+*/
+package apple1
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zellyn/go6502/chips"
+	"github.com/zellyn/go6502/cpu"
+)
+
+// PIA register addresses.
+const (
+	KBD   = 0xD010
+	KBDCR = 0xD011
+	DSP   = 0xD012
+	DSPCR = 0xD013
+)
+
+// ClockHz is the Apple-1's cpu clock rate.
+const ClockHz = 1022727
+
+// Screen dimensions.
+const (
+	Columns = 40
+	Rows    = 24
+)
+
+// Config describes a machine's memory, and display speed.
+type Config struct {
+	ROM []byte // ROM image, mapped to end at $FFFF
+	// RAM is the number of bytes of RAM starting at $0000: 4K in a
+	// stock machine, 8K with the second bank populated. The default
+	// is 8K.
+	RAM int
+	// RAME000 adds a 4K bank of RAM at $E000, where Apple-1 BASIC
+	// is loaded.
+	RAME000 bool
+	// DisplayCycles is how long the display stays busy after each
+	// character. The real terminal accepts one character per
+	// frame, about 17000 cycles; zero means it is always ready.
+	DisplayCycles int
+}
+
+// memory is the Apple-1 address space, apart from the PIA. Unpopulated
+// addresses read as $FF, and ignore writes.
+type memory struct {
+	ram      [65536]byte
+	writable [65536 / 256]bool // per page
+	readable [65536 / 256]bool
+}
+
+func (m *memory) Read(address uint16) byte {
+	if !m.readable[address>>8] {
+		return 0xFF
+	}
+	return m.ram[address]
+}
+
+func (m *memory) Write(address uint16, value byte) {
+	if m.writable[address>>8] {
+		m.ram[address] = value
+	}
+}
+
+// Machine is an Apple-1.
+type Machine struct {
+	Cpu    cpu.Cpu
+	PIA    *chips.PIA
+	Screen *Screen
+	Cycles uint64 // cycles run since the machine was created
+
+	mem  memory
+	bus  chips.Bus
+	cfg  Config
+	keys []byte    // typed keys not yet delivered
+	in   chan byte // keys read from an io.Reader
+
+	kbd         byte // keyboard data on port A
+	dspPending  bool // a character has been written to the display
+	dspBusy     int  // cycles until the display is ready again
+	dspBusyFlag bool
+}
+
+// New returns a new machine, with output going to out (which may be
+// nil), and resets it.
+func New(cfg Config, out io.Writer) (*Machine, error) {
+	if cfg.RAM == 0 {
+		cfg.RAM = 0x2000
+	}
+	if cfg.RAM < 0 || cfg.RAM > 0xD000 || cfg.RAM%256 != 0 {
+		return nil, fmt.Errorf("RAM size must be a multiple of 256, up to $D000; got %d", cfg.RAM)
+	}
+	if len(cfg.ROM) == 0 || len(cfg.ROM) > 0x2000 || len(cfg.ROM)%256 != 0 {
+		return nil, fmt.Errorf("ROM size must be a multiple of 256, up to $2000; got %d", len(cfg.ROM))
+	}
+	m := &Machine{cfg: cfg, Screen: NewScreen(out)}
+	for p := 0; p < cfg.RAM/256; p++ {
+		m.mem.readable[p], m.mem.writable[p] = true, true
+	}
+	if cfg.RAME000 {
+		for p := 0xE0; p < 0xF0; p++ {
+			m.mem.readable[p], m.mem.writable[p] = true, true
+		}
+	}
+	romStart := 0x10000 - len(cfg.ROM)
+	copy(m.mem.ram[romStart:], cfg.ROM)
+	for p := romStart / 256; p < 0x100; p++ {
+		m.mem.readable[p], m.mem.writable[p] = true, false
+	}
+
+	m.PIA = chips.NewPIA(keyboardPins{m}, displayPins{m})
+	m.PIA.CB2 = func(level bool) {
+		// Writing DSP in handshake mode takes CB2 (the display's
+		// "data available" input) low.
+		if !level {
+			m.dspPending = true
+		}
+	}
+	m.bus.RAM = &m.mem
+	m.bus.Map(KBD, DSPCR, m.PIA)
+	m.Cpu = cpu.NewCPU(&m.bus, m.tick, cpu.VERSION_6502)
+	m.Reset()
+	return m, nil
+}
+
+// Memory returns the machine's address space, as seen by the cpu.
+func (m *Machine) Memory() cpu.Memory {
+	return &m.bus
+}
+
+// Load copies data into memory at addr, as if typed into the monitor.
+// Only RAM is written.
+func (m *Machine) Load(addr uint16, data []byte) {
+	for i, b := range data {
+		m.mem.Write(addr+uint16(i), b)
+	}
+}
+
+// Reset presses the RESET button: the cpu and PIA are reset, but
+// memory and the screen are untouched.
+func (m *Machine) Reset() {
+	m.PIA.Reset()
+	m.Cpu.Reset()
+}
+
+// ClearScreen presses the CLEAR SCREEN button.
+func (m *Machine) ClearScreen() {
+	m.Screen.Clear()
+}
+
+// keyboardPins connects the keyboard to port A.
+type keyboardPins struct{ m *Machine }
+
+func (k keyboardPins) Input() byte            { return k.m.kbd }
+func (k keyboardPins) Output(value, ddr byte) {}
+
+// displayPins connects the display to port B: bit 7 is the display's
+// busy signal; bits 0-6 are the character.
+type displayPins struct{ m *Machine }
+
+func (d displayPins) Input() byte {
+	if d.m.dspBusyFlag {
+		return 0x80
+	}
+	return 0
+}
+func (d displayPins) Output(value, ddr byte) {}
+
+// Key converts a byte from a modern keyboard to what the Apple-1
+// keyboard would send: uppercase, with bit 7 set, RETURN for newline,
+// and underscore (the Woz Monitor's rubout) for backspace. It returns
+// false for keys the Apple-1 can't type.
+func Key(b byte) (byte, bool) {
+	switch {
+	case b == '\n' || b == '\r':
+		b = 0x0D
+	case b == 0x08 || b == 0x7F:
+		b = '_'
+	case b >= 'a' && b <= 'z':
+		b -= 0x20
+	case b >= 0x80:
+		return 0, false
+	}
+	return b | 0x80, true
+}
+
+// Type queues keys to be typed, in order, each as soon as the last has
+// been read. Keys are converted with Key.
+func (m *Machine) Type(s string) {
+	for i := 0; i < len(s); i++ {
+		if k, ok := Key(s[i]); ok {
+			m.keys = append(m.keys, k)
+		}
+	}
+}
+
+// TypeFrom types keys read from r, as they arrive. Reading happens in
+// a separate goroutine.
+func (m *Machine) TypeFrom(r io.Reader) {
+	ch := make(chan byte, 64)
+	m.in = ch
+	go func() {
+		var b [1]byte
+		for {
+			n, err := r.Read(b[:])
+			if n > 0 {
+				ch <- b[0]
+			}
+			if err != nil {
+				close(ch)
+				return
+			}
+		}
+	}()
+}
+
+// tick is called by the cpu once per cycle.
+func (m *Machine) tick() {
+	m.Cycles++
+	m.tickKeyboard()
+	m.tickDisplay()
+}
+
+func (m *Machine) tickKeyboard() {
+	if m.in != nil && len(m.keys) == 0 {
+		select {
+		case b, ok := <-m.in:
+			if !ok {
+				m.in = nil
+			} else {
+				m.Type(string(b))
+			}
+		default:
+		}
+	}
+	if len(m.keys) == 0 || m.PIA.Peek(KBDCR)&chips.PIA_IRQ1 != 0 {
+		return
+	}
+	// The keyboard strobe is connected to CA1.
+	m.kbd = m.keys[0]
+	m.keys = m.keys[1:]
+	m.PIA.SetCA1(false)
+	m.PIA.SetCA1(true)
+}
+
+func (m *Machine) tickDisplay() {
+	if m.dspBusy > 0 {
+		m.dspBusy--
+		if m.dspBusy == 0 {
+			m.displayReady()
+		}
+		return
+	}
+	if !m.dspPending {
+		return
+	}
+	m.dspPending = false
+	m.Screen.Put(m.PIA.Peek(DSP) & 0x7F)
+	m.dspBusyFlag = true
+	m.dspBusy = m.cfg.DisplayCycles
+	if m.dspBusy == 0 {
+		m.displayReady()
+	}
+}
+
+// displayReady acknowledges a character on CB1, as the terminal does
+// once it has displayed it.
+func (m *Machine) displayReady() {
+	m.dspBusyFlag = false
+	m.PIA.SetCB1(false)
+	m.PIA.SetCB1(true)
+}
+
+// Step runs one instruction.
+func (m *Machine) Step() error {
+	return m.Cpu.Step()
+}
+
+// Run runs instructions until at least cycles more cycles have
+// passed, or there is an error.
+func (m *Machine) Run(cycles uint64) error {
+	end := m.Cycles + cycles
+	for m.Cycles < end {
+		if err := m.Cpu.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Screen is the Apple-1's video terminal: 24 lines of 40 uppercase
+// characters. The cursor is always at the end of the output; the
+// terminal can't move it except by writing a character or a carriage
+// return, and when it reaches the bottom, the screen scrolls.
+//
+// Everything displayed is also copied to an io.Writer, as lines of
+// text.
+type Screen struct {
+	out      io.Writer
+	lines    [Rows][Columns]byte
+	row, col int
+}
+
+// NewScreen returns a cleared screen that copies its output to out,
+// which may be nil.
+func NewScreen(out io.Writer) *Screen {
+	s := &Screen{out: out}
+	s.Clear()
+	return s
+}
+
+// Clear blanks the screen, and homes the cursor.
+func (s *Screen) Clear() {
+	for r := range s.lines {
+		for c := range s.lines[r] {
+			s.lines[r][c] = ' '
+		}
+	}
+	s.row, s.col = 0, 0
+}
+
+// Cursor returns the cursor position.
+func (s *Screen) Cursor() (row, col int) {
+	return s.row, s.col
+}
+
+func (s *Screen) write(b byte) {
+	if s.out != nil {
+		s.out.Write([]byte{b})
+	}
+}
+
+// newline moves the cursor to the start of the next line, scrolling
+// if necessary.
+func (s *Screen) newline() {
+	s.write('\n')
+	s.col = 0
+	if s.row < Rows-1 {
+		s.row++
+		return
+	}
+	copy(s.lines[:], s.lines[1:])
+	for c := range s.lines[Rows-1] {
+		s.lines[Rows-1][c] = ' '
+	}
+}
+
+// Put displays a 7-bit character. Carriage return starts a new line;
+// other control characters are ignored. The character generator has
+// only 64 glyphs, so lowercase displays as uppercase. Writing past
+// the 40th column wraps to the next line.
+func (s *Screen) Put(b byte) {
+	b &= 0x7F
+	switch {
+	case b == 0x0D:
+		s.newline()
+		return
+	case b < 0x20 || b == 0x7F:
+		return
+	case b >= 0x60:
+		b -= 0x20
+	}
+	s.lines[s.row][s.col] = b
+	s.write(b)
+	s.col++
+	if s.col == Columns {
+		s.newline()
+	}
+}
+
+// String returns the screen contents, as lines with trailing spaces
+// removed.
+func (s *Screen) String() string {
+	ls := make([]string, Rows)
+	for r := range s.lines {
+		ls[r] = strings.TrimRight(string(s.lines[r][:]), " ")
+	}
+	return strings.Join(ls, "\n")
+}
@@ -0,0 +1,117 @@
+package apple1
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm/asmtest"
+)
+
+// echoROM is a tiny stand-in for the Woz Monitor: it sets up the PIA
+// the same way, then echoes keys to the display, printing a prompt
+// after each RETURN. Its I/O routines match the monitor's.
+const echoROM = `
+KBD      .EQ $D010
+KBDCR    .EQ $D011
+DSP      .EQ $D012
+DSPCR    .EQ $D013
+         .OR $FF00
+RESET    CLD
+         CLI
+         LDY #$7F
+         STY DSP
+         LDA #$A7
+         STA KBDCR
+         STA DSPCR
+PROMPT   LDA #$DC      BACKSLASH
+         JSR ECHO
+NEXTCHAR LDA KBDCR
+         BPL NEXTCHAR
+         LDA KBD
+         JSR ECHO
+         CMP #$8D
+         BEQ PROMPT
+         BNE NEXTCHAR
+ECHO     BIT DSP
+         BMI ECHO
+         STA DSP
+         RTS
+         .OR $FFFA
+         .DA $0F00,RESET,$0000
+`
+
+func TestEcho(t *testing.T) {
+	rom := asmtest.Image(t, asmtest.Assemble(t, echoROM), 0xFF00, 256)
+	for _, cycles := range []int{0, ClockHz / 60} {
+		var out bytes.Buffer
+		m, err := New(Config{ROM: rom, DisplayCycles: cycles}, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Type("hello\nworld")
+		if err := m.Run(ClockHz); err != nil {
+			t.Fatal(err)
+		}
+		want := "\\HELLO\n\\WORLD"
+		if got := out.String(); got != want {
+			t.Errorf("DisplayCycles=%d: want output %q; got %q", cycles, want, got)
+		}
+		if got := m.Screen.String(); !strings.HasPrefix(got, want+"\n\n") {
+			t.Errorf("DisplayCycles=%d: want screen to start %q; got %q", cycles, want, got)
+		}
+		if row, col := m.Screen.Cursor(); row != 1 || col != 6 {
+			t.Errorf("DisplayCycles=%d: want cursor at 1,6; got %d,%d", cycles, row, col)
+		}
+	}
+}
+
+func TestScreen(t *testing.T) {
+	var out bytes.Buffer
+	s := NewScreen(&out)
+	for i := 0; i < 41; i++ {
+		s.Put('A' + byte(i%26))
+	}
+	if row, col := s.Cursor(); row != 1 || col != 1 {
+		t.Errorf("want the 41st character to wrap to 1,1; got %d,%d", row, col)
+	}
+	s.Put(0x07) // ignored
+	s.Put('z')  // displayed as uppercase
+	if got, want := strings.Split(s.String(), "\n")[1], "OZ"; got != want {
+		t.Errorf("want second line %q; got %q", want, got)
+	}
+	for i := 0; i < Rows; i++ {
+		s.Put('\r')
+	}
+	if row, _ := s.Cursor(); row != Rows-1 {
+		t.Errorf("want cursor on the last row; got %d", row)
+	}
+	if got := s.String(); strings.TrimSpace(got) != "" {
+		t.Errorf("want everything scrolled off; got %q", got)
+	}
+	if !strings.HasPrefix(out.String(), strings.Repeat("ABCDEFGHIJKLMNOPQRSTUVWXYZ", 2)[:40]+"\nOZ\n") {
+		t.Errorf("unexpected output stream %q", out.String())
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m, err := New(Config{ROM: make([]byte, 256), RAM: 0x1000}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := m.Memory()
+	for _, tt := range []struct {
+		addr     uint16
+		writable bool
+	}{
+		{0x0000, true}, {0x0FFF, true}, {0x1000, false}, {0xE000, false}, {0xFF00, false},
+	} {
+		mem.Write(tt.addr, 0x42)
+		if got := mem.Read(tt.addr) == 0x42; got != tt.writable {
+			t.Errorf("$%04X: want writable=%v", tt.addr, tt.writable)
+		}
+	}
+	if _, err := New(Config{ROM: make([]byte, 100)}, nil); err == nil {
+		t.Error("want an error for an odd-sized ROM")
+	}
+}
@@ -0,0 +1,120 @@
+// apple1 runs an Apple-1, with the keyboard on stdin and the display on
+// stdout. When stdin is a terminal, it is put into unbuffered mode
+// (with stty) so keys go straight to the machine; press Ctrl-C to
+// quit.
+//
+// A typical session, with the Woz Monitor ROM, and a program
+// assembled with a2as to load at $0280:
+//
+//	apple1 -rom wozmon.bin -load 0280:prog.bin -keys '280R\n'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zellyn/go6502/apple1"
+)
+
+var romfile = flag.String("rom", "", "ROM image, mapped to end at $FFFF (required)")
+var ram = flag.Int("ram", 8, "kilobytes of RAM from $0000")
+var rame000 = flag.Bool("e000", false, "add 4K of RAM at $E000, for Apple-1 BASIC")
+var loads = flag.String("load", "", "comma-separated files to load into RAM, as hexaddr:filename")
+var keys = flag.String("keys", "", `keys to type at startup; \n is RETURN`)
+var speed = flag.Float64("speed", 1, "speed relative to a real Apple-1; 0 means as fast as possible")
+var slowDisplay = flag.Bool("slow", false, "display characters at the real terminal's 60 per second")
+
+func die(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// rawTerminal puts stdin into unbuffered, non-echoing mode, if it is a
+// terminal, and returns a function that restores it.
+func rawTerminal() func() {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return func() {}
+	}
+	stty := func(args ...string) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		cmd.Run()
+	}
+	stty("-icanon", "-echo", "min", "1")
+	return func() { stty("sane") }
+}
+
+func main() {
+	flag.Parse()
+	if *romfile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	rom, err := ioutil.ReadFile(*romfile)
+	if err != nil {
+		die("%v", err)
+	}
+	cfg := apple1.Config{ROM: rom, RAM: *ram * 1024, RAME000: *rame000}
+	if *slowDisplay {
+		cfg.DisplayCycles = apple1.ClockHz / 60
+	}
+	m, err := apple1.New(cfg, os.Stdout)
+	if err != nil {
+		die("%v", err)
+	}
+	if *loads != "" {
+		for _, l := range strings.Split(*loads, ",") {
+			parts := strings.SplitN(l, ":", 2)
+			if len(parts) != 2 {
+				die("bad -load %q: want hexaddr:filename", l)
+			}
+			addr, err := strconv.ParseUint(strings.TrimPrefix(parts[0], "$"), 16, 16)
+			if err != nil {
+				die("bad -load address %q: %v", parts[0], err)
+			}
+			data, err := ioutil.ReadFile(parts[1])
+			if err != nil {
+				die("%v", err)
+			}
+			m.Load(uint16(addr), data)
+		}
+	}
+	m.Type(strings.Replace(*keys, `\n`, "\n", -1))
+
+	restore := rawTerminal()
+	defer restore()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	m.TypeFrom(os.Stdin)
+
+	// Run in slices of 1/100th of a second, sleeping to keep to the
+	// requested speed.
+	const slice = apple1.ClockHz / 100
+	start := time.Now()
+	for ran := uint64(0); ; ran += slice {
+		select {
+		case <-interrupt:
+			fmt.Println()
+			return
+		default:
+		}
+		if err := m.Run(slice); err != nil {
+			restore()
+			die("\n%v", err)
+		}
+		if *speed > 0 {
+			want := time.Duration(float64(ran+slice) / (apple1.ClockHz * *speed) * float64(time.Second))
+			if d := want - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+		}
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Package apple2 emulates an Apple II or II+: a 6502, 48K of RAM, the
+system ROMs, the keyboard, speaker, and video soft switches, and seven
+peripheral slots. It also provides the Apple IIe's bank-switched
+memory, as an MMU.
+
+The ROMs are not included: they are loaded from user-supplied images.
+*/
+package apple2
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zellyn/go6502/cpu"
+)
+
+// ClockHz is the Apple II's (average) cpu clock rate.
+const ClockHz = 1020484
+
+// Soft switch and I/O addresses.
+const (
+	KBD      = 0xC000 // keyboard data; bit 7 is the strobe
+	KBDSTRB  = 0xC010 // clear the keyboard strobe
+	TAPEOUT  = 0xC020 // toggle the cassette output
+	SPKR     = 0xC030 // toggle the speaker
+	STROBE   = 0xC040 // utility strobe
+	TXTCLR   = 0xC050 // graphics
+	TXTSET   = 0xC051 // text
+	MIXCLR   = 0xC052 // full-screen graphics
+	MIXSET   = 0xC053 // four lines of text below graphics
+	LOWSCR   = 0xC054 // display page 1
+	HISCR    = 0xC055 // display page 2
+	LORES    = 0xC056 // lores graphics
+	HIRES    = 0xC057 // hires graphics
+	SETAN0   = 0xC058 // annunciators: $C058-$C05F clear/set AN0-AN3
+	TAPEIN   = 0xC060 // cassette input, in bit 7
+	PB0      = 0xC061 // pushbuttons: $C061-$C063
+	PADDL0   = 0xC064 // paddle timers: $C064-$C067
+	PTRIG    = 0xC070 // start the paddle timers
+	SLOTIO   = 0xC080 // slot n's I/O is at SLOTIO+n*16
+	SLOTROM  = 0xC100 // slot n's ROM is at SLOTROM-$100+n*$100
+	EXPROM   = 0xC800 // shared expansion ROM space
+	EXPROMEN = 0xCFFF // access disables expansion ROMs
+)
+
+// Mode is the set of video soft switches.
+type Mode struct {
+	Text  bool // TXTSET
+	Mixed bool // MIXSET
+	Page2 bool // HISCR
+	Hires bool // HIRES
+}
+
+// Card is a peripheral card in a slot.
+type Card interface {
+	// IO handles an access to one of the card's sixteen I/O
+	// locations, $C080+slot*16+reg. For reads, write is false and
+	// value is ignored.
+	IO(reg byte, value byte, write bool) byte
+	// ROM returns the card's 256-byte $Cn00 ROM, or nil.
+	ROM() []byte
+}
+
+// Ticker is implemented by cards that need to be told about every
+// cycle.
+type Ticker interface {
+	Tick()
+}
+
+// ROMDevice is implemented by cards, like the Mockingboard, that
+// decode accesses to their $Cn00-$CnFF page themselves, instead of
+// providing a ROM there.
+type ROMDevice interface {
+	// ROMIO handles an access to the page.
+	ROMIO(offset byte, value byte, write bool) byte
+	// ROMPeek reads the page without side effects.
+	ROMPeek(offset byte) byte
+}
+
+// Config describes a machine.
+type Config struct {
+	// ROM is the system ROM: normally 12K, for $D000-$FFFF. Images
+	// are mapped to end at $FFFF; 16K images include the $C000
+	// page, which is ignored.
+	ROM []byte
+	// Slots holds the cards in slots 1-7; index 0 is unused.
+	Slots [8]Card
+}
+
+// Machine is an Apple II+.
+type Machine struct {
+	Cpu    cpu.Cpu
+	Cycles uint64 // cycles run since the machine was created
+	Mode   Mode
+	// Annunciators are the four annunciator outputs.
+	Annunciators [4]bool
+	// Buttons are the three pushbutton inputs.
+	Buttons [3]bool
+	// Paddles are the four paddle positions, 0-255.
+	Paddles [4]byte
+	// TapeIn is the level on the cassette input.
+	TapeIn bool
+	// TapeOut is the level on the cassette output.
+	TapeOut bool
+	// Speaker records the cycles at which the speaker was toggled,
+	// until taken with TakeSpeaker.
+	Speaker []uint64
+
+	ram        [0xC000]byte
+	rom        [0x3000]byte
+	slots      [8]Card
+	tickers    []Ticker
+	expSlot    int // slot whose expansion ROM is selected, or 0
+	kbd        byte
+	keys       []byte
+	in         chan byte
+	paddleTrig uint64 // cycle of the last PTRIG access
+}
+
+// New returns a new machine, and resets it.
+func New(cfg Config) (*Machine, error) {
+	rom := cfg.ROM
+	if len(rom) == 0x4000 {
+		rom = rom[0x1000:]
+	}
+	if len(rom) == 0 || len(rom) > 0x3000 || len(rom)%0x800 != 0 {
+		return nil, fmt.Errorf("ROM size must be a multiple of 2K, up to 12K (or 16K); got %d", len(cfg.ROM))
+	}
+	if cfg.Slots[0] != nil {
+		return nil, errors.New("slot 0 is not supported")
+	}
+	m := &Machine{slots: cfg.Slots}
+	copy(m.rom[0x3000-len(rom):], rom)
+	for _, c := range m.slots {
+		if t, ok := c.(Ticker); ok {
+			m.tickers = append(m.tickers, t)
+		}
+	}
+	m.Mode = Mode{Text: true}
+	m.Cpu = cpu.NewCPU(m, m.tick, cpu.VERSION_6502)
+	m.Reset()
+	return m, nil
+}
+
+// Reset presses RESET. Memory and soft switches are unchanged.
+func (m *Machine) Reset() {
+	m.expSlot = 0
+	m.Cpu.Reset()
+}
+
+// Load copies data into RAM at addr.
+func (m *Machine) Load(addr uint16, data []byte) {
+	copy(m.ram[addr:], data)
+}
+
+// tick is called by the cpu once per cycle.
+func (m *Machine) tick() {
+	m.Cycles++
+	m.tickKeyboard()
+	for _, t := range m.tickers {
+		t.Tick()
+	}
+}
+
+// TakeSpeaker returns the recorded speaker toggles, and forgets them.
+func (m *Machine) TakeSpeaker() []uint64 {
+	s := m.Speaker
+	m.Speaker = nil
+	return s
+}
+
+// Read reads memory. Satisfies cpu.Memory.
+func (m *Machine) Read(address uint16) byte {
+	return m.access(address, 0, false)
+}
+
+// Write writes memory. Satisfies cpu.Memory.
+func (m *Machine) Write(address uint16, value byte) {
+	m.access(address, value, true)
+}
+
+// Peek reads memory without side effects. Satisfies cpu.Peeker. Soft
+// switches read as zero, apart from the keyboard.
+func (m *Machine) Peek(address uint16) byte {
+	switch {
+	case address < 0xC000:
+		return m.ram[address]
+	case address >= 0xD000:
+		return m.rom[address-0xD000]
+	case address < KBDSTRB:
+		return m.kbd
+	case address >= SLOTROM:
+		return m.romByte(address)
+	}
+	return 0
+}
+
+// romByte returns a byte of slot or expansion ROM.
+func (m *Machine) romByte(address uint16) byte {
+	if address < EXPROM {
+		if c := m.slots[address>>8&7]; c != nil {
+			if d, ok := c.(ROMDevice); ok {
+				return d.ROMPeek(byte(address))
+			}
+			if rom := c.ROM(); len(rom) > int(address&0xFF) {
+				return rom[address&0xFF]
+			}
+		}
+		return 0
+	}
+	if e, ok := m.slots[m.expSlot].(interface{ ExpansionROM() []byte }); ok {
+		if rom := e.ExpansionROM(); len(rom) > int(address-EXPROM) {
+			return rom[address-EXPROM]
+		}
+	}
+	return 0
+}
+
+// access performs a read or write.
+func (m *Machine) access(address uint16, value byte, write bool) byte {
+	switch {
+	case address < 0xC000:
+		if write {
+			m.ram[address] = value
+		}
+		return m.ram[address]
+	case address >= 0xD000:
+		return m.rom[address-0xD000]
+	case address >= SLOTROM:
+		if address < EXPROM {
+			// Accessing a slot's ROM selects its expansion ROM.
+			m.expSlot = int(address >> 8 & 7)
+			if d, ok := m.slots[m.expSlot].(ROMDevice); ok {
+				return d.ROMIO(byte(address), value, write)
+			}
+		} else if address == EXPROMEN {
+			m.expSlot = 0
+			return 0
+		}
+		return m.romByte(address)
+	case address >= SLOTIO:
+		slot := address >> 4 & 7
+		if c := m.slots[slot]; c != nil {
+			return c.IO(byte(address&0xF), value, write)
+		}
+		return 0
+	}
+	return m.softSwitch(address, value, write)
+}
+
+// softSwitch handles accesses to $C000-$C07F.
+func (m *Machine) softSwitch(address uint16, value byte, write bool) byte {
+	switch address & 0xFFF0 {
+	case KBD:
+		return m.kbd
+	case KBDSTRB:
+		m.kbd &^= 0x80
+		return m.kbd
+	case TAPEOUT:
+		m.TapeOut = !m.TapeOut
+	case SPKR:
+		m.Speaker = append(m.Speaker, m.Cycles)
+	case TXTCLR:
+		on := address&1 != 0
+		switch address & 0xF {
+		case 0x0, 0x1:
+			m.Mode.Text = on
+		case 0x2, 0x3:
+			m.Mode.Mixed = on
+		case 0x4, 0x5:
+			m.Mode.Page2 = on
+		case 0x6, 0x7:
+			m.Mode.Hires = on
+		default:
+			m.Annunciators[(address-SETAN0)>>1] = on
+		}
+	case TAPEIN:
+		switch a := address & 7; {
+		case a == 0:
+			return bit7(m.TapeIn)
+		case a < 4:
+			return bit7(m.Buttons[a-1])
+		default:
+			return bit7(m.paddleTimer(int(a - 4)))
+		}
+	case PTRIG:
+		m.paddleTrig = m.Cycles
+	}
+	return 0
+}
+
+func bit7(b bool) byte {
+	if b {
+		return 0x80
+	}
+	return 0
+}
+
+// paddleTimer returns true if paddle p's timer is still running: it
+// runs for about 11 cycles per unit of paddle position after PTRIG.
+func (m *Machine) paddleTimer(p int) bool {
+	return m.Cycles-m.paddleTrig < uint64(m.Paddles[p])*11
+}
+
+// Step runs one instruction.
+func (m *Machine) Step() error {
+	return m.Cpu.Step()
+}
+
+// Run runs instructions until at least cycles more cycles have
+// passed, or there is an error.
+func (m *Machine) Run(cycles uint64) error {
+	end := m.Cycles + cycles
+	for m.Cycles < end {
+		if err := m.Cpu.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
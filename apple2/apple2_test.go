@@ -0,0 +1,231 @@
+package apple2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm/asmtest"
+)
+
+// testROM is a tiny 2K stand-in for the monitor ROM: it clears the
+// screen, shows "OK" in inverse and a flashing "!", then echoes typed
+// keys to the second row (and the third, after RETURN), clicking the
+// speaker for each one.
+const testROM = `
+KBD      .EQ $C000
+KBDSTRB  .EQ $C010
+SPKR     .EQ $C030
+TXTSET   .EQ $C051
+BASL     .EQ $28
+         .OR $F800
+RESET    LDA TXTSET
+         LDA #$A0
+         LDX #0
+.1       STA $0400,X
+         STA $0500,X
+         STA $0600,X
+         STA $0700,X
+         INX
+         BNE .1
+         LDA #$0F      INVERSE O
+         STA $0400
+         LDA #$0B      INVERSE K
+         STA $0401
+         LDA #$61      FLASHING !
+         STA $0403
+         LDA #$80
+         STA BASL
+         LDA #$04
+         STA BASL+1
+         LDY #0
+LOOP     LDA KBD
+         BPL LOOP
+         STA KBDSTRB
+         BIT SPKR
+         CMP #$8D
+         BEQ .1
+         STA (BASL),Y
+         INY
+         BNE LOOP
+.1       LDA #$00      ROW 2 IS AT $0500
+         STA BASL
+         LDA #$05
+         STA BASL+1
+         LDY #0
+         BEQ LOOP
+         .OR $FFFA
+         .DA RESET,RESET,RESET
+`
+
+// testROMImage assembles testROM.
+func testROMImage(t *testing.T) []byte {
+	return asmtest.Image(t, asmtest.Assemble(t, testROM), 0xF800, 0x800)
+}
+
+func newTestMachine(t *testing.T) *Machine {
+	m, err := New(Config{ROM: testROMImage(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestHeadless(t *testing.T) {
+	m := newTestMachine(t)
+	screen, err := m.RunKeys("hello\nworld", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(screen, "\n")
+	if len(lines) != TextRows {
+		t.Fatalf("want %d lines; got %d", TextRows, len(lines))
+	}
+	for i, want := range []string{"OK !", "HELLO", "WORLD", ""} {
+		if lines[i] != want {
+			t.Errorf("line %d: want %q; got %q", i, want, lines[i])
+		}
+	}
+	if got := len(m.TakeSpeaker()); got != 11 {
+		t.Errorf("want 11 speaker clicks; got %d", got)
+	}
+	if got := len(m.TakeSpeaker()); got != 0 {
+		t.Errorf("want TakeSpeaker to forget clicks; got %d", got)
+	}
+}
+
+func TestRenderANSI(t *testing.T) {
+	m := newTestMachine(t)
+	if err := m.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+	for _, flash := range []bool{false, true} {
+		for m.FlashOn() != flash {
+			m.Run(10000)
+		}
+		var buf bytes.Buffer
+		m.RenderANSI(&buf)
+		first := strings.SplitN(buf.String(), "\r\n", 2)[0]
+		want := ansiHome + ansiInverse + "OK" + ansiNormal + " !"
+		if flash {
+			want = ansiHome + ansiInverse + "OK" + ansiNormal + " " + ansiInverse + "!" + ansiNormal
+		}
+		want += strings.Repeat(" ", TextColumns-4)
+		if first != want {
+			t.Errorf("flash=%v: want first line %q; got %q", flash, want, first)
+		}
+	}
+}
+
+func TestSoftSwitches(t *testing.T) {
+	m := newTestMachine(t)
+	if err := m.Run(100000); err != nil {
+		t.Fatal(err)
+	}
+	m.Read(TXTCLR)
+	m.Read(MIXSET)
+	m.Read(HIRES)
+	m.Write(HISCR, 0)
+	if got, want := m.Mode.String(), "HIRES MIXED PAGE2"; got != want {
+		t.Errorf("want mode %q; got %q", want, got)
+	}
+	m.Read(LOWSCR)
+	if got := m.Text(); !strings.HasPrefix(got, strings.Repeat("\n", TextRows-4)) {
+		t.Errorf("want only the bottom four rows in mixed mode; got %q", got)
+	}
+	m.Read(SETAN0 + 3)
+	if !m.Annunciators[1] {
+		t.Error("want $C05B to set AN1")
+	}
+	m.Buttons[1] = true
+	if m.Read(PB0+1)&0x80 == 0 {
+		t.Error("want button 1 pressed")
+	}
+	m.Paddles[0] = 10
+	m.Read(PTRIG)
+	if m.Read(PADDL0)&0x80 == 0 {
+		t.Error("want paddle timer running after PTRIG")
+	}
+	m.Run(200)
+	if m.Read(PADDL0)&0x80 != 0 {
+		t.Error("want paddle timer to have expired")
+	}
+}
+
+func TestTextRowAddr(t *testing.T) {
+	for _, tt := range []struct {
+		page2 bool
+		row   int
+		want  uint16
+	}{
+		{false, 0, 0x0400}, {false, 1, 0x0480}, {false, 8, 0x0428},
+		{false, 23, 0x07D0}, {true, 0, 0x0800}, {true, 16, 0x0850},
+	} {
+		if got := TextRowAddr(tt.page2, tt.row); got != tt.want {
+			t.Errorf("TextRowAddr(%v, %d): want $%04X; got $%04X", tt.page2, tt.row, tt.want, got)
+		}
+	}
+}
+
+// card is a test peripheral card.
+type card struct {
+	regs [16]byte
+	rom  []byte
+	exp  []byte
+}
+
+func (c *card) IO(reg byte, value byte, write bool) byte {
+	if write {
+		c.regs[reg] = value
+	}
+	return c.regs[reg]
+}
+func (c *card) ROM() []byte          { return c.rom }
+func (c *card) ExpansionROM() []byte { return c.exp }
+
+// romCard is a test card with registers in its $Cn00 page.
+type romCard struct {
+	card
+	page [256]byte
+}
+
+func (c *romCard) ROMIO(offset byte, value byte, write bool) byte {
+	if write {
+		c.page[offset] = value
+	}
+	return c.page[offset]
+}
+func (c *romCard) ROMPeek(offset byte) byte { return c.page[offset] }
+
+func TestSlots(t *testing.T) {
+	c := &card{rom: bytes.Repeat([]byte{0x33}, 256), exp: bytes.Repeat([]byte{0x44}, 2048)}
+	rc := &romCard{}
+	m, err := New(Config{ROM: testROMImage(t), Slots: [8]Card{3: c, 4: rc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Write(SLOTIO+3*16+5, 0x99)
+	if c.regs[5] != 0x99 || m.Read(SLOTIO+3*16+5) != 0x99 {
+		t.Error("want slot 3 register 5 to be $99")
+	}
+	if m.Read(SLOTIO+4*16+5) != 0 {
+		t.Error("want empty slot 4 to read 0")
+	}
+	if m.Read(EXPROM) != 0 {
+		t.Error("want expansion ROM deselected after reset")
+	}
+	if m.Read(0xC300) != 0x33 {
+		t.Error("want slot 3 ROM at $C300")
+	}
+	if m.Read(EXPROM) != 0x44 {
+		t.Error("want slot 3 expansion ROM selected")
+	}
+	m.Read(EXPROMEN)
+	if m.Read(EXPROM) != 0 {
+		t.Error("want expansion ROM deselected by $CFFF")
+	}
+	m.Write(0xC481, 0x55)
+	if rc.page[0x81] != 0x55 || m.Read(0xC481) != 0x55 || m.Peek(0xC481) != 0x55 {
+		t.Error("want slot 4 page register $81 to be $55")
+	}
+}
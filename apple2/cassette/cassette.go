@@ -0,0 +1,244 @@
+/*
+Package cassette converts data to and from the Apple II's cassette tape
+format, as audio.
+
+A tape holds records, each written by one call to the Monitor's WRITE
+routine (an Applesoft SAVE writes two: the program's length, then the
+program). A record is:
+
+  - a header tone of 770Hz, for ten seconds, to let the tape get up to
+    speed and the levels settle
+  - a sync bit: a 200µs half cycle, then a 250µs one
+  - the data bytes, most significant bit first: a 0 bit is one cycle
+    of 2kHz, and a 1 bit one cycle of 1kHz
+  - a checksum byte: $FF, exclusive-ored with each data byte
+
+Reading, like the Apple II, only looks at when the signal crosses
+zero, so it copes with recordings of real tapes, at any volume.
+*/
+package cassette
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/zellyn/go6502/asm/membuf"
+	"github.com/zellyn/go6502/wav"
+)
+
+// Half-cycle lengths, in microseconds.
+const (
+	halfHeader = 650 // 770Hz
+	halfSync1  = 200
+	halfSync2  = 250
+	halfZero   = 250 // 2kHz
+	halfOne    = 500 // 1kHz
+)
+
+const (
+	// HeaderSeconds is how long the header tone before each record
+	// lasts.
+	HeaderSeconds = 10
+	// Rate is the default sample rate.
+	Rate = 44100
+	// amplitude is the level of the square wave written.
+	amplitude = 0x6000
+	// tailCycles is how many cycles of header tone follow each record,
+	// so the last bit ends cleanly.
+	tailCycles = 16
+	// gapSeconds is the silence between records.
+	gapSeconds = 0.5
+)
+
+// Thresholds for reading, in microseconds. The Apple II's own are
+// similar: the ROM times whole cycles, and calls anything shorter
+// than about 750µs a 0 bit.
+const (
+	minHeader   = 500  // shortest header half cycle
+	maxHeader   = 900  // longest header half cycle
+	maxSync     = 400  // longest half cycle seen as the sync bit
+	maxZero     = 750  // longest cycle seen as a 0 bit
+	maxOne      = 1150 // longest cycle seen as a 1 bit
+	headerCount = 64   // how many header half cycles make a header
+)
+
+// ErrChecksum is returned when a record's checksum is wrong.
+var ErrChecksum = errors.New("cassette checksum mismatch")
+
+// ErrNoData is returned when a recording holds no records.
+var ErrNoData = errors.New("no cassette data found")
+
+// writer builds a square wave from half cycles.
+type writer struct {
+	rate    int
+	samples []int16
+	t       float64 // time written so far, in microseconds
+	high    bool
+}
+
+// half writes a half cycle of the given length, in microseconds.
+func (w *writer) half(us float64) {
+	w.t += us
+	end := int(math.Floor(w.t*float64(w.rate)/1e6 + 0.5))
+	level := int16(-amplitude)
+	if w.high {
+		level = amplitude
+	}
+	for len(w.samples) < end {
+		w.samples = append(w.samples, level)
+	}
+	w.high = !w.high
+}
+
+// silence writes silence of the given length, in microseconds.
+func (w *writer) silence(us float64) {
+	w.t += us
+	end := int(math.Floor(w.t*float64(w.rate)/1e6 + 0.5))
+	for len(w.samples) < end {
+		w.samples = append(w.samples, 0)
+	}
+}
+
+// byte writes a byte, most significant bit first.
+func (w *writer) byte(b byte) {
+	for i := uint(0); i < 8; i++ {
+		us := float64(halfZero)
+		if b&(0x80>>i) != 0 {
+			us = halfOne
+		}
+		w.half(us)
+		w.half(us)
+	}
+}
+
+// Encode returns the audio for a tape holding the given records, at
+// the given sample rate.
+func Encode(rate int, records ...[]byte) wav.Sound {
+	w := &writer{rate: rate}
+	for i, data := range records {
+		if i > 0 {
+			w.silence(gapSeconds * 1e6)
+		}
+		for n := 0; n < HeaderSeconds*1000000/halfHeader; n++ {
+			w.half(halfHeader)
+		}
+		w.half(halfSync1)
+		w.half(halfSync2)
+		sum := byte(0xFF)
+		for _, b := range data {
+			w.byte(b)
+			sum ^= b
+		}
+		w.byte(sum)
+		for n := 0; n < 2*tailCycles; n++ {
+			w.half(halfHeader)
+		}
+	}
+	return wav.Sound{Rate: rate, Samples: w.samples}
+}
+
+// halves returns the lengths, in microseconds, of the half cycles in a
+// recording: the times between zero crossings. The signal's average
+// is taken as zero, and it must swing a quarter of the way to its peak
+// on the other side to count as a crossing, so noise is ignored.
+func halves(s wav.Sound) []float64 {
+	if len(s.Samples) == 0 || s.Rate == 0 {
+		return nil
+	}
+	var sum float64
+	for _, v := range s.Samples {
+		sum += float64(v)
+	}
+	mean := sum / float64(len(s.Samples))
+	var peak float64
+	for _, v := range s.Samples {
+		peak = math.Max(peak, math.Abs(float64(v)-mean))
+	}
+	if peak == 0 {
+		return nil
+	}
+	hysteresis := peak / 4
+
+	var result []float64
+	var last float64 = -1 // time of the last crossing, in samples
+	high := float64(s.Samples[0])-mean > 0
+	for i, v := range s.Samples {
+		x := float64(v) - mean
+		if (high && x < -hysteresis) || (!high && x > hysteresis) {
+			high = !high
+			if last >= 0 {
+				result = append(result, (float64(i)-last)*1e6/float64(s.Rate))
+			}
+			last = float64(i)
+		}
+	}
+	return result
+}
+
+// Decode returns the records on a tape, without their checksums. If
+// there is an error, the records read before it are returned too.
+func Decode(s wav.Sound) ([][]byte, error) {
+	h := halves(s)
+	var records [][]byte
+	count := 0 // header half cycles seen in a row
+	for i := 0; i < len(h); i++ {
+		if h[i] >= minHeader && h[i] <= maxHeader {
+			count++
+			continue
+		}
+		if count < headerCount || h[i] > maxSync {
+			count = 0
+			continue
+		}
+		// A sync bit: skip its second half, and read the data.
+		count = 0
+		data, next := readBits(h, i+2)
+		i = next - 1
+		if len(data) == 0 {
+			continue
+		}
+		sum := byte(0xFF)
+		for _, b := range data {
+			sum ^= b
+		}
+		if sum != 0 {
+			return records, fmt.Errorf("record %d: %v", len(records)+1, ErrChecksum)
+		}
+		records = append(records, data[:len(data)-1])
+	}
+	if len(records) == 0 {
+		return nil, ErrNoData
+	}
+	return records, nil
+}
+
+// readBits reads bytes from half cycles, starting at h[i], until it
+// finds a cycle too long to be a bit. It returns the bytes, and the
+// index of the first half cycle not used.
+func readBits(h []float64, i int) ([]byte, int) {
+	var data []byte
+	var b byte
+	bits := 0
+	for ; i+1 < len(h); i += 2 {
+		cycle := h[i] + h[i+1]
+		if cycle > maxOne {
+			break
+		}
+		b <<= 1
+		if cycle > maxZero {
+			b |= 1
+		}
+		if bits++; bits == 8 {
+			data = append(data, b)
+			b, bits = 0, 0
+		}
+	}
+	return data, i
+}
+
+// Command returns the Monitor command to read a piece of memory from
+// tape, eg. "800.8FFR".
+func Command(p membuf.Piece) string {
+	return fmt.Sprintf("%X.%XR", p.Addr, p.Addr+uint32(len(p.Data))-1)
+}
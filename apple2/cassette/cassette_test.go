@@ -0,0 +1,108 @@
+package cassette
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/zellyn/go6502/asm/membuf"
+	"github.com/zellyn/go6502/wav"
+)
+
+// testData returns n bytes of random data.
+func testData(n int) []byte {
+	r := rand.New(rand.NewSource(int64(n)))
+	data := make([]byte, n)
+	r.Read(data)
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	records := [][]byte{{0x12, 0x34, 0x56}, testData(300)}
+	for _, rate := range []int{8000, 22050, Rate, 48000} {
+		s := Encode(rate, records...)
+		got, err := Decode(s)
+		if err != nil {
+			t.Errorf("%dHz: %v", rate, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, records) {
+			t.Errorf("%dHz: want %d records %x; got %d: %x", rate, len(records), records, len(got), got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	s := Encode(Rate, []byte{0x00})
+	// Header, sync, eight 0 bits and the checksum's eight 1 bits, and
+	// the tail.
+	us := HeaderSeconds*1000000/halfHeader*halfHeader + halfSync1 + halfSync2 + 16*halfZero + 16*halfOne + 2*tailCycles*halfHeader
+	if want := int(math.Floor(float64(us)*Rate/1e6 + 0.5)); len(s.Samples) != want {
+		t.Errorf("want %d samples; got %d", want, len(s.Samples))
+	}
+	if d := s.Duration(); d < HeaderSeconds-0.01 || d > HeaderSeconds+0.1 {
+		t.Errorf("want about %ds of audio; got %gs", HeaderSeconds, d)
+	}
+}
+
+func TestDecodeRecording(t *testing.T) {
+	// Something more like a real recording: quiet, inverted, off
+	// center, noisy, and through a WAV file.
+	data := testData(100)
+	s := Encode(Rate, data)
+	r := rand.New(rand.NewSource(1))
+	for i, v := range s.Samples {
+		s.Samples[i] = int16(-int(v)/8 + 1000 + r.Intn(400) - 200)
+	}
+	var buf bytes.Buffer
+	if err := wav.Write(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	s, err := wav.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !bytes.Equal(got[0], data) {
+		t.Errorf("want %x; got %x", data, got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := Decode(wav.Sound{Rate: Rate, Samples: make([]int16, Rate)}); err != ErrNoData {
+		t.Errorf("silence: want ErrNoData; got %v", err)
+	}
+
+	// A good record, then one with a bad checksum.
+	s := Encode(Rate, []byte{1})
+	w := &writer{rate: Rate, samples: s.Samples, t: float64(len(s.Samples)) * 1e6 / Rate}
+	for n := 0; n < 2*headerCount; n++ {
+		w.half(halfHeader)
+	}
+	w.half(halfSync1)
+	w.half(halfSync2)
+	w.byte(2)
+	w.byte(0xFF)
+	w.half(halfHeader)
+	w.half(halfHeader)
+	s.Samples = w.samples
+	got, err := Decode(s)
+	if err == nil {
+		t.Fatal("want checksum error")
+	}
+	if want := [][]byte{{1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want first record %x; got %x", want, got)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	p := membuf.Piece{Addr: 0x800, Data: make([]byte, 0x100)}
+	if got, want := Command(p), "800.8FFR"; got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+}
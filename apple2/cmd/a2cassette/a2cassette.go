@@ -0,0 +1,98 @@
+// a2cassette converts binaries, such as those assembled by a2as, to
+// Apple II cassette tape audio in a WAV file, to be played into a real
+// machine's cassette input. Given -addr, it prints the Monitor command
+// that reads the tape back into memory.
+//
+// With -decode, it goes the other way, writing the data from each
+// record on a tape recording to the -out file, or to -out.1, -out.2
+// and so on when there is more than one.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zellyn/go6502/apple2/cassette"
+	"github.com/zellyn/go6502/asm/membuf"
+	"github.com/zellyn/go6502/wav"
+)
+
+var infile = flag.String("in", "", "input file: a binary, or with -decode, a WAV file")
+var outfile = flag.String("out", "", "output file: a WAV file, or with -decode, a binary")
+var decode = flag.Bool("decode", false, "decode a WAV file to binary")
+var rate = flag.Int("rate", cassette.Rate, "sample rate of the WAV file written")
+var addr = flag.String("addr", "", "hex load address, to print the Monitor command to read the tape")
+
+func die(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Parse()
+	if *infile == "" || *outfile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *decode {
+		decodeFile()
+	} else {
+		encodeFile()
+	}
+}
+
+func encodeFile() {
+	data, err := ioutil.ReadFile(*infile)
+	if err != nil {
+		die("%v", err)
+	}
+	if len(data) == 0 {
+		die("%s is empty", *infile)
+	}
+	out, err := os.Create(*outfile)
+	if err != nil {
+		die("%v", err)
+	}
+	if err := wav.Write(out, cassette.Encode(*rate, data)); err != nil {
+		die("%v", err)
+	}
+	if err := out.Close(); err != nil {
+		die("%v", err)
+	}
+	if *addr != "" {
+		a, err := strconv.ParseUint(strings.TrimPrefix(*addr, "$"), 16, 16)
+		if err != nil {
+			die("bad -addr %q: %v", *addr, err)
+		}
+		fmt.Println(cassette.Command(membuf.Piece{Addr: uint32(a), Data: data}))
+	}
+}
+
+func decodeFile() {
+	in, err := os.Open(*infile)
+	if err != nil {
+		die("%v", err)
+	}
+	s, err := wav.Read(in)
+	in.Close()
+	if err != nil {
+		die("%s: %v", *infile, err)
+	}
+	records, err := cassette.Decode(s)
+	for i, data := range records {
+		filename := *outfile
+		if len(records) > 1 {
+			filename = fmt.Sprintf("%s.%d", *outfile, i+1)
+		}
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			die("%v", err)
+		}
+	}
+	if err != nil {
+		die("%s: %v", *infile, err)
+	}
+}
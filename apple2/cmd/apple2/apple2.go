@@ -0,0 +1,243 @@
+// apple2 runs an Apple II+, with the keyboard on stdin and the text
+// screen drawn on the terminal. When stdin is a terminal, it is put
+// into unbuffered mode (with stty) so keys go straight to the machine;
+// press Ctrl-C to quit.
+//
+// With -diskrom, a Disk II controller is put in slot 6, with the
+// -disk1 and -disk2 images in its drives. Changed images are written
+// back when apple2 exits.
+//
+// With -mockingboard, a Mockingboard sound card is put in slot 4.
+//
+// With -headless, it types the -keys, runs until they have been read
+// (and -settle more cycles), then prints the text screen and exits. With
+// -png, it also writes the screen, in color, to a PNG file, and with
+// -wav, everything the speaker and Mockingboard played to a WAV file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zellyn/go6502/apple2"
+	"github.com/zellyn/go6502/apple2/diskii"
+	"github.com/zellyn/go6502/apple2/mockingboard"
+	"github.com/zellyn/go6502/apple2/sound"
+	"github.com/zellyn/go6502/apple2/video"
+	"github.com/zellyn/go6502/wav"
+)
+
+var romfile = flag.String("rom", "", "system ROM image, mapped to end at $FFFF (required)")
+var loads = flag.String("load", "", "comma-separated files to load into RAM, as hexaddr:filename")
+var keys = flag.String("keys", "", `keys to type at startup; \n is RETURN`)
+var speed = flag.Float64("speed", 1, "speed relative to a real Apple II; 0 means as fast as possible")
+var diskrom = flag.String("diskrom", "", "Disk II boot ROM image, for a controller in slot 6")
+var disk1 = flag.String("disk1", "", "disk image (.dsk, .do, or .po) for drive 1")
+var disk2 = flag.String("disk2", "", "disk image (.dsk, .do, or .po) for drive 2")
+var mbFlag = flag.Bool("mockingboard", false, "put a Mockingboard in slot 4")
+var headless = flag.Bool("headless", false, "type -keys, print the screen, and exit")
+var settle = flag.Uint64("settle", apple2.ClockHz, "headless: cycles to run after the keys are read")
+var pngfile = flag.String("png", "", "headless: also write the screen to this PNG file")
+var wavfile = flag.String("wav", "", "headless: also write the sound to this WAV file")
+
+func die(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// rawTerminal puts stdin into unbuffered, non-echoing mode, if it is a
+// terminal, and returns a function that restores it.
+func rawTerminal() func() {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return func() {}
+	}
+	stty := func(args ...string) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		cmd.Run()
+	}
+	stty("-icanon", "-echo", "min", "1")
+	return func() { stty("sane") }
+}
+
+// loadDisks returns a Disk II controller with the -disk1 and -disk2
+// images inserted.
+func loadDisks() *diskii.Card {
+	rom, err := ioutil.ReadFile(*diskrom)
+	if err != nil {
+		die("%v", err)
+	}
+	c, err := diskii.NewCard(rom)
+	if err != nil {
+		die("%v", err)
+	}
+	for i, filename := range []string{*disk1, *disk2} {
+		if filename == "" {
+			continue
+		}
+		d, err := diskii.Load(filename)
+		if err != nil {
+			die("%v", err)
+		}
+		c.Insert(i, d)
+	}
+	return c
+}
+
+// saveDisks writes back changed disk images.
+func saveDisks(c *diskii.Card) {
+	if c == nil {
+		return
+	}
+	for i, filename := range []string{*disk1, *disk2} {
+		d := c.Drives[i].Disk
+		if d == nil || !d.Changed() {
+			continue
+		}
+		if err := d.Save(filename); err != nil {
+			fmt.Fprintf(os.Stderr, "saving %s: %v\n", filename, err)
+		}
+	}
+}
+
+// writePNG writes the screen to the -png file.
+func writePNG(m *apple2.Machine) error {
+	f, err := os.Create(*pngfile)
+	if err != nil {
+		return err
+	}
+	if err := video.WritePNG(f, video.FromMachine(m), video.MONITOR_COLOR); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeWAV writes the sound since the machine started to the -wav
+// file.
+func writeWAV(m *apple2.Machine, mb *mockingboard.Card) error {
+	samples := sound.NewSpeaker(sound.Rate, 0).Render(m.TakeSpeaker(), m.Cycles)
+	if mb != nil {
+		samples = sound.Mix(samples, mb.TakeSamples())
+	}
+	f, err := os.Create(*wavfile)
+	if err != nil {
+		return err
+	}
+	if err := wav.Write(f, wav.Sound{Rate: sound.Rate, Samples: samples}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	flag.Parse()
+	if *romfile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	rom, err := ioutil.ReadFile(*romfile)
+	if err != nil {
+		die("%v", err)
+	}
+	cfg := apple2.Config{ROM: rom}
+	var disks *diskii.Card
+	if *diskrom != "" {
+		disks = loadDisks()
+		cfg.Slots[6] = disks
+	}
+	var mb *mockingboard.Card
+	if *mbFlag {
+		mb = mockingboard.New(sound.Rate)
+		cfg.Slots[4] = mb
+	}
+	m, err := apple2.New(cfg)
+	if err != nil {
+		die("%v", err)
+	}
+	if mb != nil {
+		mb.IRQ = m.Cpu.SetIRQ
+	}
+	defer saveDisks(disks)
+	if *loads != "" {
+		for _, l := range strings.Split(*loads, ",") {
+			parts := strings.SplitN(l, ":", 2)
+			if len(parts) != 2 {
+				die("bad -load %q: want hexaddr:filename", l)
+			}
+			addr, err := strconv.ParseUint(strings.TrimPrefix(parts[0], "$"), 16, 16)
+			if err != nil {
+				die("bad -load address %q: %v", parts[0], err)
+			}
+			data, err := ioutil.ReadFile(parts[1])
+			if err != nil {
+				die("%v", err)
+			}
+			m.Load(uint16(addr), data)
+		}
+	}
+	typed := strings.Replace(*keys, `\n`, "\n", -1)
+
+	if *headless {
+		screen, err := m.RunKeys(typed, *settle)
+		fmt.Println(screen)
+		if err == nil && *pngfile != "" {
+			err = writePNG(m)
+		}
+		if err == nil && *wavfile != "" {
+			err = writeWAV(m, mb)
+		}
+		if err != nil {
+			saveDisks(disks)
+			die("%v", err)
+		}
+		return
+	}
+
+	m.Type(typed)
+	restore := rawTerminal()
+	defer restore()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	m.TypeFrom(os.Stdin)
+	fmt.Print("\x1b[2J\x1b[?25l") // clear, and hide the cursor
+	defer fmt.Print("\x1b[?25h")
+
+	// Run in slices of 1/30th of a second, redrawing the screen after
+	// each, and sleeping to keep to the requested speed.
+	const slice = apple2.ClockHz / 30
+	start := time.Now()
+	for ran := uint64(0); ; ran += slice {
+		select {
+		case <-interrupt:
+			fmt.Println()
+			return
+		default:
+		}
+		if err := m.Run(slice); err != nil {
+			restore()
+			saveDisks(disks)
+			die("\n%v", err)
+		}
+		m.TakeSpeaker()
+		if mb != nil {
+			mb.TakeSamples()
+		}
+		m.RenderANSI(os.Stdout)
+		if *speed > 0 {
+			want := time.Duration(float64(ran+slice) / (apple2.ClockHz * *speed) * float64(time.Second))
+			if d := want - time.Since(start); d > 0 {
+				time.Sleep(d)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package diskii
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Disk geometry.
+const (
+	Tracks          = 35
+	SectorsPerTrack = 16
+	SectorSize      = 256
+	ImageSize       = Tracks * SectorsPerTrack * SectorSize
+)
+
+// Order is the order sectors are stored in an image file.
+type Order int
+
+const (
+	ORDER_DOS    Order = iota // .dsk, .do: DOS 3.3 logical sectors
+	ORDER_PRODOS              // .po: ProDOS blocks
+)
+
+// Physical sector n holds these logical sectors, in each order.
+var (
+	dosOrder    = [SectorsPerTrack]int{0, 7, 14, 6, 13, 5, 12, 4, 11, 3, 10, 2, 9, 1, 8, 15}
+	prodosOrder = [SectorsPerTrack]int{0, 8, 1, 9, 2, 10, 3, 11, 4, 12, 5, 13, 6, 14, 7, 15}
+)
+
+// OrderFor returns the sector order implied by a filename's extension:
+// ProDOS order for .po, DOS order otherwise.
+func OrderFor(filename string) Order {
+	if strings.EqualFold(filepath.Ext(filename), ".po") {
+		return ORDER_PRODOS
+	}
+	return ORDER_DOS
+}
+
+// Disk is a 140K 5.25" floppy, held as a sector image. Tracks are
+// nibblized when the head reaches them, and decoded back into the
+// image when they have been written and the head moves on, or on
+// Flush.
+type Disk struct {
+	Image          []byte // sector image, in Order
+	Order          Order
+	WriteProtected bool
+
+	track   int    // track currently nibblized, or -1
+	nibbles []byte // its nibbles
+	dirty   bool   // nibbles have been written
+	err     error  // first error decoding a written track
+	changed bool   // Image has been changed since loading
+}
+
+// NewDisk returns a disk holding a 143360-byte sector image.
+func NewDisk(image []byte, order Order) (*Disk, error) {
+	if len(image) != ImageSize {
+		return nil, fmt.Errorf("disk image must be %d bytes; got %d", ImageSize, len(image))
+	}
+	return &Disk{Image: image, Order: order, track: -1}, nil
+}
+
+// Load reads a .dsk, .do, or .po image file.
+func Load(filename string) (*Disk, error) {
+	image, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewDisk(image, OrderFor(filename))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return d, nil
+}
+
+// Save flushes the disk, and writes its image to a file.
+func (d *Disk) Save(filename string) error {
+	if err := d.Flush(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, d.Image, 0666)
+}
+
+// Changed returns true if the image has been written to since it was
+// loaded. Tracks not yet flushed count.
+func (d *Disk) Changed() bool {
+	return d.changed || d.dirty
+}
+
+// sectorOffset returns the offset in Image of a physical sector.
+func (d *Disk) sectorOffset(track, physical int) int {
+	logical := dosOrder[physical]
+	if d.Order == ORDER_PRODOS {
+		logical = prodosOrder[physical]
+	}
+	return (track*SectorsPerTrack + logical) * SectorSize
+}
+
+// Track returns the nibbles of a track, nibblizing it if necessary.
+// The returned slice is the disk's own: writes to it are flushed back
+// to the image.
+func (d *Disk) Track(track int) []byte {
+	if track == d.track {
+		return d.nibbles
+	}
+	d.flushTrack()
+	var sectors [SectorsPerTrack][]byte
+	for s := range sectors {
+		off := d.sectorOffset(track, s)
+		sectors[s] = d.Image[off : off+SectorSize]
+	}
+	d.track = track
+	d.nibbles = nibblizeTrack(track, sectors)
+	return d.nibbles
+}
+
+// flushTrack decodes the current track back into the image, if it
+// has been written. Errors are remembered for Flush.
+func (d *Disk) flushTrack() {
+	if !d.dirty {
+		return
+	}
+	d.dirty = false
+	sectors, err := denibblizeTrack(d.track, d.nibbles)
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+	for s, data := range sectors {
+		if data == nil {
+			if d.err == nil {
+				d.err = fmt.Errorf("track %d: sector %d not found after writing", d.track, s)
+			}
+			continue
+		}
+		off := d.sectorOffset(d.track, s)
+		copy(d.Image[off:off+SectorSize], data)
+	}
+	d.changed = true
+}
+
+// Flush decodes any written track back into the image. It returns the
+// first error found decoding written tracks since the last Flush: a
+// sector that couldn't be found or decoded keeps its old contents.
+func (d *Disk) Flush() error {
+	d.flushTrack()
+	err := d.err
+	d.err = nil
+	return err
+}
+
+// write writes a nibble to a track.
+func (d *Disk) write(track, pos int, b byte) {
+	nibbles := d.Track(track)
+	nibbles[pos%len(nibbles)] = b
+	d.dirty = true
+}
@@ -0,0 +1,212 @@
+/*
+Package diskii emulates the Disk II floppy controller card, normally in
+slot 6, with two drives.
+
+The drives hold sector images (.dsk, .do, or .po files). Each track is
+converted to the nibble stream the real drive would read, with
+6-and-2 encoded sectors, when the head reaches it. Nibbles pass under
+the head every 32 cycles while the motor is on, so timing-dependent
+code, like the RWTS routines in DOS 3.3 and ProDOS, works unchanged.
+Tracks that are written are decoded back into the image.
+
+The card's 256-byte boot ROM is not included: it is loaded from a
+user-supplied image.
+*/
+package diskii
+
+import "fmt"
+
+// Controller registers: offsets from $C080+slot*16.
+const (
+	PHASE0OFF = 0x0 // stepper phases: PHASE0OFF+2*n turns phase n off,
+	PHASE0ON  = 0x1 // and PHASE0ON+2*n turns it on
+	MOTOROFF  = 0x8
+	MOTORON   = 0x9
+	DRIVE1    = 0xA
+	DRIVE2    = 0xB
+	Q6L       = 0xC // read data latch
+	Q6H       = 0xD // sense write protect; load the data latch
+	Q7L       = 0xE // read mode
+	Q7H       = 0xF // write mode
+)
+
+const (
+	// CyclesPerNibble is how long each nibble takes to pass under
+	// the head: 8 bits at 4 microseconds each.
+	CyclesPerNibble = 32
+	// motorOffCycles is how long the motor keeps turning after it is
+	// switched off: about a second.
+	motorOffCycles = 1000000
+	// maxHalfTrack is the highest half-track the head can reach.
+	maxHalfTrack = (Tracks - 1) * 2
+)
+
+// Drive is a disk drive.
+type Drive struct {
+	Disk      *Disk // nil if empty
+	halfTrack int   // head position
+	phases    [4]bool
+	pos       int // nibble under the head
+}
+
+// Track returns the track the head is on.
+func (d *Drive) Track() int {
+	return d.halfTrack / 2
+}
+
+// Card is a Disk II controller card. It satisfies apple2.Card and
+// apple2.Ticker.
+type Card struct {
+	Drives [2]Drive
+
+	rom      []byte
+	drive    int  // selected drive
+	motor    bool // motor switched on
+	spinning int  // cycles until the motor stops, once switched off
+	q6, q7   bool
+	latch    byte
+	fresh    bool // the latch holds a nibble that hasn't been read
+	cycles   int  // cycles into the current nibble
+}
+
+// NewCard returns a controller with the given 256-byte boot ROM.
+func NewCard(rom []byte) (*Card, error) {
+	if len(rom) != 256 {
+		return nil, fmt.Errorf("Disk II boot ROM must be 256 bytes; got %d", len(rom))
+	}
+	return &Card{rom: rom}, nil
+}
+
+// Insert puts a disk into a drive (0 or 1), or removes it if d is nil.
+// The disk being removed is flushed, and any error returned.
+func (c *Card) Insert(drive int, d *Disk) error {
+	var err error
+	if old := c.Drives[drive].Disk; old != nil {
+		err = old.Flush()
+	}
+	c.Drives[drive].Disk = d
+	return err
+}
+
+// ROM returns the boot ROM. Satisfies apple2.Card.
+func (c *Card) ROM() []byte {
+	return c.rom
+}
+
+// Spinning returns true if the selected drive's motor is turning.
+func (c *Card) Spinning() bool {
+	return c.motor || c.spinning > 0
+}
+
+// IO handles an access to a controller register. Satisfies
+// apple2.Card.
+func (c *Card) IO(reg byte, value byte, write bool) byte {
+	d := &c.Drives[c.drive]
+	switch {
+	case reg < MOTOROFF:
+		d.setPhase(int(reg>>1), reg&1 != 0)
+	case reg == MOTOROFF:
+		if c.motor {
+			c.motor = false
+			c.spinning = motorOffCycles
+		}
+	case reg == MOTORON:
+		c.motor = true
+	case reg == DRIVE1, reg == DRIVE2:
+		c.drive = int(reg & 1)
+	case reg == Q6L, reg == Q6H:
+		c.q6 = reg&1 != 0
+	default:
+		c.q7 = reg&1 != 0
+	}
+
+	switch {
+	case c.q7 && c.q6 && write:
+		// Load the data latch, to be written.
+		c.latch = value
+	case !c.q7 && c.q6:
+		// Sense write protect: it appears in bit 7.
+		c.latch = 0
+		if d.Disk == nil || d.Disk.WriteProtected {
+			c.latch = 0x80
+		}
+	}
+	if reg&1 != 0 || write {
+		return 0
+	}
+	// Reading any even register returns the data latch. In read mode,
+	// a nibble is only seen once: after that, bit 7 reads as clear
+	// until the next one arrives.
+	value = c.latch
+	if !c.q7 && !c.q6 {
+		if !c.fresh {
+			value &= 0x7F
+		}
+		c.fresh = false
+	}
+	return value
+}
+
+// setPhase turns a stepper motor phase on or off. The head moves a
+// half-track towards a newly energized phase adjacent to the one it is
+// lined up with.
+func (d *Drive) setPhase(phase int, on bool) {
+	d.phases[phase] = on
+	if !on {
+		return
+	}
+	switch phase {
+	case (d.halfTrack + 1) % 4:
+		if d.halfTrack < maxHalfTrack {
+			d.halfTrack++
+		}
+	case (d.halfTrack + 3) % 4:
+		if d.halfTrack > 0 {
+			d.halfTrack--
+		}
+	}
+}
+
+// Tick advances the disk by one cycle. Satisfies apple2.Ticker.
+func (c *Card) Tick() {
+	if !c.motor {
+		if c.spinning == 0 {
+			return
+		}
+		c.spinning--
+	}
+	c.cycles++
+	if c.cycles < CyclesPerNibble {
+		return
+	}
+	c.cycles = 0
+	d := &c.Drives[c.drive]
+	if d.Disk == nil {
+		return
+	}
+	track := d.Track()
+	d.pos = (d.pos + 1) % len(d.Disk.Track(track))
+	if c.q7 {
+		if !d.Disk.WriteProtected {
+			d.Disk.write(track, d.pos, c.latch)
+		}
+		return
+	}
+	if !c.q6 {
+		c.latch = d.Disk.Track(track)[d.pos]
+		c.fresh = true
+	}
+}
+
+// Flush flushes both drives' disks, returning the first error.
+func (c *Card) Flush() error {
+	var err error
+	for _, d := range c.Drives {
+		if d.Disk != nil {
+			if e := d.Disk.Flush(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,369 @@
+package diskii
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/zellyn/go6502/apple2"
+	"github.com/zellyn/go6502/asm/asmtest"
+)
+
+func randomImage(seed int64) []byte {
+	image := make([]byte, ImageSize)
+	rand.New(rand.NewSource(seed)).Read(image)
+	return image
+}
+
+func TestSectorEncoding(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		data := make([]byte, 256)
+		rng.Read(data)
+		nibbles := encodeSector(data)
+		if len(nibbles) != 343 {
+			t.Fatalf("want 343 nibbles; got %d", len(nibbles))
+		}
+		got, err := decodeSector(nibbles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("round trip failed:\n%x\n%x", data, got)
+		}
+	}
+	// A known value: all zeros encode as $96 throughout.
+	for i, n := range encodeSector(make([]byte, 256)) {
+		if n != 0x96 {
+			t.Fatalf("nibble %d: want $96; got $%02X", i, n)
+		}
+	}
+	// Change one nibble to another valid one.
+	nibbles := encodeSector(bytes.Repeat([]byte{0x55}, 256))
+	nibbles[100] = encode62[(decode62[nibbles[100]]+1)%64]
+	if _, err := decodeSector(nibbles); err == nil {
+		t.Error("want a checksum error")
+	}
+}
+
+func TestTrackEncoding(t *testing.T) {
+	d, err := NewDisk(randomImage(2), ORDER_PRODOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nibbles := d.Track(17)
+	if len(nibbles) != TrackNibbles {
+		t.Errorf("want %d nibbles; got %d", TrackNibbles, len(nibbles))
+	}
+	// Rotate the track, so fields wrap around the end.
+	rotated := append(append([]byte(nil), nibbles[1000:]...), nibbles[:1000]...)
+	sectors, err := denibblizeTrack(17, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s, data := range sectors {
+		off := d.sectorOffset(17, s)
+		if !bytes.Equal(data, d.Image[off:off+SectorSize]) {
+			t.Errorf("sector %d doesn't match", s)
+		}
+	}
+	// Physical sector 2, on track 0, is the second half of ProDOS block 0.
+	if got := d.sectorOffset(0, 2); got != 1*SectorSize {
+		t.Errorf("want physical sector 2 at offset $100; got $%X", got)
+	}
+}
+
+// drive runs the controller one cycle at a time, as a cpu would.
+type drive struct {
+	t *testing.T
+	c *Card
+}
+
+func (d drive) ticks(n int) {
+	for i := 0; i < n; i++ {
+		d.c.Tick()
+	}
+}
+
+// readNibble polls the data latch until a nibble arrives.
+func (d drive) readNibble() byte {
+	for i := 0; i < 10*CyclesPerNibble; i++ {
+		d.c.Tick()
+		if b := d.c.IO(Q6L, 0, false); b&0x80 != 0 {
+			return b
+		}
+	}
+	d.t.Fatal("no nibble read")
+	return 0
+}
+
+// findAddress reads until it has just read the address field of the
+// given sector, returning its track number.
+func (d drive) findAddress(sector int) int {
+	var last [14]byte
+	for i := 0; i < 3*TrackNibbles; i++ {
+		copy(last[:], last[1:])
+		last[13] = d.readNibble()
+		if last[0] == 0xD5 && last[1] == 0xAA && last[2] == 0x96 && last[13] == 0xEB &&
+			int(decode44(last[7], last[8])) == sector {
+			return int(decode44(last[5], last[6]))
+		}
+	}
+	d.t.Fatalf("sector %d not found", sector)
+	return 0
+}
+
+func newTestCard(t *testing.T, disk *Disk) drive {
+	c, err := NewCard(make([]byte, 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Insert(0, disk)
+	c.IO(MOTORON, 0, false)
+	c.IO(DRIVE1, 0, false)
+	c.IO(Q7L, 0, false)
+	return drive{t, c}
+}
+
+func TestReadAndSeek(t *testing.T) {
+	disk, err := NewDisk(randomImage(3), ORDER_DOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestCard(t, disk)
+	if got := d.findAddress(0); got != 0 {
+		t.Errorf("want track 0; got %d", got)
+	}
+	// Step out to track 2, the way RWTS does: turn each next phase
+	// on, then the previous one off.
+	for phase := 1; phase <= 4; phase++ {
+		d.c.IO(byte(PHASE0ON+2*(phase%4)), 0, false)
+		d.ticks(1000)
+		d.c.IO(byte(PHASE0OFF+2*((phase-1)%4)), 0, false)
+	}
+	if got := d.findAddress(7); got != 2 {
+		t.Errorf("want track 2; got %d", got)
+	}
+	// Read sector 7's data field.
+	for d.readNibble() != 0xD5 {
+	}
+	if d.readNibble() != 0xAA || d.readNibble() != 0xAD {
+		t.Fatal("want data prologue")
+	}
+	nibbles := make([]byte, 343)
+	for i := range nibbles {
+		nibbles[i] = d.readNibble()
+	}
+	data, err := decodeSector(nibbles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := (2*SectorsPerTrack + dosOrder[7]) * SectorSize
+	if !bytes.Equal(data, disk.Image[off:off+SectorSize]) {
+		t.Error("sector data doesn't match the image")
+	}
+	// Stepping back past track 0 stops there.
+	for phase := 3; phase >= -8; phase-- {
+		d.c.IO(byte(PHASE0ON+2*((phase+8)%4)), 0, false)
+		d.c.IO(byte(PHASE0OFF+2*((phase+9)%4)), 0, false)
+	}
+	if got := d.c.Drives[0].Track(); got != 0 {
+		t.Errorf("want head at track 0; got %d", got)
+	}
+	if disk.Changed() {
+		t.Error("want disk unchanged by reading")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	image := randomImage(4)
+	disk, err := NewDisk(append([]byte(nil), image...), ORDER_DOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestCard(t, disk)
+	if d.c.IO(Q6H, 0, false); d.c.IO(Q7L, 0, false)&0x80 != 0 {
+		t.Fatal("want disk not write-protected")
+	}
+	d.c.IO(Q6L, 0, false)
+	d.findAddress(5)
+
+	// Write a new data field, loading the latch every 32 cycles.
+	newData := bytes.Repeat([]byte("HELLO, WORLD! "), 20)[:256]
+	field := append([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xD5, 0xAA, 0xAD}, encodeSector(newData)...)
+	field = append(field, 0xDE, 0xAA, 0xEB, 0xFF)
+	d.c.IO(Q6H, 0, false)
+	d.c.IO(Q7H, field[0], true)
+	for _, b := range field[1:] {
+		d.c.IO(Q6L, 0, false)
+		d.ticks(CyclesPerNibble - 8)
+		d.c.IO(Q6H, b, true)
+		d.ticks(8)
+	}
+	d.ticks(CyclesPerNibble)
+	d.c.IO(Q7L, 0, false)
+	d.c.IO(Q6L, 0, false)
+
+	if !disk.Changed() {
+		t.Error("want disk changed")
+	}
+	if err := d.c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	off := 5 * SectorSize
+	if !bytes.Equal(disk.Image[off:off+SectorSize], newData) {
+		t.Error("want new sector data written back")
+	}
+	copy(image[off:], newData)
+	if !bytes.Equal(disk.Image, image) {
+		t.Error("want the rest of the image unchanged")
+	}
+
+	// Write-protected disks aren't written.
+	disk.WriteProtected = true
+	if d.c.IO(Q6H, 0, false); d.c.IO(Q7L, 0, false)&0x80 == 0 {
+		t.Error("want disk write-protected")
+	}
+}
+
+// bootROM is a boot ROM for a controller in slot 6, after the real
+// one: it reads track 0, sector 0 into $0800-$08FF, and jumps to
+// $0801. It doesn't seek, or retry after checksum errors.
+const bootROM = `
+MOTORON  .EQ $C0E9
+DRIVE1   .EQ $C0EA
+Q6L      .EQ $C0EC
+Q7L      .EQ $C0EE
+DECODE   .EQ $0300    DISK BYTE-$96 TO SIX BITS
+TWOS     .EQ $0380    LOW TWO BITS OF EACH BYTE
+TMP      .EQ $26
+         .OR $C600
+BOOT     LDA MOTORON
+         LDA DRIVE1
+         LDA Q7L
+         LDA Q6L
+         LDY #63
+.1       LDX ENCODE,Y
+         TYA
+         STA DECODE-$96,X
+         DEY
+         BPL .1
+* FIND THE ADDRESS FIELD OF SECTOR 0
+FIND     LDX Q6L
+         BPL FIND
+.2       CPX #$D5
+         BNE FIND
+.3       LDX Q6L
+         BPL .3
+         CPX #$AA
+         BNE .2
+.4       LDX Q6L
+         BPL .4
+         CPX #$96
+         BNE .2
+         LDY #4       SKIP VOLUME AND TRACK
+.5       LDA Q6L
+         BPL .5
+         DEY
+         BNE .5
+.6       LDA Q6L
+         BPL .6
+         SEC
+         ROL
+         STA TMP
+.7       LDA Q6L
+         BPL .7
+         AND TMP
+         BNE FIND
+* FIND THE DATA FIELD, AND READ IT
+.8       LDX Q6L
+         BPL .8
+.9       CPX #$D5
+         BNE .8
+.10      LDX Q6L
+         BPL .10
+         CPX #$AA
+         BNE .9
+.11      LDX Q6L
+         BPL .11
+         CPX #$AD
+         BNE .9
+         LDA #0
+         LDY #85
+.12      LDX Q6L
+         BPL .12
+         EOR DECODE-$96,X
+         STA TWOS,Y
+         DEY
+         BPL .12
+         INY
+.13      LDX Q6L
+         BPL .13
+         EOR DECODE-$96,X
+         STA $0800,Y
+         INY
+         BNE .13
+* PUT BACK EACH BYTE'S LOW TWO BITS
+         LDX #85
+.14      LDA $0800,Y
+         LSR TWOS,X
+         ROL
+         LSR TWOS,X
+         ROL
+         STA $0800,Y
+         DEX
+         BPL .15
+         LDX #85
+.15      INY
+         BNE .14
+         JMP $0801
+ENCODE   .HS 96979A9B9D9E9FA6A7ABACADAEAFB2B3
+         .HS B4B5B6B7B9BABBBCBDBEBFCBCDCECFD3
+         .HS D6D7D9DADBDCDDDEDFE5E6E7E9EAEBEC
+         .HS EDEEEFF2F3F4F5F6F7F9FAFBFCFDFEFF
+`
+
+// resetROM is a 2K system ROM that jumps to the boot ROM in slot 6.
+const resetROM = `
+         .OR $F800
+RESET    JMP $C600
+         .OR $FFFA
+         .DA RESET,RESET,RESET
+`
+
+func TestBoot(t *testing.T) {
+	image := randomImage(5)
+	// INC $0900, then loop.
+	copy(image, []byte{0x01, 0xEE, 0x00, 0x09, 0x4C, 0x04, 0x08})
+	disk, err := NewDisk(image, ORDER_DOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCard(asmtest.Image(t, asmtest.Assemble(t, bootROM), 0xC600, 256))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Insert(0, disk)
+	rom := asmtest.Image(t, asmtest.Assemble(t, resetROM), 0xF800, 0x800)
+	m, err := apple2.New(apple2.Config{ROM: rom, Slots: [8]apple2.Card{6: c}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two turns of the disk is plenty.
+	for m.Cpu.PC() != 0x0804 && m.Cycles < 2*TrackNibbles*CyclesPerNibble {
+		if err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pc := m.Cpu.PC(); pc != 0x0804 {
+		t.Fatalf("want boot sector running, at $0804; got PC=$%04X", pc)
+	}
+	for i := 0; i < SectorSize; i++ {
+		if got := m.Peek(0x0800 + uint16(i)); got != image[i] {
+			t.Fatalf("$%04X: want $%02X, from the boot sector; got $%02X", 0x0800+i, image[i], got)
+		}
+	}
+	if got := m.Peek(0x0900); got != 1 {
+		t.Errorf("want boot sector to have run once, leaving $0900=1; got %d", got)
+	}
+}
@@ -0,0 +1,185 @@
+package diskii
+
+import "fmt"
+
+// Track layout, in nibbles.
+const (
+	gap1 = 48 // sync bytes before the first sector
+	gap2 = 6  // sync bytes between address and data fields
+	gap3 = 27 // sync bytes after each sector
+
+	// TrackNibbles is the length of a nibblized track.
+	TrackNibbles = gap1 + SectorsPerTrack*(14+gap2+349+gap3)
+)
+
+// Volume is the volume number written into address fields.
+const Volume = 254
+
+// encode62 maps six-bit values to valid disk bytes.
+var encode62 = [64]byte{
+	0x96, 0x97, 0x9A, 0x9B, 0x9D, 0x9E, 0x9F, 0xA6,
+	0xA7, 0xAB, 0xAC, 0xAD, 0xAE, 0xAF, 0xB2, 0xB3,
+	0xB4, 0xB5, 0xB6, 0xB7, 0xB9, 0xBA, 0xBB, 0xBC,
+	0xBD, 0xBE, 0xBF, 0xCB, 0xCD, 0xCE, 0xCF, 0xD3,
+	0xD6, 0xD7, 0xD9, 0xDA, 0xDB, 0xDC, 0xDD, 0xDE,
+	0xDF, 0xE5, 0xE6, 0xE7, 0xE9, 0xEA, 0xEB, 0xEC,
+	0xED, 0xEE, 0xEF, 0xF2, 0xF3, 0xF4, 0xF5, 0xF6,
+	0xF7, 0xF9, 0xFA, 0xFB, 0xFC, 0xFD, 0xFE, 0xFF,
+}
+
+// decode62 is the inverse of encode62; invalid disk bytes map to 0xFF.
+var decode62 [256]byte
+
+func init() {
+	for i := range decode62 {
+		decode62[i] = 0xFF
+	}
+	for i, b := range encode62 {
+		decode62[b] = byte(i)
+	}
+}
+
+// encode44 returns the two "4-and-4" nibbles for a byte in an address
+// field: odd bits, then even bits, each interleaved with ones.
+func encode44(b byte) (byte, byte) {
+	return b>>1 | 0xAA, b | 0xAA
+}
+
+func decode44(odd, even byte) byte {
+	return (odd<<1 | 1) & even
+}
+
+// encodeSector returns the 343 nibbles (342 plus checksum) of a
+// sector's data field, 6-and-2 encoded. The low two bits of each
+// byte, bit-swapped, are packed into the first 86 values, written in
+// reverse order; the high six bits follow. Each value is XORed with
+// the previous one.
+func encodeSector(data []byte) []byte {
+	var twos [86]byte
+	pos, shift := 85, uint(0)
+	for _, b := range data[:256] {
+		twos[pos] |= (b&1<<1 | b&2>>1) << shift
+		if pos--; pos < 0 {
+			pos, shift = 85, shift+2
+		}
+	}
+	out := make([]byte, 0, 343)
+	last := byte(0)
+	for i := 85; i >= 0; i-- {
+		out = append(out, encode62[twos[i]^last])
+		last = twos[i]
+	}
+	for _, b := range data[:256] {
+		out = append(out, encode62[b>>2^last])
+		last = b >> 2
+	}
+	return append(out, encode62[last])
+}
+
+// decodeSector decodes the 343 nibbles of a data field into 256 bytes.
+func decodeSector(nibbles []byte) ([]byte, error) {
+	var twos [86]byte
+	var tops [256]byte
+	last := byte(0)
+	for i, n := range nibbles[:343] {
+		v := decode62[n]
+		if v == 0xFF {
+			return nil, fmt.Errorf("invalid disk byte $%02X in data field", n)
+		}
+		v ^= last
+		switch {
+		case i < 86:
+			twos[85-i] = v
+		case i < 342:
+			tops[i-86] = v
+		default:
+			if v != 0 {
+				return nil, fmt.Errorf("data field checksum mismatch")
+			}
+		}
+		last = v
+	}
+	data := make([]byte, 256)
+	pos, shift := 85, uint(0)
+	for i := range data {
+		low := twos[pos] >> shift & 3
+		data[i] = tops[i]<<2 | low&1<<1 | low&2>>1
+		if pos--; pos < 0 {
+			pos, shift = 85, shift+2
+		}
+	}
+	return data, nil
+}
+
+// nibblizeTrack returns the nibbles of a track, given its sixteen
+// sectors in physical order.
+func nibblizeTrack(track int, sectors [SectorsPerTrack][]byte) []byte {
+	sync := func(out []byte, n int) []byte {
+		for i := 0; i < n; i++ {
+			out = append(out, 0xFF)
+		}
+		return out
+	}
+	out := make([]byte, 0, TrackNibbles)
+	out = sync(out, gap1)
+	for s := 0; s < SectorsPerTrack; s++ {
+		out = append(out, 0xD5, 0xAA, 0x96)
+		for _, b := range []byte{Volume, byte(track), byte(s), Volume ^ byte(track) ^ byte(s)} {
+			odd, even := encode44(b)
+			out = append(out, odd, even)
+		}
+		out = append(out, 0xDE, 0xAA, 0xEB)
+		out = sync(out, gap2)
+		out = append(out, 0xD5, 0xAA, 0xAD)
+		out = append(out, encodeSector(sectors[s])...)
+		out = append(out, 0xDE, 0xAA, 0xEB)
+		out = sync(out, gap3)
+	}
+	return out
+}
+
+// denibblizeTrack finds and decodes the sectors in a track's nibbles,
+// which may have been rewritten, so fields can start anywhere
+// (including wrapped around the end). Sectors that can't be found are
+// nil.
+func denibblizeTrack(track int, nibbles []byte) ([SectorsPerTrack][]byte, error) {
+	var sectors [SectorsPerTrack][]byte
+	n := len(nibbles)
+	at := func(i int) byte { return nibbles[i%n] }
+	var firstErr error
+	for i := 0; i < n; i++ {
+		if at(i) != 0xD5 || at(i+1) != 0xAA || at(i+2) != 0x96 {
+			continue
+		}
+		var f [4]byte
+		for j := range f {
+			f[j] = decode44(at(i+3+2*j), at(i+4+2*j))
+		}
+		if f[0]^f[1]^f[2] != f[3] || int(f[1]) != track || f[2] >= SectorsPerTrack {
+			continue
+		}
+		// Find the data field that follows, before the next address field.
+		for j := i + 11; j < i+11+64; j++ {
+			if at(j) != 0xD5 || at(j+1) != 0xAA {
+				continue
+			}
+			if at(j+2) != 0xAD {
+				break
+			}
+			buf := make([]byte, 343)
+			for k := range buf {
+				buf[k] = at(j + 3 + k)
+			}
+			data, err := decodeSector(buf)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("track %d sector %d: %v", track, f[2], err)
+				}
+				break
+			}
+			sectors[f[2]] = data
+			break
+		}
+	}
+	return sectors, firstErr
+}
@@ -0,0 +1,104 @@
+package apple2
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrTimeout is returned by RunKeys if the machine stops reading keys.
+var ErrTimeout = errors.New("typed keys were not read")
+
+// Key converts a byte from a modern keyboard to what the Apple II+
+// keyboard would send: uppercase, with bit 7 (the strobe) set, RETURN
+// for newline, and the left arrow for backspace and delete. It returns
+// false for keys the Apple II+ can't type.
+func Key(b byte) (byte, bool) {
+	switch {
+	case b == '\n':
+		b = 0x0D
+	case b == 0x7F:
+		b = 0x08
+	case b >= 'a' && b <= 'z':
+		b -= 0x20
+	case b >= 0x80 || b == '`' || b == '{' || b == '|' || b == '}' || b == '~':
+		return 0, false
+	}
+	return b | 0x80, true
+}
+
+// Type queues keys to be typed, in order, each as soon as the last has
+// been read and its strobe cleared. Keys are converted with Key.
+func (m *Machine) Type(s string) {
+	for i := 0; i < len(s); i++ {
+		if k, ok := Key(s[i]); ok {
+			m.keys = append(m.keys, k)
+		}
+	}
+}
+
+// KeysPending returns the number of keys typed but not yet delivered
+// to the keyboard latch.
+func (m *Machine) KeysPending() int {
+	return len(m.keys)
+}
+
+// TypeFrom types keys read from r, as they arrive. Reading happens in
+// a separate goroutine.
+func (m *Machine) TypeFrom(r io.Reader) {
+	ch := make(chan byte, 64)
+	m.in = ch
+	go func() {
+		var b [1]byte
+		for {
+			n, err := r.Read(b[:])
+			if n > 0 {
+				ch <- b[0]
+			}
+			if err != nil {
+				close(ch)
+				return
+			}
+		}
+	}()
+}
+
+// tickKeyboard latches the next typed key, once the last one's strobe
+// has been cleared.
+func (m *Machine) tickKeyboard() {
+	if m.in != nil && len(m.keys) == 0 {
+		select {
+		case b, ok := <-m.in:
+			if !ok {
+				m.in = nil
+			} else {
+				m.Type(string(b))
+			}
+		default:
+		}
+	}
+	if len(m.keys) == 0 || m.kbd&0x80 != 0 {
+		return
+	}
+	m.kbd = m.keys[0]
+	m.keys = m.keys[1:]
+}
+
+// RunKeys is the headless mode, for tests and scripts: it types keys,
+// runs until they have all been read, then runs for settle more
+// cycles, and returns the text screen, as from Text. It gives up with
+// ErrTimeout if the keys still haven't been read after a minute of
+// emulated time.
+func (m *Machine) RunKeys(keys string, settle uint64) (string, error) {
+	m.Type(keys)
+	limit := m.Cycles + 60*ClockHz
+	for len(m.keys) > 0 || m.kbd&0x80 != 0 {
+		if m.Cycles > limit {
+			return m.Text(), ErrTimeout
+		}
+		if err := m.Run(1000); err != nil {
+			return m.Text(), err
+		}
+	}
+	err := m.Run(settle)
+	return m.Text(), err
+}
@@ -0,0 +1,364 @@
+package apple2
+
+import "fmt"
+
+// Apple IIe MMU soft switches. Below $C010, they are written; the
+// status flags at $C011-$C01F are read, in bit 7.
+const (
+	CLR80STORE  = 0xC000 // 80STORE off
+	SET80STORE  = 0xC001 // 80STORE on: PAGE2 selects main/aux display pages
+	RDMAINRAM   = 0xC002 // RAMRD off
+	RDCARDRAM   = 0xC003 // RAMRD on: read aux $0200-$BFFF
+	WRMAINRAM   = 0xC004 // RAMWRT off
+	WRCARDRAM   = 0xC005 // RAMWRT on: write aux $0200-$BFFF
+	SETSLOTCX   = 0xC006 // INTCXROM off
+	SETINTCX    = 0xC007 // INTCXROM on: internal ROM at $C100-$CFFF
+	SETSTDZP    = 0xC008 // ALTZP off
+	SETALTZP    = 0xC009 // ALTZP on: aux zero page, stack, and language card
+	SETINTC3    = 0xC00A // SLOTC3ROM off
+	SETSLOTC3   = 0xC00B // SLOTC3ROM on: slot 3 ROM at $C300
+	CLR80VID    = 0xC00C // 80-column display off
+	SET80VID    = 0xC00D // 80-column display on
+	CLRALTCHAR  = 0xC00E // primary character set
+	SETALTCHAR  = 0xC00F // alternate (MouseText) character set
+	RDLCBNK2    = 0xC011 // language card bank 2 selected
+	RDLCRAM     = 0xC012 // language card RAM read enabled
+	RDRAMRD     = 0xC013
+	RDRAMWRT    = 0xC014
+	RDCXROM     = 0xC015
+	RDALTZP     = 0xC016
+	RDC3ROM     = 0xC017
+	RD80STORE   = 0xC018
+	RDVBL       = 0xC019 // vertical blanking; handled by the IO function
+	RDTEXT      = 0xC01A
+	RDMIXED     = 0xC01B
+	RDPAGE2     = 0xC01C
+	RDHIRES     = 0xC01D
+	RDALTCHAR   = 0xC01E
+	RD80VID     = 0xC01F
+	LANGCARD    = 0xC080 // language card switches: $C080-$C08F
+	BANK1       = 0x08   // language card switch bit: select bank 1
+	LCREADRAM   = 0x03   // language card switch bits: read RAM if both or neither set
+	LCWRITEBIT  = 0x01   // language card switch bit: write-enable on two reads
+	INTC8ROMOFF = 0xCFFF // access disables the internal $C800 ROM
+)
+
+// MMUSwitches is the state of the IIe's memory management soft
+// switches, and the video switches that affect banking or are read
+// back through the MMU.
+type MMUSwitches struct {
+	Store80   bool // 80STORE
+	RAMRD     bool // read aux $0200-$BFFF
+	RAMWRT    bool // write aux $0200-$BFFF
+	INTCXROM  bool // internal ROM at $C100-$CFFF
+	ALTZP     bool // aux $0000-$01FF and language card
+	SLOTC3ROM bool // slot ROM, rather than internal, at $C300
+	INTC8ROM  bool // internal ROM at $C800-$CFFF, after a $C3xx access
+	Col80     bool // 80-column display
+	AltChar   bool // alternate character set
+
+	LCBank2  bool // language card bank 2 at $D000-$DFFF
+	LCRead   bool // read language card RAM, not ROM, at $D000-$FFFF
+	LCWrite  bool // write language card RAM at $D000-$FFFF
+	prewrite bool // the last language card access was a read of an odd switch
+
+	Mode
+}
+
+// MMU implements the Apple IIe's bank-switched memory as a cpu.Memory:
+// 64K of main and 64K of auxiliary RAM, each with a 16K language card
+// area, the 16K system ROM, and the slots' ROMs.
+//
+// In Main and Aux, $C000-$CFFF holds language card bank 1, and
+// $D000-$DFFF bank 2.
+//
+// The MMU handles its own soft switches, and the video switches, which
+// affect banking when 80STORE is on. Everything else from $C000 to
+// $C0FF, apart from slot I/O, goes to the IO function, which also
+// supplies the keyboard data returned in the low seven bits of status
+// reads.
+type MMU struct {
+	Main, Aux [0x10000]byte
+	ROM       [0x4000]byte // $C000-$FFFF; $C100-$CFFF is the internal Cx ROM
+	Slots     [8]Card      // cards in slots 1-7
+	// IO handles other soft switch accesses; may be nil. For reads,
+	// write is false and value is ignored.
+	IO func(address uint16, value byte, write bool) byte
+
+	MMUSwitches
+	expSlot int // slot whose expansion ROM is selected, or 0
+}
+
+// NewMMU returns a reset MMU with the given 16K (or 12K, for
+// $D000-$FFFF) ROM image.
+func NewMMU(rom []byte, io func(address uint16, value byte, write bool) byte) (*MMU, error) {
+	if len(rom) != 0x4000 && len(rom) != 0x3000 {
+		return nil, fmt.Errorf("IIe ROM must be 16K (or 12K); got %d bytes", len(rom))
+	}
+	m := &MMU{IO: io}
+	copy(m.ROM[0x4000-len(rom):], rom)
+	m.Reset()
+	return m, nil
+}
+
+// Reset resets the MMU, as the RESET line does: all switches are
+// cleared, and the language card reads ROM and writes RAM bank 2.
+func (m *MMU) Reset() {
+	m.MMUSwitches = MMUSwitches{LCBank2: true, LCWrite: true, Mode: Mode{Text: true}}
+	m.expSlot = 0
+}
+
+// bank returns the RAM an access to address (below $C000) uses.
+func (m *MMU) bank(address uint16, write bool) *[0x10000]byte {
+	aux := m.RAMRD
+	if write {
+		aux = m.RAMWRT
+	}
+	switch {
+	case address < 0x0200:
+		aux = m.ALTZP
+	case m.Store80 && address >= 0x0400 && address < 0x0800:
+		aux = m.Page2
+	case m.Store80 && m.Hires && address >= 0x2000 && address < 0x4000:
+		aux = m.Page2
+	}
+	if aux {
+		return &m.Aux
+	}
+	return &m.Main
+}
+
+// lcAddr returns the index in Main or Aux of a language card address.
+func (m *MMU) lcAddr(address uint16) uint16 {
+	if address < 0xE000 && !m.LCBank2 {
+		return address - 0x1000
+	}
+	return address
+}
+
+// lcBank returns the RAM the language card uses.
+func (m *MMU) lcBank() *[0x10000]byte {
+	if m.ALTZP {
+		return &m.Aux
+	}
+	return &m.Main
+}
+
+// Peek reads memory without side effects. Satisfies cpu.Peeker. Soft
+// switches read as zero.
+func (m *MMU) Peek(address uint16) byte {
+	switch {
+	case address < 0xC000:
+		return m.bank(address, false)[address]
+	case address >= 0xD000:
+		if m.LCRead {
+			return m.lcBank()[m.lcAddr(address)]
+		}
+		return m.ROM[address-0xC000]
+	case address >= 0xC100:
+		return m.cxROM(address)
+	}
+	return 0
+}
+
+// internalROM returns true if an address in $C100-$CFFF reads the
+// internal ROM, rather than a slot's.
+func (m *MMU) internalROM(address uint16) bool {
+	switch slot := address >> 8 & 0xF; {
+	case slot == 3:
+		return m.INTCXROM || !m.SLOTC3ROM
+	case slot >= 8:
+		return m.INTCXROM || m.INTC8ROM
+	}
+	return m.INTCXROM
+}
+
+// cxROM returns a byte from $C100-$CFFF: internal ROM, or a slot's.
+func (m *MMU) cxROM(address uint16) byte {
+	if m.internalROM(address) {
+		return m.ROM[address-0xC000]
+	}
+	slot := int(address >> 8 & 0xF)
+	if slot < 8 {
+		if c := m.Slots[slot]; c != nil {
+			if d, ok := c.(ROMDevice); ok {
+				return d.ROMPeek(byte(address))
+			}
+			if rom := c.ROM(); len(rom) > int(address&0xFF) {
+				return rom[address&0xFF]
+			}
+		}
+		return 0
+	}
+	if e, ok := m.Slots[m.expSlot].(interface{ ExpansionROM() []byte }); ok {
+		if rom := e.ExpansionROM(); len(rom) > int(address-EXPROM) {
+			return rom[address-EXPROM]
+		}
+	}
+	return 0
+}
+
+// Read reads memory. Satisfies cpu.Memory.
+func (m *MMU) Read(address uint16) byte {
+	return m.access(address, 0, false)
+}
+
+// Write writes memory. Satisfies cpu.Memory.
+func (m *MMU) Write(address uint16, value byte) {
+	m.access(address, value, true)
+}
+
+func (m *MMU) access(address uint16, value byte, write bool) byte {
+	switch {
+	case address < 0xC000:
+		b := m.bank(address, write)
+		if write {
+			b[address] = value
+		}
+		return b[address]
+	case address >= 0xD000:
+		if write {
+			if m.LCWrite {
+				m.lcBank()[m.lcAddr(address)] = value
+			}
+			return 0
+		}
+		return m.Peek(address)
+	case address >= 0xC100:
+		if address >= 0xC300 && address < 0xC400 && !m.SLOTC3ROM {
+			m.INTC8ROM = true
+		}
+		if address < EXPROM {
+			m.expSlot = int(address >> 8 & 7)
+			if d, ok := m.Slots[m.expSlot].(ROMDevice); ok && !m.internalROM(address) {
+				return d.ROMIO(byte(address), value, write)
+			}
+		} else if address == INTC8ROMOFF {
+			m.INTC8ROM = false
+			m.expSlot = 0
+		}
+		return m.cxROM(address)
+	case address >= SLOTIO+0x10:
+		slot := address >> 4 & 7
+		if c := m.Slots[slot]; c != nil {
+			return c.IO(byte(address&0xF), value, write)
+		}
+		return 0
+	case address >= LANGCARD:
+		m.languageCard(address, write)
+		return 0
+	case address < KBDSTRB && write:
+		m.setSwitch(address)
+		return 0
+	case address > KBDSTRB && address < 0xC020 && !write:
+		return m.status(address)
+	case address >= TXTCLR && address < SETAN0:
+		on := address&1 != 0
+		switch address & 0xF {
+		case 0x0, 0x1:
+			m.Text = on
+		case 0x2, 0x3:
+			m.Mixed = on
+		case 0x4, 0x5:
+			m.Page2 = on
+		case 0x6, 0x7:
+			m.Hires = on
+		}
+	}
+	if m.IO != nil {
+		return m.IO(address, value, write)
+	}
+	return 0
+}
+
+// setSwitch handles a write to $C000-$C00F.
+func (m *MMU) setSwitch(address uint16) {
+	on := address&1 != 0
+	switch address &^ 1 {
+	case CLR80STORE:
+		m.Store80 = on
+	case RDMAINRAM:
+		m.RAMRD = on
+	case WRMAINRAM:
+		m.RAMWRT = on
+	case SETSLOTCX:
+		m.INTCXROM = on
+	case SETSTDZP:
+		m.ALTZP = on
+	case SETINTC3:
+		m.SLOTC3ROM = on
+	case CLR80VID:
+		m.Col80 = on
+	case CLRALTCHAR:
+		m.AltChar = on
+	}
+}
+
+// status handles a read of $C011-$C01F: the flag is in bit 7, and the
+// keyboard data in the rest.
+func (m *MMU) status(address uint16) byte {
+	var flag bool
+	switch address {
+	case RDLCBNK2:
+		flag = m.LCBank2
+	case RDLCRAM:
+		flag = m.LCRead
+	case RDRAMRD:
+		flag = m.RAMRD
+	case RDRAMWRT:
+		flag = m.RAMWRT
+	case RDCXROM:
+		flag = m.INTCXROM
+	case RDALTZP:
+		flag = m.ALTZP
+	case RDC3ROM:
+		flag = m.SLOTC3ROM
+	case RD80STORE:
+		flag = m.Store80
+	case RDTEXT:
+		flag = m.Text
+	case RDMIXED:
+		flag = m.Mixed
+	case RDPAGE2:
+		flag = m.Page2
+	case RDHIRES:
+		flag = m.Hires
+	case RDALTCHAR:
+		flag = m.AltChar
+	case RD80VID:
+		flag = m.Col80
+	default: // RDVBL
+		if m.IO != nil {
+			return m.IO(address, 0, false)
+		}
+		return 0
+	}
+	var kbd byte
+	if m.IO != nil {
+		kbd = m.IO(KBD, 0, false) & 0x7F
+	}
+	return bit7(flag) | kbd
+}
+
+// languageCard handles an access to $C080-$C08F. Bit 3 selects bank 1
+// or 2; bits 0 and 1 select whether $D000-$FFFF reads RAM or ROM, and
+// whether it is write-enabled. Enabling writes takes two consecutive
+// reads of an odd switch: a write to one doesn't count, and resets the
+// sequence.
+func (m *MMU) languageCard(address uint16, write bool) {
+	m.LCBank2 = address&BANK1 == 0
+	m.LCRead = address&LCREADRAM == 0 || address&LCREADRAM == LCREADRAM
+	if address&LCWRITEBIT == 0 {
+		m.LCWrite = false
+		m.prewrite = false
+		return
+	}
+	if write {
+		m.prewrite = false
+		return
+	}
+	if m.prewrite {
+		m.LCWrite = true
+	}
+	m.prewrite = true
+}
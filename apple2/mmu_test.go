@@ -0,0 +1,186 @@
+package apple2
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newTestMMU(t *testing.T) *MMU {
+	rom := bytes.Repeat([]byte{0xEE}, 0x4000)
+	rom[0x0300] = 0xC3 // internal $C300 ROM
+	rom[0x0800] = 0xC8 // internal $C800 ROM
+	m, err := NewMMU(rom, func(address uint16, value byte, write bool) byte {
+		if address == KBD {
+			return 0xC1 // "A", strobe set
+		}
+		return 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMMUAuxRAM(t *testing.T) {
+	m := newTestMMU(t)
+	m.Write(0x1000, 0x11)
+	m.Write(WRCARDRAM, 0)
+	m.Write(0x1000, 0x22)
+	if m.Read(0x1000) != 0x11 {
+		t.Error("want RAMWRT to leave reads from main RAM")
+	}
+	m.Write(RDCARDRAM, 0)
+	if m.Read(0x1000) != 0x22 {
+		t.Error("want RAMRD to read aux RAM")
+	}
+	if m.Read(RDRAMRD) != 0x80|0x41 || m.Read(RDRAMWRT)&0x80 == 0 {
+		t.Error("want RAMRD and RAMWRT status set, with keyboard data")
+	}
+
+	// Zero page and stack follow ALTZP, not RAMRD/RAMWRT.
+	m.Write(0x0080, 0x33)
+	if m.Main[0x80] != 0x33 {
+		t.Error("want zero page in main RAM without ALTZP")
+	}
+	m.Write(SETALTZP, 0)
+	m.Write(0x0180, 0x44)
+	if m.Aux[0x180] != 0x44 || m.Read(RDALTZP)&0x80 == 0 {
+		t.Error("want stack in aux RAM with ALTZP")
+	}
+}
+
+func TestMMU80Store(t *testing.T) {
+	m := newTestMMU(t)
+	m.Write(SET80STORE, 0)
+	m.Write(RDCARDRAM, 0) // ignored for the display page, with 80STORE
+	m.Read(HISCR)
+	m.Write(0x0400, 0x55)
+	m.Write(0x2000, 0x66)
+	if m.Aux[0x0400] != 0x55 {
+		t.Error("want PAGE2 to select aux text page with 80STORE")
+	}
+	if m.Main[0x2000] != 0x66 {
+		t.Error("want hires page in main RAM (per RAMWRT) without HIRES")
+	}
+	m.Read(HIRES)
+	m.Write(0x2000, 0x77)
+	if m.Aux[0x2000] != 0x77 {
+		t.Error("want PAGE2 to select aux hires page with 80STORE and HIRES")
+	}
+	m.Read(LOWSCR)
+	if m.Read(0x0400) != 0 || m.Read(0x2000) != 0x66 {
+		t.Error("want PAGE1 to select main display pages")
+	}
+	if m.Read(RDPAGE2)&0x80 != 0 || m.Read(RDHIRES)&0x80 == 0 || m.Read(RD80STORE)&0x80 == 0 {
+		t.Error("want PAGE2 off, HIRES on, 80STORE on")
+	}
+}
+
+func TestMMULanguageCard(t *testing.T) {
+	m := newTestMMU(t)
+	if m.Read(0xD000) != 0xEE {
+		t.Fatal("want ROM at $D000 after reset")
+	}
+	// Reset leaves RAM bank 2 write-enabled.
+	m.Write(0xD000, 0x02)
+	m.Read(0xC083) // one read: read RAM, bank 2, still write-enabled
+	if m.Read(0xD000) != 0x02 {
+		t.Error("want bank 2 RAM")
+	}
+
+	m.Read(0xC08A) // bank 1, read ROM, write-protect
+	m.Write(0xD000, 0x99)
+	m.Read(0xC08B) // one read: not yet write-enabled
+	m.Write(0xD000, 0x11)
+	if got := m.Read(0xD000); got != 0 {
+		t.Errorf("want bank 1 write-protected after one read; got $%02X", got)
+	}
+	m.Write(0xC08B, 0) // a write resets the sequence
+	m.Read(0xC08B)
+	m.Write(0xD000, 0x11)
+	if got := m.Read(0xD000); got != 0 {
+		t.Errorf("want a write to reset the double-read sequence; got $%02X", got)
+	}
+	m.Read(0xC08B)
+	m.Write(0xD000, 0x11)
+	if got := m.Read(0xD000); got != 0x11 {
+		t.Errorf("want bank 1 write-enabled after two reads; got $%02X", got)
+	}
+	if m.Main[0xC000] != 0x11 || m.Main[0xD000] != 0x02 {
+		t.Error("want bank 1 stored at $C000, bank 2 at $D000")
+	}
+	if m.Read(RDLCBNK2)&0x80 != 0 || m.Read(RDLCRAM)&0x80 == 0 {
+		t.Error("want bank 1 RAM status")
+	}
+
+	// $E000-$FFFF isn't banked.
+	m.Write(0xE000, 0x42)
+	m.Read(0xC083)
+	if m.Read(0xE000) != 0x42 {
+		t.Error("want $E000 shared by both banks")
+	}
+
+	// $C081: read ROM, write RAM.
+	m.Read(0xC081)
+	m.Read(0xC081)
+	m.Write(0xF000, 0x43)
+	if m.Read(0xF000) != 0xEE || m.Main[0xF000] != 0x43 {
+		t.Error("want $C081 to read ROM and write RAM")
+	}
+	m.Read(0xC080)
+	m.Write(0xF000, 0x44)
+	if m.Read(0xF000) != 0x43 {
+		t.Error("want $C080 to read RAM, write-protected")
+	}
+
+	// ALTZP switches the language card to aux RAM.
+	m.Write(SETALTZP, 0)
+	m.Read(0xC083)
+	m.Read(0xC083)
+	m.Write(0xF000, 0x45)
+	if m.Aux[0xF000] != 0x45 || m.Main[0xF000] != 0x43 {
+		t.Error("want ALTZP to select the aux language card")
+	}
+}
+
+func TestMMUCxROM(t *testing.T) {
+	m := newTestMMU(t)
+	c := &card{rom: bytes.Repeat([]byte{0x33}, 256), exp: bytes.Repeat([]byte{0x44}, 2048)}
+	m.Slots[3] = c
+	m.Slots[6] = &card{rom: bytes.Repeat([]byte{0x66}, 256)}
+	rc := &romCard{}
+	m.Slots[4] = rc
+	if m.Read(0xC300) != 0xC3 {
+		t.Error("want internal $C300 ROM by default")
+	}
+	if m.Read(0xC800) != 0xC8 {
+		t.Error("want internal $C800 ROM after a $C3xx access")
+	}
+	m.Read(INTC8ROMOFF)
+	if m.Read(0xC600) != 0x66 {
+		t.Error("want slot 6 ROM")
+	}
+	m.Write(SETSLOTC3, 0)
+	if m.Read(0xC300) != 0x33 || m.Read(0xC800) != 0x44 {
+		t.Error("want slot 3 ROMs with SLOTC3ROM")
+	}
+	m.Write(0xC410, 0x55)
+	if rc.page[0x10] != 0x55 || m.Read(0xC410) != 0x55 {
+		t.Error("want slot 4 page register $10 to be $55")
+	}
+	m.Write(SETINTCX, 0)
+	m.Write(0xC410, 0xAA)
+	if rc.page[0x10] != 0x55 {
+		t.Error("want INTCXROM to hide slot 4's registers")
+	}
+	if m.Read(0xC600) != 0xEE || m.Read(RDCXROM)&0x80 == 0 {
+		t.Error("want internal ROM everywhere with INTCXROM")
+	}
+	if m.Read(RDC3ROM)&0x80 == 0 {
+		t.Error("want SLOTC3ROM status")
+	}
+	m.Reset()
+	if m.Read(RDCXROM)&0x80 != 0 || m.Read(RDC3ROM)&0x80 != 0 {
+		t.Error("want reset to clear INTCXROM and SLOTC3ROM")
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Package mockingboard emulates the Sweet Micro Systems Mockingboard
+sound card: two 6522 VIAs, at $Cn00 and $Cn80, each driving an
+AY-3-8910 sound generator. A VIA's port A is its PSG's data bus, and
+the low three bits of port B are the PSG's BC1, BDIR, and /RESET
+lines. Both VIAs' interrupts go to the cpu's IRQ.
+
+The card makes its own samples, of the two PSGs mixed to mono.
+*/
+package mockingboard
+
+import (
+	"github.com/zellyn/go6502/apple2/sound"
+	"github.com/zellyn/go6502/chips"
+)
+
+// Offsets of the VIAs in the card's $Cn00 page.
+const (
+	VIA1 = 0x00
+	VIA2 = 0x80
+)
+
+// PSG bus commands, written to the low three bits of a VIA's port B.
+// Any command with bit 2 clear resets the PSG.
+const (
+	CMD_RESET    = 0x0
+	CMD_INACTIVE = 0x4
+	CMD_READ     = 0x5 // read the latched register onto port A
+	CMD_WRITE    = 0x6 // write port A to the latched register
+	CMD_LATCH    = 0x7 // latch port A as the register number
+)
+
+// bus is a PSG's bus, driven by a VIA's ports.
+type bus struct {
+	psg     *chips.PSG
+	data    byte
+	control byte
+}
+
+// apply carries out the current bus command.
+func (b *bus) apply() {
+	switch {
+	case b.control&4 == 0:
+		b.psg.Reset()
+	case b.control == CMD_WRITE:
+		b.psg.WriteData(b.data)
+	case b.control == CMD_LATCH:
+		b.psg.Latch(b.data)
+	}
+}
+
+// dataPins is a VIA's port A, connected to the PSG's data bus.
+type dataPins struct{ *bus }
+
+func (p dataPins) Input() byte {
+	if p.control == CMD_READ {
+		return p.psg.ReadData()
+	}
+	return 0xFF
+}
+
+func (p dataPins) Output(value, ddr byte) {
+	p.data = value&ddr | ^ddr
+	p.apply()
+}
+
+// controlPins is a VIA's port B, connected to the PSG's control lines.
+// Like the data lines, lines that aren't driven are pulled high.
+type controlPins struct{ *bus }
+
+func (p controlPins) Input() byte {
+	return 0xFF
+}
+
+func (p controlPins) Output(value, ddr byte) {
+	p.control = (value&ddr | ^ddr) & 7
+	p.apply()
+}
+
+// Card is a Mockingboard. It satisfies apple2.Card, apple2.ROMDevice,
+// apple2.Ticker, and sound.Source.
+type Card struct {
+	IRQ  func(asserted bool) // interrupt output; may be nil
+	VIAs [2]*chips.VIA
+	PSGs [2]*chips.PSG
+
+	sampler *sound.Sampler
+}
+
+// New returns a Mockingboard that makes samples at the given rate.
+func New(rate int) *Card {
+	c := &Card{sampler: sound.NewSampler(rate)}
+	irq := chips.NewLine(func(asserted bool) {
+		if c.IRQ != nil {
+			c.IRQ(asserted)
+		}
+	})
+	for i := range c.VIAs {
+		c.PSGs[i] = chips.NewPSG()
+		b := &bus{psg: c.PSGs[i]}
+		c.VIAs[i] = chips.NewVIA(dataPins{b}, controlPins{b})
+		c.VIAs[i].IRQ = irq.Input()
+	}
+	return c
+}
+
+// IO handles an access to the card's $C0n0 registers: it has none.
+// Satisfies apple2.Card.
+func (c *Card) IO(reg byte, value byte, write bool) byte {
+	return 0
+}
+
+// ROM returns nil: the card's page holds the VIAs. Satisfies
+// apple2.Card.
+func (c *Card) ROM() []byte {
+	return nil
+}
+
+// via returns the VIA at an offset in the card's page.
+func (c *Card) via(offset byte) *chips.VIA {
+	return c.VIAs[offset>>7]
+}
+
+// ROMIO handles an access to a VIA. Satisfies apple2.ROMDevice.
+func (c *Card) ROMIO(offset byte, value byte, write bool) byte {
+	v := c.via(offset)
+	if write {
+		v.Write(uint16(offset&0xF), value)
+		return 0
+	}
+	return v.Read(uint16(offset & 0xF))
+}
+
+// ROMPeek reads a VIA without side effects. Satisfies
+// apple2.ROMDevice.
+func (c *Card) ROMPeek(offset byte) byte {
+	return c.via(offset).Peek(uint16(offset & 0xF))
+}
+
+// Reset resets both VIAs, and through them, the PSGs.
+func (c *Card) Reset() {
+	for _, v := range c.VIAs {
+		v.Reset()
+	}
+}
+
+// Tick advances the card by one cycle: the PSGs are clocked at the
+// cpu's speed. Satisfies apple2.Ticker.
+func (c *Card) Tick() {
+	for i, v := range c.VIAs {
+		v.Tick()
+		c.PSGs[i].Tick()
+	}
+	// The sum ranges from 0 to 2: AC coupling centers it on zero.
+	c.sampler.Add(c.PSGs[0].Output() + c.PSGs[1].Output())
+}
+
+// TakeSamples returns the samples made so far, and forgets them.
+// Satisfies sound.Source.
+func (c *Card) TakeSamples() []int16 {
+	return c.sampler.Take()
+}
@@ -0,0 +1,234 @@
+package mockingboard
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/zellyn/go6502/apple2"
+	"github.com/zellyn/go6502/apple2/sound"
+	"github.com/zellyn/go6502/asm/asmtest"
+	"github.com/zellyn/go6502/chips"
+	"github.com/zellyn/go6502/wav"
+)
+
+var update = flag.Bool("update", false, "update golden sounds in testdata")
+
+// playROM is a 2K ROM that sets up both PSGs of a Mockingboard in slot
+// 4, from a table of register values, then loops: a 441Hz tone on PSG
+// 1's channel A, and a 220Hz one on PSG 2's channel B, dying away
+// over half a second.
+const playROM = `
+ORB1     .EQ $C400
+ORA1     .EQ $C401
+DDRB1    .EQ $C402
+DDRA1    .EQ $C403
+ORB2     .EQ $C480
+ORA2     .EQ $C481
+DDRB2    .EQ $C482
+DDRA2    .EQ $C483
+         .OR $F800
+RESET    LDA #$FF
+         STA DDRA1
+         STA DDRA2
+         LDA #$07
+         STA DDRB1
+         STA DDRB2
+         LDA #$04      INACTIVE
+         STA ORB1
+         STA ORB2
+         LDX #0
+.1       LDA TABLE,X
+         STA ORA1
+         LDA TABLE+1,X
+         STA ORA2
+         LDA #$07      LATCH
+         STA ORB1
+         STA ORB2
+         LDA #$04
+         STA ORB1
+         STA ORB2
+         LDA TABLE+2,X
+         STA ORA1
+         LDA TABLE+3,X
+         STA ORA2
+         LDA #$06      WRITE
+         STA ORB1
+         STA ORB2
+         LDA #$04
+         STA ORB1
+         STA ORB2
+         INX
+         INX
+         INX
+         INX
+         CPX #TABLEND-TABLE
+         BNE .1
+LOOP     JMP LOOP
+*        REGISTER 1, REGISTER 2, VALUE 1, VALUE 2
+TABLE    .HS 00029122   TONE A PERIOD 145: 441HZ,
+         .HS 01030001   TONE B PERIOD 290: 220HZ
+         .HS 07073E3D   MIXER: TONE A, TONE B
+         .HS 08090F10   AMPLITUDE: 15, ENVELOPE
+         .HS 0B0C0008   ENVELOPE PERIOD $800: 1/2S
+         .HS 0D0D0000   ENVELOPE SHAPE: DECAY
+TABLEND
+         .OR $FFFA
+         .DA RESET,RESET,RESET
+`
+
+// newTestMachine returns a machine running playROM, with a
+// Mockingboard in slot 4.
+func newTestMachine(t *testing.T, rate int) (*apple2.Machine, *Card) {
+	rom := asmtest.Image(t, asmtest.Assemble(t, playROM), 0xF800, 0x800)
+	c := New(rate)
+	m, err := apple2.New(apple2.Config{ROM: rom, Slots: [8]apple2.Card{4: c}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.IRQ = m.Cpu.SetIRQ
+	return m, c
+}
+
+func TestPlay(t *testing.T) {
+	m, c := newTestMachine(t, sound.Rate)
+	if err := m.Run(apple2.ClockHz * 6 / 10); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range [][16]byte{
+		{0x91, 0, 0, 0, 0, 0, 0, 0x3E, 0x0F, 0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0x22, 0x01, 0, 0, 0, 0x3D, 0, 0x10, 0, 0, 0x08, 0, 0, 0},
+	} {
+		var got [16]byte
+		for r := range got {
+			got[r] = c.PSGs[i].Register(r)
+		}
+		if got != want {
+			t.Errorf("PSG %d: want registers % X; got % X", i+1, want, got)
+		}
+	}
+
+	s, err := sound.Record(m, apple2.ClockHz/2, sound.Rate, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Duration(); d < 0.499 || d > 0.501 {
+		t.Errorf("want 0.5s of sound; got %gs", d)
+	}
+	// The envelope has died away, leaving PSG 1's tone: 441Hz is 441
+	// crossings in half a second.
+	crossings := 0
+	for i := 1; i < len(s.Samples); i++ {
+		if (s.Samples[i-1] < 0) != (s.Samples[i] < 0) {
+			crossings++
+		}
+	}
+	if crossings < 437 || crossings > 445 {
+		t.Errorf("want about 441 zero crossings; got %d", crossings)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	m, c := newTestMachine(t, 22050)
+	s, err := sound.Record(m, apple2.ClockHz/10, 22050, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := wav.Write(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "play.wav")
+	if *update {
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	f, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := wav.Read(bytes.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, first := sound.Diff(s.Samples, want.Samples, 0); n != 0 {
+		t.Errorf("%d samples differ, starting at %d; run with -update if the change is intended", n, first)
+	}
+}
+
+func TestReadPSG(t *testing.T) {
+	c := New(sound.Rate)
+	via := func(reg int, value byte) {
+		c.ROMIO(VIA2+byte(reg), value, true)
+	}
+	via(chips.VIA_DDRA, 0xFF)
+	via(chips.VIA_DDRB, 0x07)
+	via(chips.VIA_ORA, chips.PSG_PORT_A)
+	via(chips.VIA_ORB, CMD_LATCH)
+	via(chips.VIA_ORB, CMD_INACTIVE)
+	via(chips.VIA_ORA, 0x5A)
+	via(chips.VIA_ORB, CMD_WRITE)
+	via(chips.VIA_ORB, CMD_INACTIVE)
+	via(chips.VIA_DDRA, 0x00)
+	via(chips.VIA_ORB, CMD_READ)
+	if got := c.ROMIO(VIA2+chips.VIA_ORA_NH, 0, false); got != 0x5A {
+		t.Errorf("want PSG 2 port A $5A; got $%02X", got)
+	}
+	if c.PSGs[0].Register(chips.PSG_PORT_A) != 0 {
+		t.Error("want PSG 1 untouched")
+	}
+	via(chips.VIA_ORB, CMD_RESET)
+	if c.PSGs[1].Register(chips.PSG_PORT_A) != 0 {
+		t.Error("want PSG 2 reset")
+	}
+}
+
+// Control lines that the VIA doesn't drive are pulled high, so they
+// don't reset the PSG.
+func TestUndrivenControlLines(t *testing.T) {
+	c := New(sound.Rate)
+	via := func(reg int, value byte) {
+		c.ROMIO(VIA1+byte(reg), value, true)
+	}
+	via(chips.VIA_DDRA, 0xFF)
+	via(chips.VIA_DDRB, 0x07)
+	via(chips.VIA_ORA, chips.PSG_PORT_A)
+	via(chips.VIA_ORB, CMD_LATCH)
+	via(chips.VIA_ORB, CMD_INACTIVE)
+	via(chips.VIA_ORA, 0x5A)
+	via(chips.VIA_ORB, CMD_WRITE)
+	via(chips.VIA_ORB, CMD_INACTIVE)
+	via(chips.VIA_DDRB, 0x00)
+	if got := c.PSGs[0].Register(chips.PSG_PORT_A); got != 0x5A {
+		t.Errorf("want PSG 1 port A to survive undriven control lines; got $%02X", got)
+	}
+	if got := c.ROMIO(VIA1+chips.VIA_ORB, 0, false) & 7; got != 7 {
+		t.Errorf("want undriven port B lines to read high; got $%X", got)
+	}
+}
+
+func TestIRQ(t *testing.T) {
+	c := New(sound.Rate)
+	irq := false
+	c.IRQ = func(asserted bool) { irq = asserted }
+	c.ROMIO(VIA1+chips.VIA_IER, 0x80|chips.VIA_INT_T1, true)
+	c.ROMIO(VIA1+chips.VIA_T1CL, 10, true)
+	c.ROMIO(VIA1+chips.VIA_T1CH, 0, true)
+	for i := 0; i < 12; i++ {
+		c.Tick()
+	}
+	if !irq {
+		t.Fatal("want timer 1 interrupt")
+	}
+	if c.ROMPeek(VIA1+chips.VIA_IFR)&chips.VIA_INT_T1 == 0 {
+		t.Error("want T1 flag")
+	}
+	c.ROMIO(VIA1+chips.VIA_T1CL, 0, false)
+	if irq {
+		t.Error("want reading T1CL to clear the interrupt")
+	}
+}
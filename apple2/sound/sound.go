@@ -0,0 +1,192 @@
+/*
+Package sound renders an Apple II's sound to audio, offline: the
+built-in speaker, reconstructed from the cycles at which the program
+toggled it, and sources like the Mockingboard that make their own.
+
+Sound is sampled by averaging the level over each sample period, and,
+like a real sound output, AC coupled: a level held steady fades to
+silence. Since emulation is exact, the same program always renders
+the same samples, so sound code can be tested by comparing them.
+*/
+package sound
+
+import (
+	"math"
+
+	"github.com/zellyn/go6502/apple2"
+	"github.com/zellyn/go6502/wav"
+)
+
+const (
+	// Rate is the default sample rate.
+	Rate = 44100
+	// cutoffHz is the AC coupling's cutoff frequency.
+	cutoffHz = 20
+	// amplitude is the sample value for a level of 1.
+	amplitude = 0x2000
+)
+
+// Sampler turns a level, given every cycle, into samples.
+type Sampler struct {
+	step    float64 // samples per cycle
+	pos     float64 // position in the current sample
+	sum     float64
+	n       int
+	r       float64 // high-pass filter coefficient
+	in, out float64 // high-pass filter's last input and output
+	started bool    // whether in has been set
+	samples []int16
+}
+
+// NewSampler returns a sampler that makes samples at the given rate.
+func NewSampler(rate int) *Sampler {
+	return &Sampler{
+		step: float64(rate) / apple2.ClockHz,
+		r:    math.Exp(-2 * math.Pi * cutoffHz / float64(rate)),
+	}
+}
+
+// Add adds a cycle's level. Full scale is a swing of 2, eg. from -1
+// to 1.
+func (s *Sampler) Add(level float64) {
+	s.sum += level
+	s.n++
+	s.pos += s.step
+	if s.pos < 1 {
+		return
+	}
+	s.pos--
+	x := s.sum / float64(s.n)
+	s.sum, s.n = 0, 0
+	if !s.started {
+		// Start at rest, rather than with a step up to the first level.
+		s.in, s.started = x, true
+	}
+	s.out = s.r * (s.out + x - s.in)
+	s.in = x
+	s.samples = append(s.samples, clip(s.out*amplitude))
+}
+
+// Take returns the samples made so far, and forgets them.
+func (s *Sampler) Take() []int16 {
+	samples := s.samples
+	s.samples = nil
+	return samples
+}
+
+// clip converts a sample value to an int16, clipping it.
+func clip(v float64) int16 {
+	switch {
+	case v > math.MaxInt16:
+		return math.MaxInt16
+	case v < math.MinInt16:
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
+// Speaker renders the speaker toggles recorded by a Machine.
+type Speaker struct {
+	sampler *Sampler
+	cycle   uint64 // cycles rendered so far
+	high    bool
+}
+
+// NewSpeaker returns a Speaker that makes samples at the given rate,
+// starting at the given cycle.
+func NewSpeaker(rate int, cycle uint64) *Speaker {
+	return &Speaker{sampler: NewSampler(rate), cycle: cycle}
+}
+
+// Render renders the speaker up to the given cycle, given the cycles
+// at which it was toggled since the last call, and returns the
+// samples.
+func (s *Speaker) Render(toggles []uint64, until uint64) []int16 {
+	for _, t := range toggles {
+		s.fill(t)
+		s.high = !s.high
+	}
+	s.fill(until)
+	return s.sampler.Take()
+}
+
+// fill adds the current level up to the given cycle.
+func (s *Speaker) fill(until uint64) {
+	level := -1.0
+	if s.high {
+		level = 1
+	}
+	for ; s.cycle < until; s.cycle++ {
+		s.sampler.Add(level)
+	}
+}
+
+// Source is a sound source that makes its own samples, like a
+// Mockingboard.
+type Source interface {
+	// TakeSamples returns the samples made so far, and forgets them.
+	TakeSamples() []int16
+}
+
+// Mix adds tracks together, clipping the result. It is as long as the
+// longest track.
+func Mix(tracks ...[]int16) []int16 {
+	n := 0
+	for _, t := range tracks {
+		if len(t) > n {
+			n = len(t)
+		}
+	}
+	sums := make([]float64, n)
+	for _, t := range tracks {
+		for i, v := range t {
+			sums[i] += float64(v)
+		}
+	}
+	mixed := make([]int16, n)
+	for i, v := range sums {
+		mixed[i] = clip(v)
+	}
+	return mixed
+}
+
+// Record runs a machine for the given number of cycles, and returns
+// its sound at the given rate: the speaker, mixed with any sources,
+// which must make samples at the same rate. Sound from before the
+// call is discarded. If there is an error, the sound up to it is
+// returned too.
+func Record(m *apple2.Machine, cycles uint64, rate int, sources ...Source) (wav.Sound, error) {
+	m.TakeSpeaker()
+	for _, src := range sources {
+		src.TakeSamples()
+	}
+	speaker := NewSpeaker(rate, m.Cycles)
+	err := m.Run(cycles)
+	tracks := [][]int16{speaker.Render(m.TakeSpeaker(), m.Cycles)}
+	for _, src := range sources {
+		tracks = append(tracks, src.TakeSamples())
+	}
+	return wav.Sound{Rate: rate, Samples: Mix(tracks...)}, err
+}
+
+// Diff compares two sounds' samples, returning how many differ by more
+// than the tolerance, and the index of the first.
+func Diff(a, b []int16, tolerance int) (int, int) {
+	n, first := 0, -1
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = int(a[i])
+		}
+		if i < len(b) {
+			y = int(b[i])
+		}
+		if x-y > tolerance || y-x > tolerance {
+			if n == 0 {
+				first = i
+			}
+			n++
+		}
+	}
+	return n, first
+}
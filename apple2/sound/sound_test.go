@@ -0,0 +1,92 @@
+package sound
+
+import (
+	"testing"
+
+	"github.com/zellyn/go6502/apple2"
+	"github.com/zellyn/go6502/asm/asmtest"
+)
+
+// beepROM is a 2K ROM that toggles the speaker every 5*226+8 = 1138
+// cycles: about 448Hz.
+const beepROM = `
+SPKR     .EQ $C030
+         .OR $F800
+RESET    BIT SPKR
+         LDX #226
+.1       DEX
+         BNE .1
+         JMP RESET
+         .OR $FFFA
+         .DA RESET,RESET,RESET
+`
+
+func newTestMachine(t *testing.T) *apple2.Machine {
+	rom := asmtest.Image(t, asmtest.Assemble(t, beepROM), 0xF800, 0x800)
+	m, err := apple2.New(apple2.Config{ROM: rom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// crossings counts the times samples change sign.
+func crossings(samples []int16) int {
+	n := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRecord(t *testing.T) {
+	m := newTestMachine(t)
+	s, err := Record(m, apple2.ClockHz, Rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Samples) != Rate {
+		t.Errorf("want %d samples; got %d", Rate, len(s.Samples))
+	}
+	if n := crossings(s.Samples); n < 2*446 || n > 2*450 {
+		t.Errorf("want about %d zero crossings; got %d", 2*448, n)
+	}
+}
+
+func TestSpeaker(t *testing.T) {
+	s := NewSpeaker(Rate, 1000)
+	// One toggle, then silence: a single click, fading away.
+	samples := s.Render([]uint64{1000 + apple2.ClockHz/100}, 1000+apple2.ClockHz/2)
+	if len(samples) != Rate/2 {
+		t.Errorf("want %d samples; got %d", Rate/2, len(samples))
+	}
+	click := Rate / 100
+	for i, v := range samples[:click-1] {
+		if v != 0 {
+			t.Fatalf("want silence before the click; got sample %d = %d", i, v)
+		}
+	}
+	peak := samples[click]
+	if peak < amplitude {
+		t.Errorf("want a click of %d or more; got %d", amplitude, peak)
+	}
+	if end := samples[len(samples)-1]; end < 0 || end > peak/100 {
+		t.Errorf("want the click to have faded; got %d", end)
+	}
+	if more := s.Render(nil, 1000+apple2.ClockHz); len(more) != Rate/2 {
+		t.Errorf("want %d more samples; got %d", Rate/2, len(more))
+	}
+}
+
+func TestMix(t *testing.T) {
+	got := Mix([]int16{1, 30000, -30000}, []int16{2, 30000}, []int16{3, 0, -30000, 4})
+	want := []int16{6, 32767, -32768, 4}
+	if n, first := Diff(got, want, 0); n != 0 {
+		t.Errorf("want %v; got %v: first difference at %d", want, got, first)
+	}
+	if n, first := Diff([]int16{1, 2, 3}, []int16{1, 5, 3, 7}, 2); n != 2 || first != 1 {
+		t.Errorf("want 2 differences, starting at 1; got %d, starting at %d", n, first)
+	}
+}
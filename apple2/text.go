@@ -0,0 +1,156 @@
+package apple2
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Text screen dimensions.
+const (
+	TextColumns = 40
+	TextRows    = 24
+)
+
+// TextRowAddr returns the address of the start of a row of text (or
+// lores) page 1 or 2. Rows are interleaved: each group of three rows,
+// eight rows apart, shares a 128-byte block.
+func TextRowAddr(page2 bool, row int) uint16 {
+	base := uint16(0x0400)
+	if page2 {
+		base = 0x0800
+	}
+	return base + uint16(row&7)*0x80 + uint16(row>>3)*0x28
+}
+
+// Attr is how a character is displayed.
+type Attr int
+
+const (
+	ATTR_NORMAL Attr = iota
+	ATTR_INVERSE
+	ATTR_FLASH
+)
+
+// TextChar decodes a byte of screen memory to the character the Apple
+// II+ character generator displays, and its attribute. There is no
+// lowercase: $60-$7F (in each attribute range) display as $20-$3F.
+func TextChar(b byte) (byte, Attr) {
+	attr := ATTR_NORMAL
+	switch {
+	case b < 0x40:
+		attr = ATTR_INVERSE
+	case b < 0x80:
+		attr = ATTR_FLASH
+	}
+	c := b & 0x3F
+	if c < 0x20 {
+		c += 0x40
+	}
+	return c, attr
+}
+
+// flashCycles is the length of each half of the flash cycle: a little
+// under 2Hz, from a 555 timer.
+const flashCycles = ClockHz * 16 / 60
+
+// FlashOn returns true if flashing characters are currently shown
+// inverted.
+func (m *Machine) FlashOn() bool {
+	return m.Cycles/flashCycles%2 == 1
+}
+
+// textRows returns the first row the display shows as text: all rows
+// in text mode, the bottom four in mixed mode, none otherwise.
+func (m *Machine) textRows() int {
+	switch {
+	case m.Mode.Text:
+		return 0
+	case m.Mode.Mixed:
+		return TextRows - 4
+	}
+	return TextRows
+}
+
+// Text returns the displayed text page, as lines with trailing spaces
+// removed, and without attributes. Rows showing graphics are blank.
+func (m *Machine) Text() string {
+	first := m.textRows()
+	ls := make([]string, TextRows)
+	for row := first; row < TextRows; row++ {
+		addr := TextRowAddr(m.Mode.Page2, row)
+		var line [TextColumns]byte
+		for col := range line {
+			line[col], _ = TextChar(m.ram[addr+uint16(col)])
+		}
+		ls[row] = strings.TrimRight(string(line[:]), " ")
+	}
+	return strings.Join(ls, "\n")
+}
+
+// ANSI escape sequences.
+const (
+	ansiHome    = "\x1b[H"
+	ansiInverse = "\x1b[7m"
+	ansiNormal  = "\x1b[0m"
+)
+
+// RenderANSI draws the displayed text page on a terminal, using ANSI
+// escape sequences to home the cursor and show inverse characters.
+// Flashing characters alternate between normal and inverse, so it
+// should be called a few times a second. Rows showing graphics are
+// blank.
+func (m *Machine) RenderANSI(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(ansiHome)
+	first := m.textRows()
+	flash := m.FlashOn()
+	for row := 0; row < TextRows; row++ {
+		inverse := false
+		for col := 0; col < TextColumns; col++ {
+			c, attr := byte(' '), ATTR_NORMAL
+			if row >= first {
+				c, attr = TextChar(m.ram[TextRowAddr(m.Mode.Page2, row)+uint16(col)])
+			}
+			inv := attr == ATTR_INVERSE || attr == ATTR_FLASH && flash
+			if inv != inverse {
+				if inv {
+					buf.WriteString(ansiInverse)
+				} else {
+					buf.WriteString(ansiNormal)
+				}
+				inverse = inv
+			}
+			buf.WriteByte(c)
+		}
+		if inverse {
+			buf.WriteString(ansiNormal)
+		}
+		buf.WriteString("\r\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// String returns a description of the video mode.
+func (m Mode) String() string {
+	var parts []string
+	switch {
+	case m.Text:
+		parts = append(parts, "TEXT")
+	case m.Hires:
+		parts = append(parts, "HIRES")
+	default:
+		parts = append(parts, "LORES")
+	}
+	if m.Mixed && !m.Text {
+		parts = append(parts, "MIXED")
+	}
+	page := 1
+	if m.Page2 {
+		page = 2
+	}
+	parts = append(parts, fmt.Sprintf("PAGE%d", page))
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,86 @@
+package video
+
+// font is a 5x7 dot-matrix character set for $20-$5F, in the style of
+// the Apple II character generator. Each glyph is seven rows of five
+// dots, most significant bit on the left.
+var font [64][7]byte
+
+// glyphs holds the character set, $20-$5F, as rows of dots.
+var glyphs = [64][7]string{
+	{"     ", "     ", "     ", "     ", "     ", "     ", "     "}, // space
+	{"  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "     ", "  #  "}, // !
+	{" # # ", " # # ", " # # ", "     ", "     ", "     ", "     "}, // "
+	{" # # ", " # # ", "#####", " # # ", "#####", " # # ", " # # "}, // #
+	{"  #  ", " ####", "# #  ", " ### ", "  # #", "#### ", "  #  "}, // $
+	{"##   ", "##  #", "   # ", "  #  ", " #   ", "#  ##", "   ##"}, // %
+	{" #   ", "# #  ", "# #  ", " #   ", "# # #", "#  # ", " ## #"}, // &
+	{"  #  ", "  #  ", "  #  ", "     ", "     ", "     ", "     "}, // '
+	{"  #  ", " #   ", "#    ", "#    ", "#    ", " #   ", "  #  "}, // (
+	{"  #  ", "   # ", "    #", "    #", "    #", "   # ", "  #  "}, // )
+	{"  #  ", "# # #", " ### ", "  #  ", " ### ", "# # #", "  #  "}, // *
+	{"     ", "  #  ", "  #  ", "#####", "  #  ", "  #  ", "     "}, // +
+	{"     ", "     ", "     ", "     ", "  #  ", "  #  ", " #   "}, // ,
+	{"     ", "     ", "     ", "#####", "     ", "     ", "     "}, // -
+	{"     ", "     ", "     ", "     ", "     ", "     ", "  #  "}, // .
+	{"     ", "    #", "   # ", "  #  ", " #   ", "#    ", "     "}, // /
+	{" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "}, // 0
+	{"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "}, // 1
+	{" ### ", "#   #", "    #", "  ## ", " #   ", "#    ", "#####"}, // 2
+	{"#####", "    #", "   # ", "  ## ", "    #", "#   #", " ### "}, // 3
+	{"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "}, // 4
+	{"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "}, // 5
+	{"  ###", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "}, // 6
+	{"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "}, // 7
+	{" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "}, // 8
+	{" ### ", "#   #", "#   #", " ####", "    #", "   # ", "###  "}, // 9
+	{"     ", "     ", "  #  ", "     ", "  #  ", "     ", "     "}, // :
+	{"     ", "     ", "  #  ", "     ", "  #  ", "  #  ", " #   "}, // ;
+	{"   # ", "  #  ", " #   ", "#    ", " #   ", "  #  ", "   # "}, // <
+	{"     ", "     ", "#####", "     ", "#####", "     ", "     "}, // =
+	{" #   ", "  #  ", "   # ", "    #", "   # ", "  #  ", " #   "}, // >
+	{" ### ", "#   #", "   # ", "  #  ", "  #  ", "     ", "  #  "}, // ?
+	{" ### ", "#   #", "# # #", "# ###", "# ## ", "#    ", " ####"}, // @
+	{"  #  ", " # # ", "#   #", "#   #", "#####", "#   #", "#   #"}, // A
+	{"#### ", "#   #", "#   #", "#### ", "#   #", "#   #", "#### "}, // B
+	{" ### ", "#   #", "#    ", "#    ", "#    ", "#   #", " ### "}, // C
+	{"#### ", "#   #", "#   #", "#   #", "#   #", "#   #", "#### "}, // D
+	{"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#####"}, // E
+	{"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#    "}, // F
+	{" ####", "#    ", "#    ", "#    ", "#  ##", "#   #", " ####"}, // G
+	{"#   #", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"}, // H
+	{" ### ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "}, // I
+	{"    #", "    #", "    #", "    #", "    #", "#   #", " ### "}, // J
+	{"#   #", "#  # ", "# #  ", "##   ", "# #  ", "#  # ", "#   #"}, // K
+	{"#    ", "#    ", "#    ", "#    ", "#    ", "#    ", "#####"}, // L
+	{"#   #", "## ##", "# # #", "# # #", "#   #", "#   #", "#   #"}, // M
+	{"#   #", "#   #", "##  #", "# # #", "#  ##", "#   #", "#   #"}, // N
+	{" ### ", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "}, // O
+	{"#### ", "#   #", "#   #", "#### ", "#    ", "#    ", "#    "}, // P
+	{" ### ", "#   #", "#   #", "#   #", "# # #", "#  # ", " ## #"}, // Q
+	{"#### ", "#   #", "#   #", "#### ", "# #  ", "#  # ", "#   #"}, // R
+	{" ### ", "#   #", "#    ", " ### ", "    #", "#   #", " ### "}, // S
+	{"#####", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  "}, // T
+	{"#   #", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "}, // U
+	{"#   #", "#   #", "#   #", "#   #", "#   #", " # # ", "  #  "}, // V
+	{"#   #", "#   #", "#   #", "# # #", "# # #", "## ##", "#   #"}, // W
+	{"#   #", "#   #", " # # ", "  #  ", " # # ", "#   #", "#   #"}, // X
+	{"#   #", "#   #", " # # ", "  #  ", "  #  ", "  #  ", "  #  "}, // Y
+	{"#####", "    #", "   # ", "  #  ", " #   ", "#    ", "#####"}, // Z
+	{"#####", "##   ", "##   ", "##   ", "##   ", "##   ", "#####"}, // [
+	{"     ", "#    ", " #   ", "  #  ", "   # ", "    #", "     "}, // backslash
+	{"#####", "   ##", "   ##", "   ##", "   ##", "   ##", "#####"}, // ]
+	{"     ", "     ", "  #  ", " # # ", "#   #", "     ", "     "}, // ^
+	{"     ", "     ", "     ", "     ", "     ", "     ", "#####"}, // _
+}
+
+func init() {
+	for c, g := range glyphs {
+		for row, dots := range g {
+			for i := 0; i < 5; i++ {
+				if dots[i] == '#' {
+					font[c][row] |= 0x10 >> uint(i)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,392 @@
+/*
+Package video renders the Apple II display from a snapshot of memory:
+text (40 and 80 columns), lores, hires, and double hires, as full or
+mixed screens, on page 1 or 2.
+
+Images are 560x384: 560 dots across, the finest horizontal resolution
+(double hires, and 80-column text), and each of the 192 scanlines drawn
+twice, so the picture has about the right shape. They can be drawn as
+on a monochrome monitor, or with the colors an NTSC color monitor
+shows.
+
+Text uses a built-in character set, in the style of the Apple II+
+character generator.
+*/
+package video
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"github.com/zellyn/go6502/apple2"
+	"github.com/zellyn/go6502/cpu"
+)
+
+// Image dimensions.
+const (
+	Width     = 560
+	Height    = 384
+	scanlines = 192
+)
+
+// Monitor says how the picture is drawn.
+type Monitor int
+
+const (
+	MONITOR_MONO  Monitor = iota // white dots on black
+	MONITOR_COLOR                // NTSC artifact color
+)
+
+// Snapshot is everything the video hardware looks at to draw a frame.
+type Snapshot struct {
+	// Main is main memory from $0000, at least up to $5FFF.
+	Main []byte
+	// Aux is auxiliary memory, for 80-column text and double hires.
+	// It may be nil otherwise.
+	Aux  []byte
+	Mode apple2.Mode
+	// Col80 selects 80-column text, and, with DoubleHires, double
+	// hires instead of hires.
+	Col80       bool
+	DoubleHires bool
+	// Store80 is the IIe's 80STORE switch: when it is on, PAGE2
+	// switches memory banks instead of the displayed page.
+	Store80 bool
+	// Flash is true if flashing characters are currently inverse.
+	Flash bool
+}
+
+// FromMemory takes a snapshot of $0000-$5FFF of a cpu.Memory, using
+// cpu.Peek so soft switches aren't disturbed.
+func FromMemory(m cpu.Memory, mode apple2.Mode, flash bool) *Snapshot {
+	main := make([]byte, 0x6000)
+	for i := range main {
+		main[i] = cpu.Peek(m, uint16(i))
+	}
+	return &Snapshot{Main: main, Mode: mode, Flash: flash}
+}
+
+// FromMachine takes a snapshot of an Apple II+.
+func FromMachine(m *apple2.Machine) *Snapshot {
+	return FromMemory(m, m.Mode, m.FlashOn())
+}
+
+// FromMMU takes a snapshot of an Apple IIe's memory. The MMU doesn't
+// track the AN3 annunciator, so the caller sets DoubleHires.
+func FromMMU(m *apple2.MMU, flash bool) *Snapshot {
+	return &Snapshot{
+		Main:    m.Main[:0x6000],
+		Aux:     m.Aux[:0x6000],
+		Mode:    m.Mode,
+		Col80:   m.Col80,
+		Store80: m.Store80,
+		Flash:   flash,
+	}
+}
+
+// Palette holds the sixteen colors, in lores color number order.
+var Palette = [16]color.RGBA{
+	{0x00, 0x00, 0x00, 0xFF}, // black
+	{0xDD, 0x00, 0x33, 0xFF}, // magenta
+	{0x00, 0x00, 0x99, 0xFF}, // dark blue
+	{0xDD, 0x22, 0xDD, 0xFF}, // purple
+	{0x00, 0x77, 0x22, 0xFF}, // dark green
+	{0x55, 0x55, 0x55, 0xFF}, // grey 1
+	{0x22, 0x22, 0xFF, 0xFF}, // medium blue
+	{0x66, 0xAA, 0xFF, 0xFF}, // light blue
+	{0x88, 0x55, 0x00, 0xFF}, // brown
+	{0xFF, 0x66, 0x00, 0xFF}, // orange
+	{0xAA, 0xAA, 0xAA, 0xFF}, // grey 2
+	{0xFF, 0x99, 0x88, 0xFF}, // pink
+	{0x11, 0xDD, 0x00, 0xFF}, // light green
+	{0xFF, 0xFF, 0x00, 0xFF}, // yellow
+	{0x44, 0xFF, 0x99, 0xFF}, // aqua
+	{0xFF, 0xFF, 0xFF, 0xFF}, // white
+}
+
+// HiresRowAddr returns the address of a scanline of hires page 1 or
+// 2. Like text rows, scanlines are interleaved.
+func HiresRowAddr(page2 bool, y int) uint16 {
+	base := uint16(0x2000)
+	if page2 {
+		base = 0x4000
+	}
+	return base + uint16(y&7)*0x400 + uint16(y>>3&7)*0x80 + uint16(y>>6)*0x28
+}
+
+// page2 returns true if page 2 is displayed.
+func (s *Snapshot) page2() bool {
+	return s.Mode.Page2 && !s.Store80
+}
+
+// line is a scanline's dots.
+type line [Width]bool
+
+// Render draws a snapshot.
+func Render(s *Snapshot, monitor Monitor) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	firstText := scanlines
+	switch {
+	case s.Mode.Text:
+		firstText = 0
+	case s.Mode.Mixed:
+		firstText = scanlines - 32
+	}
+	for y := 0; y < scanlines; y++ {
+		var dots line
+		var colors [Width]color.RGBA
+		switch {
+		case y >= firstText:
+			dots = s.textLine(y)
+			colors = monoColors(&dots)
+		case !s.Mode.Hires:
+			dots = s.loresLine(y)
+			colors = s.loresColors(y, &dots, monitor)
+		case s.Col80 && s.DoubleHires:
+			dots = s.doubleHiresLine(y)
+			colors = doubleHiresColors(&dots, monitor)
+		default:
+			dots = s.hiresLine(y)
+			colors = s.hiresColors(y, &dots, monitor)
+		}
+		for x, c := range colors {
+			img.SetRGBA(x, 2*y, c)
+			img.SetRGBA(x, 2*y+1, c)
+		}
+	}
+	return img
+}
+
+// aux returns a byte of aux memory, or zero if there is none.
+func (s *Snapshot) aux(addr uint16) byte {
+	if int(addr) < len(s.Aux) {
+		return s.Aux[addr]
+	}
+	return 0
+}
+
+// textLine returns the dots of a text scanline. Each character is
+// seven dots wide, doubled in 40-column mode; in 80 columns, the aux
+// byte comes first.
+func (s *Snapshot) textLine(y int) line {
+	var dots line
+	row, glyphRow := y/8, y%8
+	addr := apple2.TextRowAddr(s.page2(), row)
+	put := func(x, width int, b byte) {
+		c, attr := apple2.TextChar(b)
+		var bits byte
+		if glyphRow < 7 {
+			bits = font[c-0x20][glyphRow] << 1 // dot 0 is blank
+		}
+		if attr == apple2.ATTR_INVERSE || attr == apple2.ATTR_FLASH && s.Flash {
+			bits ^= 0x7F
+		}
+		for i := 0; i < 7; i++ {
+			if bits&(0x40>>uint(i)) != 0 {
+				for j := 0; j < width; j++ {
+					dots[x+i*width+j] = true
+				}
+			}
+		}
+	}
+	for col := 0; col < apple2.TextColumns; col++ {
+		if s.Col80 {
+			put(col*14, 1, s.aux(addr+uint16(col)))
+			put(col*14+7, 1, s.Main[addr+uint16(col)])
+		} else {
+			put(col*14, 2, s.Main[addr+uint16(col)])
+		}
+	}
+	return dots
+}
+
+// loresLine returns the dots of a lores scanline. Each block is 14
+// dots wide, and its four color bits repeat across it, in order.
+func (s *Snapshot) loresLine(y int) line {
+	var dots line
+	for col := 0; col < apple2.TextColumns; col++ {
+		color := s.loresColor(y, col)
+		for x := col * 14; x < col*14+14; x++ {
+			dots[x] = color>>uint(x%4)&1 != 0
+		}
+	}
+	return dots
+}
+
+// loresColor returns the color of the block at a scanline and column.
+func (s *Snapshot) loresColor(y, col int) byte {
+	b := s.Main[apple2.TextRowAddr(s.page2(), y/8)+uint16(col)]
+	if y%8 >= 4 {
+		return b >> 4
+	}
+	return b & 0xF
+}
+
+// loresColors colors a lores scanline: color monitors show each block
+// in its color.
+func (s *Snapshot) loresColors(y int, dots *line, monitor Monitor) [Width]color.RGBA {
+	if monitor == MONITOR_MONO {
+		return monoColors(dots)
+	}
+	var colors [Width]color.RGBA
+	for x := range colors {
+		colors[x] = Palette[s.loresColor(y, x/14)]
+	}
+	return colors
+}
+
+// hiresLine returns the dots of a hires scanline. Each bit is two
+// dots wide, least significant first; bit 7 delays the byte's dots by
+// one.
+func (s *Snapshot) hiresLine(y int) line {
+	var dots line
+	addr := HiresRowAddr(s.page2(), y)
+	for col := 0; col < apple2.TextColumns; col++ {
+		b := s.Main[addr+uint16(col)]
+		shift := int(b >> 7)
+		for i := 0; i < 7; i++ {
+			if b&(1<<uint(i)) == 0 {
+				continue
+			}
+			for _, x := range []int{col*14 + 2*i + shift, col*14 + 2*i + 1 + shift} {
+				if x < Width {
+					dots[x] = true
+				}
+			}
+		}
+	}
+	return dots
+}
+
+// doubleHiresLine returns the dots of a double hires scanline: seven
+// dots from each byte, aux then main, least significant first.
+func (s *Snapshot) doubleHiresLine(y int) line {
+	var dots line
+	addr := HiresRowAddr(s.page2(), y)
+	for col := 0; col < apple2.TextColumns; col++ {
+		for half, b := range []byte{s.aux(addr + uint16(col)), s.Main[addr+uint16(col)]} {
+			for i := 0; i < 7; i++ {
+				dots[col*14+half*7+i] = b&(1<<uint(i)) != 0
+			}
+		}
+	}
+	return dots
+}
+
+// monoColors draws dots white on black.
+func monoColors(dots *line) [Width]color.RGBA {
+	var colors [Width]color.RGBA
+	for x, on := range dots {
+		colors[x] = Palette[0]
+		if on {
+			colors[x] = Palette[15]
+		}
+	}
+	return colors
+}
+
+// nibble returns the four dots from x to x+3 as a color number: each
+// dot's bit position is its position mod 4, as for lores colors.
+func nibble(dots *line, x int) byte {
+	var n byte
+	for i := x; i < x+4; i++ {
+		if dots[i] {
+			n |= 1 << uint(i%4)
+		}
+	}
+	return n
+}
+
+// hiresColors colors a hires scanline. Color monitors show a lone
+// pixel as violet or green, depending on whether its position is even
+// or odd, or, if bit 7 of its byte is set, as blue or orange. Adjacent
+// pixels are white, and a gap between two pixels of the same color is
+// filled with that color.
+func (s *Snapshot) hiresColors(y int, dots *line, monitor Monitor) [Width]color.RGBA {
+	if monitor == MONITOR_MONO {
+		return monoColors(dots)
+	}
+	addr := HiresRowAddr(s.page2(), y)
+	const pixels = Width / 2
+	var on [pixels]bool
+	var shift [pixels]int
+	for p := range on {
+		b := s.Main[addr+uint16(p/7)]
+		on[p] = b&(1<<uint(p%7)) != 0
+		shift[p] = int(b >> 7)
+	}
+	// pixelColor returns the color a lone pixel shows.
+	pixelColor := func(p int) byte {
+		return [2][2]byte{{3, 12}, {6, 9}}[shift[p]][p%2]
+	}
+	var colors [Width]color.RGBA
+	for x := range colors {
+		colors[x] = Palette[0]
+	}
+	for p := range on {
+		left := p > 0 && on[p-1]
+		right := p < pixels-1 && on[p+1]
+		var c byte
+		switch {
+		case on[p] && (left || right):
+			c = 15
+		case on[p]:
+			c = pixelColor(p)
+		case left && right && pixelColor(p-1) == pixelColor(p+1):
+			c = pixelColor(p - 1)
+		default:
+			continue
+		}
+		for _, x := range []int{2*p + shift[p], 2*p + 1 + shift[p]} {
+			if x < Width {
+				colors[x] = Palette[c]
+			}
+		}
+	}
+	return colors
+}
+
+// doubleHiresColors colors a double hires scanline: each group of
+// four dots is one of the sixteen colors.
+func doubleHiresColors(dots *line, monitor Monitor) [Width]color.RGBA {
+	if monitor == MONITOR_MONO {
+		return monoColors(dots)
+	}
+	var colors [Width]color.RGBA
+	for x := range colors {
+		colors[x] = Palette[nibble(dots, x&^3)]
+	}
+	return colors
+}
+
+// Diff compares two images, returning the number of pixels that
+// differ, and the first one that does. It is meant for golden-image
+// tests.
+func Diff(a, b image.Image) (int, image.Point) {
+	if a.Bounds() != b.Bounds() {
+		return -1, image.Point{}
+	}
+	n := 0
+	var first image.Point
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ar, ag, ab, aa := a.At(x, y).RGBA()
+			br, bg, bb, ba := b.At(x, y).RGBA()
+			if ar != br || ag != bg || ab != bb || aa != ba {
+				if n == 0 {
+					first = image.Pt(x, y)
+				}
+				n++
+			}
+		}
+	}
+	return n, first
+}
+
+// WritePNG renders a snapshot, and writes it as a PNG.
+func WritePNG(w io.Writer, s *Snapshot, monitor Monitor) error {
+	return png.Encode(w, Render(s, monitor))
+}
@@ -0,0 +1,188 @@
+package video
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/zellyn/go6502/apple2"
+)
+
+var update = flag.Bool("update", false, "update golden images in testdata")
+
+func newSnapshot(mode apple2.Mode) *Snapshot {
+	return &Snapshot{Main: make([]byte, 0x6000), Aux: make([]byte, 0x6000), Mode: mode}
+}
+
+// pixel returns the color at a dot and scanline.
+func pixel(img *image.RGBA, x, y int) [3]byte {
+	c := img.RGBAAt(x, 2*y)
+	return [3]byte{c.R, c.G, c.B}
+}
+
+func rgb(n int) [3]byte {
+	c := Palette[n]
+	return [3]byte{c.R, c.G, c.B}
+}
+
+func TestText(t *testing.T) {
+	s := newSnapshot(apple2.Mode{Text: true})
+	s.Main[0x0400] = 0xC1 // normal A
+	s.Main[0x0401] = 0x01 // inverse A
+	s.Main[0x0402] = 0x41 // flashing A
+	img := Render(s, MONITOR_COLOR)
+	// The top row of A is "  #  ", in dots 1-5 of 7, doubled.
+	for x := 0; x < 14; x++ {
+		want := rgb(0)
+		if x == 6 || x == 7 {
+			want = rgb(15)
+		}
+		if got := pixel(img, x, 0); got != want {
+			t.Errorf("normal: dot %d: want %v; got %v", x, want, got)
+		}
+		if got, inv := pixel(img, 14+x, 0), [3]byte{255 - want[0], 255 - want[1], 255 - want[2]}; got != inv {
+			t.Errorf("inverse: dot %d: want %v; got %v", x, inv, got)
+		}
+	}
+	if pixel(img, 28+6, 0) != rgb(15) || pixel(img, 28, 0) != rgb(0) {
+		t.Error("want flashing A shown normal")
+	}
+	s.Flash = true
+	img = Render(s, MONITOR_COLOR)
+	if pixel(img, 28+6, 0) != rgb(0) || pixel(img, 28, 0) != rgb(15) {
+		t.Error("want flashing A shown inverse")
+	}
+
+	// 80 columns: aux first.
+	s.Col80 = true
+	s.Aux[0x0400] = 0x01 // inverse A
+	img = Render(s, MONITOR_MONO)
+	if pixel(img, 0, 0) != rgb(15) || pixel(img, 7, 0) != rgb(0) || pixel(img, 10, 0) != rgb(15) {
+		t.Error("want inverse A from aux, then normal A from main")
+	}
+}
+
+func TestLores(t *testing.T) {
+	s := newSnapshot(apple2.Mode{})
+	s.Main[0x0400] = 0x9D // orange over yellow
+	s.Main[0x0800+39] = 0x66
+	img := Render(s, MONITOR_COLOR)
+	if pixel(img, 0, 0) != rgb(13) || pixel(img, 13, 3) != rgb(13) {
+		t.Error("want yellow top block")
+	}
+	if pixel(img, 0, 4) != rgb(9) || pixel(img, 13, 7) != rgb(9) {
+		t.Error("want orange bottom block")
+	}
+	if pixel(img, 14, 0) != rgb(0) {
+		t.Error("want black second block")
+	}
+	s.Mode.Page2 = true
+	if pixel(Render(s, MONITOR_COLOR), Width-1, 0) != rgb(6) {
+		t.Error("want medium blue block on page 2")
+	}
+	// In mono, the color's bits repeat across the block.
+	s.Mode.Page2 = false
+	img = Render(s, MONITOR_MONO)
+	for x, want := range []int{15, 0, 15, 15, 15, 0, 15, 15} { // yellow is 1101
+		if pixel(img, x, 0) != rgb(want) {
+			t.Errorf("mono yellow: dot %d: want %v", x, rgb(want))
+		}
+	}
+}
+
+func TestHires(t *testing.T) {
+	s := newSnapshot(apple2.Mode{Hires: true})
+	s.Main[0x2000] = 0x01 // violet pixel
+	s.Main[0x2400] = 0x02 // green pixel, on scanline 1
+	s.Main[0x2800] = 0x81 // blue pixel
+	s.Main[0x2C00] = 0x82 // orange pixel
+	s.Main[0x3000] = 0x03 // two pixels: white
+	img := Render(s, MONITOR_MONO)
+	if pixel(img, 0, 0) != rgb(15) || pixel(img, 1, 0) != rgb(15) || pixel(img, 2, 0) != rgb(0) {
+		t.Error("want mono pixel in dots 0 and 1")
+	}
+	if pixel(img, 0, 2) != rgb(0) || pixel(img, 1, 2) != rgb(15) || pixel(img, 2, 2) != rgb(15) {
+		t.Error("want bit 7 to delay by one dot")
+	}
+	img = Render(s, MONITOR_COLOR)
+	for _, tt := range []struct {
+		x, y, color int
+	}{
+		{0, 0, 3}, {1, 0, 3}, {2, 1, 12}, {3, 1, 12}, {1, 2, 6}, {2, 2, 6}, {3, 3, 9}, {4, 3, 9}, {1, 4, 15}, {2, 4, 15},
+	} {
+		if got := pixel(img, tt.x, tt.y); got != rgb(tt.color) {
+			t.Errorf("dot %d, scanline %d: want color %d %v; got %v", tt.x, tt.y, tt.color, rgb(tt.color), got)
+		}
+	}
+}
+
+func TestDoubleHires(t *testing.T) {
+	s := newSnapshot(apple2.Mode{Hires: true})
+	s.Col80, s.DoubleHires = true, true
+	s.Aux[0x2000] = 0x09  // orange, then a pixel from the next three bits
+	s.Main[0x2000] = 0x7F // white
+	img := Render(s, MONITOR_COLOR)
+	for x := 0; x < 4; x++ {
+		if pixel(img, x, 0) != rgb(9) {
+			t.Errorf("dot %d: want orange", x)
+		}
+	}
+	if pixel(img, 8, 0) != rgb(15) {
+		t.Error("want white from main")
+	}
+}
+
+func TestGolden(t *testing.T) {
+	// A mixed-mode hires screen: a diagonal line, color bars, and
+	// text below.
+	s := newSnapshot(apple2.Mode{Hires: true, Mixed: true})
+	for y := 0; y < 160; y++ {
+		col, bit := y/7, uint(y%7)
+		s.Main[HiresRowAddr(false, y)+uint16(col)] |= 1 << bit
+		for col := 24; col < 40; col++ {
+			s.Main[HiresRowAddr(false, y)+uint16(col)] = []byte{0x55, 0x2A, 0xD5, 0xAA, 0x7F}[(col-24)/4%5]
+		}
+	}
+	for i, c := range []byte("HELLO, WORLD!") {
+		s.Main[apple2.TextRowAddr(false, 21)+uint16(i)] = c | 0x80
+		s.Main[apple2.TextRowAddr(false, 22)+uint16(i)] = c & 0x3F
+	}
+	for _, tt := range []struct {
+		name    string
+		monitor Monitor
+	}{
+		{"mixed_mono.png", MONITOR_MONO},
+		{"mixed_color.png", MONITOR_COLOR},
+	} {
+		var buf bytes.Buffer
+		if err := WritePNG(&buf, s, tt.monitor); err != nil {
+			t.Fatal(err)
+		}
+		golden := filepath.Join("testdata", tt.name)
+		if *update {
+			if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		f, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := png.Decode(bytes.NewReader(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, first := Diff(got, want); n != 0 {
+			t.Errorf("%s: %d pixels differ, starting at %v; run with -update if the change is intended", tt.name, n, first)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package asm
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"path/filepath"
 
 	"github.com/zellyn/go6502/asm/context"
@@ -21,6 +22,42 @@ type Assembler struct {
 	Insts  []*inst.I
 	Macros map[string]macros.M
 	Ctx    *context.SimpleContext
+
+	// MacroDepth limits how deeply macro calls may nest, to catch
+	// runaway recursion. Zero means DefaultMacroDepth.
+	MacroDepth int
+
+	// SegmentOrigins gives segments' starting addresses, for segments
+	// the source doesn't place itself.
+	SegmentOrigins map[string]uint16
+
+	segments map[string]*segment
+	seg      *segment // the current segment
+	dummy    bool     // in a dummy section?
+	dummyEnd uint16   // where the location counter resumes after the dummy section
+}
+
+// segment is a named section of output, with its own location
+// counter. The first segment is "code".
+type segment struct {
+	addr      uint16 // location counter, saved while another segment is current
+	target    uint16 // where the next bytes are stored, if targeting
+	targeting bool   // whether a target address is set apart from the origin
+	bss       bool   // whether it reserves addresses, but stores no bytes
+}
+
+// DefaultMacroDepth is the default limit on macro call nesting.
+const DefaultMacroDepth = 64
+
+// DefaultSegment is the name of the segment assembly starts in.
+const DefaultSegment = "code"
+
+// SavedFile is output that the source asks to have saved to a file,
+// like Merlin's SAV and DSK do.
+type SavedFile struct {
+	Name string
+	Addr uint16 // where the first byte is stored
+	Data []byte // including any header
 }
 
 // NewAssembler creates a new assembler with the given flavor and
@@ -39,7 +76,16 @@ func NewAssembler(flavor flavors.F, opener lines.Opener) *Assembler {
 
 type ifdef struct {
 	active bool
+	skip   bool // nested in an inactive branch: neither branch is active
 	in     inst.I
+	taken  bool // has a branch been active?
+	elseIf bool // has there been an else-if branch?
+}
+
+// expansion is a macro call being expanded.
+type expansion struct {
+	sources int // number of line sources, including the macro's
+	ifdefs  int // number of open ifdefs at the call
 }
 
 // Load loads a new assembler file, deleting any previous data.
@@ -52,7 +98,16 @@ func (a *Assembler) Load(filename string, prefix int) error {
 	}
 	lineSources := []lines.LineSource{ls}
 	ifdefs := []ifdef{}
+	expansions := []expansion{}
 	macroCall := 0
+	// ifdefBase is the number of ifdefs that were open when the
+	// current macro was called: its own can't close them.
+	ifdefBase := func() int {
+		if len(expansions) == 0 {
+			return 0
+		}
+		return expansions[0].ifdefs
+	}
 	for len(lineSources) > 0 {
 		line, done, err := lineSources[0].Next()
 		if err != nil {
@@ -68,6 +123,11 @@ func (a *Assembler) Load(filename string, prefix int) error {
 			mode = flavors.ParseModeInactive
 		}
 		in, parseErr := a.Flavor.ParseInstr(a.Ctx, line, mode)
+		if inactive && in.Type == inst.TypeIfdef {
+			// Track nested ifdefs, so their ends don't end ours.
+			ifdefs = append([]ifdef{{false, true, in, false, false}}, ifdefs...)
+			continue
+		}
 		if inactive && in.Type != inst.TypeIfdefElse && in.Type != inst.TypeIfdefEnd {
 			// we're still in an inactive ifdef branch
 			continue
@@ -78,15 +138,21 @@ func (a *Assembler) Load(filename string, prefix int) error {
 
 		switch in.Type {
 		case inst.TypeOrg:
-			a.Ctx.SetAddr(in.Addr)
+			a.setOrg(&in)
+		case inst.TypeSegment:
+			a.setSegment(&in)
+		case inst.TypeDummy, inst.TypeDummyEnd:
+			a.setDummy(&in)
+		case inst.TypeInclude:
+			if in.Var == inst.VarIncludeBinary {
+				if err := a.includeBinary(&in); err != nil {
+					return err
+				}
+			}
 		case inst.TypeTarget:
-			return in.Errorf("target not implemented yet")
+			a.setTarget(&in)
 		}
-
-		// Update address
-		addr := a.Ctx.GetAddr()
-		in.Addr = addr
-		a.Ctx.SetAddr(addr + in.Width)
+		a.advance(&in)
 
 		switch in.Type {
 		case inst.TypeUnknown:
@@ -105,17 +171,42 @@ func (a *Assembler) Load(filename string, prefix int) error {
 			if !ok {
 				return in.Errorf(`unknown macro: "%s"`, in.Command)
 			}
+			if len(expansions) >= a.macroDepth() {
+				return in.Errorf(`macro calls nested more than %d deep, calling "%s": runaway recursion?`, a.macroDepth(), m.Name)
+			}
 			subLs, err := m.LineSource(a.Flavor, in, macroCall, prefix)
 			if err != nil {
 				return in.Errorf(`error calling macro "%s": %v`, m.Name, err)
 			}
 			lineSources = append([]lines.LineSource{subLs}, lineSources...)
+			expansions = append([]expansion{{len(lineSources), len(ifdefs)}}, expansions...)
 			a.Ctx.PushMacroCall(m.Name, macroCall, m.Locals)
 		case inst.TypeMacroEnd:
 			// If we reached here, it's in a macro call, not a definition.
-			if !a.Ctx.PopMacroCall() {
+			if len(expansions) == 0 || !a.Ctx.PopMacroCall() {
 				return in.Errorf("unexpected end of macro")
 			}
+			if len(ifdefs) > ifdefBase() {
+				return ifdefs[0].in.Errorf("Ifdef not closed before end of macro")
+			}
+			expansions = expansions[1:]
+		case inst.TypeMacroExit:
+			if len(expansions) == 0 || !a.Ctx.PopMacroCall() {
+				return in.Errorf("macro exit outside macro")
+			}
+			// Drop the rest of the macro, and anything it included,
+			// and any ifdefs it opened.
+			lineSources = lineSources[len(lineSources)-expansions[0].sources+1:]
+			ifdefs = ifdefs[len(ifdefs)-ifdefBase():]
+			expansions = expansions[1:]
+		case inst.TypeLoopStart:
+			subLs, err := a.readLoop(in, lineSources[0], prefix)
+			if err != nil {
+				return err
+			}
+			lineSources = append([]lines.LineSource{subLs}, lineSources...)
+		case inst.TypeLoopEnd:
+			return in.Errorf("end of repeated block without start")
 		case inst.TypeIfdef:
 			if len(in.Exprs) == 0 {
 				panic(fmt.Sprintf("Ifdef got parsed with no expression: %s", line))
@@ -124,30 +215,55 @@ func (a *Assembler) Load(filename string, prefix int) error {
 			if err != nil {
 				return in.Errorf("cannot eval ifdef condition: %v", err)
 			}
-			ifdefs = append([]ifdef{{val != 0, in}}, ifdefs...)
+			ifdefs = append([]ifdef{{val != 0, false, in, val != 0, false}}, ifdefs...)
 
 		case inst.TypeIfdefElse:
-			if len(ifdefs) == 0 {
+			if len(ifdefs) == ifdefBase() {
 				return in.Errorf("ifdef else branch encountered outside ifdef: %s", line)
 			}
-			ifdefs[0].active = !ifdefs[0].active
+			ifd := &ifdefs[0]
+			switch {
+			case ifd.skip:
+			case in.Var == inst.VarElseIf:
+				ifd.elseIf = true
+				if ifd.taken {
+					ifd.active = false
+					break
+				}
+				val, err := in.Exprs[0].Eval(a.Ctx, in.Line)
+				if err != nil {
+					return in.Errorf("cannot eval ifdef condition: %v", err)
+				}
+				ifd.active = val != 0
+				ifd.taken = val != 0
+			case ifd.elseIf:
+				ifd.active = !ifd.taken
+				ifd.taken = true
+			default:
+				// Some assemblers allow several else branches, each
+				// flipping the last.
+				ifd.active = !ifd.active
+			}
 		case inst.TypeIfdefEnd:
-			if len(ifdefs) == 0 {
+			if len(ifdefs) == ifdefBase() {
 				return in.Errorf("ifdef end encountered outside ifdef: %s", line)
 			}
 			ifdefs = ifdefs[1:]
 		case inst.TypeInclude:
+			if in.Var == inst.VarIncludeBinary {
+				break
+			}
 			filename = filepath.Join(filepath.Dir(in.Line.Context.Filename), in.TextArg)
 			subContext := lines.Context{Filename: filename, Parent: in.Line}
 			subLs, err := lines.NewFileLineSource(filename, subContext, a.Opener, prefix)
 			if err != nil {
 				return in.Errorf("error including file: %v", err)
 			}
+			if in.Var == inst.VarIncludeChain {
+				lineSources[0] = subLs
+				break
+			}
 			lineSources = append([]lines.LineSource{subLs}, lineSources...)
-		case inst.TypeTarget:
-			return in.Errorf("target not (yet) implemented: %s", line)
-		case inst.TypeSegment:
-			return in.Errorf("segment not (yet) implemented: %s", line)
 		case inst.TypeEnd:
 			return nil
 		default:
@@ -177,18 +293,27 @@ func (a *Assembler) AssembleWithPrefix(filename string, prefix int) error {
 	return nil
 }
 
-func (a *Assembler) readMacro(in inst.I, ls lines.LineSource) error {
-	m := macros.M{
-		Name: in.TextArg,
-		Args: in.MacroArgs,
+// macroDepth returns the limit on macro call nesting.
+func (a *Assembler) macroDepth() int {
+	if a.MacroDepth > 0 {
+		return a.MacroDepth
 	}
+	return DefaultMacroDepth
+}
+
+// readMacro reads a macro definition, up to its end. Definitions
+// nested inside it are read as part of it, to be defined when it is
+// called.
+func (a *Assembler) readMacro(in inst.I, ls lines.LineSource) error {
+	m := macros.New(in.TextArg, in.MacroArgs)
 	if a.Flavor.LocalMacroLabels() {
 		m.Locals = make(map[string]bool)
 	}
+	depth := 0
 	for {
 		line, done, err := ls.Next()
 		if err != nil {
-			return in.Errorf("error while reading macro %s: %v", m.Name)
+			return in.Errorf("error while reading macro %s: %v", m.Name, err)
 		}
 		if done {
 			return in.Errorf("end of file while reading macro %s", m.Name)
@@ -198,7 +323,14 @@ func (a *Assembler) readMacro(in inst.I, ls lines.LineSource) error {
 			m.Locals[in2.Label] = true
 		}
 		m.Lines = append(m.Lines, line.Parse.Text())
+		if err == nil && in2.Type == inst.TypeMacroStart {
+			depth++
+		}
 		if err == nil && in2.Type == inst.TypeMacroEnd {
+			if depth > 0 {
+				depth--
+				continue
+			}
 			a.Macros[m.Name] = m
 			a.Ctx.AddMacroName(m.Name)
 			return nil
@@ -206,11 +338,183 @@ func (a *Assembler) readMacro(in inst.I, ls lines.LineSource) error {
 	}
 }
 
+// readLoop reads a repeated block of lines, up to its end, and returns
+// a line source that repeats them, Exprs[0] times. If the instruction
+// names a variable, in TextArg, it's set before each repetition,
+// starting at Exprs[1] and stepping by Exprs[2].
+func (a *Assembler) readLoop(in inst.I, ls lines.LineSource, prefix int) (lines.LineSource, error) {
+	var vals [3]int64
+	for i, e := range in.Exprs {
+		val, err := e.Eval(a.Ctx, in.Line)
+		if err != nil {
+			return nil, in.Errorf("cannot eval repeat count: %v", err)
+		}
+		vals[i] = val
+	}
+	count, first, step := vals[0], vals[1], vals[2]
+	if count < 0 {
+		return nil, in.Errorf("negative repeat count: %d", count)
+	}
+	var body []string
+	depth := 0
+	for {
+		line, done, err := ls.Next()
+		if err != nil {
+			return nil, in.Errorf("error while reading repeated block: %v", err)
+		}
+		if done {
+			return nil, in.Errorf("end of file while reading repeated block")
+		}
+		in2, err := a.Flavor.ParseInstr(a.Ctx, line, flavors.ParseModeMacroSave)
+		if err == nil && in2.Type == inst.TypeLoopStart {
+			depth++
+		}
+		if err == nil && in2.Type == inst.TypeLoopEnd {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		body = append(body, line.Parse.Text())
+	}
+	var start func(int)
+	if in.TextArg != "" {
+		start = func(i int) {
+			a.Ctx.Set(in.TextArg, first+int64(i)*step)
+		}
+	}
+	context := lines.Context{Filename: "repeat", Parent: in.Line}
+	return lines.NewRepeatLineSource(context, body, int(count), prefix, start), nil
+}
+
+// includeBinary reads the bytes of a binary include.
+func (a *Assembler) includeBinary(in *inst.I) error {
+	filename := filepath.Join(filepath.Dir(in.Line.Context.Filename), in.TextArg)
+	f, err := a.Opener.Open(filename)
+	if err != nil {
+		return in.Errorf("error including file: %v", err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return in.Errorf("error including file: %v", err)
+	}
+	var vals []int64
+	for _, e := range in.Exprs {
+		val, err := e.Eval(a.Ctx, in.Line)
+		if err != nil {
+			return in.Errorf("cannot eval binary include offset or size: %v", err)
+		}
+		vals = append(vals, val)
+	}
+	if len(vals) > 0 {
+		if vals[0] < 0 || vals[0] > int64(len(data)) {
+			return in.Errorf("offset %d outside %d-byte file %s", vals[0], len(data), in.TextArg)
+		}
+		data = data[vals[0]:]
+	}
+	if len(vals) > 1 {
+		if vals[1] < 0 || vals[1] > int64(len(data)) {
+			return in.Errorf("cannot include %d bytes of %d-byte file %s", vals[1], len(data), in.TextArg)
+		}
+		data = data[:vals[1]]
+	}
+	in.Data = data
+	in.Width = uint16(len(data))
+	in.Final = true
+	return nil
+}
+
 // Clear out stuff that may be hanging around from the previous pass, set origin to default, etc.
 func (a *Assembler) initPass() {
 	a.Ctx.SetLastLabel("") // No last label (yet)
 	a.Ctx.RemoveChanged()  // Remove any variables whose value ever changed.
-	a.Ctx.SetAddr(a.Flavor.DefaultOrigin())
+	a.segments = make(map[string]*segment)
+	a.seg = a.newSegment(DefaultSegment, false)
+	a.Ctx.SetAddr(a.seg.addr)
+	a.dummy = false
+}
+
+// newSegment adds a segment, starting at its address from
+// SegmentOrigins, or the flavor's default origin.
+func (a *Assembler) newSegment(name string, bss bool) *segment {
+	seg := &segment{addr: a.Flavor.DefaultOrigin(), bss: bss}
+	if addr, ok := a.SegmentOrigins[name]; ok {
+		seg.addr = addr
+	}
+	a.segments[name] = seg
+	return seg
+}
+
+// setSegment switches to the segment named by a segment instruction,
+// saving the current segment's location counter and picking up the
+// new one's.
+func (a *Assembler) setSegment(in *inst.I) {
+	a.seg.addr = a.Ctx.GetAddr()
+	seg, ok := a.segments[in.TextArg]
+	if !ok {
+		seg = a.newSegment(in.TextArg, in.Var == inst.VarSegmentBss)
+	}
+	a.seg = seg
+	a.Ctx.SetAddr(seg.addr)
+}
+
+// setDummy starts or ends a dummy section. Inside one, instructions
+// get addresses, but their bytes aren't stored, and when it ends, the
+// location counter goes back to where it was before.
+func (a *Assembler) setDummy(in *inst.I) {
+	switch {
+	case in.Type == inst.TypeDummy:
+		if !a.dummy {
+			a.dummyEnd = a.Ctx.GetAddr()
+			a.dummy = true
+		}
+		a.Ctx.SetAddr(uint16(in.Value))
+	case a.dummy:
+		a.Ctx.SetAddr(a.dummyEnd)
+		a.dummy = false
+	}
+}
+
+// setOrg handles an origin instruction. Some flavors' origins go back
+// to storing bytes at the origin. Others, like ACME's "!pseudopc",
+// keep storing them where they were going, until the matching end.
+func (a *Assembler) setOrg(in *inst.I) {
+	switch in.Var {
+	case inst.VarOrgTarget:
+		a.seg.targeting = false
+	case inst.VarOrgPseudo:
+		if !a.seg.targeting {
+			a.seg.target = a.Ctx.GetAddr()
+			a.seg.targeting = true
+		}
+	case inst.VarOrgPseudoEnd:
+		a.Ctx.SetAddr(a.seg.target)
+		a.seg.targeting = false
+		return
+	}
+	a.Ctx.SetAddr(in.Addr)
+}
+
+// setTarget handles a target (or object) address instruction, which
+// starts storing bytes at its address, independently of the origin.
+func (a *Assembler) setTarget(in *inst.I) {
+	a.seg.target = uint16(in.Value)
+	a.seg.targeting = true
+}
+
+// advance sets an instruction's address and target address, and moves
+// both past it. Labels and "*" follow the address.
+func (a *Assembler) advance(in *inst.I) {
+	addr := a.Ctx.GetAddr()
+	in.Addr = addr
+	in.Target = addr
+	in.Reserve = a.seg.bss || a.dummy
+	if a.seg.targeting && !in.Reserve {
+		in.Target = a.seg.target
+		a.seg.target += in.Width
+	}
+	a.Ctx.SetAddr(addr + in.Width)
 }
 
 // Pass2 performs the second assembly pass. It returns an error for
@@ -218,11 +522,23 @@ func (a *Assembler) initPass() {
 func (a *Assembler) Pass2() error {
 	a.initPass()
 
+	var sum byte // for checksums
 	for _, in := range a.Insts {
 		switch in.Type {
 		case inst.TypeOrg:
-			a.Ctx.SetAddr(in.Addr)
+			a.setOrg(in)
 			continue
+		case inst.TypeTarget:
+			a.setTarget(in)
+		case inst.TypeSegment:
+			a.setSegment(in)
+		case inst.TypeDummy, inst.TypeDummyEnd:
+			a.setDummy(in)
+		case inst.TypeChecksum:
+			in.Data = []byte{sum}
+			in.Final = true
+		case inst.TypeSave:
+			sum = 0
 		case inst.TypeEqu:
 			val, err := in.Exprs[0].Eval(a.Ctx, in.Line)
 			if err != nil {
@@ -238,10 +554,12 @@ func (a *Assembler) Pass2() error {
 			return err
 		}
 
-		// Update address
-		addr := a.Ctx.GetAddr()
-		in.Addr = addr
-		a.Ctx.SetAddr(addr + in.Width)
+		a.advance(in)
+		if !in.Reserve {
+			for _, b := range in.Data {
+				sum ^= b
+			}
+		}
 	}
 
 	return nil
@@ -275,22 +593,102 @@ func (a *Assembler) Membuf() (*membuf.Membuf, error) {
 		if !in.Final {
 			return nil, in.Errorf("cannot finalize value: %s", in)
 		}
-		if in.Width > 0 {
-			m.Write(int(in.Addr), in.Data)
+		if in.Width > 0 && !in.Reserve {
+			m.Write(int(in.Target), in.Data)
 		}
 	}
 	return m, nil
 }
 
+// SavedFiles returns the output the source asks to have saved to
+// files: everything since the previous save, for VarSaveBefore saves,
+// and everything up to the next one, for VarSaveAfter saves and the
+// variants that add headers.
+func (a *Assembler) SavedFiles() ([]SavedFile, error) {
+	var files []SavedFile
+	var data []byte
+	var addr uint16
+	name, header := "", inst.VarSaveAfter // the pending VarSaveAfter file, if any
+	flush := func(n string, header inst.Variant) {
+		switch header {
+		case inst.VarSaveAfterAddr:
+			data = append([]byte{byte(addr), byte(addr >> 8)}, data...)
+		case inst.VarSaveAfterAddrLen:
+			data = append([]byte{byte(addr), byte(addr >> 8), byte(len(data)), byte(len(data) >> 8)}, data...)
+		}
+		files = append(files, SavedFile{Name: n, Addr: addr, Data: data})
+		data = nil
+	}
+	for _, in := range a.Insts {
+		if !in.Final {
+			return nil, in.Errorf("cannot finalize value: %s", in)
+		}
+		switch {
+		case in.Type == inst.TypeSave && in.Var == inst.VarSaveBefore:
+			flush(in.TextArg, in.Var)
+			name = ""
+		case in.Type == inst.TypeSave:
+			if name != "" {
+				flush(name, header)
+			}
+			data = nil
+			name, header = in.TextArg, in.Var
+		case in.Width > 0 && !in.Reserve:
+			if len(data) == 0 {
+				addr = in.Target
+			}
+			data = append(data, in.Data...)
+			for i := len(in.Data); i < int(in.Width); i++ {
+				data = append(data, 0x00)
+			}
+		}
+	}
+	if name != "" {
+		flush(name, header)
+	}
+	return files, nil
+}
+
+// LineForAddr returns the source line of the instruction that
+// assembled bytes to the given address, or nil if there isn't one.
+func (a *Assembler) LineForAddr(addr uint16) *lines.Line {
+	for _, in := range a.Insts {
+		if in.Width > 0 && addr >= in.Addr && uint32(addr) < uint32(in.Addr)+uint32(in.Width) {
+			return in.Line
+		}
+	}
+	return nil
+}
+
+// GenerateListing writes a listing: each line's address and bytes,
+// width bytes to a row, then the source line. If any bytes are stored
+// somewhere other than their address (see TypeTarget), each row shows
+// both, as "address/target". Equates show their value instead, marked
+// with "=" rather than ":".
 func (a *Assembler) GenerateListing(w io.Writer, width int) error {
+	targets := false
+	for _, in := range a.Insts {
+		if in.Target != in.Addr {
+			targets = true
+		}
+	}
 	for _, in := range a.Insts {
 		if !in.Final {
 			return in.Errorf("cannot finalize value: %s", in)
 		}
+		if in.Unlisted {
+			continue
+		}
 
 		for i := 0; i < len(in.Data) || i < width; i++ {
 			if i%width == 0 {
 				s := fmt.Sprintf("%04x:", int(in.Addr)+i)
+				if targets {
+					s = fmt.Sprintf("%04x/%04x:", int(in.Addr)+i, int(in.Target)+i)
+				}
+				if in.Type == inst.TypeEqu {
+					s = fmt.Sprintf("%*s=", len(s)-1, fmt.Sprintf("%04x", uint16(in.Value)))
+				}
 				if i > 0 {
 					s = "\n" + s
 				}
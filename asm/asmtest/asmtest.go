@@ -0,0 +1,50 @@
+/*
+Package asmtest assembles SCMA source for tests: test programs, and
+ROMs for the machines.
+*/
+package asmtest
+
+import (
+	"testing"
+
+	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/asm/flavors/scma"
+	"github.com/zellyn/go6502/asm/lines"
+	"github.com/zellyn/go6502/asm/opcodes"
+)
+
+// Assemble assembles SCMA source text, failing the test on any
+// error. It returns the assembler, so callers can get at the bytes,
+// labels, and source lines.
+func Assemble(t testing.TB, source string) *asm.Assembler {
+	t.Helper()
+	o := lines.NewTestOpener()
+	a := asm.NewAssembler(scma.New(opcodes.SetUnknown), o)
+	o["FILE"] = source
+	if err := a.Load("FILE", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Pass2(); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// Image returns the size bytes of memory starting at origin, as the
+// assembler's output fills them. Untouched bytes are zero, and output
+// outside that range fails the test.
+func Image(t testing.TB, a *asm.Assembler, origin uint32, size int) []byte {
+	t.Helper()
+	mb, err := a.Membuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := make([]byte, size)
+	for _, p := range mb.Pieces() {
+		if p.Addr < origin || p.Addr+uint32(len(p.Data)) > origin+uint32(size) {
+			t.Fatalf("piece of %d bytes at $%04X is outside $%04X-$%04X", len(p.Data), p.Addr, origin, origin+uint32(size)-1)
+		}
+		copy(image[p.Addr-origin:], p.Data)
+	}
+	return image
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/zellyn/go6502/asm"
 	"github.com/zellyn/go6502/asm/flavors"
+	"github.com/zellyn/go6502/asm/flavors/acme"
+	"github.com/zellyn/go6502/asm/flavors/as65"
+	"github.com/zellyn/go6502/asm/flavors/ca65"
+	"github.com/zellyn/go6502/asm/flavors/edasm"
 	"github.com/zellyn/go6502/asm/flavors/merlin"
 	"github.com/zellyn/go6502/asm/flavors/redbook"
 	"github.com/zellyn/go6502/asm/flavors/scma"
@@ -21,6 +30,10 @@ var flavorNames = []string{
 	"scma",
 	"redbooka",
 	"redbookb",
+	"edasm",
+	"as65",
+	"acme",
+	"ca65",
 }
 
 var infile = flag.String("in", "", "input file")
@@ -30,6 +43,9 @@ var format = flag.String("format", "binary", "output format: binary/ihex")
 var fill = flag.Uint("fillbyte", 0x00, "byte value to use when filling gaps between assmebler output regions")
 var prefix = flag.Int("prefix", -1, "length of prefix to skip past addresses and bytes, -1 to guess")
 var sweet16 = flag.Bool("sw16", false, "assemble sweet16 opcodes")
+var segments = flag.String("segments", "", "segment addresses, eg. code=$1000,data=$2000")
+var segfile = flag.String("segfile", "", "file of segment addresses, one name=address per line")
+var macroDepth = flag.Int("macrodepth", asm.DefaultMacroDepth, "maximum nesting of macro calls")
 var flavorName = flag.String("flavor", "", fmt.Sprintf("assemble flavor: %s", strings.Join(flavorNames, ",")))
 
 func main() {
@@ -47,7 +63,7 @@ func main() {
 		os.Exit(1)
 	}
 	if *fill > 0xff {
-		fmt.Fprintf(os.Stderr, "fillbyte must be <= 255; got '%s'\n", *format)
+		fmt.Fprintf(os.Stderr, "fillbyte must be <= 255; got '%d'\n", *fill)
 		os.Exit(1)
 	}
 
@@ -63,13 +79,23 @@ func main() {
 	}
 	switch *flavorName {
 	case "merlin":
-		f = merlin.New(set)
+		m := merlin.New(set)
+		m.Kbd = readKbd
+		f = m
 	case "scma":
 		f = scma.New(set)
 	case "redbooka":
 		f = redbook.NewRedbookA(set)
 	case "redbookb":
 		f = redbook.NewRedbookB(set)
+	case "edasm":
+		f = edasm.New(set)
+	case "as65":
+		f = as65.New(set)
+	case "acme":
+		f = acme.New(set)
+	case "ca65":
+		f = ca65.New(set)
 	default:
 		fmt.Fprintf(os.Stderr, "invalid flavor: %q\n", *flavorName)
 		os.Exit(1)
@@ -77,13 +103,19 @@ func main() {
 
 	var o lines.OsOpener
 	a := asm.NewAssembler(f, o)
+	origins, err := segmentOrigins()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	a.SegmentOrigins = origins
+	a.MacroDepth = *macroDepth
 
 	p := *prefix
 	if p < 0 {
-		var err error
 		p, err = lines.GuessFilePrefixSize(*infile, o)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error trying to determine prefix length for file '%s'", *infile, err)
+			fmt.Fprintf(os.Stderr, "Error trying to determine prefix length for file '%s': %v\n", *infile, err)
 			os.Exit(1)
 		}
 	}
@@ -112,16 +144,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	// EDASM's REL code is written as a relocatable object file.
+	e, rel := f.(*edasm.Edasm)
+	rel = rel && e.Relocatable()
+	if rel && *format != "binary" {
+		fmt.Fprintf(os.Stderr, "relocatable (REL) code must use the binary format; got '%s'\n", *format)
+		os.Exit(1)
+	}
+
 	switch *format {
 	case "binary":
-		p := m.Piece(byte(*fill))
-		n, err := out.Write(p.Data)
+		data := m.Piece(byte(*fill)).Data
+		if rel {
+			data, err = e.Rel(a.Ctx, a.Insts)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		n, err := out.Write(data)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		if n != len(p.Data) {
-			fmt.Fprintf(os.Stderr, "Error writing to '%s': wrote %d of %d bytes", *outfile, n, len(p.Data))
+		if n != len(data) {
+			fmt.Fprintf(os.Stderr, "Error writing to '%s': wrote %d of %d bytes", *outfile, n, len(data))
 			os.Exit(1)
 
 		}
@@ -139,6 +186,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	files, err := a.SavedFiles()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, file := range files {
+		filename := filepath.Join(filepath.Dir(*outfile), file.Name)
+		if err := ioutil.WriteFile(filename, file.Data, 0666); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
 	if *listfile != "" {
 		list, err := os.Create(*listfile)
 		if err != nil {
@@ -161,3 +221,69 @@ func main() {
 	}
 
 }
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// readKbd prompts for, and reads, a line of input, for Merlin's KBD
+// directive.
+func readKbd(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	s, err := stdin.ReadString('\n')
+	if err == io.EOF && s != "" {
+		err = nil
+	}
+	return s, err
+}
+
+// segmentOrigins collects segment addresses from the -segfile file,
+// then the -segments flag.
+func segmentOrigins() (map[string]uint16, error) {
+	origins := make(map[string]uint16)
+	if *segfile != "" {
+		f, err := os.Open(*segfile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := addSegmentOrigin(origins, line); err != nil {
+				return nil, fmt.Errorf("%s: %v", *segfile, err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if *segments != "" {
+		for _, seg := range strings.Split(*segments, ",") {
+			if err := addSegmentOrigin(origins, seg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return origins, nil
+}
+
+// addSegmentOrigin parses a segment address, like "code=$1000", and
+// adds it to origins.
+func addSegmentOrigin(origins map[string]uint16, s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("segment address must be name=address; got %q", s)
+	}
+	name, addr := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if strings.HasPrefix(addr, "$") {
+		addr = "0x" + addr[1:]
+	}
+	a, err := strconv.ParseUint(addr, 0, 16)
+	if err != nil {
+		return fmt.Errorf("bad address for segment %q: %v", name, err)
+	}
+	origins[name] = uint16(a)
+	return nil
+}
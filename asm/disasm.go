@@ -17,10 +17,10 @@ import (
 // Symbols are symbol tables used to convert addresses to names
 type Symbols map[int]string
 
-// name returns a symbol for an address. If lookback is 1 or greater,
+// Name returns a symbol for an address. If lookback is 1 or greater,
 // it can return strings like "FOO+1" for the addresses succeeding
 // defined symbols.
-func (s Symbols) name(addr int, lookback int) string {
+func (s Symbols) Name(addr int, lookback int) string {
 	if n, ok := s[addr]; ok {
 		return n
 	}
@@ -47,14 +47,14 @@ func bytesString(byte0, byte1, byte2 byte, length int) string {
 }
 
 func (s *Symbols) addr4(addr uint16, lookback int) string {
-	if n := s.name(int(addr), lookback); n != "" {
+	if n := s.Name(int(addr), lookback); n != "" {
 		return n
 	}
 	return fmt.Sprintf("$%04X", addr)
 }
 
 func (s *Symbols) addr2(addr byte, lookback int) string {
-	if n := s.name(int(addr), lookback); n != "" {
+	if n := s.Name(int(addr), lookback); n != "" {
 		return n
 	}
 	return fmt.Sprintf("$%02X", addr)
@@ -3,6 +3,7 @@ package expr
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/zellyn/go6502/asm/context"
 	"github.com/zellyn/go6502/asm/lines"
@@ -18,6 +19,9 @@ func (e UnknownLabelError) Error() string {
 
 type Operator int
 
+// scopeSep separates the scopes in scoped labels' names.
+const scopeSep = "::"
+
 const (
 	OpUnknown Operator = iota
 	OpLeaf             // No op - this is a leaf node
@@ -34,22 +38,52 @@ const (
 	OpAnd
 	OpOr
 	OpXor
+	OpBank   // Bank byte: bits 16-23 (65816)
+	OpMod    // Remainder
+	OpShl    // Shift left
+	OpShr    // Shift right
+	OpLe     // Less than or equal to
+	OpGe     // Greater than or equal to
+	OpNe     // Not equal to
+	OpPow    // Power
+	OpInt    // Integer value: since all values are integers, the value itself
+	OpNot    // Bitwise not (unary)
+	OpLogNot // Boolean not (unary)
+	OpLogAnd // Boolean and
+	OpLogOr  // Boolean or
+	OpLogXor // Boolean exclusive or
+	OpLsr    // Logical shift right
 )
 
 var OpStrings = map[Operator]string{
-	OpPlus:  "+",
-	OpMinus: "-",
-	OpMul:   "*",
-	OpDiv:   "/",
-	OpLsb:   "lsb",
-	OpMsb:   "msb",
-	OpByte:  "byte",
-	OpLt:    "<",
-	OpGt:    ">",
-	OpEq:    "=",
-	OpAnd:   "&",
-	OpOr:    "|",
-	OpXor:   "^",
+	OpPlus:   "+",
+	OpMinus:  "-",
+	OpMul:    "*",
+	OpDiv:    "/",
+	OpLsb:    "lsb",
+	OpMsb:    "msb",
+	OpByte:   "byte",
+	OpLt:     "<",
+	OpGt:     ">",
+	OpEq:     "=",
+	OpAnd:    "&",
+	OpOr:     "|",
+	OpXor:    "^",
+	OpBank:   "bank",
+	OpMod:    "%",
+	OpShl:    "<<",
+	OpShr:    ">>",
+	OpLe:     "<=",
+	OpGe:     ">=",
+	OpNe:     "!=",
+	OpPow:    "**",
+	OpInt:    "int",
+	OpNot:    "~",
+	OpLogNot: "!",
+	OpLogAnd: "&&",
+	OpLogOr:  "||",
+	OpLogXor: "xor",
+	OpLsr:    ">>>",
 }
 
 type E struct {
@@ -68,7 +102,8 @@ func (e E) String() string {
 		}
 		return fmt.Sprintf("$%04x", e.Val)
 
-	case OpPlus, OpMinus, OpMul, OpDiv, OpLsb, OpMsb, OpByte, OpLt, OpGt, OpEq, OpAnd, OpOr, OpXor:
+	case OpPlus, OpMinus, OpMul, OpDiv, OpLsb, OpMsb, OpByte, OpLt, OpGt, OpEq, OpAnd, OpOr, OpXor, OpBank,
+		OpMod, OpShl, OpShr, OpLe, OpGe, OpNe, OpPow, OpInt, OpNot, OpLogNot, OpLogAnd, OpLogOr, OpLogXor, OpLsr:
 		if e.Right != nil {
 			return fmt.Sprintf("(%s %s %s)", OpStrings[e.Op], *e.Left, *e.Right)
 		}
@@ -97,10 +132,10 @@ func (e *E) Equal(o *E) bool {
 }
 
 // Width returns the width in bytes of an expression. It'll be two for anything except
-// expressions that start with Lsb or Msb operators.
+// expressions that start with Lsb, Msb, or Bank operators.
 func (e *E) Width() uint16 {
 	switch e.Op {
-	case OpLsb, OpMsb, OpByte:
+	case OpLsb, OpMsb, OpByte, OpBank:
 		return 1
 	}
 	return 2
@@ -115,7 +150,7 @@ func (e *E) Eval(ctx context.Context, ln *lines.Line) (int64, error) {
 		if e.Text == "" {
 			return e.Val, nil
 		}
-		if val, ok := ctx.Get(e.Text); ok {
+		if val, ok := lookup(ctx, e.Text); ok {
 			return val, nil
 		}
 		return 0, UnknownLabelError{Err: ln.Errorf("unknown label: %s", e.Text)}
@@ -132,18 +167,33 @@ func (e *E) Eval(ctx context.Context, ln *lines.Line) (int64, error) {
 			return 0, err
 		}
 		return l - r, nil
-	case OpMsb, OpLsb:
+	case OpMsb, OpLsb, OpBank:
 		l, err := e.Left.Eval(ctx, ln)
 		if err != nil {
 			return 0, err
 		}
-		if e.Op == OpMsb {
+		switch e.Op {
+		case OpMsb:
 			return l >> 8, nil
+		case OpBank:
+			return (l >> 16) & 0xff, nil
 		}
 		return l & 0xff, nil
 	case OpByte:
 		return e.Val, nil
-	case OpPlus, OpMul, OpDiv, OpLt, OpGt, OpEq, OpAnd, OpOr, OpXor:
+	case OpInt:
+		return e.Left.Eval(ctx, ln)
+	case OpNot, OpLogNot:
+		l, err := e.Left.Eval(ctx, ln)
+		if err != nil {
+			return 0, err
+		}
+		if e.Op == OpNot {
+			return ^l, nil
+		}
+		return boolVal(l == 0), nil
+	case OpPlus, OpMul, OpDiv, OpLt, OpGt, OpEq, OpAnd, OpOr, OpXor,
+		OpMod, OpShl, OpShr, OpLe, OpGe, OpNe, OpPow, OpLogAnd, OpLogOr, OpLogXor, OpLsr:
 		l, err := e.Left.Eval(ctx, ln)
 		if err != nil {
 			return 0, err
@@ -172,7 +222,30 @@ func (e *E) Eval(ctx context.Context, ln *lines.Line) (int64, error) {
 				return 1, nil
 			}
 			return 0, nil
-		case OpDiv:
+		case OpLe:
+			if l <= r {
+				return 1, nil
+			}
+			return 0, nil
+		case OpGe:
+			if l >= r {
+				return 1, nil
+			}
+			return 0, nil
+		case OpNe:
+			if l != r {
+				return 1, nil
+			}
+			return 0, nil
+		case OpShl:
+			return l << uint64(r), nil
+		case OpShr:
+			return l >> uint64(r), nil
+		case OpLsr:
+			return int64(uint64(l) >> uint64(r)), nil
+		case OpPow:
+			return pow(l, r, ln)
+		case OpDiv, OpMod:
 			if r == 0 {
 				z := ctx.DivZero()
 				if z == nil {
@@ -180,6 +253,9 @@ func (e *E) Eval(ctx context.Context, ln *lines.Line) (int64, error) {
 				}
 				return int64(*z), nil
 			}
+			if e.Op == OpMod {
+				return l % r, nil
+			}
 			return l / r, nil
 		case OpAnd:
 			return l & r, nil
@@ -187,12 +263,76 @@ func (e *E) Eval(ctx context.Context, ln *lines.Line) (int64, error) {
 			return l | r, nil
 		case OpXor:
 			return l ^ r, nil
+		case OpLogAnd:
+			return boolVal(l != 0 && r != 0), nil
+		case OpLogOr:
+			return boolVal(l != 0 || r != 0), nil
+		case OpLogXor:
+			return boolVal((l != 0) != (r != 0)), nil
 		}
 		panic(fmt.Sprintf("bad code - missing switch case: %d", e.Op))
 	}
 	panic(fmt.Sprintf("unknown operator type: %d", e.Op))
 }
 
+// pow raises l to the power r, stopping with an error if the result
+// overflows.
+func pow(l, r int64, ln *lines.Line) (int64, error) {
+	if r < 0 {
+		return 0, ln.Errorf("negative power: %d", r)
+	}
+	switch l {
+	case 0, 1:
+		if r == 0 {
+			return 1, nil
+		}
+		return l, nil
+	case -1:
+		if r%2 == 0 {
+			return 1, nil
+		}
+		return -1, nil
+	}
+	p := int64(1)
+	for i := r; i > 0; i-- {
+		q := p * l
+		if q/l != p {
+			return 0, ln.Errorf("power overflows: %d^%d", l, r)
+		}
+		p = q
+	}
+	return p, nil
+}
+
+// boolVal converts a boolean to 1 or 0.
+func boolVal(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// lookup gets the value of a label. Scoped labels, like ca65's
+// "outer::inner::x", fall back to enclosing scopes: "outer::x", then
+// "x".
+func lookup(ctx context.Context, name string) (int64, bool) {
+	for {
+		if val, ok := ctx.Get(name); ok {
+			return val, true
+		}
+		i := strings.LastIndex(name, scopeSep)
+		if i < 0 {
+			return 0, false
+		}
+		j := strings.LastIndex(name[:i], scopeSep)
+		if j < 0 {
+			name = name[i+len(scopeSep):]
+		} else {
+			name = name[:j] + name[i:]
+		}
+	}
+}
+
 // CheckedEval calls Eval, but also turns UnknownLabelErrors into labelMissing booleans.
 func (e *E) CheckedEval(ctx context.Context, ln *lines.Line) (val int64, labelMissing bool, err error) {
 	val, err = e.Eval(ctx, ln)
@@ -0,0 +1,786 @@
+package acme
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zellyn/go6502/asm/context"
+	"github.com/zellyn/go6502/asm/expr"
+	"github.com/zellyn/go6502/asm/flavors"
+	"github.com/zellyn/go6502/asm/flavors/common"
+	"github.com/zellyn/go6502/asm/inst"
+	"github.com/zellyn/go6502/asm/lines"
+	"github.com/zellyn/go6502/asm/opcodes"
+)
+
+// Acme implements an ACME-compatible assembler flavor.
+// See https://sourceforge.net/projects/acme-crossass/
+type Acme struct {
+	common.Base
+	blocks []block         // open "{...}" blocks, innermost last
+	zone   int             // the current zone, for ".local" labels
+	zones  int             // zones so far
+	anon   map[string]int  // anonymous label counts, by "+"/"-" run
+	vars   map[string]bool // "!for" loop variables
+}
+
+// blockKind is the kind of an open "{...}" block, which says what its
+// closing "}" means.
+type blockKind int
+
+const (
+	blockIf blockKind = iota
+	blockElse
+	blockFor
+	blockMacro // a macro definition
+	blockCall  // a macro expansion
+	blockZone
+	blockPseudoPC
+)
+
+type block struct {
+	kind blockKind
+	zone int // the zone to go back to at the block's end
+}
+
+// blockKinds gives the kind of block each directive opens.
+var blockKinds = map[string]blockKind{
+	"!if":       blockIf,
+	"!ifdef":    blockIf,
+	"!ifndef":   blockIf,
+	"!for":      blockFor,
+	"!macro":    blockMacro,
+	"!zone":     blockZone,
+	"!zn":       blockZone,
+	"!pseudopc": blockPseudoPC,
+}
+
+const whitespace = " \t"
+
+func New(sets opcodes.Set) *Acme {
+	a := &Acme{}
+	a.Name = "acme"
+	a.OpcodesByName = opcodes.ByName(sets)
+	a.LabelChars = common.Letters + common.Digits + "_.@"
+	a.LabelColons = common.ReqOptional
+	a.ExplicitARegister = common.ReqOptional
+	a.CommandsStartLines = true
+	a.SpacesInExpressions = true
+	a.Parentheses = true
+	a.CommentChar = ';'
+	a.BinaryChar = '%'
+	a.ExtraCmdChars = "!+" // "!" starts directives, "+" macro calls
+	a.ImmediateChars = "#"
+	a.CharChars = `'"`
+	a.MacroArgSep = ","
+
+	a.Directives = map[string]common.DirectiveInfo{
+		"=":          {inst.TypeEqu, a.ParseEquate, inst.VarEquNormal},
+		"!byte":      {inst.TypeData, a.ParseData, inst.VarBytes},
+		"!by":        {inst.TypeData, a.ParseData, inst.VarBytes},
+		"!08":        {inst.TypeData, a.ParseData, inst.VarBytes},
+		"!8":         {inst.TypeData, a.ParseData, inst.VarBytes},
+		"!word":      {inst.TypeData, a.ParseData, inst.VarWordsLe},
+		"!wo":        {inst.TypeData, a.ParseData, inst.VarWordsLe},
+		"!16":        {inst.TypeData, a.ParseData, inst.VarWordsLe},
+		"!text":      {inst.TypeData, a.ParseText, inst.VarBytes},
+		"!tx":        {inst.TypeData, a.ParseText, inst.VarBytes},
+		"!raw":       {inst.TypeData, a.ParseText, inst.VarBytes},
+		"!pet":       {inst.TypeData, a.ParseText, inst.VarBytes},
+		"!scr":       {inst.TypeData, a.ParseText, inst.VarBytes},
+		"!fill":      {inst.TypeData, a.ParseFill, inst.VarBytes},
+		"!fi":        {inst.TypeData, a.ParseFill, inst.VarBytes},
+		"!align":     {inst.TypeData, a.ParseAlign, inst.VarBytes},
+		"!if":        {inst.TypeIfdef, a.ParseDo, 0},
+		"!ifdef":     {inst.TypeIfdef, a.ParseIfdef, 0},
+		"!ifndef":    {inst.TypeIfdef, a.ParseIfdef, 0},
+		"!for":       {inst.TypeLoopStart, a.ParseFor, 0},
+		"!macro":     {inst.TypeMacroStart, a.ParseMacroDef, 0},
+		"!zone":      {inst.TypeNone, a.ParseZone, 0},
+		"!zn":        {inst.TypeNone, a.ParseZone, 0},
+		"!source":    {inst.TypeInclude, a.ParseSource, 0},
+		"!src":       {inst.TypeInclude, a.ParseSource, 0},
+		"!binary":    {inst.TypeInclude, a.ParseBinary, inst.VarIncludeBinary},
+		"!bin":       {inst.TypeInclude, a.ParseBinary, inst.VarIncludeBinary},
+		"!bi":        {inst.TypeInclude, a.ParseBinary, inst.VarIncludeBinary},
+		"!pseudopc":  {inst.TypeOrg, a.ParseOrg, inst.VarOrgPseudo},
+		"!to":        {inst.TypeSave, a.ParseTo, inst.VarSaveAfterAddr},
+		"!eof":       {inst.TypeEnd, a.ParseNoArgDir, 0},
+		"!endoffile": {inst.TypeEnd, a.ParseNoArgDir, 0},
+	}
+
+	a.EquateDirectives = map[string]bool{
+		"=": true,
+	}
+
+	a.Operators = map[string]expr.Operator{
+		"^":   expr.OpPow,
+		"*":   expr.OpMul,
+		"/":   expr.OpDiv,
+		"DIV": expr.OpDiv,
+		"div": expr.OpDiv,
+		"%":   expr.OpMod,
+		"MOD": expr.OpMod,
+		"mod": expr.OpMod,
+		"+":   expr.OpPlus,
+		"-":   expr.OpMinus,
+		"<<":  expr.OpShl,
+		"ASL": expr.OpShl,
+		"asl": expr.OpShl,
+		"LSL": expr.OpShl,
+		"lsl": expr.OpShl,
+		">>":  expr.OpShr,
+		"ASR": expr.OpShr,
+		"asr": expr.OpShr,
+		">>>": expr.OpLsr,
+		"LSR": expr.OpLsr,
+		"lsr": expr.OpLsr,
+		"<":   expr.OpLt,
+		"<=":  expr.OpLe,
+		">":   expr.OpGt,
+		">=":  expr.OpGe,
+		"!=":  expr.OpNe,
+		"<>":  expr.OpNe,
+		"><":  expr.OpNe,
+		"=":   expr.OpEq,
+		"&":   expr.OpAnd,
+		"AND": expr.OpAnd,
+		"and": expr.OpAnd,
+		"XOR": expr.OpXor,
+		"xor": expr.OpXor,
+		"|":   expr.OpOr,
+		"OR":  expr.OpOr,
+		"or":  expr.OpOr,
+	}
+
+	a.UnaryOperators = map[string]expr.Operator{
+		"!": expr.OpNot,
+		"<": expr.OpLsb,
+		">": expr.OpMsb,
+		"^": expr.OpBank,
+	}
+
+	// As ACME's documentation numbers them. Unary minus (11) binds
+	// just its term.
+	a.Precedence = map[expr.Operator]int{
+		expr.OpNot:   13,
+		expr.OpPow:   12,
+		expr.OpMul:   10,
+		expr.OpDiv:   10,
+		expr.OpMod:   10,
+		expr.OpPlus:  9,
+		expr.OpMinus: 9,
+		expr.OpShl:   8,
+		expr.OpShr:   8,
+		expr.OpLsr:   8,
+		expr.OpLsb:   7,
+		expr.OpMsb:   7,
+		expr.OpBank:  7,
+		expr.OpLt:    6,
+		expr.OpLe:    6,
+		expr.OpGt:    6,
+		expr.OpGe:    6,
+		expr.OpNe:    5,
+		expr.OpEq:    5,
+		expr.OpAnd:   4,
+		expr.OpXor:   3,
+		expr.OpOr:    2,
+	}
+
+	a.Functions = map[string]expr.Operator{
+		"int":     expr.OpInt,
+		"addr":    expr.OpInt,
+		"address": expr.OpInt,
+		// Floating point: not supported.
+		"float":  expr.OpUnknown,
+		"sin":    expr.OpUnknown,
+		"cos":    expr.OpUnknown,
+		"tan":    expr.OpUnknown,
+		"arcsin": expr.OpUnknown,
+		"arccos": expr.OpUnknown,
+		"arctan": expr.OpUnknown,
+	}
+
+	a.InitContextFunc = func(ctx context.Context) {
+		a.blocks = nil
+		a.zone, a.zones = 0, 0
+		a.anon = make(map[string]int)
+		a.vars = make(map[string]bool)
+	}
+
+	// ParseMacroCall parses a macro call: "+name", followed by
+	// comma-separated arguments.
+	a.ParseMacroCall = func(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, bool, error) {
+		if !strings.HasPrefix(in.Command, "+") {
+			return in, false, nil
+		}
+		in.Type = inst.TypeMacroCall
+		in.Command = in.Command[1:]
+		if !ctx.HasMacroName(in.Command) {
+			return in, true, in.Errorf(`unknown macro: "%s"`, in.Command)
+		}
+		lp.IgnoreRun(whitespace)
+		for lp.Peek() != lines.Eol && lp.Peek() != a.CommentChar {
+			s, err := a.ParseMacroArg(in, lp)
+			if err != nil {
+				return in, true, err
+			}
+			in.MacroArgs = append(in.MacroArgs, s)
+			lp.IgnoreRun(whitespace)
+			if !lp.Consume(",") {
+				break
+			}
+			lp.IgnoreRun(whitespace)
+		}
+		// Each expansion gets its own zone.
+		a.blocks = append(a.blocks, block{blockCall, a.zone})
+		a.newZone()
+		return in, true, nil
+	}
+
+	a.FixLabel = func(ctx context.Context, label string) (string, error) {
+		switch {
+		case label == "":
+			return label, nil
+		case label[0] == '.':
+			return fmt.Sprintf("%s{zone %d}", label, a.zone), nil
+		case label[0] == '@':
+			if last := ctx.LastLabel(); last == "" {
+				return "", fmt.Errorf("cheap local label '%s' without previous label", label)
+			} else {
+				return fmt.Sprintf("%s/%s", last, label), nil
+			}
+		}
+		return label, nil
+	}
+
+	a.IsNewParentLabel = func(label string) bool {
+		return label != "" && label[0] != '.' && label[0] != '@'
+	}
+
+	a.IsVariable = func(label string) bool {
+		return a.vars[label]
+	}
+
+	// AnonymousLabel names the anonymous label a reference refers to:
+	// the last "-" (or "--", etc.) defined, or the next "+".
+	a.AnonymousLabel = func(ref string) string {
+		return fmt.Sprintf("%s#%d", ref, a.anon[ref])
+	}
+
+	return a
+}
+
+// ParseInstr parses an instruction. It handles what's particular to
+// ACME: "{...}" blocks, "*=" origins, and anonymous labels, and leaves
+// the rest to Base.
+func (a *Acme) ParseInstr(ctx context.Context, line lines.Line, mode flavors.ParseMode) (inst.I, error) {
+	lp := line.Parse
+	in := inst.I{Line: &line}
+	rest := strings.TrimSpace(stripComment(lp.Rest()))
+
+	switch {
+	case strings.HasPrefix(rest, "}"):
+		return a.parseBlockEnd(in, strings.TrimSpace(rest[1:]))
+	case strings.HasPrefix(rest, "*") && strings.HasPrefix(strings.TrimSpace(rest[1:]), "="):
+		if mode != flavors.ParseModeNormal {
+			in.Type = inst.TypeOrg
+			return in, nil
+		}
+		lp.IgnoreRun(whitespace)
+		lp.AcceptUntil("=")
+		in.Command = "*="
+		lp.Consume("=")
+		in.Type = inst.TypeOrg
+		return a.ParseOrg(ctx, in, lp)
+	}
+
+	// An anonymous label: a run of +'s or -'s, on its own.
+	anon := ""
+	if c := lp.Peek(); c == '+' || c == '-' {
+		run := lp.Rest()[:len(lp.Rest())-len(strings.TrimLeft(lp.Rest(), string(c)))]
+		if next := strings.TrimPrefix(lp.Rest(), run); next == "" || strings.ContainsAny(next[:1], whitespace+";") {
+			lp.AcceptString(run)
+			lp.Ignore()
+			anon = run
+		}
+	}
+
+	zone := a.zone
+	in, err := a.Base.ParseInstr(ctx, line, mode)
+	if err != nil {
+		return in, err
+	}
+
+	if anon != "" && mode == flavors.ParseModeNormal {
+		if anon[0] == '-' {
+			a.anon[anon]++
+		}
+		in.Label = a.AnonymousLabel(anon)
+		if anon[0] == '+' {
+			a.anon[anon]++
+		}
+		ctx.Set(in.Label, int64(ctx.GetAddr()))
+	}
+
+	if strings.HasSuffix(rest, "{") {
+		kind, ok := blockKinds[in.Command]
+		if !ok {
+			return in, in.Errorf("unexpected '{' after %s", in.Command)
+		}
+		a.blocks = append(a.blocks, block{kind, zone})
+	}
+	return in, nil
+}
+
+// parseBlockEnd parses a line starting with a "}", closing a block, and
+// possibly opening an else block: "} else {".
+func (a *Acme) parseBlockEnd(in inst.I, after string) (inst.I, error) {
+	if len(a.blocks) == 0 {
+		return in, in.Errorf("'}' without open block")
+	}
+	b := a.blocks[len(a.blocks)-1]
+	a.blocks = a.blocks[:len(a.blocks)-1]
+	a.zone = b.zone
+	in.Command = "}"
+	in.Width = 0
+	in.Final = true
+
+	if after != "" {
+		if b.kind != blockIf || !strings.HasPrefix(after, "else") || !strings.HasSuffix(after, "{") ||
+			strings.TrimSpace(after[len("else"):len(after)-1]) != "" {
+			return in, in.Errorf("unexpected '%s' after '}'", after)
+		}
+		in.Command = "else"
+		in.Type = inst.TypeIfdefElse
+		a.blocks = append(a.blocks, block{blockElse, b.zone})
+		return in, nil
+	}
+
+	switch b.kind {
+	case blockIf, blockElse:
+		in.Type = inst.TypeIfdefEnd
+	case blockFor:
+		in.Type = inst.TypeLoopEnd
+	case blockMacro, blockCall:
+		in.Type = inst.TypeMacroEnd
+	case blockZone:
+		in.Type = inst.TypeNone
+	case blockPseudoPC:
+		in.Type = inst.TypeOrg
+		in.Var = inst.VarOrgPseudoEnd
+	}
+	return in, nil
+}
+
+// stripComment removes any comment from the end of a line.
+func stripComment(s string) string {
+	quote := rune(0)
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';':
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// newZone starts a new zone, for ".local" labels.
+func (a *Acme) newZone() {
+	a.zones++
+	a.zone = a.zones
+}
+
+// ParseZone parses a "!zone [name]" directive, which starts a new zone.
+// The name is just for show.
+func (a *Acme) ParseZone(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	if lp.AcceptRun(a.LabelChars) {
+		in.TextArg = lp.Emit()
+	}
+	a.newZone()
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseIfdef parses "!ifdef label" and "!ifndef label".
+func (a *Acme) ParseIfdef(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	if !lp.AcceptRun(a.LabelChars) {
+		return in, in.Errorf("%s expects a label, found '%c'", in.Command, lp.Next())
+	}
+	label, err := a.FixLabel(ctx, lp.Emit())
+	if err != nil {
+		return in, in.Errorf("%v", err)
+	}
+	_, defined := ctx.Get(label)
+	var val int64
+	if defined == (in.Command == "!ifdef") {
+		val = 1
+	}
+	in.Exprs = append(in.Exprs, &expr.E{Op: expr.OpLeaf, Val: val})
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseFor parses a "!for var, start, end" loop, whose variable counts
+// from start to end, or the older "!for var, end", which counts from 1.
+func (a *Acme) ParseFor(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	if !lp.AcceptRun(a.LabelChars) {
+		return in, in.Errorf("!for expects a variable, found '%c'", lp.Next())
+	}
+	name, err := a.FixLabel(ctx, lp.Emit())
+	if err != nil {
+		return in, in.Errorf("%v", err)
+	}
+	var vals []int64
+	for lp.IgnoreRun(whitespace); lp.Consume(","); lp.IgnoreRun(whitespace) {
+		if in, err = a.ParseDo(ctx, in, lp); err != nil {
+			return in, err
+		}
+		val, err := in.Exprs[len(in.Exprs)-1].Eval(ctx, in.Line)
+		if err != nil {
+			return in, in.Errorf("cannot evaluate !for limit on first pass: %v", err)
+		}
+		vals = append(vals, val)
+	}
+	var first, last int64
+	switch len(vals) {
+	case 1:
+		first, last = 1, vals[0]
+		if last < first {
+			last = first - 1 // no repetitions
+		}
+	case 2:
+		first, last = vals[0], vals[1]
+	default:
+		return in, in.Errorf("!for expects a variable, and a start and end")
+	}
+	count, step := last-first+1, int64(1)
+	if last < first && len(vals) == 2 {
+		count, step = first-last+1, -1
+	}
+	a.vars[name] = true
+	in.TextArg = name
+	in.Exprs = []*expr.E{
+		{Op: expr.OpLeaf, Val: count},
+		{Op: expr.OpLeaf, Val: first},
+		{Op: expr.OpLeaf, Val: step},
+	}
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseMacroDef parses a macro definition: "!macro name param1, param2 {".
+func (a *Acme) ParseMacroDef(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := a.ParseMacroStart(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	for lp.IgnoreRun(whitespace); lp.Peek() != '{'; lp.IgnoreRun(whitespace) {
+		if !lp.AcceptRun(a.LabelChars + "~") {
+			return in, in.Errorf("expecting macro parameter name, found '%c'", lp.Next())
+		}
+		in.MacroArgs = append(in.MacroArgs, lp.Emit())
+		lp.IgnoreRun(whitespace)
+		if !lp.Consume(",") {
+			lp.IgnoreRun(whitespace)
+			break
+		}
+	}
+	if lp.Peek() != '{' {
+		return in, in.Errorf("expecting '{' after macro parameters, found '%c'", lp.Next())
+	}
+	return in, nil
+}
+
+var identRe = regexp.MustCompile(`[~.]?[A-Za-z_][A-Za-z0-9_]*`)
+
+// termRe matches macro arguments that are a single term.
+var termRe = regexp.MustCompile(`^(~?[A-Za-z_.@][A-Za-z0-9_.@]*|\$[0-9A-Fa-f]+|%[01]+|[0-9]+|'.'|\*)$`)
+
+// ReplaceMacroArgs replaces macro parameters' names with their
+// arguments, outside strings. ACME passes arguments by value, so one
+// that's more than a single term is wrapped in "int(...)" to keep it
+// whole: "lda #v*2" with v = "1+2" is 6, not 5. (Parentheses would
+// make "lda v" indirect.) In instructions, ",x" and ",y" index the
+// address even if there's a parameter of that name.
+func (a *Acme) ReplaceMacroArgs(line string, args []string, kwargs map[string]string, params []string, passed int) (string, error) {
+	op := false // is the line an instruction, with or without a label?
+	for i, f := range strings.Fields(line) {
+		if i == 2 {
+			break
+		}
+		if _, ok := a.OpcodesByName[strings.ToUpper(f)]; ok {
+			op = true
+		}
+	}
+	parts := strings.Split(line, `"`)
+	for i := 0; i < len(parts); i += 2 {
+		part := parts[i]
+		var b strings.Builder
+		last := 0
+		for _, m := range identRe.FindAllStringIndex(part, -1) {
+			v, ok := kwargs[part[m[0]:m[1]]]
+			if !ok || (op && isIndexRegister(part, m[0], m[1])) {
+				continue
+			}
+			if !termRe.MatchString(v) && !strings.HasPrefix(v, `"`) {
+				v = "int(" + v + ")"
+			}
+			b.WriteString(part[last:m[0]])
+			b.WriteString(v)
+			last = m[1]
+		}
+		b.WriteString(part[last:])
+		parts[i] = b.String()
+	}
+	return strings.Join(parts, `"`), nil
+}
+
+// isIndexRegister says whether s[start:end] is the "x" or "y" of an
+// indexed address: just after a comma, and at the end of the operand.
+func isIndexRegister(s string, start, end int) bool {
+	if end-start != 1 || !strings.Contains("xXyY", s[start:end]) {
+		return false
+	}
+	before := strings.TrimRight(s[:start], whitespace)
+	after := strings.TrimLeft(s[end:], whitespace)
+	return strings.HasSuffix(before, ",") && (after == "" || after[0] == ';' || after[0] == ')')
+}
+
+// parseString parses a double-quoted string. We expect to be looking
+// at the opening quote.
+func (a *Acme) parseString(in inst.I, lp *lines.Parse) (string, error) {
+	lp.Consume(`"`)
+	lp.AcceptUntil(`"`)
+	s := lp.Emit()
+	if !lp.Consume(`"`) {
+		return "", in.Errorf("%s: expected closing quote", in.Command)
+	}
+	return s, nil
+}
+
+// ParseText parses the text directives: "!text" (and "!raw"), which
+// store strings as they are, "!pet", which converts them to PETSCII,
+// and "!scr", which converts them to C64 screen codes. Numbers are
+// stored as they are.
+func (a *Acme) ParseText(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	for {
+		lp.IgnoreRun(whitespace)
+		if lp.Peek() == '"' {
+			s, err := a.parseString(in, lp)
+			if err != nil {
+				return in, err
+			}
+			for _, c := range []byte(s) {
+				in.Exprs = append(in.Exprs, &expr.E{Op: expr.OpLeaf, Val: int64(convert(in.Command, c))})
+			}
+		} else {
+			var err error
+			if in, err = a.ParseDo(ctx, in, lp); err != nil {
+				return in, err
+			}
+		}
+		lp.IgnoreRun(whitespace)
+		if !lp.Consume(",") {
+			break
+		}
+	}
+	in.Width = uint16(len(in.Exprs))
+	in.Final = true
+	for _, e := range in.Exprs {
+		val, err := e.Eval(ctx, in.Line)
+		if err != nil {
+			in.Final = false
+			in.Data = nil
+			break
+		}
+		in.Data = append(in.Data, byte(val))
+	}
+	return in, nil
+}
+
+// convert converts a character for a text directive.
+func convert(command string, c byte) byte {
+	switch command {
+	case "!pet":
+		switch {
+		case c >= 'a' && c <= 'z':
+			return c - 0x20
+		case c >= 'A' && c <= 'Z':
+			return c + 0x80
+		}
+	case "!scr":
+		switch {
+		case c >= 'a' && c <= 'z':
+			return c - 0x60
+		case c == '@' || (c >= '[' && c <= '_'):
+			return c - 0x40
+		case c == '`':
+			return 0x40
+		}
+	}
+	return c
+}
+
+// ParseFill parses "!fill count [, value]".
+func (a *Acme) ParseFill(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	vals, err := a.parseKnown(ctx, &in, lp, 1, 2)
+	if err != nil {
+		return in, err
+	}
+	vals = append(vals, 0)
+	return a.fill(in, vals[0], byte(vals[1]))
+}
+
+// ParseAlign parses "!align and, equal [, value]", which fills with
+// value (by default, NOP) until the address, ANDed with and, is equal.
+func (a *Acme) ParseAlign(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	vals, err := a.parseKnown(ctx, &in, lp, 2, 3)
+	if err != nil {
+		return in, err
+	}
+	vals = append(vals, 0xea)
+	addr := int64(ctx.GetAddr())
+	count := int64(0)
+	for ; (addr+count)&vals[0] != vals[1]; count++ {
+		if count > 0xffff {
+			return in, in.Errorf("!align can never be satisfied")
+		}
+	}
+	return a.fill(in, count, byte(vals[2]))
+}
+
+// parseKnown parses between min and max comma-separated expressions,
+// which must be known on the first pass.
+func (a *Acme) parseKnown(ctx context.Context, in *inst.I, lp *lines.Parse, min, max int) ([]int64, error) {
+	var vals []int64
+	for {
+		var err error
+		if *in, err = a.ParseDo(ctx, *in, lp); err != nil {
+			return nil, err
+		}
+		val, err := in.Exprs[len(in.Exprs)-1].Eval(ctx, in.Line)
+		if err != nil {
+			return nil, in.Errorf("cannot evaluate %s argument on first pass: %v", in.Command, err)
+		}
+		vals = append(vals, val)
+		lp.IgnoreRun(whitespace)
+		if !lp.Consume(",") {
+			break
+		}
+	}
+	if len(vals) < min || len(vals) > max {
+		return nil, in.Errorf("%s expects %d to %d arguments; got %d", in.Command, min, max, len(vals))
+	}
+	return vals, nil
+}
+
+// fill finishes a data instruction of count copies of value.
+func (a *Acme) fill(in inst.I, count int64, value byte) (inst.I, error) {
+	if count < 0 || count > 0xffff {
+		return in, in.Errorf("%s: bad size: %d", in.Command, count)
+	}
+	in.Data = make([]byte, count)
+	for i := range in.Data {
+		in.Data[i] = value
+	}
+	in.Width = uint16(count)
+	in.Final = true
+	return in, nil
+}
+
+// ParseSource parses `!source "filename"`.
+func (a *Acme) ParseSource(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	s, err := a.parseString(in, lp)
+	if err != nil {
+		return in, err
+	}
+	in.TextArg = s
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseBinary parses `!binary "filename" [, size [, skip]]`, leaving
+// the reading to the assembler.
+func (a *Acme) ParseBinary(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	s, err := a.parseString(in, lp)
+	if err != nil {
+		return in, err
+	}
+	in.TextArg = s
+	var size, skip *expr.E
+	lp.IgnoreRun(whitespace)
+	if lp.Consume(",") {
+		lp.IgnoreRun(whitespace)
+		if lp.Peek() != ',' {
+			if in, err = a.ParseDo(ctx, in, lp); err != nil {
+				return in, err
+			}
+			size = in.Exprs[len(in.Exprs)-1]
+		}
+		lp.IgnoreRun(whitespace)
+		if lp.Consume(",") {
+			if in, err = a.ParseDo(ctx, in, lp); err != nil {
+				return in, err
+			}
+			skip = in.Exprs[len(in.Exprs)-1]
+		}
+	}
+	// The assembler wants the offset first.
+	if skip == nil {
+		skip = &expr.E{Op: expr.OpLeaf}
+	}
+	in.Exprs = []*expr.E{skip}
+	if size != nil {
+		in.Exprs = append(in.Exprs, size)
+	}
+	in.Width = 0
+	in.Final = false
+	return in, nil
+}
+
+// ParseTo parses `!to "filename" [, format]`, where format is "cbm"
+// (the default: the load address, then the bytes), "plain", or "apple"
+// (the load address and length, then the bytes).
+func (a *Acme) ParseTo(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	s, err := a.parseString(in, lp)
+	if err != nil {
+		return in, err
+	}
+	in.TextArg = s
+	lp.IgnoreRun(whitespace)
+	if lp.Consume(",") {
+		lp.IgnoreRun(whitespace)
+		lp.AcceptRun(common.Letters)
+		switch format := lp.Emit(); format {
+		case "cbm":
+			in.Var = inst.VarSaveAfterAddr
+		case "plain":
+			in.Var = inst.VarSaveAfter
+		case "apple":
+			in.Var = inst.VarSaveAfterAddrLen
+		default:
+			return in, in.Errorf("!to: unknown format '%s'", format)
+		}
+	}
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
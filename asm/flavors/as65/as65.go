@@ -1,11 +1,13 @@
 package as65
 
 import (
-	"errors"
-	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/zellyn/go6502/asm/context"
 	"github.com/zellyn/go6502/asm/expr"
+	"github.com/zellyn/go6502/asm/flavors"
 	"github.com/zellyn/go6502/asm/flavors/common"
 	"github.com/zellyn/go6502/asm/inst"
 	"github.com/zellyn/go6502/asm/lines"
@@ -32,9 +34,10 @@ var defaultCMap = [256]byte{
 }
 
 // As65 implements an as65-compatible assembler flavor.
+// See http://www.kingswood-consulting.co.uk/assemblers/
 type As65 struct {
 	common.Base
-	cmap [256]byte
+	cmap [256]byte // character mapping for strings
 }
 
 func New(sets opcodes.Set) *As65 {
@@ -44,23 +47,25 @@ func New(sets opcodes.Set) *As65 {
 	a.LabelChars = common.Letters + common.Digits + "."
 	a.LabelColons = common.ReqOptional
 	a.ExplicitARegister = common.ReqRequired
-	a.StringEndOptional = true
+	a.SpacesInExpressions = true
+	a.Parentheses = true
 	a.CommentChar = ';'
-	a.MsbChars = "/"
+	a.BinaryChar = '%'
 	a.ImmediateChars = "#"
-	a.HexCommas = common.ReqOptional
+	a.CharChars = "'"
 	a.DefaultOriginVal = 0x0800
+	a.MacroArgSep = ","
+	a.cmap = defaultCMap
 
 	a.Directives = map[string]common.DirectiveInfo{
-		"ORG":    {inst.TypeOrg, a.ParseOrg, 0},
-		"OBJ":    {inst.TypeNone, nil, 0},
-		"ENDASM": {inst.TypeEnd, a.ParseNoArgDir, 0},
-		"EQU":    {inst.TypeEqu, a.ParseEquate, inst.VarEquNormal},
-		"EPZ":    {inst.TypeEqu, a.ParseEquate, inst.VarEquPageZero},
-		"DFB":    {inst.TypeData, a.ParseData, inst.VarBytes},
+		"=":   {inst.TypeEqu, a.ParseEquate, inst.VarEquNormal},
+		"equ": {inst.TypeEqu, a.ParseEquate, inst.VarEquNormal},
+		"org": {inst.TypeOrg, a.ParseOrg, 0},
+		"end": {inst.TypeEnd, a.ParseNoArgDir, 0},
 
 		"db":  {inst.TypeData, a.ParseBytes, inst.VarBytes},
-		"fcb": {inst.TypeData, a.ParseData, inst.VarBytes},
+		"fcb": {inst.TypeData, a.ParseBytes, inst.VarBytes},
+		"fcc": {inst.TypeData, a.ParseBytes, inst.VarBytes},
 		"ds":  {inst.TypeData, a.ParseData, inst.VarBytesZero},
 		"rmb": {inst.TypeData, a.ParseData, inst.VarBytesZero},
 		"dw":  {inst.TypeData, a.ParseData, inst.VarWordsLe},
@@ -69,54 +74,124 @@ func New(sets opcodes.Set) *As65 {
 
 		"cmap": {inst.TypeNone, a.ParseCMap, 0},
 
-		"DDB":   {inst.TypeData, a.ParseData, inst.VarWordsBe},
-		"ASC":   {inst.TypeData, a.ParseAscii, inst.VarAscii},
-		"DCI":   {inst.TypeData, a.ParseAscii, inst.VarAsciiFlip},
-		"HEX":   {inst.TypeData, a.ParseHexString, inst.VarBytes},
-		"PAGE":  {inst.TypeNone, nil, 0}, // New page
-		"TITLE": {inst.TypeNone, nil, 0}, // Title
-		"SBTL":  {inst.TypeNone, nil, 0}, // Subtitle
-		"SKP":   {inst.TypeNone, nil, 0}, // Skip lines
-		"REP":   {inst.TypeNone, nil, 0}, // Repeat character
-		"CHR":   {inst.TypeNone, nil, 0}, // Set repeated character
+		"include": {inst.TypeInclude, a.ParseInclude, 0},
+
+		"macro":  {inst.TypeMacroStart, a.MarkMacroStartParams, 0},
+		"endm":   {inst.TypeMacroEnd, a.ParseNoArgDir, 0},
+		"exitm":  {inst.TypeMacroExit, a.ParseNoArgDir, 0},
+		"if":     {inst.TypeIfdef, a.ParseDo, 0},
+		"ifdef":  {inst.TypeIfdef, a.ParseIfdef, 0},
+		"ifndef": {inst.TypeIfdef, a.ParseIfdef, 0},
+		"else":   {inst.TypeIfdefElse, a.ParseNoArgDir, 0},
+		"endif":  {inst.TypeIfdefEnd, a.ParseNoArgDir, 0},
+
+		"code": {inst.TypeSegment, a.ParseSegment, 0},
+		"data": {inst.TypeSegment, a.ParseSegment, 0},
+		"bss":  {inst.TypeSegment, a.ParseSegment, inst.VarSegmentBss},
+
+		"list":   {inst.TypeNone, a.ParseList, 0},
+		"nolist": {inst.TypeNone, a.ParseList, 0},
+		"page":   {inst.TypeNone, nil, 0}, // New page
+		"title":  {inst.TypeNone, nil, 0}, // Title
+		"subttl": {inst.TypeNone, nil, 0}, // Subtitle
+	}
+	// Directives are case-insensitive.
+	for name, d := range a.Directives {
+		a.Directives[strings.ToUpper(name)] = d
 	}
 
 	a.EquateDirectives = map[string]bool{
+		"=":   true,
+		"equ": true,
 		"EQU": true,
-		"EPZ": true,
 	}
 
 	a.Operators = map[string]expr.Operator{
-		"*": expr.OpMul,
-		"/": expr.OpDiv,
-		"+": expr.OpPlus,
-		"-": expr.OpMinus,
-		"<": expr.OpLt,
-		">": expr.OpGt,
-		"=": expr.OpEq,
+		"*":  expr.OpMul,
+		"/":  expr.OpDiv,
+		"%":  expr.OpMod,
+		"+":  expr.OpPlus,
+		"-":  expr.OpMinus,
+		"<<": expr.OpShl,
+		">>": expr.OpShr,
+		"<":  expr.OpLt,
+		">":  expr.OpGt,
+		"<=": expr.OpLe,
+		">=": expr.OpGe,
+		"=":  expr.OpEq,
+		"==": expr.OpEq,
+		"!=": expr.OpNe,
+		"&":  expr.OpAnd,
+		"^":  expr.OpXor,
+		"|":  expr.OpOr,
+		"&&": expr.OpLogAnd,
+		"||": expr.OpLogOr,
+	}
+
+	a.UnaryOperators = map[string]expr.Operator{
+		"~":  expr.OpNot,
+		"!":  expr.OpLogNot,
+		"lo": expr.OpLsb,
+		"LO": expr.OpLsb,
+		"hi": expr.OpMsb,
+		"HI": expr.OpMsb,
+	}
+
+	// As in C.
+	a.Precedence = map[expr.Operator]int{
+		expr.OpMul:    10,
+		expr.OpDiv:    10,
+		expr.OpMod:    10,
+		expr.OpPlus:   9,
+		expr.OpMinus:  9,
+		expr.OpShl:    8,
+		expr.OpShr:    8,
+		expr.OpLt:     7,
+		expr.OpGt:     7,
+		expr.OpLe:     7,
+		expr.OpGe:     7,
+		expr.OpEq:     6,
+		expr.OpNe:     6,
+		expr.OpAnd:    5,
+		expr.OpXor:    4,
+		expr.OpOr:     3,
+		expr.OpLogAnd: 2,
+		expr.OpLogOr:  1,
+		expr.OpLsb:    0, // "lo" and "hi" bind less tightly than anything
+		expr.OpMsb:    0,
 	}
 
 	a.InitContextFunc = func(ctx context.Context) {
 		ctx.SetOnOffDefaults(map[string]bool{
-			"MSB": true, // MSB defaults to true, as per manual
-			"LST": true, // Display listing: not used
+			"LST": true, // Display listing
 		})
+		a.cmap = defaultCMap
 	}
 
-	a.SetAsciiVariation = func(ctx context.Context, in *inst.I, lp *lines.Parse) {
-		if in.Command == "ASC" {
-			if ctx.Setting("MSB") {
-				in.Var = inst.VarAsciiHi
-			} else {
-				in.Var = inst.VarAscii
-			}
-			return
+	// ParseMacroCall parses a macro call: the macro's name, followed
+	// by comma-separated arguments, each positional, or "name=value".
+	a.ParseMacroCall = func(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, bool, error) {
+		if in.Command == "" || !ctx.HasMacroName(in.Command) {
+			return in, false, nil
 		}
-		if in.Command == "DCI" {
-			in.Var = inst.VarAsciiFlip
-		} else {
-			panic(fmt.Sprintf("Unknown ascii directive: '%s'", in.Command))
+		in.Type = inst.TypeMacroCall
+		lp.IgnoreRun(common.Whitespace)
+		if lp.Peek() == lines.Eol || lp.Peek() == a.CommentChar {
+			return in, true, nil
+		}
+		for {
+			s, err := a.ParseMacroArg(in, lp)
+			if err != nil {
+				return in, true, err
+			}
+			in.MacroArgs = append(in.MacroArgs, s)
+			lp.IgnoreRun(common.Whitespace)
+			if !lp.Consume(",") {
+				break
+			}
+			lp.IgnoreRun(common.Whitespace)
 		}
+		return in, true, nil
 	}
 
 	a.FixLabel = a.DefaultFixLabel
@@ -125,23 +200,237 @@ func New(sets opcodes.Set) *As65 {
 	return a
 }
 
-// ParseCMap parses a character mapping.
-// TODO(zellyn): implement
+// ParseInstr parses an instruction, marking it unlisted if listing is
+// turned off.
+func (a *As65) ParseInstr(ctx context.Context, line lines.Line, mode flavors.ParseMode) (inst.I, error) {
+	in, err := a.Base.ParseInstr(ctx, line, mode)
+	if err != nil || mode != flavors.ParseModeNormal {
+		return in, err
+	}
+	in.Unlisted = !ctx.Setting("LST")
+	return in, nil
+}
+
+var macroArgRe = regexp.MustCompile(`\\(\??)([0-9]|#|[A-Za-z_][A-Za-z0-9_.]*)`)
+
+// ReplaceMacroArgs replaces macro arguments: \1 to \9 by position,
+// \name by name, and \# by the number of arguments passed. \?1 and
+// \?name are 1 if the argument was passed, and 0 if not: defaults
+// don't count.
+func (a *As65) ReplaceMacroArgs(line string, args []string, kwargs map[string]string, params []string, passed int) (string, error) {
+	return macroArgRe.ReplaceAllStringFunc(line, func(s string) string {
+		m := macroArgRe.FindStringSubmatch(s)
+		present, ref := m[1] == "?", m[2]
+		var value string
+		var ok bool
+		n := 0
+		switch {
+		case ref == "#":
+			return strconv.Itoa(passed)
+		case ref[0] >= '0' && ref[0] <= '9':
+			n, _ = strconv.Atoi(ref)
+			if n > 0 && n <= len(args) {
+				value, ok = args[n-1], true
+			}
+		default:
+			value, ok = kwargs[ref]
+			if !ok && !present {
+				return s
+			}
+			for i, p := range params {
+				if p == ref {
+					n = i + 1
+				}
+			}
+		}
+		if present {
+			if ok && n > 0 && n <= passed && value != "" {
+				return "1"
+			}
+			return "0"
+		}
+		return value
+	}), nil
+}
+
+// stringEscapes are the characters that may follow a backslash in a
+// string, and what they stand for.
+var stringEscapes = map[rune]byte{
+	'a':  0x07,
+	'b':  0x08,
+	'f':  0x0c,
+	'n':  0x0a,
+	'r':  0x0d,
+	't':  0x09,
+	'v':  0x0b,
+	'0':  0x00,
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+}
+
+// parseString parses a double-quoted string, with C-style escapes,
+// mapping its characters through the character map. We expect to be
+// looking at the opening quote.
+func (a *As65) parseString(in inst.I, lp *lines.Parse) ([]byte, error) {
+	lp.Consume(`"`)
+	var bs []byte
+	for {
+		c := lp.Next()
+		switch c {
+		case lines.Eol:
+			return nil, in.Errorf("%s: expected closing quote", in.Command)
+		case '"':
+			lp.Ignore()
+			return bs, nil
+		case '\\':
+			e := lp.Next()
+			b, ok := stringEscapes[e]
+			if !ok {
+				return nil, in.Errorf(`%s: unknown escape in string: '\%c'`, in.Command, e)
+			}
+			bs = append(bs, a.cmap[b])
+		default:
+			bs = append(bs, a.cmap[byte(c)])
+		}
+	}
+}
+
+// ParseCMap parses a character mapping: "cmap" on its own resets the
+// mapping; "cmap 'c', value" maps the character c to value, and
+// `cmap "chars", value` maps each of chars to successive values.
 func (a *As65) ParseCMap(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in.Width = 0
+	in.Final = true
 	lp.IgnoreRun(common.Whitespace)
 	if lp.Peek() == lines.Eol || lp.Peek() == a.CommentChar {
 		a.cmap = defaultCMap
 		return in, nil
 	}
-	return in, errors.New("TODO(zellyn): parse cmap instruction")
+	var chars []byte
+	if lp.Peek() == '"' {
+		s, err := a.parseString(in, lp)
+		if err != nil {
+			return in, err
+		}
+		chars = s
+	} else {
+		in, err := a.ParseDo(ctx, in, lp)
+		if err != nil {
+			return in, err
+		}
+		c, err := in.Exprs[0].Eval(ctx, in.Line)
+		if err != nil {
+			return in, in.Errorf("cmap: cannot evaluate character: %v", err)
+		}
+		chars = []byte{byte(c)}
+		in.Exprs = nil
+	}
+	lp.IgnoreRun(common.Whitespace)
+	if !lp.Consume(",") {
+		return in, in.Errorf("cmap expects characters, then the value to map them to")
+	}
+	in, err := a.ParseDo(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	val, err := in.Exprs[0].Eval(ctx, in.Line)
+	if err != nil {
+		return in, in.Errorf("cmap: cannot evaluate value: %v", err)
+	}
+	in.Exprs = nil
+	for i, c := range chars {
+		a.cmap[c] = byte(val + int64(i))
+	}
+	return in, nil
 }
 
-// ParseBytes parses a db instruction.
+// ParseBytes parses db, fcb, and fcc, whose arguments may be
+// expressions or strings.
 func (a *As65) ParseBytes(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	for {
+		lp.IgnoreRun(common.Whitespace)
+		if lp.Peek() == '"' {
+			bs, err := a.parseString(in, lp)
+			if err != nil {
+				return in, err
+			}
+			for _, b := range bs {
+				in.Exprs = append(in.Exprs, &expr.E{Op: expr.OpLeaf, Val: int64(b)})
+			}
+		} else {
+			var err error
+			if in, err = a.ParseDo(ctx, in, lp); err != nil {
+				return in, err
+			}
+		}
+		lp.IgnoreRun(common.Whitespace)
+		if !lp.Consume(",") {
+			break
+		}
+	}
+	in.Width = uint16(len(in.Exprs))
+	in.Final = true
+	for _, e := range in.Exprs {
+		val, err := e.Eval(ctx, in.Line)
+		if err != nil {
+			in.Final = false
+			in.Data = nil
+			break
+		}
+		in.Data = append(in.Data, byte(val))
+	}
+	return in, nil
+}
+
+// ParseInclude parses `include "filename"`: the quotes are optional.
+func (a *As65) ParseInclude(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
 	lp.IgnoreRun(common.Whitespace)
+	if lp.Consume(`"`) {
+		lp.Ignore()
+		lp.AcceptUntil(`"`)
+		in.TextArg = lp.Emit()
+		if !lp.Consume(`"`) {
+			return in, in.Errorf("%s: expected closing quote", in.Command)
+		}
+	} else {
+		if !lp.AcceptUntil(common.Whitespace + string(a.CommentChar)) {
+			return in, in.Errorf("%s expects a filename", in.Command)
+		}
+		in.TextArg = lp.Emit()
+	}
+	in.Width = 0
+	in.Final = true
 	return in, nil
 }
 
-func (a *As65) ParseByteList(lp *lines.Parse, cmap *[256]byte) (bytes []byte, final bool, err error) {
-	return nil, false, nil
+// ParseIfdef parses "ifdef label" and "ifndef label".
+func (a *As65) ParseIfdef(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := a.ParseDo(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	label := in.Exprs[0]
+	if label.Op != expr.OpLeaf || label.Text == "" {
+		return in, in.Errorf("%s expects a label", in.Command)
+	}
+	_, err = label.Eval(ctx, in.Line)
+	var val int64
+	if (err == nil) == strings.EqualFold(in.Command, "ifdef") {
+		val = 1
+	}
+	in.Exprs[0] = &expr.E{Op: expr.OpLeaf, Val: val}
+	return in, nil
+}
+
+// ParseList parses list and nolist, which turn the listing on and off.
+func (a *As65) ParseList(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	if strings.EqualFold(in.Command, "list") {
+		ctx.SettingOn("LST")
+	} else {
+		ctx.SettingOff("LST")
+	}
+	in.Width = 0
+	in.Final = true
+	return in, nil
 }
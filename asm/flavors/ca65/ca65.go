@@ -0,0 +1,590 @@
+package ca65
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zellyn/go6502/asm/context"
+	"github.com/zellyn/go6502/asm/expr"
+	"github.com/zellyn/go6502/asm/flavors"
+	"github.com/zellyn/go6502/asm/flavors/common"
+	"github.com/zellyn/go6502/asm/inst"
+	"github.com/zellyn/go6502/asm/lines"
+	"github.com/zellyn/go6502/asm/opcodes"
+)
+
+// Ca65 implements a ca65-compatible assembler flavor, for the subset
+// of ca65 used by single-file projects.
+// See https://cc65.github.io/doc/ca65.html
+type Ca65 struct {
+	common.Base
+	scopes     []scope         // open scopes, innermost last
+	scopeNames map[string]bool // full names of scopes so far
+	anonScopes int             // scopes without names so far
+	unnamed    int             // unnamed labels defined so far
+}
+
+// scope is a ".proc" or ".scope" scope.
+type scope struct {
+	name string
+	proc bool
+}
+
+const whitespace = " \t"
+
+func New(sets opcodes.Set) *Ca65 {
+	a := &Ca65{}
+	a.Name = "ca65"
+	a.OpcodesByName = opcodes.ByName(sets)
+	a.LabelChars = common.Letters + common.Digits + "@"
+	a.LabelColons = common.ReqOptional
+	a.ExplicitARegister = common.ReqOptional
+	a.CommandsStartLines = true
+	a.SpacesInExpressions = true
+	a.Parentheses = true
+	a.CommentChar = ';'
+	a.BinaryChar = '%'
+	a.ImmediateChars = "#"
+	a.CharChars = "'"
+	a.MacroArgSep = ","
+	a.AnonymousPrefix = ":"
+	a.ScopeSeparator = "::"
+
+	a.Directives = map[string]common.DirectiveInfo{
+		"=":          {inst.TypeEqu, a.ParseEquate, inst.VarEquNormal},
+		".org":       {inst.TypeOrg, a.ParseOrg, 0},
+		".byte":      {inst.TypeData, a.ParseBytes, inst.VarBytes},
+		".byt":       {inst.TypeData, a.ParseBytes, inst.VarBytes},
+		".asciiz":    {inst.TypeData, a.ParseBytes, inst.VarBytes},
+		".word":      {inst.TypeData, a.ParseData, inst.VarWordsLe},
+		".addr":      {inst.TypeData, a.ParseData, inst.VarWordsLe},
+		".dbyt":      {inst.TypeData, a.ParseData, inst.VarWordsBe},
+		".res":       {inst.TypeData, a.ParseRes, inst.VarBytes},
+		".include":   {inst.TypeInclude, a.ParseInclude, 0},
+		".incbin":    {inst.TypeInclude, a.ParseIncbin, inst.VarIncludeBinary},
+		".if":        {inst.TypeIfdef, a.ParseDo, 0},
+		".ifdef":     {inst.TypeIfdef, a.ParseIfdef, 0},
+		".ifndef":    {inst.TypeIfdef, a.ParseIfdef, 0},
+		".ifblank":   {inst.TypeIfdef, a.ParseIfblank, 0},
+		".ifnblank":  {inst.TypeIfdef, a.ParseIfblank, 0},
+		".elseif":    {inst.TypeIfdefElse, a.ParseDo, inst.VarElseIf},
+		".else":      {inst.TypeIfdefElse, a.ParseNoArgDir, 0},
+		".endif":     {inst.TypeIfdefEnd, a.ParseNoArgDir, 0},
+		".macro":     {inst.TypeMacroStart, a.ParseMacroDef, 0},
+		".mac":       {inst.TypeMacroStart, a.ParseMacroDef, 0},
+		".endmacro":  {inst.TypeMacroEnd, a.ParseNoArgDir, 0},
+		".endmac":    {inst.TypeMacroEnd, a.ParseNoArgDir, 0},
+		".exitmacro": {inst.TypeMacroExit, a.ParseNoArgDir, 0},
+		".exitmac":   {inst.TypeMacroExit, a.ParseNoArgDir, 0},
+		".proc":      {inst.TypeNone, a.ParseScope, 0},
+		".endproc":   {inst.TypeNone, a.ParseEndScope, 0},
+		".scope":     {inst.TypeNone, a.ParseScope, 0},
+		".endscope":  {inst.TypeNone, a.ParseEndScope, 0},
+		".segment":   {inst.TypeSegment, a.ParseSegment, 0},
+		".code":      {inst.TypeSegment, a.ParseSegment, 0},
+		".rodata":    {inst.TypeSegment, a.ParseSegment, 0},
+		".data":      {inst.TypeSegment, a.ParseSegment, 0},
+		".bss":       {inst.TypeSegment, a.ParseSegment, inst.VarSegmentBss},
+		".zeropage":  {inst.TypeSegment, a.ParseSegment, inst.VarSegmentBss},
+		".end":       {inst.TypeEnd, a.ParseNoArgDir, 0},
+	}
+	// Directives are case-insensitive.
+	for name, d := range a.Directives {
+		a.Directives[strings.ToUpper(name)] = d
+	}
+
+	a.EquateDirectives = map[string]bool{
+		"=": true,
+	}
+
+	a.Operators = map[string]expr.Operator{
+		"*":       expr.OpMul,
+		"/":       expr.OpDiv,
+		".mod":    expr.OpMod,
+		"&":       expr.OpAnd,
+		".bitand": expr.OpAnd,
+		"^":       expr.OpXor,
+		".bitxor": expr.OpXor,
+		"<<":      expr.OpShl,
+		".shl":    expr.OpShl,
+		">>":      expr.OpShr,
+		".shr":    expr.OpShr,
+		"+":       expr.OpPlus,
+		"-":       expr.OpMinus,
+		"|":       expr.OpOr,
+		".bitor":  expr.OpOr,
+		"=":       expr.OpEq,
+		"<>":      expr.OpNe,
+		"<":       expr.OpLt,
+		">":       expr.OpGt,
+		"<=":      expr.OpLe,
+		">=":      expr.OpGe,
+		"&&":      expr.OpLogAnd,
+		".and":    expr.OpLogAnd,
+		".xor":    expr.OpLogXor,
+		"||":      expr.OpLogOr,
+		".or":     expr.OpLogOr,
+	}
+
+	a.UnaryOperators = map[string]expr.Operator{
+		"+":         expr.OpInt,
+		"~":         expr.OpNot,
+		".bitnot":   expr.OpNot,
+		"<":         expr.OpLsb,
+		".lobyte":   expr.OpLsb,
+		">":         expr.OpMsb,
+		".hibyte":   expr.OpMsb,
+		"^":         expr.OpBank,
+		".bankbyte": expr.OpBank,
+		"!":         expr.OpLogNot,
+		".not":      expr.OpLogNot,
+	}
+
+	// Keyword operators are case-insensitive too.
+	for _, ops := range []map[string]expr.Operator{a.Operators, a.UnaryOperators} {
+		for name, op := range ops {
+			ops[strings.ToUpper(name)] = op
+		}
+	}
+
+	a.Precedence = map[expr.Operator]int{
+		expr.OpMul:    5,
+		expr.OpDiv:    5,
+		expr.OpMod:    5,
+		expr.OpAnd:    5,
+		expr.OpXor:    5,
+		expr.OpShl:    5,
+		expr.OpShr:    5,
+		expr.OpPlus:   4,
+		expr.OpMinus:  4,
+		expr.OpOr:     4,
+		expr.OpEq:     3,
+		expr.OpNe:     3,
+		expr.OpLt:     3,
+		expr.OpGt:     3,
+		expr.OpLe:     3,
+		expr.OpGe:     3,
+		expr.OpLogAnd: 2,
+		expr.OpLogXor: 2,
+		expr.OpLogOr:  1,
+		expr.OpLogNot: 0, // binds less tightly than anything
+	}
+
+	a.InitContextFunc = func(ctx context.Context) {
+		a.scopes = nil
+		a.scopeNames = make(map[string]bool)
+		a.anonScopes = 0
+		a.unnamed = 0
+	}
+
+	a.ParseMacroCall = func(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, bool, error) {
+		if !ctx.HasMacroName(in.Command) {
+			return in, false, nil
+		}
+		in.Type = inst.TypeMacroCall
+		args, err := a.parseMacroArgs(in, lp)
+		in.MacroArgs = args
+		return in, true, err
+	}
+
+	a.FixLabel = func(ctx context.Context, label string) (string, error) {
+		switch {
+		case label == "" || label == "a" || label == "A":
+			return label, nil
+		case strings.HasPrefix(label, "::"):
+			return label[2:], nil
+		case strings.Contains(label, "::"):
+			return a.qualified(label), nil
+		case label[0] == '@':
+			if last := ctx.LastLabel(); last == "" {
+				return "", fmt.Errorf("cheap local label '%s' without previous label", label)
+			} else {
+				return last + label, nil
+			}
+		}
+		return a.scoped(label), nil
+	}
+
+	a.IsNewParentLabel = func(label string) bool {
+		return label != "" && label[0] != '@'
+	}
+
+	// AnonymousLabel names the unnamed label a reference refers to:
+	// ":-" is the last one, ":--" the one before, ":+" the next, and
+	// so on.
+	a.AnonymousLabel = func(ref string) string {
+		if ref[0] == '-' {
+			return unnamedLabel(a.unnamed - len(ref))
+		}
+		return unnamedLabel(a.unnamed + len(ref) - 1)
+	}
+
+	return a
+}
+
+// unnamedLabel names the i'th unnamed label.
+func unnamedLabel(i int) string {
+	return fmt.Sprintf(":%d", i)
+}
+
+// scoped qualifies a label with the current scope's name.
+func (a *Ca65) scoped(label string) string {
+	return scopedIn(a.scopes, label)
+}
+
+// scopedIn qualifies a label with the name of the given scope.
+func scopedIn(scopes []scope, label string) string {
+	for i := len(scopes) - 1; i >= 0; i-- {
+		label = scopes[i].name + "::" + label
+	}
+	return label
+}
+
+// qualified resolves a label qualified by scopes, like "outer::x": the
+// first scope is looked for in the current scope, then the enclosing
+// ones. Scopes not seen yet are taken to be global.
+func (a *Ca65) qualified(label string) string {
+	first := label[:strings.Index(label, "::")]
+	for i := len(a.scopes); i > 0; i-- {
+		if a.scopeNames[scopedIn(a.scopes[:i], first)] {
+			return scopedIn(a.scopes[:i], label)
+		}
+	}
+	return label
+}
+
+// ParseInstr parses an instruction, handling ca65's unnamed labels,
+// and leaving the rest to Base.
+func (a *Ca65) ParseInstr(ctx context.Context, line lines.Line, mode flavors.ParseMode) (inst.I, error) {
+	lp := line.Parse
+	unnamed := false
+	if rest := lp.Rest(); strings.HasPrefix(rest, ":") && !strings.HasPrefix(rest, ":=") {
+		lp.Consume(":")
+		unnamed = true
+	}
+	in, err := a.Base.ParseInstr(ctx, line, mode)
+	if err != nil {
+		return in, err
+	}
+	if unnamed && mode == flavors.ParseModeNormal {
+		in.Label = unnamedLabel(a.unnamed)
+		a.unnamed++
+		ctx.Set(in.Label, int64(ctx.GetAddr()))
+	}
+	return in, nil
+}
+
+// ParseEquate parses "label = value", in the current scope.
+func (a *Ca65) ParseEquate(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	label, err := a.FixLabel(ctx, in.Label)
+	if err != nil {
+		return in, in.Errorf("%v", err)
+	}
+	in.Label = label
+	return a.Base.ParseEquate(ctx, in, lp)
+}
+
+// parseString parses a double-quoted string. We expect to be looking
+// at the opening quote.
+func (a *Ca65) parseString(in inst.I, lp *lines.Parse) (string, error) {
+	lp.Consume(`"`)
+	lp.AcceptUntil(`"`)
+	s := lp.Emit()
+	if !lp.Consume(`"`) {
+		return "", in.Errorf("%s: expected closing quote", in.Command)
+	}
+	return s, nil
+}
+
+// ParseBytes parses ".byte" and ".asciiz", whose arguments may be
+// expressions or strings. ".asciiz" adds a zero byte at the end.
+func (a *Ca65) ParseBytes(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	for {
+		lp.IgnoreRun(whitespace)
+		if lp.Peek() == '"' {
+			s, err := a.parseString(in, lp)
+			if err != nil {
+				return in, err
+			}
+			for _, c := range []byte(s) {
+				in.Exprs = append(in.Exprs, &expr.E{Op: expr.OpLeaf, Val: int64(c)})
+			}
+		} else {
+			var err error
+			if in, err = a.ParseDo(ctx, in, lp); err != nil {
+				return in, err
+			}
+		}
+		lp.IgnoreRun(whitespace)
+		if !lp.Consume(",") {
+			break
+		}
+	}
+	if strings.EqualFold(in.Command, ".asciiz") {
+		in.Exprs = append(in.Exprs, &expr.E{Op: expr.OpLeaf, Val: 0})
+	}
+	in.Width = uint16(len(in.Exprs))
+	in.Final = true
+	for _, e := range in.Exprs {
+		val, err := e.Eval(ctx, in.Line)
+		if err != nil {
+			in.Final = false
+			in.Data = nil
+			break
+		}
+		in.Data = append(in.Data, byte(val))
+	}
+	return in, nil
+}
+
+// ParseRes parses ".res count [, fill]".
+func (a *Ca65) ParseRes(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	var vals []int64
+	for {
+		var err error
+		if in, err = a.ParseDo(ctx, in, lp); err != nil {
+			return in, err
+		}
+		val, err := in.Exprs[len(in.Exprs)-1].Eval(ctx, in.Line)
+		if err != nil {
+			return in, in.Errorf("cannot evaluate %s argument on first pass: %v", in.Command, err)
+		}
+		vals = append(vals, val)
+		lp.IgnoreRun(whitespace)
+		if !lp.Consume(",") {
+			break
+		}
+	}
+	if len(vals) > 2 {
+		return in, in.Errorf("%s expects a count, and an optional fill value", in.Command)
+	}
+	vals = append(vals, 0)
+	if vals[0] < 0 || vals[0] > 0xffff {
+		return in, in.Errorf("%s: bad size: %d", in.Command, vals[0])
+	}
+	in.Data = make([]byte, vals[0])
+	for i := range in.Data {
+		in.Data[i] = byte(vals[1])
+	}
+	in.Width = uint16(vals[0])
+	in.Final = true
+	return in, nil
+}
+
+// ParseInclude parses `.include "filename"`.
+func (a *Ca65) ParseInclude(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	s, err := a.parseString(in, lp)
+	if err != nil {
+		return in, err
+	}
+	in.TextArg = s
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseIncbin parses `.incbin "filename" [, start [, size]]`, leaving
+// the reading to the assembler.
+func (a *Ca65) ParseIncbin(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := a.ParseInclude(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	for lp.IgnoreRun(whitespace); lp.Consume(","); lp.IgnoreRun(whitespace) {
+		if in, err = a.ParseDo(ctx, in, lp); err != nil {
+			return in, err
+		}
+	}
+	switch len(in.Exprs) {
+	case 0:
+		in.Exprs = append(in.Exprs, &expr.E{Op: expr.OpLeaf})
+	case 1, 2:
+	default:
+		return in, in.Errorf(".incbin expects a filename, and optional start and size")
+	}
+	in.Final = false
+	return in, nil
+}
+
+// ParseIfdef parses ".ifdef label" and ".ifndef label".
+func (a *Ca65) ParseIfdef(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := a.ParseDo(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	label := in.Exprs[0]
+	if label.Op != expr.OpLeaf || label.Text == "" {
+		return in, in.Errorf("%s expects a label", in.Command)
+	}
+	_, err = label.Eval(ctx, in.Line)
+	var val int64
+	if (err == nil) == strings.EqualFold(in.Command, ".ifdef") {
+		val = 1
+	}
+	in.Exprs[0] = &expr.E{Op: expr.OpLeaf, Val: val}
+	return in, nil
+}
+
+// ParseIfblank parses ".ifblank" and ".ifnblank", which test whether
+// anything follows them: usually, a macro argument.
+func (a *Ca65) ParseIfblank(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	blank := lp.Peek() == lines.Eol || lp.Peek() == a.CommentChar
+	var val int64
+	if blank == strings.EqualFold(in.Command, ".ifblank") {
+		val = 1
+	}
+	in.Exprs = append(in.Exprs, &expr.E{Op: expr.OpLeaf, Val: val})
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseScope parses ".proc name", which defines the label name and
+// starts a scope of the same name, and ".scope [name]", which just
+// starts a scope.
+func (a *Ca65) ParseScope(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	proc := strings.EqualFold(in.Command, ".proc")
+	lp.IgnoreRun(whitespace)
+	if !lp.AcceptRun(a.LabelChars) && proc {
+		return in, in.Errorf(".proc expects a name, found '%c'", lp.Next())
+	}
+	name := lp.Emit()
+	if name == "" {
+		a.anonScopes++
+		name = fmt.Sprintf("{scope %d}", a.anonScopes)
+	}
+	if proc {
+		label := a.scoped(name)
+		if val, ok := ctx.Get(label); ok && val != int64(ctx.GetAddr()) {
+			return in, in.Errorf("Trying to set label '%s' to $%04x, but it already has value $%04x", label, ctx.GetAddr(), val)
+		}
+		ctx.Set(label, int64(ctx.GetAddr()))
+		ctx.SetLastLabel(label)
+	}
+	a.scopeNames[a.scoped(name)] = true
+	a.scopes = append(a.scopes, scope{name, proc})
+	in.TextArg = name
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseEndScope parses ".endproc" and ".endscope".
+func (a *Ca65) ParseEndScope(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	proc := strings.EqualFold(in.Command, ".endproc")
+	if len(a.scopes) == 0 || a.scopes[len(a.scopes)-1].proc != proc {
+		return in, in.Errorf("%s without matching start", in.Command)
+	}
+	a.scopes = a.scopes[:len(a.scopes)-1]
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseSegment parses `.segment "NAME"`, and the shortcuts for the
+// usual segments, like ".code" and ".bss". Segment names are
+// lowercased, to match the assembler's default "code" segment; the
+// "BSS" and "ZEROPAGE" segments only reserve space.
+func (a *Ca65) ParseSegment(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	name := strings.TrimPrefix(in.Command, ".")
+	if strings.EqualFold(name, "segment") {
+		lp.IgnoreRun(whitespace)
+		s, err := a.parseString(in, lp)
+		if err != nil {
+			return in, err
+		}
+		name = s
+	}
+	in.TextArg = strings.ToLower(name)
+	if in.TextArg == "bss" || in.TextArg == "zeropage" {
+		in.Var = inst.VarSegmentBss
+	}
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseMacroDef parses a macro definition: ".macro name param1, param2".
+// Parameters left out of a call are blank.
+func (a *Ca65) ParseMacroDef(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := a.ParseMacroStart(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	for lp.IgnoreRun(whitespace); lp.Peek() != lines.Eol && lp.Peek() != a.CommentChar; lp.IgnoreRun(whitespace) {
+		if !lp.AcceptRun(a.LabelChars) {
+			return in, in.Errorf("expecting macro parameter name, found '%c'", lp.Next())
+		}
+		in.MacroArgs = append(in.MacroArgs, lp.Emit()+"=")
+		lp.IgnoreRun(whitespace)
+		if !lp.Consume(",") {
+			break
+		}
+	}
+	return in, nil
+}
+
+// parseMacroArgs parses a macro call's arguments: comma-separated,
+// except within parentheses or curly braces. Curly braces around an
+// argument are removed.
+func (a *Ca65) parseMacroArgs(in inst.I, lp *lines.Parse) ([]string, error) {
+	rest := lp.Rest()
+	var args []string
+	depth, start := 0, 0
+	quote := rune(0)
+	end := func(i int) {
+		arg := strings.TrimSpace(rest[start:i])
+		if strings.HasPrefix(arg, "{") && strings.HasSuffix(arg, "}") {
+			arg = arg[1 : len(arg)-1]
+		}
+		args = append(args, arg)
+	}
+chars:
+	for i, c := range rest {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			end(i)
+			start = i + 1
+		case c == a.CommentChar:
+			rest = rest[:i]
+			break chars
+		}
+	}
+	if depth != 0 {
+		return nil, in.Errorf("unbalanced parentheses or braces in macro arguments")
+	}
+	if strings.TrimSpace(rest[start:]) != "" || len(args) > 0 {
+		end(len(rest))
+	}
+	return args, nil
+}
+
+var identRe = regexp.MustCompile(`[.@]?[A-Za-z_][A-Za-z0-9_]*`)
+
+// ReplaceMacroArgs replaces macro parameters' names with their
+// arguments, outside strings.
+func (a *Ca65) ReplaceMacroArgs(line string, args []string, kwargs map[string]string, params []string, passed int) (string, error) {
+	parts := strings.Split(line, `"`)
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = identRe.ReplaceAllStringFunc(parts[i], func(s string) string {
+			if v, ok := kwargs[s]; ok {
+				return v
+			}
+			return s
+		})
+	}
+	return strings.Join(parts, `"`), nil
+}
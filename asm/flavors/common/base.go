@@ -30,6 +30,15 @@ type DirectiveInfo struct {
 	Var  inst.Variant
 }
 
+// force says how an op's argument width was forced.
+type force int
+
+const (
+	forceNone force = iota
+	forceWide       // absolute, not zero page
+	forceLong       // long (65816)
+)
+
 type Requiredness int
 
 const (
@@ -53,19 +62,32 @@ type Base struct {
 	SpacesForComment    int  // this many spaces after command means it's the comment field
 	StringEndOptional   bool // can omit closing delimeter from string args?
 	SuffixForWide       bool // is eg. "LDA:" a force-wide on "LDA"? (Merlin)
+	CommandsStartLines  bool // can ops and directives start lines, where labels usually go? (ACME)
+	SpacesInExpressions bool // can expressions contain spaces?
+	Parentheses         bool // can expressions group terms with parentheses?
 	CommentChar         rune
 	BinaryChar          rune
 	MsbChars            string
 	LsbChars            string
+	BankChars           string // prefixes for the bank byte (bits 16-23) of a value
 	ImmediateChars      string
-	operatorChars       string
 	CharChars           string
 	InvCharChars        string
 	MacroArgSep         string
+	ExtraCmdChars       string                   // characters commands can use beyond cmdChars, like ACME's "!" and "+"
+	Precedence          map[expr.Operator]int    // binding strength of binary operators; nil means strictly left to right
+	Functions           map[string]expr.Operator // functions, like "int(x)"; OpUnknown for unsupported ones
+	UnaryOperators      map[string]expr.Operator // prefix operators within terms, like "~"; a Precedence entry says how much they take
 	ExtraCommenty       func(string) bool
 	SetAsciiVariation   func(context.Context, *inst.I, *lines.Parse)
 	ParseMacroCall      func(context.Context, inst.I, *lines.Parse) (inst.I, bool, error)
+	WideImmediate       func(cmd string) bool               // does the op take a two-byte immediate argument? (65816)
+	WideArg             func(context.Context, *expr.E) bool // must the op's address be two bytes, whatever its value? (EDASM's relocatable code)
 	IsNewParentLabel    func(label string) bool
+	IsVariable          func(label string) bool // can the label be redefined? (eg. Merlin's "]LOOP")
+	AnonymousLabel      func(ref string) string // the label a run of +'s or -'s refers to, for flavors that have them (ACME)
+	AnonymousPrefix     string                  // what anonymous label references start with, if anything (ca65's ":")
+	ScopeSeparator      string                  // separates scopes in qualified label names, if they have them (ca65's "::")
 	InitContextFunc     func(context.Context)
 	FixLabel            func(context.Context, string) (string, error)
 	LocalMacroLabelsVal bool
@@ -116,7 +138,7 @@ func (a *Base) ParseInstr(ctx context.Context, line lines.Line, mode flavors.Par
 	}
 
 	// See if we have a label at the start
-	if lp.AcceptRun(a.LabelChars) {
+	if !a.startsWithCommand(ctx, lp.Rest()) && lp.AcceptRun(a.LabelChars) {
 		in.Label = lp.Emit()
 
 		// Some need colons after labels, some allow them.
@@ -147,6 +169,18 @@ func (a *Base) ParseInstr(ctx context.Context, line lines.Line, mode flavors.Par
 	return a.parseCmd(ctx, in, lp, mode)
 }
 
+// startsWithCommand says whether, for flavors where ops, directives,
+// and macro calls can start lines, s starts with one.
+func (a *Base) startsWithCommand(ctx context.Context, s string) bool {
+	if !a.CommandsStartLines {
+		return false
+	}
+	word := s[:len(s)-len(strings.TrimLeft(s, a.LabelChars))]
+	_, op := a.OpcodesByName[strings.ToUpper(word)]
+	_, dir := a.Directives[word]
+	return op || dir || ctx.HasMacroName(word)
+}
+
 func (a *Base) handleLabel(ctx context.Context, in inst.I) error {
 	if in.Label == "" {
 		return nil
@@ -166,7 +200,7 @@ func (a *Base) handleLabel(ctx context.Context, in inst.I) error {
 	}
 
 	lval, lok := ctx.Get(in.Label)
-	if lok && int64(addr) != lval {
+	if lok && int64(addr) != lval && !a.isVariable(in.Label) {
 		return in.Errorf("Trying to set label '%s' to $%04x, but it already has value $%04x", in.Label, addr, lval)
 	}
 	ctx.Set(in.Label, int64(addr))
@@ -176,7 +210,7 @@ func (a *Base) handleLabel(ctx context.Context, in inst.I) error {
 // parseCmd parses the "command" part of an instruction: we expect to be
 // looking at a non-whitespace character.
 func (a *Base) parseCmd(ctx context.Context, in inst.I, lp *lines.Parse, mode flavors.ParseMode) (inst.I, error) {
-	if !lp.AcceptRun(cmdChars) && !(a.Directives["="].Func != nil && lp.Accept("=")) {
+	if !lp.AcceptRun(cmdChars+a.ExtraCmdChars) && !(a.Directives["="].Func != nil && lp.Accept("=")) {
 		c := lp.Next()
 		return in, in.Errorf("expecting instruction, found '%c' (%d)", c, c)
 	}
@@ -200,6 +234,11 @@ func (a *Base) parseCmd(ctx context.Context, in inst.I, lp *lines.Parse, mode fl
 					return in, err
 				}
 			}
+			if dir.Var == inst.VarElseIf {
+				// We need the condition.
+				in.Var = dir.Var
+				return dir.Func(ctx, in, lp)
+			}
 		}
 		return in, nil
 	}
@@ -242,16 +281,20 @@ func (a *Base) parseCmd(ctx context.Context, in inst.I, lp *lines.Parse, mode fl
 	upperCmd := strings.ToUpper(in.Command)
 	if summary, ok := a.OpcodesByName[upperCmd]; ok {
 		in.Type = inst.TypeOp
-		return a.parseOpArgs(ctx, in, lp, summary, false)
+		return a.parseOpArgs(ctx, in, lp, summary, forceNone)
 	}
 
-	// Merlin lets you say "LDA:" or "LDA@" or "LDAZ" to force non-zero-page.
+	// Merlin lets you say "LDA:" or "LDA@" or "LDAZ" to force
+	// non-zero-page, and, for the 65816, "LDAL" to force long.
 	if a.SuffixForWide && len(in.Command) == 4 {
 		prefix := in.Command[:len(in.Command)-1]
 		if summary, ok := a.OpcodesByName[prefix]; ok {
 			in.Command = prefix
 			in.Type = inst.TypeOp
-			return a.parseOpArgs(ctx, in, lp, summary, true)
+			if upperCmd[3] == 'L' && summary.AnyModes(opcodes.MODE_ABS_LONG|opcodes.MODE_ABS_LONG_X) {
+				return a.parseOpArgs(ctx, in, lp, summary, forceLong)
+			}
+			return a.parseOpArgs(ctx, in, lp, summary, forceWide)
 		}
 	}
 
@@ -319,7 +362,7 @@ func (a *Base) parseQuoted(in inst.I, lp *lines.Parse) (string, error) {
 
 // parseOpArgs parses the arguments to an assembly op. We expect to be
 // looking at the first non-op character (probably whitespace)
-func (a *Base) parseOpArgs(ctx context.Context, in inst.I, lp *lines.Parse, summary opcodes.OpSummary, forceWide bool) (inst.I, error) {
+func (a *Base) parseOpArgs(ctx context.Context, in inst.I, lp *lines.Parse, summary opcodes.OpSummary, f force) (inst.I, error) {
 
 	// MODE_IMPLIED: we don't really care what comes next: it's a comment.
 	if summary.Modes == opcodes.MODE_IMPLIED {
@@ -356,16 +399,35 @@ func (a *Base) parseOpArgs(ctx context.Context, in inst.I, lp *lines.Parse, summ
 		return in, nil
 	}
 
-	indirect := lp.Consume("(")
+	if summary.Modes == opcodes.MODE_BLOCK_MOVE {
+		return a.parseBlockMove(ctx, in, lp, summary)
+	}
+
+	// 65816: ">" forces long addressing, and "|" or "!" absolute.
+	if f == forceNone && summary.AnyModes(opcodes.MODE_ABS_LONG|opcodes.MODE_ABS_LONG_X) {
+		switch {
+		case lp.Consume(">"):
+			f = forceLong
+		case lp.Consume("|!"):
+			f = forceWide
+		}
+	}
+
+	longIndirect := lp.Consume("[")
+	if longIndirect && !summary.AnyModes(opcodes.MODE_INDIRECT_LONG_ANY) {
+		return in, in.Errorf("%s doesn't support any long indirect modes", in.Command)
+	}
+	indirect := !longIndirect && lp.Consume("(")
 	if indirect && !summary.AnyModes(opcodes.MODE_INDIRECT_ANY) {
 		return in, in.Errorf("%s doesn't support any indirect modes", in.Command)
 	}
 	xy := '-'
+	rest := lp.Rest()
 	expr, err := a.parseExpression(ctx, in, lp)
 	if err != nil {
 		return in, err
 	}
-	if !indirect && (expr.Text == "a" || expr.Text == "A") {
+	if !indirect && !longIndirect && (expr.Text == "a" || expr.Text == "A") {
 		if !summary.AnyModes(opcodes.MODE_A) {
 			return in, in.Errorf("%s doesn't support A mode", in.Command)
 		}
@@ -389,12 +451,20 @@ func (a *Base) parseOpArgs(ctx context.Context, in inst.I, lp *lines.Parse, summ
 	comma := lp.Consume(",")
 	if comma {
 		if lp.Consume("xX") {
+			if longIndirect {
+				return in, in.Errorf(",X unexpected inside brackets")
+			}
 			xy = 'x'
 		} else if lp.Consume("yY") {
-			if indirect {
+			if indirect || longIndirect {
 				return in, in.Errorf(",Y unexpected inside parens")
 			}
 			xy = 'y'
+		} else if summary.AnyModes(opcodes.MODE_STACK_REL|opcodes.MODE_STACK_REL_INDIRECT_Y) && lp.Consume("sS") {
+			if longIndirect {
+				return in, in.Errorf(",S unexpected inside brackets")
+			}
+			xy = 's'
 		} else {
 			return in, in.Errorf("X or Y expected after comma")
 		}
@@ -406,17 +476,84 @@ func (a *Base) parseOpArgs(ctx context.Context, in inst.I, lp *lines.Parse, summ
 		}
 		comma2 = lp.Consume(",")
 		if comma2 {
-			if comma {
+			if comma && xy != 's' {
 				return in, in.Errorf("Cannot have ,X or ,Y twice.")
 			}
 			if !lp.Consume("yY") {
 				return in, in.Errorf("Only ,Y can follow parens.")
 			}
+			if xy != 's' {
+				xy = 'y'
+			}
+		} else if xy == 's' {
+			return in, in.Errorf("Expected ,Y after (sr,S)")
+		}
+	}
+	if longIndirect {
+		if !lp.Consume("]") {
+			return in, in.Errorf("Expected closing bracket")
+		}
+		if lp.Consume(",") {
+			if !lp.Consume("yY") {
+				return in, in.Errorf("Only ,Y can follow brackets.")
+			}
 			xy = 'y'
 		}
+		return decodeLongIndirect(ctx, in, summary, xy)
+	}
+	if xy == 's' {
+		return decodeStackRel(ctx, in, summary, indirect)
+	}
+	if f == forceNone && !indirect && a.WideArg != nil && summary.Modes != opcodes.MODE_RELATIVE &&
+		expr.Width() != 1 && a.WideArg(ctx, expr) {
+		f = forceWide
+	}
+	if summary.Modes == opcodes.MODE_RELATIVE_LONG {
+		return decodeBranchLong(ctx, in, summary)
+	}
+
+	// 65816: immediate arguments can be two bytes wide. "#" on its own
+	// then means the whole value, rather than the low byte.
+	if !indirect && xy == '-' && a.WideImmediate != nil && a.WideImmediate(strings.ToUpper(in.Command)) &&
+		strings.ContainsAny(rest[:1], a.ImmediateChars) && summary.AnyModes(opcodes.MODE_IMMEDIATE) {
+		if len(rest) > 1 && !strings.ContainsAny(rest[1:2], a.MsbChars+a.LsbChars+a.BankChars) {
+			in.Exprs[0] = expr.Left
+		}
+		return decodeWideImmediate(ctx, in, summary)
+	}
+	if !indirect && xy != 'y' {
+		if in, ok, err := decodeLong(ctx, in, summary, xy, f == forceLong); ok || err != nil {
+			return in, err
+		}
 	}
 
-	return DecodeOp(ctx, in, summary, indirect, xy, forceWide)
+	return DecodeOp(ctx, in, summary, indirect, xy, f == forceWide)
+}
+
+// parseBlockMove parses the two bank-byte arguments of a 65816 block
+// move: source, then destination.
+func (a *Base) parseBlockMove(ctx context.Context, in inst.I, lp *lines.Parse, summary opcodes.OpSummary) (inst.I, error) {
+	for i := 0; i < 2; i++ {
+		if i == 1 && !lp.Consume(",") {
+			return in, in.Errorf("%s expects source and destination banks", in.Command)
+		}
+		expr, err := a.parseExpression(ctx, in, lp)
+		if err != nil {
+			return in, err
+		}
+		in.Exprs = append(in.Exprs, expr)
+	}
+	in.Op = summary.Ops[0].Byte
+	in.Width = 3
+	in.Var = inst.VarOpBlockMove
+	in.ModeStr = "move"
+	src, err1 := in.Exprs[0].Eval(ctx, in.Line)
+	dst, err2 := in.Exprs[1].Eval(ctx, in.Line)
+	if err1 == nil && err2 == nil {
+		in.Data = []byte{in.Op, byte(dst), byte(src)}
+		in.Final = true
+	}
+	return in, nil
 }
 
 func (a *Base) ParseOrg(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
@@ -433,6 +570,7 @@ func (a *Base) ParseOrg(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I
 	in.Width = 0
 	in.Final = true
 	in.Addr = uint16(val)
+	in.Value = val
 	return in, nil
 }
 
@@ -649,7 +787,7 @@ func (a *Base) ParseInclude(ctx context.Context, in inst.I, lp *lines.Parse) (in
 // For assemblers where the macro name follows the macro directive.
 func (a *Base) ParseMacroStart(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
 	lp.IgnoreRun(Whitespace)
-	if !lp.AcceptRun(cmdChars) {
+	if !lp.AcceptRun(cmdChars + a.ExtraCmdChars) {
 		return in, in.Errorf("Expecting valid macro name, found '%c'", lp.Next())
 	}
 	in.TextArg = lp.Emit()
@@ -666,32 +804,71 @@ func (a *Base) MarkMacroStart(ctx context.Context, in inst.I, lp *lines.Parse) (
 	return in, nil
 }
 
+// MarkMacroStartParams is like MarkMacroStart, for assemblers whose
+// macros declare named parameters, each with an optional default:
+// "NAME MACRO ARG1,ARG2=DEFAULT".
+func (a *Base) MarkMacroStartParams(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := a.MarkMacroStart(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	lp.IgnoreRun(Whitespace)
+	for lp.Peek() != lines.Eol && lp.Peek() != a.CommentChar {
+		if !lp.AcceptRun(a.LabelChars) {
+			return in, in.Errorf("expecting macro parameter name, found '%c'", lp.Next())
+		}
+		param := lp.Emit()
+		if lp.Consume("=") {
+			def, err := a.ParseMacroArg(in, lp)
+			if err != nil {
+				return in, err
+			}
+			param += "=" + def
+		}
+		in.MacroArgs = append(in.MacroArgs, param)
+		lp.IgnoreRun(Whitespace)
+		if !lp.Consume(",") {
+			break
+		}
+		lp.IgnoreRun(Whitespace)
+	}
+	return in, nil
+}
+
 func (a *Base) ParseNoArgDir(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
 	in.Width = 0
 	in.Final = true
 	return in, nil
 }
 
+// ParseSegment parses a segment directive, like as65's "code" or
+// "bss", that switches to the segment of the same name.
+func (a *Base) ParseSegment(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in.TextArg = in.Command
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
 func (a *Base) ParseNotImplemented(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
 	return in, in.Errorf("not implemented (yet?): %s", in.Command)
 }
 
 func (a *Base) parseExpression(ctx context.Context, in inst.I, lp *lines.Parse) (*expr.E, error) {
-
-	if a.operatorChars == "" {
-		for k, _ := range a.Operators {
-			a.operatorChars += k
-		}
+	if a.SpacesInExpressions {
+		lp.IgnoreRun(Whitespace)
 	}
 
 	var outer *expr.E
-	if lp.AcceptRun(a.MsbChars + a.LsbChars + a.ImmediateChars) {
+	if lp.AcceptRun(a.MsbChars + a.LsbChars + a.BankChars + a.ImmediateChars) {
 		pc := lp.Emit()
 		switch len(pc) {
 		case 1:
 			switch {
 			case strings.Contains(a.MsbChars, pc[:1]):
 				outer = &expr.E{Op: expr.OpMsb}
+			case strings.Contains(a.BankChars, pc[:1]):
+				outer = &expr.E{Op: expr.OpBank}
 			case strings.Contains(a.LsbChars+a.ImmediateChars, pc[:1]):
 				outer = &expr.E{Op: expr.OpLsb}
 			}
@@ -704,6 +881,8 @@ func (a *Base) parseExpression(ctx context.Context, in inst.I, lp *lines.Parse)
 				outer = &expr.E{Op: expr.OpMsb}
 			case strings.Contains(a.LsbChars, pc[1:]):
 				outer = &expr.E{Op: expr.OpLsb}
+			case strings.Contains(a.BankChars, pc[1:]):
+				outer = &expr.E{Op: expr.OpBank}
 			default:
 				return &expr.E{}, err
 			}
@@ -712,28 +891,108 @@ func (a *Base) parseExpression(ctx context.Context, in inst.I, lp *lines.Parse)
 		}
 	}
 
-	tree, err := a.ParseTerm(ctx, in, lp)
+	tree, err := a.parseBinary(ctx, in, lp, 0)
 	if err != nil {
 		return &expr.E{}, err
 	}
 
-	for lp.Accept(a.operatorChars) {
-		c := lp.Emit()
-		right, err := a.ParseTerm(ctx, in, lp)
+	if outer != nil {
+		outer.Left = tree
+		return outer, nil
+	}
+	return tree, nil
+}
+
+// parseBinary parses terms joined by binary operators. If the flavor
+// gives operators' precedence, it stops at any operator that binds
+// less tightly than minPrec, and exponentiation groups right to left;
+// if not, it parses strictly left to right.
+func (a *Base) parseBinary(ctx context.Context, in inst.I, lp *lines.Parse, minPrec int) (*expr.E, error) {
+	tree, err := a.ParseTerm(ctx, in, lp)
+	if err != nil {
+		return &expr.E{}, err
+	}
+	for {
+		if a.SpacesInExpressions {
+			lp.IgnoreRun(Whitespace)
+		}
+		s, op := a.peekOperator(lp)
+		if s == "" || (a.Precedence != nil && a.Precedence[op] < minPrec) {
+			return tree, nil
+		}
+		lp.AcceptString(s)
+		lp.Ignore()
+		var right *expr.E
+		if a.Precedence != nil {
+			next := a.Precedence[op] + 1
+			if op == expr.OpPow {
+				next--
+			}
+			right, err = a.parseBinary(ctx, in, lp, next)
+		} else {
+			right, err = a.ParseTerm(ctx, in, lp)
+		}
 		if err != nil {
 			return &expr.E{}, err
 		}
-		tree = &expr.E{Op: a.Operators[c], Left: tree, Right: right}
+		tree = &expr.E{Op: op, Left: tree, Right: right}
 	}
+}
 
-	if outer != nil {
-		outer.Left = tree
-		return outer, nil
+// peekOperator returns the longest operator at the current position,
+// and its meaning, without consuming it. Operators that are words, like
+// "AND", can't run into a following label.
+func (a *Base) peekOperator(lp *lines.Parse) (string, expr.Operator) {
+	return a.peekIn(a.Operators, lp)
+}
+
+// peekIn is peekOperator, for the given operators.
+func (a *Base) peekIn(ops map[string]expr.Operator, lp *lines.Parse) (string, expr.Operator) {
+	rest := lp.Rest()
+	best := ""
+	for s := range ops {
+		if len(s) <= len(best) || !strings.HasPrefix(rest, s) {
+			continue
+		}
+		if strings.Contains(Letters, s[len(s)-1:]) && len(rest) > len(s) && strings.Contains(a.LabelChars, rest[len(s):len(s)+1]) {
+			continue
+		}
+		best = s
 	}
-	return tree, nil
+	return best, ops[best]
 }
 
 func (a *Base) ParseTerm(ctx context.Context, in inst.I, lp *lines.Parse) (*expr.E, error) {
+	if a.SpacesInExpressions {
+		lp.IgnoreRun(Whitespace)
+	}
+
+	// Anonymous label reference: a run of +'s or -'s on its own.
+	if a.AnonymousLabel != nil {
+		if ref := a.anonymousRef(lp.Rest()); ref != "" {
+			lp.AcceptString(a.AnonymousPrefix + ref)
+			lp.Ignore()
+			return &expr.E{Op: expr.OpLeaf, Text: a.AnonymousLabel(ref)}, nil
+		}
+	}
+
+	// Unary operators
+	if s, op := a.peekIn(a.UnaryOperators, lp); s != "" {
+		lp.AcceptString(s)
+		lp.Ignore()
+		var operand *expr.E
+		var err error
+		if prec, ok := a.Precedence[op]; ok {
+			operand, err = a.parseBinary(ctx, in, lp, prec)
+		} else {
+			operand, err = a.ParseTerm(ctx, in, lp)
+		}
+		if err != nil {
+			return &expr.E{}, err
+		}
+		return &expr.E{Op: op, Left: operand}, nil
+	}
+
 	ex := &expr.E{}
 	top := ex
 
@@ -742,6 +1001,16 @@ func (a *Base) ParseTerm(ctx context.Context, in inst.I, lp *lines.Parse) (*expr
 		top = &expr.E{Op: expr.OpMinus, Left: ex}
 	}
 
+	// Parentheses
+	if a.Parentheses && lp.Consume("(") {
+		inner, err := a.parseGroup(ctx, in, lp)
+		if err != nil {
+			return &expr.E{}, err
+		}
+		*ex = *inner
+		return top, nil
+	}
+
 	// Current location
 	if lp.Consume("*") {
 		ex.Op = expr.OpLeaf
@@ -811,27 +1080,95 @@ func (a *Base) ParseTerm(ctx context.Context, in inst.I, lp *lines.Parse) (*expr
 		return top, nil
 	}
 
-	// Label
+	// Label, possibly qualified by scopes
+	if a.ScopeSeparator != "" {
+		lp.AcceptString(a.ScopeSeparator)
+	}
 	if !lp.AcceptRun(a.LabelChars) {
 		c := lp.Next()
 		return &expr.E{}, in.Errorf("expecting *, (hex) number, or label; found '%c' (%d)", c, c)
 	}
+	for a.ScopeSeparator != "" && lp.AcceptString(a.ScopeSeparator) {
+		if !lp.AcceptRun(a.LabelChars) {
+			c := lp.Next()
+			return &expr.E{}, in.Errorf("expecting label after '%s', found '%c' (%d)", a.ScopeSeparator, c, c)
+		}
+	}
 
 	ex.Op = expr.OpLeaf
 	ex.Text = lp.Emit()
+
+	// Function call
+	if op, ok := a.Functions[ex.Text]; ok && lp.Consume("(") {
+		if op == expr.OpUnknown {
+			return &expr.E{}, in.Errorf("function %s() is not supported", ex.Text)
+		}
+		arg, err := a.parseGroup(ctx, in, lp)
+		if err != nil {
+			return &expr.E{}, err
+		}
+		ex.Op, ex.Text, ex.Left = op, "", arg
+		return top, nil
+	}
+
 	newL, err := a.FixLabel(ctx, ex.Text)
 	if err != nil {
 		return &expr.E{}, in.Errorf("%v", err)
 	}
 	ex.Text = newL
+
+	// Variables take their current value, since they may change later.
+	if a.isVariable(ex.Text) {
+		if val, ok := ctx.Get(ex.Text); ok {
+			ex.Text = ""
+			ex.Val = val
+		}
+	}
 	return top, nil
 }
 
+// parseGroup parses an expression, up to a closing parenthesis. We
+// expect the opening one to have been consumed.
+func (a *Base) parseGroup(ctx context.Context, in inst.I, lp *lines.Parse) (*expr.E, error) {
+	ex, err := a.parseExpression(ctx, in, lp)
+	if err != nil {
+		return &expr.E{}, err
+	}
+	if a.SpacesInExpressions {
+		lp.IgnoreRun(Whitespace)
+	}
+	if !lp.Consume(")") {
+		return &expr.E{}, in.Errorf("expecting ')', found '%c'", lp.Peek())
+	}
+	return ex, nil
+}
+
+// anonymousRef returns the anonymous label reference s starts with:
+// a run of +'s or -'s, on its own, after any AnonymousPrefix.
+func (a *Base) anonymousRef(s string) string {
+	if !strings.HasPrefix(s, a.AnonymousPrefix) {
+		return ""
+	}
+	s = s[len(a.AnonymousPrefix):]
+	if s == "" || (s[0] != '+' && s[0] != '-') {
+		return ""
+	}
+	n := len(s) - len(strings.TrimLeft(s, s[:1]))
+	if n < len(s) && !strings.ContainsRune(Whitespace+",)", rune(s[n])) && rune(s[n]) != a.CommentChar {
+		return ""
+	}
+	return s[:n]
+}
+
+func (a *Base) isVariable(label string) bool {
+	return a.IsVariable != nil && a.IsVariable(label)
+}
+
 var macroArgRe = regexp.MustCompile("][0-9]+")
 
-func (a *Base) ReplaceMacroArgs(line string, args []string, kwargs map[string]string) (string, error) {
+func (a *Base) ReplaceMacroArgs(line string, args []string, kwargs map[string]string, params []string, passed int) (string, error) {
 	var err error
-	line = strings.Replace(line, "]#", strconv.Itoa(len(args)), -1)
+	line = strings.Replace(line, "]#", strconv.Itoa(passed), -1)
 	line = string(macroArgRe.ReplaceAllFunc([]byte(line), func(in []byte) []byte {
 		n, _ := strconv.Atoi(string(in[1:]))
 		if n > 0 && n <= len(args) {
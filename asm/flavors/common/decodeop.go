@@ -20,6 +20,19 @@ func DecodeOp(c context.Context, in inst.I, summary opcodes.OpSummary, indirect
 		switch xy {
 		case 'x':
 			op, ok := summary.OpForMode(opcodes.MODE_INDIRECT_X)
+			if !ok && summary.AnyModes(opcodes.MODE_ABS_INDIRECT_X) {
+				// 65C02 JMP (abs,X)
+				op, _ = summary.OpForMode(opcodes.MODE_ABS_INDIRECT_X)
+				in.Op = op.Byte
+				in.Width = 3
+				in.Var = inst.VarOpWord
+				in.ModeStr = "absindx"
+				if valKnown {
+					in.Final = true
+					in.Data = []byte{in.Op, byte(val), byte(val >> 8)}
+				}
+				return in, nil
+			}
 			if !ok {
 				return in, in.Errorf("%s doesn't support indexed indirect (addr,X) mode", in.Command)
 			}
@@ -54,6 +67,19 @@ func DecodeOp(c context.Context, in inst.I, summary opcodes.OpSummary, indirect
 			return in, nil
 		default:
 			op, ok := summary.OpForMode(opcodes.MODE_INDIRECT)
+			if !ok && summary.AnyModes(opcodes.MODE_ZP_INDIRECT) {
+				// 65C02 (zp)
+				op, _ = summary.OpForMode(opcodes.MODE_ZP_INDIRECT)
+				in.Op = op.Byte
+				in.Width = 2
+				in.Var = inst.VarOpByte
+				in.ModeStr = "zpind"
+				if valKnown {
+					in.Final = true
+					in.Data = []byte{in.Op, byte(val)}
+				}
+				return in, nil
+			}
 			if !ok {
 				return in, in.Errorf("%s doesn't support indirect (addr) mode", in.Command)
 			}
@@ -207,6 +233,108 @@ func DecodeOp(c context.Context, in inst.I, summary opcodes.OpSummary, indirect
 	return in, nil
 }
 
+// decodeLong decodes an op as long (65816) if it's forced to be, or
+// its argument is known to be above $FFFF. It returns false if it
+// didn't.
+func decodeLong(c context.Context, in inst.I, summary opcodes.OpSummary, xy rune, force bool) (inst.I, bool, error) {
+	mode, modeStr := opcodes.AddressingMode(opcodes.MODE_ABS_LONG), "long"
+	if xy == 'x' {
+		mode, modeStr = opcodes.MODE_ABS_LONG_X, "longx"
+	}
+	op, ok := summary.OpForMode(mode)
+	if !ok {
+		if force {
+			return in, false, in.Errorf("%s doesn't have a long variant", in.Command)
+		}
+		return in, false, nil
+	}
+	val, err := in.Exprs[0].Eval(c, in.Line)
+	valKnown := err == nil
+	if !force && (!valKnown || val <= 0xFFFF) {
+		return in, false, nil
+	}
+	in.Op = op.Byte
+	in.Width = 4
+	in.Var = inst.VarOpLong
+	in.ModeStr = modeStr
+	if valKnown {
+		in.Data = []byte{in.Op, byte(val), byte(val >> 8), byte(val >> 16)}
+		in.Final = true
+	}
+	return in, true, nil
+}
+
+// decodeLongIndirect decodes the 65816's bracketed long indirect
+// modes: [dp], [dp],Y, and [abs].
+func decodeLongIndirect(c context.Context, in inst.I, summary opcodes.OpSummary, xy rune) (inst.I, error) {
+	mode, width, modeStr := opcodes.AddressingMode(opcodes.MODE_INDIRECT_LONG), uint16(2), "indl"
+	switch {
+	case xy == 'y':
+		mode, modeStr = opcodes.MODE_INDIRECT_LONG_Y, "indly"
+	case !summary.AnyModes(opcodes.MODE_INDIRECT_LONG):
+		mode, width, modeStr = opcodes.MODE_ABS_INDIRECT_LONG, 3, "absindl"
+	}
+	op, ok := summary.OpForMode(mode)
+	if !ok {
+		return in, in.Errorf("%s doesn't support that long indirect mode", in.Command)
+	}
+	return finishOp(c, in, op, width, modeStr)
+}
+
+// decodeStackRel decodes the 65816's stack-relative modes: sr,S and
+// (sr,S),Y.
+func decodeStackRel(c context.Context, in inst.I, summary opcodes.OpSummary, indirect bool) (inst.I, error) {
+	mode, modeStr := opcodes.AddressingMode(opcodes.MODE_STACK_REL), "sr"
+	if indirect {
+		mode, modeStr = opcodes.MODE_STACK_REL_INDIRECT_Y, "srindy"
+	}
+	op, ok := summary.OpForMode(mode)
+	if !ok {
+		return in, in.Errorf("%s doesn't support that stack-relative mode", in.Command)
+	}
+	return finishOp(c, in, op, 2, modeStr)
+}
+
+// decodeWideImmediate decodes a 65816 immediate op with a two-byte
+// argument.
+func decodeWideImmediate(c context.Context, in inst.I, summary opcodes.OpSummary) (inst.I, error) {
+	op, _ := summary.OpForMode(opcodes.MODE_IMMEDIATE)
+	return finishOp(c, in, op, 3, "imm")
+}
+
+// decodeBranchLong decodes a 65816 op with a two-byte relative
+// address, like BRL.
+func decodeBranchLong(c context.Context, in inst.I, summary opcodes.OpSummary) (inst.I, error) {
+	in.Op = summary.Ops[0].Byte
+	in.Width = 3
+	in.Var = inst.VarOpBranchLong
+	if val, err := in.Exprs[0].Eval(c, in.Line); err == nil {
+		offset := val - (int64(c.GetAddr()) + 3)
+		in.Data = []byte{in.Op, byte(offset), byte(offset >> 8)}
+		in.Final = true
+	}
+	return in, nil
+}
+
+// finishOp fills in an op with a one- or two-byte argument.
+func finishOp(c context.Context, in inst.I, op opcodes.OpInfo, width uint16, modeStr string) (inst.I, error) {
+	in.Op = op.Byte
+	in.Width = width
+	in.Var = inst.VarOpByte
+	if width == 3 {
+		in.Var = inst.VarOpWord
+	}
+	in.ModeStr = modeStr
+	if val, err := in.Exprs[0].Eval(c, in.Line); err == nil {
+		in.Data = []byte{in.Op, byte(val)}
+		if width == 3 {
+			in.Data = append(in.Data, byte(val>>8))
+		}
+		in.Final = true
+	}
+	return in, nil
+}
+
 func RelativeAddr(c context.Context, in inst.I, val uint16) (byte, error) {
 	curr := c.GetAddr()
 	offset := int32(val) - (int32(curr) + 2)
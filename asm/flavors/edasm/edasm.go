@@ -0,0 +1,249 @@
+package edasm
+
+import (
+	"fmt"
+
+	"github.com/zellyn/go6502/asm/context"
+	"github.com/zellyn/go6502/asm/expr"
+	"github.com/zellyn/go6502/asm/flavors"
+	"github.com/zellyn/go6502/asm/flavors/common"
+	"github.com/zellyn/go6502/asm/inst"
+	"github.com/zellyn/go6502/asm/lines"
+	"github.com/zellyn/go6502/asm/opcodes"
+)
+
+// Edasm implements an assembler flavor compatible with Apple's ProDOS
+// Editor/Assembler (EDASM).
+type Edasm struct {
+	common.Base
+	rel      bool            // REL: is the code relocatable?
+	started  bool            // has there been code, or an ORG, yet?
+	relative map[string]bool // in REL code, labels that move with the code
+	entries  []string        // ENTRY symbols, in order
+	externs  []string        // EXTRN symbols, in order: their ESD numbers start at 1
+}
+
+func New(sets opcodes.Set) *Edasm {
+	e := &Edasm{}
+	e.Name = "edasm"
+	e.OpcodesByName = opcodes.ByName(sets)
+	e.LabelChars = common.Letters + common.Digits + "."
+	e.LabelColons = common.ReqOptional
+	e.ExplicitARegister = common.ReqOptional
+	e.StringEndOptional = true
+	e.CommentChar = ';'
+	e.BinaryChar = '%'
+	e.MsbChars = ">/"
+	e.LsbChars = "<"
+	e.ImmediateChars = "#"
+	e.CharChars = `'"`
+	e.HexCommas = common.ReqOptional
+	e.DefaultOriginVal = 0x0000 // As in the listing in docs/assembler.org
+
+	e.Directives = map[string]common.DirectiveInfo{
+		"ORG":     {inst.TypeOrg, e.ParseOrg, 0},
+		"EQU":     {inst.TypeEqu, e.ParseEquate, inst.VarEquNormal},
+		"DFB":     {inst.TypeData, e.ParseData, inst.VarBytes},
+		"DW":      {inst.TypeData, e.ParseData, inst.VarWordsLe},
+		"DDB":     {inst.TypeData, e.ParseData, inst.VarWordsBe},
+		"ASC":     {inst.TypeData, e.ParseAscii, inst.VarAscii},
+		"DCI":     {inst.TypeData, e.ParseAscii, inst.VarAsciiFlip},
+		"STR":     {inst.TypeData, e.ParseStr, inst.VarAscii},
+		"DS":      {inst.TypeData, e.ParseData, inst.VarBytesZero},
+		"REL":     {inst.TypeNone, e.ParseRel, 0},
+		"ENTRY":   {inst.TypeNone, e.ParseSymbols, 0},
+		"EXTRN":   {inst.TypeNone, e.ParseSymbols, 0},
+		"INCLUDE": {inst.TypeInclude, e.ParseInclude, 0},
+		"CHN":     {inst.TypeInclude, e.ParseInclude, inst.VarIncludeChain},
+		"DO":      {inst.TypeIfdef, e.ParseDo, 0},
+		"ELSE":    {inst.TypeIfdefElse, e.ParseNoArgDir, 0},
+		"FIN":     {inst.TypeIfdefEnd, e.ParseNoArgDir, 0},
+		"SBTL":    {inst.TypeNone, nil, 0}, // Subtitle
+		"PAGE":    {inst.TypeNone, nil, 0}, // New page
+		"SKP":     {inst.TypeNone, nil, 0}, // Skip lines
+		"REP":     {inst.TypeNone, nil, 0}, // Repeat character
+		"CHR":     {inst.TypeNone, nil, 0}, // Set repeated character
+	}
+
+	e.EquateDirectives = map[string]bool{
+		"EQU": true,
+	}
+
+	e.Operators = map[string]expr.Operator{
+		"*": expr.OpMul,
+		"/": expr.OpDiv,
+		"+": expr.OpPlus,
+		"-": expr.OpMinus,
+		"&": expr.OpAnd,
+		"!": expr.OpOr,
+		"|": expr.OpOr,
+		"^": expr.OpXor,
+	}
+
+	e.InitContextFunc = func(ctx context.Context) {
+		e.rel, e.started = false, false
+		e.relative = make(map[string]bool)
+		e.entries, e.externs = nil, nil
+		ctx.SetOnOffDefaults(map[string]bool{
+			"MSB": false, // The manual says ON, but EDASM starts OFF: see docs/assembler.org
+			"LST": true,  // Display listing
+		})
+	}
+
+	e.SetAsciiVariation = func(ctx context.Context, in *inst.I, lp *lines.Parse) {
+		msb := ctx.Setting("MSB")
+		switch in.Command {
+		case "ASC", "STR":
+			if msb {
+				in.Var = inst.VarAsciiHi
+			} else {
+				in.Var = inst.VarAscii
+			}
+		case "DCI":
+			if msb {
+				in.Var = inst.VarAsciiHiFlip
+			} else {
+				in.Var = inst.VarAsciiFlip
+			}
+		default:
+			panic(fmt.Sprintf("Unknown ascii directive: '%s'", in.Command))
+		}
+	}
+
+	// In REL code, addresses that will move, or aren't known yet,
+	// take two bytes, even if they're in page zero for now.
+	e.WideArg = func(ctx context.Context, ex *expr.E) bool {
+		if !e.rel {
+			return false
+		}
+		wide := false
+		walkLabels(ex, func(label string) {
+			_, known := ctx.Get(label)
+			wide = wide || !known || label == "*" || e.relative[label] || e.extern(label) > 0
+		})
+		return wide
+	}
+
+	e.FixLabel = e.DefaultFixLabel
+	e.IsNewParentLabel = e.DefaultIsNewParentLabel
+
+	return e
+}
+
+// ParseInstr parses an instruction, marking it unlisted if LST is off,
+// and noting, in REL code, which labels move with the code.
+func (e *Edasm) ParseInstr(ctx context.Context, line lines.Line, mode flavors.ParseMode) (inst.I, error) {
+	in, err := e.Base.ParseInstr(ctx, line, mode)
+	if err != nil || mode != flavors.ParseModeNormal {
+		return in, err
+	}
+	in.Unlisted = !ctx.Setting("LST")
+	if in.Type == inst.TypeOrg && e.rel {
+		return in, in.Errorf("ORG not allowed in REL code")
+	}
+	e.started = e.started || in.Width > 0 || in.Type == inst.TypeOrg
+	if e.rel && in.Label != "" {
+		if in.Type != inst.TypeEqu {
+			e.relative[in.Label] = true
+			return in, nil
+		}
+		n, ext, err := e.relocation(&in, in.Exprs[0])
+		if err == nil && ext != "" {
+			err = in.Errorf("%s: equates can't refer to EXTRN symbols", in.Label)
+		}
+		if err != nil {
+			return in, err
+		}
+		e.relative[in.Label] = n == 1
+	}
+	return in, nil
+}
+
+// ParseStr parses STR, which is like ASC, preceded by the length.
+func (e *Edasm) ParseStr(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := e.ParseAscii(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	if len(in.Data) > 0xff {
+		return in, in.Errorf("STR string too long: %d characters", len(in.Data))
+	}
+	in.Data = append([]byte{byte(len(in.Data))}, in.Data...)
+	in.Width++
+	return in, nil
+}
+
+// ParseInclude parses INCLUDE and CHN, whose filenames are ProDOS
+// pathnames.
+func (e *Edasm) ParseInclude(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(common.Whitespace)
+	if !lp.AcceptUntil(common.Whitespace + string(e.CommentChar)) {
+		return in, in.Errorf("%s expects a filename", in.Command)
+	}
+	in.TextArg = lp.Emit()
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseRel parses REL, which makes the code relocatable: see Rel.
+func (e *Edasm) ParseRel(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	if e.started {
+		return in, in.Errorf("REL must come before any code or ORG")
+	}
+	e.rel = true
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseSymbols parses ENTRY and EXTRN, which list symbols that other
+// modules can use, and symbols from other modules. EXTRN symbols are
+// zero here: the linker fills them in.
+func (e *Edasm) ParseSymbols(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	if !e.rel {
+		return in, in.Errorf("%s without REL", in.Command)
+	}
+	for {
+		lp.IgnoreRun(common.Whitespace)
+		if !lp.AcceptRun(e.LabelChars) {
+			return in, in.Errorf("%s expects symbol names, found '%c'", in.Command, lp.Next())
+		}
+		name := lp.Emit()
+		if in.Command == "ENTRY" {
+			e.entries = append(e.entries, name)
+		} else if e.extern(name) == 0 {
+			e.externs = append(e.externs, name)
+			ctx.Set(name, 0)
+		}
+		if !lp.Consume(",") {
+			break
+		}
+	}
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// extern returns the ESD number of an EXTRN symbol, or 0 if label
+// isn't one.
+func (e *Edasm) extern(label string) int {
+	for i, name := range e.externs {
+		if name == label {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// walkLabels calls f with each label an expression refers to.
+func walkLabels(ex *expr.E, f func(string)) {
+	if ex == nil {
+		return
+	}
+	if ex.Op == expr.OpLeaf && ex.Text != "" {
+		f(ex.Text)
+	}
+	walkLabels(ex.Left, f)
+	walkLabels(ex.Right, f)
+}
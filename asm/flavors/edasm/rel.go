@@ -0,0 +1,197 @@
+package edasm
+
+import (
+	"fmt"
+
+	"github.com/zellyn/go6502/asm/context"
+	"github.com/zellyn/go6502/asm/expr"
+	"github.com/zellyn/go6502/asm/inst"
+	"github.com/zellyn/go6502/asm/membuf"
+)
+
+// RLD (relocation dictionary) entry flags.
+const (
+	rldWord     = 0x80 // the field is two bytes, not one
+	rldUpper    = 0x40 // a one-byte field holds the upper byte of the address
+	rldReversed = 0x20 // a two-byte field is high byte first (DDB)
+	rldExtern   = 0x10 // the field refers to an EXTRN symbol
+	rldNotEnd   = 0x08 // set in every entry, telling it from the $00 that ends the RLD
+)
+
+// ESD (external symbol directory) entry flags.
+const (
+	esdExtern = 0x10
+	esdEntry  = 0x08
+)
+
+// Relocatable says whether the source asked for relocatable output,
+// with REL.
+func (e *Edasm) Relocatable() bool {
+	return e.rel
+}
+
+// Rel returns the assembled instructions as EDASM's relocatable object
+// file: the length of the code, the code, the RLD, which lists the
+// fields the linker has to adjust, and the ESD, which lists the ENTRY
+// and EXTRN symbols. See docs/assembler.org.
+func (e *Edasm) Rel(ctx context.Context, insts []*inst.I) ([]byte, error) {
+	m := &membuf.Membuf{}
+	var rld []byte
+	base, started := uint16(0), false // where the code starts
+	for _, in := range insts {
+		if !in.Final {
+			return nil, in.Errorf("cannot finalize value: %s", in)
+		}
+		if in.Width == 0 || in.Reserve {
+			continue
+		}
+		if !started {
+			base, started = in.Target, true
+		}
+		data := append([]byte{}, in.Data...)
+		for len(data) < int(in.Width) {
+			data = append(data, 0x00)
+		}
+		m.Write(int(in.Target), data)
+		var fields []uint16 // offsets of each expression's field
+		var flags byte
+		switch {
+		case in.Type == inst.TypeOp && in.Var == inst.VarOpWord:
+			fields, flags = []uint16{1}, rldWord
+		case in.Type == inst.TypeOp && in.Var == inst.VarOpByte:
+			fields = []uint16{1}
+		case in.Type == inst.TypeData && in.Var == inst.VarBytes:
+			for i := range in.Exprs {
+				fields = append(fields, uint16(i))
+			}
+		case in.Type == inst.TypeData && (in.Var == inst.VarWordsLe || in.Var == inst.VarWordsBe):
+			for i := range in.Exprs {
+				fields = append(fields, uint16(2*i))
+			}
+			flags = rldWord
+			if in.Var == inst.VarWordsBe {
+				flags |= rldReversed
+			}
+		}
+		for i, off := range fields {
+			entry, err := e.rldEntry(ctx, in, in.Exprs[i], in.Target-base+off, flags)
+			if err != nil {
+				return nil, err
+			}
+			rld = append(rld, entry...)
+		}
+	}
+
+	code := m.Piece(0).Data
+	out := append([]byte{byte(len(code)), byte(len(code) >> 8)}, code...)
+	out = append(append(out, rld...), 0x00)
+	for _, name := range e.entries {
+		val, ok := ctx.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("ENTRY symbol %s is never defined", name)
+		}
+		out = append(append(out, esdName(name)...), esdEntry, byte(val), byte(val>>8))
+	}
+	for i, name := range e.externs {
+		out = append(append(out, esdName(name)...), esdExtern, byte(i+1), 0x00)
+	}
+	return append(out, 0x00), nil
+}
+
+// rldEntry returns the RLD entry for the field at offset off, which
+// holds the value of ex, or nothing if the field doesn't need
+// adjusting.
+func (e *Edasm) rldEntry(ctx context.Context, in *inst.I, ex *expr.E, off uint16, flags byte) ([]byte, error) {
+	if flags&rldWord == 0 {
+		switch ex.Op {
+		case expr.OpMsb:
+			flags |= rldUpper
+			ex = ex.Left
+		case expr.OpLsb:
+			ex = ex.Left
+		}
+	}
+	n, ext, err := e.relocation(in, ex)
+	if err != nil {
+		return nil, err
+	}
+	var last byte // the ESD number, or the low byte for upper bytes
+	switch {
+	case n == 0 && ext == "":
+		return nil, nil
+	case n == 0:
+		flags |= rldExtern
+		last = byte(e.extern(ext))
+	case n == 1 && ext == "":
+		if flags&rldUpper != 0 {
+			val, err := ex.Eval(ctx, in.Line)
+			if err != nil {
+				return nil, err
+			}
+			last = byte(val)
+		}
+	default:
+		return nil, in.Errorf("%s: not relocatable", ex)
+	}
+	return []byte{flags | rldNotEnd, byte(off), byte(off >> 8), last}, nil
+}
+
+// relocation says how an expression's value depends on where the code
+// is loaded: n is the number of times it counts the load address, and
+// ext, the EXTRN symbol it's an offset from, if any. Only sums and
+// differences of relative labels can be relocated.
+func (e *Edasm) relocation(in *inst.I, ex *expr.E) (n int, ext string, err error) {
+	switch ex.Op {
+	case expr.OpLeaf:
+		switch {
+		case ex.Text == "*" || e.relative[ex.Text]:
+			return 1, "", nil
+		case e.extern(ex.Text) > 0:
+			return 0, ex.Text, nil
+		}
+		return 0, "", nil
+	case expr.OpPlus, expr.OpMinus:
+		ln, lext, err := e.relocation(in, ex.Left)
+		if err != nil {
+			return 0, "", err
+		}
+		if ex.Right == nil { // negation
+			if ln != 0 || lext != "" {
+				return 0, "", in.Errorf("%s: not relocatable", ex)
+			}
+			return 0, "", nil
+		}
+		rn, rext, err := e.relocation(in, ex.Right)
+		if err != nil {
+			return 0, "", err
+		}
+		if ex.Op == expr.OpMinus {
+			if rext != "" {
+				return 0, "", in.Errorf("%s: not relocatable", ex)
+			}
+			rn = -rn
+		}
+		if lext != "" && rext != "" {
+			return 0, "", in.Errorf("%s: not relocatable", ex)
+		}
+		return ln + rn, lext + rext, nil
+	}
+	movable := false
+	walkLabels(ex, func(label string) {
+		movable = movable || label == "*" || e.relative[label] || e.extern(label) > 0
+	})
+	if movable {
+		return 0, "", in.Errorf("%s: not relocatable", ex)
+	}
+	return 0, "", nil
+}
+
+// esdName returns a symbol's name as the ESD stores it: high bits set
+// on all but the last character.
+func esdName(name string) []byte {
+	b := []byte(name)
+	for i := 0; i < len(b)-1; i++ {
+		b[i] |= 0x80
+	}
+	return b
+}
@@ -17,7 +17,7 @@ const (
 type F interface {
 	ParseInstr(ctx context.Context, Line lines.Line, mode ParseMode) (inst.I, error)
 	DefaultOrigin() uint16
-	ReplaceMacroArgs(line string, args []string, kwargs map[string]string) (string, error)
+	ReplaceMacroArgs(line string, args []string, kwargs map[string]string, params []string, passed int) (string, error)
 	LocalMacroLabels() bool
 	String() string
 	InitContext(context.Context)
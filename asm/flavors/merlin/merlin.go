@@ -1,11 +1,13 @@
 package merlin
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 
 	"github.com/zellyn/go6502/asm/context"
 	"github.com/zellyn/go6502/asm/expr"
+	"github.com/zellyn/go6502/asm/flavors"
 	"github.com/zellyn/go6502/asm/flavors/common"
 	"github.com/zellyn/go6502/asm/inst"
 	"github.com/zellyn/go6502/asm/lines"
@@ -17,6 +19,14 @@ import (
 // http://www.apple-iigs.info/doc/fichiers/merlin816.pdf‎
 type Merlin struct {
 	common.Base
+	byXC [3]map[string]opcodes.OpSummary // opcodes after zero, one, and two XCs
+	xc   int                             // XCs seen: 1 for 65C02, 2 for 65816
+	mx   byte                            // MX: bit 1 set for 8-bit accumulator, bit 0 for 8-bit index registers
+	exp  string                          // EXP: ON, OFF, or ONLY
+
+	// Kbd reads a value, given a prompt, for the KBD directive. If
+	// nil, KBD is an error.
+	Kbd func(prompt string) (string, error)
 }
 
 const whitespace = " \t"
@@ -25,8 +35,13 @@ const macroNameChars = common.Letters + common.Digits + "_"
 func New(sets opcodes.Set) *Merlin {
 	m := &Merlin{}
 	m.Name = "merlin"
-	m.OpcodesByName = opcodes.ByName(sets)
-	m.LabelChars = common.Letters + common.Digits + ":"
+	m.byXC = [3]map[string]opcodes.OpSummary{
+		opcodes.ByName(sets),
+		opcodes.ByName(sets | opcodes.Set65C02),
+		opcodes.ByName(sets | opcodes.Set65816),
+	}
+	m.OpcodesByName = m.byXC[0]
+	m.LabelChars = common.Letters + common.Digits + ":]"
 	m.LabelColons = common.ReqDisallowed
 	m.ExplicitARegister = common.ReqOptional
 	m.StringEndOptional = false
@@ -34,6 +49,8 @@ func New(sets opcodes.Set) *Merlin {
 	m.BinaryChar = '%'
 	m.LsbChars = "<"
 	m.MsbChars = ">/"
+	m.BankChars = "^"
+	m.ExtraCmdChars = "-^" // "--^" ends a loop
 	m.ImmediateChars = "#"
 	m.HexCommas = common.ReqOptional
 	m.CharChars = "'"
@@ -45,32 +62,55 @@ func New(sets opcodes.Set) *Merlin {
 
 	m.Directives = map[string]common.DirectiveInfo{
 		"ORG":    {inst.TypeOrg, m.ParseOrg, 0},
-		"OBJ":    {inst.TypeNone, nil, 0},
+		"OBJ":    {inst.TypeTarget, m.ParseOrg, 0},
+		"XC":     {inst.TypeNone, m.ParseXC, 0},
+		"MX":     {inst.TypeNone, m.ParseMX, 0},
+		"DUM":    {inst.TypeDummy, m.ParseOrg, 0},
+		"DEND":   {inst.TypeDummyEnd, m.ParseNoArgDir, 0},
 		"ENDASM": {inst.TypeEnd, m.ParseNoArgDir, 0},
 		"=":      {inst.TypeEqu, m.ParseEquate, inst.VarEquNormal},
+		"KBD":    {inst.TypeEqu, m.ParseKbd, inst.VarEquNormal},
+		"VAR":    {inst.TypeNone, m.ParseVar, 0},
 		"HEX":    {inst.TypeData, m.ParseHexString, inst.VarBytes},
 		"DFB":    {inst.TypeData, m.ParseData, inst.VarBytes},
 		"DB":     {inst.TypeData, m.ParseData, inst.VarBytes},
 		"DA":     {inst.TypeData, m.ParseData, inst.VarWordsLe},
+		"DW":     {inst.TypeData, m.ParseData, inst.VarWordsLe},
 		"DDB":    {inst.TypeData, m.ParseData, inst.VarWordsBe},
+		"DS":     {inst.TypeData, m.ParseDS, inst.VarBytes},
 		"ASC":    {inst.TypeData, m.ParseAscii, inst.VarAscii},
 		"DCI":    {inst.TypeData, m.ParseAscii, inst.VarAsciiFlip},
+		"STR":    {inst.TypeData, m.ParseString, inst.VarAscii},
+		"STRL":   {inst.TypeData, m.ParseString, inst.VarAscii},
+		"REV":    {inst.TypeData, m.ParseString, inst.VarAscii},
+		"INV":    {inst.TypeData, m.ParseString, inst.VarAscii},
+		"FLS":    {inst.TypeData, m.ParseString, inst.VarAscii},
+		"CHK":    {inst.TypeChecksum, m.ParseChk, 0},
+		"ERR":    {inst.TypeError, m.ParseErr, 0},
+		"DO":     {inst.TypeIfdef, m.ParseDo, 0},
+		"IF":     {inst.TypeIfdef, m.ParseIf, 0},
+		"ELSE":   {inst.TypeIfdefElse, m.ParseNoArgDir, 0},
+		"FIN":    {inst.TypeIfdefEnd, m.ParseNoArgDir, 0},
 		".DO":    {inst.TypeIfdef, m.ParseDo, 0},
 		".ELSE":  {inst.TypeIfdefElse, m.ParseNoArgDir, 0},
 		".FIN":   {inst.TypeIfdefEnd, m.ParseNoArgDir, 0},
+		"LUP":    {inst.TypeLoopStart, m.ParseDo, 0},
+		"--^":    {inst.TypeLoopEnd, m.ParseNoArgDir, 0},
 		"MAC":    {inst.TypeMacroStart, m.MarkMacroStart, 0},
 		"EOM":    {inst.TypeMacroEnd, m.ParseNoArgDir, 0},
 		"<<<":    {inst.TypeMacroEnd, m.ParseNoArgDir, 0},
+		"EXP":    {inst.TypeSetting, m.ParseExp, 0},
 		"PAGE":   {inst.TypeNone, nil, 0}, // New page
 		"TTL":    {inst.TypeNone, nil, 0}, // Title
-		"SAV":    {inst.TypeNone, nil, 0}, // Save
-		"DSK":    {inst.TypeNone, nil, 0}, // Assemble to disk
+		"SAV":    {inst.TypeSave, m.ParseSave, inst.VarSaveBefore},
+		"DSK":    {inst.TypeSave, m.ParseSave, inst.VarSaveAfter},
 		"PUT":    {inst.TypeInclude, m.ParseInclude, 0},
 		"USE":    {inst.TypeInclude, m.ParseInclude, 0},
 	}
 
 	m.EquateDirectives = map[string]bool{
-		"=": true,
+		"=":   true,
+		"KBD": true,
 	}
 
 	m.Operators = map[string]expr.Operator{
@@ -87,10 +127,11 @@ func New(sets opcodes.Set) *Merlin {
 	}
 
 	m.InitContextFunc = func(ctx context.Context) {
+		m.xc, m.mx = 0, 3
+		m.OpcodesByName = m.byXC[0]
+		m.exp = "ON"
 		ctx.SetOnOffDefaults(map[string]bool{
-			"LST":   true,  // Display listing: not used
-			"XC":    false, // Extended commands: not implemented yet
-			"EXP":   false, // How to print macro calls
+			"LST":   true,  // Display listing
 			"LSTDO": false, // List conditional code?
 			"TR":    false, // truncate listing to 3 bytes?
 			"CYC":   false, // print cycle times?
@@ -98,7 +139,9 @@ func New(sets opcodes.Set) *Merlin {
 	}
 
 	m.SetAsciiVariation = func(ctx context.Context, in *inst.I, lp *lines.Parse) {
-		if in.Command != "ASC" && in.Command != "DCI" {
+		switch in.Command {
+		case "ASC", "DCI", "STR", "STRL", "REV", "INV", "FLS":
+		default:
 			panic(fmt.Sprintf("Unimplemented/unknown ascii directive: '%s'", in.Command))
 		}
 		invert := lp.Peek() < '\''
@@ -186,9 +229,297 @@ func New(sets opcodes.Set) *Merlin {
 		return label != "" && label[0] != ':'
 	}
 
+	m.IsVariable = func(label string) bool {
+		return label != "" && label[0] == ']'
+	}
+
+	// WideImmediate says whether, in 65816 mode, an op's immediate
+	// argument is two bytes wide, according to MX.
+	m.WideImmediate = func(cmd string) bool {
+		if m.xc < 2 {
+			return false
+		}
+		switch cmd {
+		case "ADC", "AND", "BIT", "CMP", "EOR", "LDA", "ORA", "SBC":
+			return m.mx&2 == 0
+		case "CPX", "CPY", "LDX", "LDY":
+			return m.mx&1 == 0
+		}
+		return false
+	}
+
 	return m
 }
 
+// ParseInstr parses an instruction, marking it unlisted according to
+// LST and EXP. As in Merlin 16+, REP and SEP change MX.
+func (m *Merlin) ParseInstr(ctx context.Context, line lines.Line, mode flavors.ParseMode) (inst.I, error) {
+	in, err := m.Base.ParseInstr(ctx, line, mode)
+	if err != nil || mode != flavors.ParseModeNormal {
+		return in, err
+	}
+	in.Unlisted = !m.listed(ctx, in)
+	if in.Type != inst.TypeOp || !in.Final || m.xc < 2 {
+		return in, nil
+	}
+	switch strings.ToUpper(in.Command) {
+	case "REP":
+		m.mx &^= in.Data[1] >> 4 & 3
+	case "SEP":
+		m.mx |= in.Data[1] >> 4 & 3
+	}
+	return in, nil
+}
+
+// listed says whether an instruction belongs in the listing: not if
+// LST is off, and, in macro expansions, only as EXP says.
+func (m *Merlin) listed(ctx context.Context, in inst.I) bool {
+	if !ctx.Setting("LST") {
+		return false
+	}
+	if name, _, _ := ctx.GetMacroCall(); name == "" {
+		return true
+	}
+	switch m.exp {
+	case "OFF":
+		return false
+	case "ONLY":
+		return in.Width > 0
+	}
+	return true
+}
+
+// ParseExp parses an EXP directive: ON lists macro expansions, OFF
+// leaves them out, and ONLY lists just their lines that assemble
+// bytes.
+func (m *Merlin) ParseExp(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	lp.AcceptRun(common.Letters)
+	in.TextArg = lp.Emit()
+	switch in.TextArg {
+	case "ON", "OFF", "ONLY":
+		m.exp = in.TextArg
+	default:
+		return in, in.Errorf("expecting ON/OFF/ONLY, found '%s'", in.TextArg)
+	}
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseDS parses a DS directive: "DS n" reserves n bytes, and "DS \"
+// reserves bytes up to the next page boundary. Either may be followed
+// by the value to fill them with: "DS n,$FF".
+func (m *Merlin) ParseDS(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	var size int64
+	if lp.Consume(`\`) {
+		size = int64(-ctx.GetAddr() & 0xff)
+	} else {
+		var err error
+		if in, err = m.ParseDo(ctx, in, lp); err != nil {
+			return in, err
+		}
+		if size, err = in.Exprs[0].Eval(ctx, in.Line); err != nil {
+			return in, in.Errorf("cannot evaluate size of DS on first pass")
+		}
+	}
+	var fill int64
+	if lp.Consume(",") {
+		var err error
+		if in, err = m.ParseDo(ctx, in, lp); err != nil {
+			return in, err
+		}
+		if fill, err = in.Exprs[len(in.Exprs)-1].Eval(ctx, in.Line); err != nil {
+			return in, in.Errorf("cannot evaluate DS fill value on first pass")
+		}
+	}
+	in.Data = bytes.Repeat([]byte{byte(fill)}, int(uint16(size)))
+	in.Width = uint16(len(in.Data))
+	in.Final = true
+	return in, nil
+}
+
+// ParseString parses the string directives beyond ASC and DCI: STR and
+// STRL, which prefix the string with its length in one or two bytes,
+// REV, which reverses it, and INV and FLS, which make it inverse or
+// flashing text.
+func (m *Merlin) ParseString(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := m.ParseAscii(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	data := in.Data
+	switch in.Command {
+	case "STR":
+		data = append([]byte{byte(len(data))}, data...)
+	case "STRL":
+		data = append([]byte{byte(len(data)), byte(len(data) >> 8)}, data...)
+	case "REV":
+		for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+	case "INV":
+		for i := range data {
+			data[i] &= 0x3f
+		}
+	case "FLS":
+		for i := range data {
+			data[i] = data[i]&0x3f | 0x40
+		}
+	}
+	in.Data = data
+	in.Width = uint16(len(data))
+	return in, nil
+}
+
+// ParseChk parses a CHK directive: a checksum byte, computed on the
+// final pass.
+func (m *Merlin) ParseChk(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in.Width = 1
+	return in, nil
+}
+
+// ParseErr parses an ERR directive, which fails assembly, on the final
+// pass, if its expression is non-zero. "ERR \$5000" fails if the
+// location counter is past $5000.
+func (m *Merlin) ParseErr(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	past := lp.Consume(`\`)
+	in, err := m.ParseDo(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	if past {
+		in.Exprs[0] = &expr.E{Op: expr.OpGt, Left: &expr.E{Op: expr.OpLeaf, Text: "*"}, Right: in.Exprs[0]}
+	}
+	in.Final = false
+	return in, nil
+}
+
+// ParseIf parses an IF directive, "IF c,TEXT", which is true if TEXT
+// starts with the character c. TEXT is usually a macro argument, as in
+// "IF (,]1".
+func (m *Merlin) ParseIf(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	c := lp.Next()
+	if c == lines.Eol {
+		return in, in.Errorf("IF expects a character")
+	}
+	if !lp.Consume(",") {
+		return in, in.Errorf("IF expects a comma after '%c'", c)
+	}
+	var val int64
+	if lp.Next() == c {
+		val = 1
+	}
+	in.Exprs = append(in.Exprs, &expr.E{Op: expr.OpLeaf, Val: val})
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseVar parses a VAR directive, which sets the variables ]1, ]2,
+// etc. to its semicolon-separated values.
+func (m *Merlin) ParseVar(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	for {
+		var err error
+		if in, err = m.ParseDo(ctx, in, lp); err != nil {
+			return in, err
+		}
+		if !lp.Consume(";") {
+			break
+		}
+	}
+	for i, e := range in.Exprs {
+		val, err := e.Eval(ctx, in.Line)
+		if err != nil {
+			return in, in.Errorf("cannot evaluate VAR value on first pass: %v", err)
+		}
+		ctx.Set(fmt.Sprintf("]%d", i+1), val)
+	}
+	return in, nil
+}
+
+// ParseKbd parses a KBD directive, `LABEL KBD` or `LABEL KBD "PROMPT"`,
+// which reads the label's value, as an expression, using Kbd.
+func (m *Merlin) ParseKbd(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	prompt := "Give value for " + in.Label + ": "
+	if lp.Consume(`"`) {
+		lp.AcceptUntil(`"`)
+		prompt = lp.Emit()
+		lp.Consume(`"`)
+	}
+	if m.Kbd == nil {
+		return in, in.Errorf("KBD: no keyboard input")
+	}
+	s, err := m.Kbd(prompt)
+	if err != nil {
+		return in, in.Errorf("KBD: %v", err)
+	}
+	klp := lines.NewParse(strings.TrimSpace(s))
+	if in, err = m.ParseDo(ctx, in, klp); err != nil {
+		return in, err
+	}
+	if klp.Peek() != lines.Eol {
+		return in, in.Errorf("KBD: unexpected input after value: %q", klp.Rest())
+	}
+	val, err := in.Exprs[0].Eval(ctx, in.Line)
+	if err != nil {
+		return in, err
+	}
+	ctx.Set(in.Label, val)
+	return in, nil
+}
+
+// ParseSave parses SAV and DSK directives, which save output to the
+// named file.
+func (m *Merlin) ParseSave(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	lp.AcceptUntil(";")
+	in.TextArg = strings.TrimSpace(lp.Emit())
+	if in.TextArg == "" {
+		return in, in.Errorf("%s expects filename", in.Command)
+	}
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseXC parses an XC directive. The first enables 65C02 opcodes,
+// and the second, 65816 opcodes. "XC OFF" goes back to the 6502.
+func (m *Merlin) ParseXC(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	lp.IgnoreRun(whitespace)
+	switch {
+	case lp.AcceptString("OFF"):
+		m.xc = 0
+	case lp.Peek() != lines.Eol && lp.Peek() != m.CommentChar && lp.Peek() != ' ':
+		return in, in.Errorf("XC expects no argument, or OFF")
+	case m.xc < 2:
+		m.xc++
+	}
+	m.OpcodesByName = m.byXC[m.xc]
+	in.Width = 0
+	in.Final = true
+	return in, nil
+}
+
+// ParseMX parses an MX directive, setting the 65816's accumulator
+// (bit 1) and index register (bit 0) widths: a set bit means 8 bits.
+func (m *Merlin) ParseMX(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
+	in, err := m.ParseDo(ctx, in, lp)
+	if err != nil {
+		return in, err
+	}
+	val, err := in.Exprs[0].Eval(ctx, in.Line)
+	if err != nil {
+		return in, in.Errorf("MX needs a known value: %v", err)
+	}
+	m.mx = byte(val) & 3
+	return in, nil
+}
+
 func (m *Merlin) ParseInclude(ctx context.Context, in inst.I, lp *lines.Parse) (inst.I, error) {
 	lp.IgnoreRun(whitespace)
 	lp.AcceptUntil(";")
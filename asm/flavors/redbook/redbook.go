@@ -44,7 +44,7 @@ func newRedbook(name string, sets opcodes.Set) *RedBook {
 
 	r.Directives = map[string]common.DirectiveInfo{
 		"ORG":    {inst.TypeOrg, r.ParseOrg, 0},
-		"OBJ":    {inst.TypeNone, nil, 0},
+		"OBJ":    {inst.TypeTarget, r.ParseOrg, 0},
 		"ENDASM": {inst.TypeEnd, r.ParseNoArgDir, 0},
 		"EQU":    {inst.TypeEqu, r.ParseEquate, inst.VarEquNormal},
 		"EPZ":    {inst.TypeEqu, r.ParseEquate, inst.VarEquPageZero},
@@ -38,8 +38,8 @@ func New(sets opcodes.Set) *SCMA {
 
 	a.Directives = map[string]common.DirectiveInfo{
 		".IN":   {inst.TypeInclude, a.ParseInclude, 0},
-		".OR":   {inst.TypeOrg, a.ParseOrg, 0},
-		".TA":   {inst.TypeTarget, a.ParseNotImplemented, 0},
+		".OR":   {inst.TypeOrg, a.ParseOrg, inst.VarOrgTarget},
+		".TA":   {inst.TypeTarget, a.ParseOrg, 0},
 		".TF":   {inst.TypeNone, nil, 0},
 		".EN":   {inst.TypeEnd, a.ParseNoArgDir, 0},
 		".EQ":   {inst.TypeEqu, a.ParseEquate, 0},
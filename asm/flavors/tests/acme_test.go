@@ -0,0 +1,27 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zellyn/go6502/asm"
+)
+
+func TestAcmeSavedFiles(t *testing.T) {
+	a, err := assemble(ac, []string{
+		`!to "out.prg", cbm`,
+		"*=$0801",
+		"\tnop",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := a.SavedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []asm.SavedFile{{"out.prg", 0x801, h("0108ea")}}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("a.SavedFiles()=%v; want %v", files, want)
+	}
+}
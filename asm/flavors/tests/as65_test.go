@@ -0,0 +1,39 @@
+package tests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAs65Listing(t *testing.T) {
+	a, err := assemble(aa, []string{
+		"        org $1000",
+		"ROM_vectors = 1",
+		"carry   equ %00000001",
+		"        nolist",
+		"        nop ;hidden",
+		"        list",
+		"        nop ;shown",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := a.GenerateListing(&b, 3); err != nil {
+		t.Fatal(err)
+	}
+	listing := b.String()
+	for _, want := range []string{
+		"0001=             ROM_vectors = 1",
+		"0001=             carry   equ %00000001",
+		"1001: ea          ",
+	} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("want listing to contain %q; got listing:\n%s", want, listing)
+		}
+	}
+	if strings.Contains(listing, "hidden") {
+		t.Errorf("want nolist to hide lines; got listing:\n%s", listing)
+	}
+}
@@ -1,12 +1,18 @@
 package tests
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/asm/flavors/acme"
+	"github.com/zellyn/go6502/asm/flavors/as65"
+	"github.com/zellyn/go6502/asm/flavors/ca65"
+	"github.com/zellyn/go6502/asm/flavors/edasm"
 	"github.com/zellyn/go6502/asm/flavors/merlin"
 	"github.com/zellyn/go6502/asm/flavors/redbook"
 	"github.com/zellyn/go6502/asm/flavors/scma"
@@ -24,20 +30,54 @@ func h(s string) []byte {
 	return b
 }
 
-type asmFactory func() *asm.Assembler
+type asmFactory func(o lines.Opener) *asm.Assembler
 
-func TestMultiline(t *testing.T) {
-	o := lines.NewTestOpener()
-
-	ss := asmFactory(func() *asm.Assembler {
+var (
+	ss = asmFactory(func(o lines.Opener) *asm.Assembler {
 		return asm.NewAssembler(scma.New(opcodes.SetSweet16), o)
 	})
-	ra := asmFactory(func() *asm.Assembler {
+	ra = asmFactory(func(o lines.Opener) *asm.Assembler {
 		return asm.NewAssembler(redbook.NewRedbookA(opcodes.SetSweet16), o)
 	})
-	mm := asmFactory(func() *asm.Assembler {
-		return asm.NewAssembler(merlin.New(opcodes.SetSweet16), o)
+	// Merlin's KBD directive gets "$42".
+	mm = asmFactory(func(o lines.Opener) *asm.Assembler {
+		m := merlin.New(opcodes.SetSweet16)
+		m.Kbd = func(prompt string) (string, error) {
+			return "$42\n", nil
+		}
+		return asm.NewAssembler(m, o)
+	})
+	aa = asmFactory(func(o lines.Opener) *asm.Assembler {
+		return asm.NewAssembler(as65.New(opcodes.SetSweet16), o)
+	})
+	ac = asmFactory(func(o lines.Opener) *asm.Assembler {
+		return asm.NewAssembler(acme.New(opcodes.SetSweet16), o)
 	})
+	ca = asmFactory(func(o lines.Opener) *asm.Assembler {
+		return asm.NewAssembler(ca65.New(opcodes.SetSweet16), o)
+	})
+	ed = asmFactory(func(o lines.Opener) *asm.Assembler {
+		return asm.NewAssembler(edasm.New(opcodes.SetSweet16), o)
+	})
+)
+
+// assemble assembles the lines i as TESTFILE, with the other files ii,
+// using an assembler from af.
+func assemble(af asmFactory, i []string, ii map[string][]string) (*asm.Assembler, error) {
+	o := lines.NewTestOpener()
+	o["TESTFILE"] = strings.Join(i, "\n")
+	for k, v := range ii {
+		o[k] = strings.Join(v, "\n")
+	}
+	a := af(o)
+	if err := a.Load("TESTFILE", 0); err != nil {
+		return a, err
+	}
+	return a, a.Pass2()
+}
+
+func TestMultiline(t *testing.T) {
+	o := lines.NewTestOpener()
 
 	tests := []struct {
 		af     asmFactory          // assembler factory
@@ -237,6 +277,95 @@ func TestMultiline(t *testing.T) {
 			{0x2000, h("a902")},
 		}, true},
 
+		// Target address: labels follow the origin, bytes the target.
+		{ss, "Target", []string{
+			" .OR $D000",
+			" .TA $4000",
+			"L1 JMP L1",
+			" .OR $1000",
+			" NOP",
+		}, nil, "4c00d0ea", []membuf.Piece{
+			{0x1000, h("ea")},
+			{0x4000, h("4c00d0")},
+		}, true},
+
+		// Merlin: OBJ stays in force across ORGs.
+		{mm, "OBJ", []string{
+			" OBJ $4000",
+			" ORG $D000",
+			" JMP *",
+			" ORG $E000",
+			" NOP",
+		}, nil, "4c00d0ea", []membuf.Piece{
+			{0x4000, h("4c00d0ea")},
+		}, true},
+
+		// Segments keep their own location counters; bss stores nothing.
+		{aa, "Segments", []string{
+			" bss",
+			" ORG $0200",
+			"buf ds 2",
+			" code",
+			" ORG $1000",
+			" lda buf",
+			" data",
+			" ORG $2000",
+			"tbl dw buf",
+			" code",
+			" dw tbl",
+		}, nil, "0000ad000200020020", []membuf.Piece{
+			{0x1000, h("ad00020020")},
+			{0x2000, h("0002")},
+		}, true},
+
+		// as65: named macro arguments, defaults, count, and presence.
+		{aa, "Named macro arguments", []string{
+			"store macro value, addr=$10, extra",
+			" lda #\\value",
+			" sta \\addr",
+			" dw \\#,\\?extra,\\?addr",
+			" endm",
+			" store 1",
+			" store addr=$20, value=2",
+			" store 3, $30, 7",
+		}, nil, "a9018510010000000000" + "a9028520020000000100" + "a9038530030001000100", nil, true},
+
+		// as65: recursive macros, with per-expansion conditionals.
+		{aa, "Macro recursion", []string{
+			"table macro n",
+			" if \\n",
+			" table \\n-1",
+			" dw \\n",
+			" endif",
+			" endm",
+			" table 3",
+		}, nil, "010002000300", nil, true},
+
+		// as65: macro early exit.
+		{aa, "Macro exit", []string{
+			"first macro a",
+			" if \\a=0",
+			" exitm",
+			" endif",
+			" dw \\a",
+			" endm",
+			" first 0",
+			" first 5",
+			" dw 9",
+		}, nil, "05000900", nil, true},
+
+		// as65: macros defined by macros.
+		{aa, "Nested macro definitions", []string{
+			"outer macro",
+			"inner macro",
+			" dw 1",
+			" endm",
+			" dw 2",
+			" endm",
+			" outer",
+			" inner",
+		}, nil, "02000100", nil, true},
+
 		// Check turning MSB on and off
 		{ra, "MSB toggle", []string{
 			" ASC 'AB'",
@@ -306,13 +435,608 @@ func TestMultiline(t *testing.T) {
 			"FOUR = $cdef",
 			" NOP",
 		}, nil, "23ffff67abefea", nil, true},
+
+		// Merlin: XC for 65C02 and 65816 opcodes
+		{mm, "XC 65C02", []string{
+			" XC",
+			" STZ $12",
+			" BRA *",
+			" LDA ($12)",
+			" JMP ($1234,X)",
+		}, nil, "641280feb2127c3412", nil, true},
+		{mm, "XC 65816", []string{
+			" XC",
+			" XC",
+			" MX %00",
+			" LDA #$1234",
+			" LDAL $1234",
+			" LDA >$E12345",
+			" LDA [$12],Y",
+			" LDA 3,S",
+			" LDA (3,S),Y",
+			" MVN $01,$02",
+			" BRL *",
+			" JML $123456",
+		}, nil, "a93412af341200af4523e1b712a303b30354020182fdff5c563412", nil, true},
+		{mm, "XC 65816 REP and SEP", []string{
+			" XC",
+			" XC",
+			" SEP #$30",
+			" LDA #$12",
+			" REP #$20",
+			" LDA #$1234",
+			" LDX #$12",
+			" LDA #^$E12345",
+		}, nil, "e230a912c220a93412a212a9e100", nil, true},
+		{mm, "XC OFF", []string{
+			" XC",
+			" XC OFF",
+			" LDA ($12),Y",
+		}, nil, "b112", nil, true},
+
+		// Merlin: hand-written examples of its directives, with bytes
+		// worked out from the manuals' descriptions.
+		{mm, "DUM and DEND", []string{
+			"         ORG $300",
+			"         DUM $0",
+			"PTR      DS  2",
+			"COUNT    DS  1",
+			"         DEND",
+			"         LDA PTR",
+			"         STA COUNT",
+		}, nil, "", []membuf.Piece{{0x300, h("a5008502")}}, true},
+		{mm, "DS to the next page", []string{
+			"         ORG $80FE",
+			"         NOP",
+			"         DS  \\",
+			"         NOP",
+			"         DS  \\,$FF",
+			"         NOP",
+		}, nil, "", []membuf.Piece{{0x80fe, h("ea00ea" + strings.Repeat("ff", 0xff) + "ea")}}, true},
+		{mm, "LUP with a variable", []string{
+			"]A       =   0",
+			"         LUP 4",
+			"]A       =   ]A+1",
+			"         DFB ]A",
+			"         --^",
+		}, nil, "", []membuf.Piece{{0x8000, h("01020304")}}, true},
+		{mm, "Variables as branch targets", []string{
+			"         LDX #3",
+			"]LOOP    DEX",
+			"         BNE ]LOOP",
+			"         LDY #2",
+			"]LOOP    DEY",
+			"         BNE ]LOOP",
+		}, nil, "", []membuf.Piece{{0x8000, h("a203cad0fda00288d0fd")}}, true},
+		{mm, "VAR", []string{
+			"         VAR 1;$20",
+			"         LDA ]1",
+			"         STA ]2",
+		}, nil, "", []membuf.Piece{{0x8000, h("a5018520")}}, true},
+		{mm, "DO, ELSE, and FIN", []string{
+			"FLAG     =   0",
+			"         DO  FLAG",
+			"         LDA #1",
+			"         ELSE",
+			"         LDA #2",
+			"         FIN",
+		}, nil, "", []membuf.Piece{{0x8000, h("a902")}}, true},
+		{mm, "IF on a macro argument", []string{
+			"LOAD     MAC",
+			"         IF  #,]1",
+			"         LDA ]1",
+			"         ELSE",
+			"         LDA #]1",
+			"         FIN",
+			"         EOM",
+			"         LOAD #$12",
+			"         LOAD $34",
+		}, nil, "", []membuf.Piece{{0x8000, h("a912a934")}}, true},
+		{mm, "Strings", []string{
+			"         STR 'HI'",
+			`         REV "HI"`,
+			"         INV 'HI'",
+			"         FLS 'HI'",
+		}, nil, "", []membuf.Piece{{0x8000, h("024849c9c808094849")}}, true},
+		{mm, "CHK", []string{
+			"         HEX 010204",
+			"         CHK",
+		}, nil, "", []membuf.Piece{{0x8000, h("01020407")}}, true},
+		{mm, "KBD", []string{
+			"VAL      KBD \"Value?\"",
+			"         LDA #VAL",
+		}, nil, "", []membuf.Piece{{0x8000, h("a942")}}, true},
+		{mm, "ERR that passes", []string{
+			"         NOP",
+			"         ERR *-$8001",
+			"         ERR \\$8001",
+		}, nil, "", []membuf.Piece{{0x8000, h("ea")}}, true},
+
+		// ACME: examples after those in its docs.
+		{ac, "Origin and labels", []string{
+			"*=$1000",
+			"start\tlda #1",
+			"loop:\tjmp loop",
+		}, nil, "", []membuf.Piece{{0x1000, h("a9014c0210")}}, true},
+		{ac, "Precedence", []string{
+			"\t* = $1000",
+			"\t!byte 2 + 3 * 4, (2 + 3) * 4, 2 ^ 3 ^ 2 / 64",
+			"\t!byte 1 << 2 + 1, 7 & 3 | 8, 10 % 4, 9 DIV 2",
+			"\t!byte 3 < 4, 3 >= 4, 3 != 4, 3 = 3",
+			"\t!byte int(5) + addr(1)",
+			"\t!byte 2 = 2 < 3, 1 < 2 = 1",
+			"\t!byte -1 >> 63 & $ff, -1 >>> 63, -1 LSR 63, -2 ASR 1 & $ff",
+		}, nil, "", []membuf.Piece{{0x1000, h("0e1408" + "080b0204" + "01000101" + "06" + "0001" + "ff0101ff")}}, true},
+		{ac, "Unary operators", []string{
+			"\t* = $1000",
+			"\t!byte !0 & $ff, !$0f & $ff",
+			"\t!byte 1 + <$1234, 1 + >$1234, ^$123456 + 1",
+			"\t!byte <$1234 = $34, >$1234 + 1",
+			"\tlda #>$12ff + 1",
+		}, nil, "", []membuf.Piece{{0x1000, h("fff0" + "351312" + "0112" + "a913")}}, true},
+		{ac, "Bytes and words", []string{
+			"*=$1000",
+			"\t!byte $01, <label, >label",
+			"\t!08 2",
+			"label\t!word $1234, label",
+			"\t!16 5",
+		}, nil, "", []membuf.Piece{{0x1000, h("01041002" + "341204100500")}}, true},
+		{ac, "Text", []string{
+			"*=$1000",
+			`	!text "Ab", 13`,
+			`	!pet "Ab"`,
+			`	!scr "Ab@"`,
+		}, nil, "", []membuf.Piece{{0x1000, h("41620d" + "c142" + "410200")}}, true},
+		{ac, "Fill and align", []string{
+			"*=$1000",
+			"\t!fill 3",
+			"\t!fill 2, $ff",
+			"\t!align 7, 0",
+			"\t!align 3, 1, 0",
+			"\tnop",
+		}, nil, "", []membuf.Piece{{0x1000, h("000000ffff" + "eaeaea" + "00" + "ea")}}, true},
+		{ac, "Anonymous labels", []string{
+			"*=$1000",
+			"-\tdex",
+			"\tbne -",
+			"\tbeq +",
+			"\tnop",
+			"+\tbmi ++",
+			"++\trts",
+		}, nil, "", []membuf.Piece{{0x1000, h("cad0fdf001ea300060")}}, true},
+		{ac, "Zones and cheap locals", []string{
+			"*=$1000",
+			"!zone one",
+			".loop\tdex",
+			"\tbne .loop",
+			"!zone two",
+			".loop\tdey",
+			"\tbne .loop",
+			"first",
+			"@x\tnop",
+			"\tjmp @x",
+			"second",
+			"@x\tjmp @x",
+		}, nil, "", []membuf.Piece{{0x1000, h("cad0fd88d0fd" + "ea4c0610" + "4c0a10")}}, true},
+		{ac, "Zone blocks", []string{
+			"*=$1000",
+			".x\tnop",
+			"!zone inner {",
+			".x\tnop",
+			"\tjmp .x",
+			"}",
+			"\tjmp .x",
+		}, nil, "", []membuf.Piece{{0x1000, h("eaea4c01104c0010")}}, true},
+		{ac, "If and else", []string{
+			"*=$1000",
+			"flag = 1",
+			"!if flag = 1 {",
+			"\tlda #1",
+			"} else {",
+			"\tlda #2",
+			"}",
+			"!if flag > 1 {",
+			"\tlda #3",
+			"} else {",
+			"\tlda #4",
+			"}",
+			"!ifdef flag {",
+			"\tlda #5",
+			"}",
+			"!ifndef flag {",
+			"\tlda #6",
+			"}",
+		}, nil, "", []membuf.Piece{{0x1000, h("a901a904a905")}}, true},
+		{ac, "Nested blocks", []string{
+			"*=$1000",
+			"!if 0 {",
+			"\t!for i, 2 {",
+			"\t\t!byte i",
+			"\t}",
+			"} else {",
+			"\t!for i, 2 {",
+			"\t\t!if i = 2 {",
+			"\t\t\t!byte i",
+			"\t\t}",
+			"\t}",
+			"}",
+		}, nil, "", []membuf.Piece{{0x1000, h("02")}}, true},
+		{ac, "For loops", []string{
+			"*=$1000",
+			"!for i, 1, 3 {",
+			"\t!byte i",
+			"}",
+			"!for i, 3, 1 {",
+			"\t!byte i * 2",
+			"}",
+			"!for i, 2 {",
+			"\t!byte i + $10",
+			"}",
+		}, nil, "", []membuf.Piece{{0x1000, h("010203" + "060402" + "1112")}}, true},
+		{ac, "Macros", []string{
+			"*=$1000",
+			"!macro poke addr, val {",
+			"\tlda #val",
+			"\tsta addr",
+			"}",
+			"!macro wait {",
+			".loop\tdex",
+			"\tbne .loop",
+			"}",
+			"\t+poke $c000, $12",
+			"\t+wait",
+			"\t+wait",
+		}, nil, "", []membuf.Piece{{0x1000, h("a9128d00c0" + "cad0fd" + "cad0fd")}}, true},
+		{ac, "Macro arguments", []string{
+			"*=$1000",
+			"!macro double v {",
+			"\tlda #v*2",
+			"\tlda v",
+			"}",
+			"!macro index x, y {",
+			"\tlda x,x",
+			"\tsta (y),y",
+			"\t!byte x, y",
+			"}",
+			"\t+double 1+2",
+			"\t+index $12, $34",
+		}, nil, "", []membuf.Piece{{0x1000, h("a906a503" + "b512" + "9134" + "1234")}}, true},
+		{ac, "Pseudopc", []string{
+			"*=$1000",
+			"\tjmp there",
+			"!pseudopc $300 {",
+			"there\tjmp there",
+			"}",
+			"\tjmp *",
+		}, nil, "", []membuf.Piece{{0x1000, h("4c0003" + "4c0003" + "4c0610")}}, true},
+		{ac, "Source and binary", []string{
+			"*=$1000",
+			`!source "INCLUDED"`,
+			`!binary "DATA"`,
+			`!bin "DATA", 2, 1`,
+			`!bi "DATA", , 3`,
+		}, map[string][]string{
+			"DATA":     {"\x00\x01\x02\x03\x04"},
+			"INCLUDED": {"\tlda #$42"},
+		}, "", []membuf.Piece{{0x1000, h("a942" + "0001020304" + "0102" + "0304")}}, true},
+		{ac, "Comments and case", []string{
+			"*=$1000 ; start",
+			"\tLDA #';'\t; load",
+			"\tlda #\"a\"",
+		}, nil, "", []membuf.Piece{{0x1000, h("a93ba961")}}, true},
+
+		// ca65: examples after those in its docs.
+		{ca, "Origin and labels", []string{
+			"\t.org $1000",
+			"start:\tlda #1",
+			"loop:\tjmp loop",
+			"\tasl a",
+			"\tASL",
+		}, nil, "", []membuf.Piece{{0x1000, h("a9014c02100a0a")}}, true},
+		{ca, "Precedence", []string{
+			"\t.org $1000",
+			"\t.byte 2 + 3 * 4, 1 + 2 << 3, 6 & 3 | 8, 13 .MOD 4",
+			"\t.byte 1 + 1 = 2, 3 <> 3, 1 < 2 && 2 < 1, 1 .OR 0",
+			"\t.byte !1 || 1, .not 0, ~1 & $ff, 5 ^ 3",
+		}, nil, "", []membuf.Piece{{0x1000, h("0e110a01" + "01000001" + "0001fe06")}}, true},
+		{ca, "Byte operators", []string{
+			"\t.org $1000",
+			"label = $123456",
+			"\t.byte <label, >label, ^label, <label+1",
+			"\t.byte .lobyte(label+1), .hibyte(label), .bankbyte(label)",
+			"\tlda #<label",
+			"\tlda #>label",
+		}, nil, "", []membuf.Piece{{0x1000, h("56341257" + "573412" + "a956a934")}}, true},
+		{ca, "Data", []string{
+			"\t.org $1000",
+			"\t.byte \"AB\", 'C', 0",
+			"\t.asciiz \"hi\"",
+			"\t.word $1234, label",
+			"label:\t.addr label",
+			"\t.dbyt $1234",
+			"\t.res 2",
+			"\t.res 2, $ff",
+		}, nil, "", []membuf.Piece{{0x1000, h("41424300" + "686900" + "34120b10" + "0b10" + "1234" + "0000ffff")}}, true},
+		{ca, "Unnamed labels", []string{
+			"\t.org $1000",
+			":\tdex",
+			"\tbne :-",
+			"\tbeq :+",
+			"\tbmi :++",
+			":\tnop",
+			":\trts",
+		}, nil, "", []membuf.Piece{{0x1000, h("cad0fdf00230" + "01ea60")}}, true},
+		{ca, "Cheap locals", []string{
+			"\t.org $1000",
+			"first:",
+			"@x:\tnop",
+			"\tjmp @x",
+			"second:",
+			"@x:\tjmp @x",
+		}, nil, "", []membuf.Piece{{0x1000, h("ea4c00104c0410")}}, true},
+		{ca, "Procs and scopes", []string{
+			"\t.org $1000",
+			"count = 3",
+			".proc main",
+			"count = 5",
+			"\tldx #count",
+			"loop:\tjsr print",
+			"\tjmp loop",
+			".endproc",
+			".proc print",
+			"loop:\tldy #count",
+			"\tjmp loop",
+			".endproc",
+			".scope",
+			"\tjmp main::loop",
+			"\tjmp ::print",
+			".endscope",
+		}, nil, "", []membuf.Piece{{0x1000, h("a205200810" + "4c0210" + "a0034c0810" + "4c02104c0810")}}, true},
+		{ca, "If, elseif, and else", []string{
+			"\t.org $1000",
+			"mode = 2",
+			".if mode = 1",
+			"\tlda #1",
+			".elseif mode = 2",
+			"\tlda #2",
+			".elseif mode = 2",
+			"\tlda #3",
+			".else",
+			"\tlda #4",
+			".endif",
+			".if mode = 3",
+			"\tlda #5",
+			".elseif mode = 4",
+			"\tlda #6",
+			".else",
+			"\tlda #7",
+			".endif",
+			".ifdef mode",
+			"\tlda #8",
+			".endif",
+			".ifndef mode",
+			"\tlda #9",
+			".endif",
+		}, nil, "", []membuf.Piece{{0x1000, h("a902a907a908")}}, true},
+		{ca, "Macros", []string{
+			"\t.org $1000",
+			".macro poke addr, val",
+			"\t.ifblank val",
+			"\tlda #0",
+			"\t.else",
+			"\tlda #val",
+			"\t.endif",
+			"\tsta addr",
+			".endmacro",
+			".macro twice",
+			"\tnop",
+			"\t.exitmacro",
+			"\tbrk",
+			".endmac",
+			"\tpoke $c000, $12",
+			"\tpoke $c001",
+			"\tpoke {$c000 + 2}, (1 + 2)",
+			"twice",
+		}, nil, "", []membuf.Piece{{0x1000, h("a9128d00c0" + "a9008d01c0" + "a9038d02c0" + "ea")}}, true},
+		{ca, "Include and incbin", []string{
+			"\t.org $1000",
+			".include \"defs.inc\"",
+			"\tsta SCREEN",
+			".incbin \"data.bin\"",
+			".incbin \"data.bin\", 3",
+			".incbin \"data.bin\", 1, 2",
+		}, map[string][]string{
+			"data.bin": {"\x00\x01\x02\x03\x04"},
+			"defs.inc": {"SCREEN = $0400"},
+		}, "", []membuf.Piece{{0x1000, h("8d0004" + "0001020304" + "0304" + "0102")}}, true},
+		{ca, "Case and comments", []string{
+			"\t.ORG $1000 ; start",
+			"\tLDA #';'\t; load",
+			"\t.BYTE 1",
+		}, nil, "", []membuf.Piece{{0x1000, h("a93b01")}}, true},
+
+		// EDASM: examples written from the manual's directive
+		// descriptions. Only "MSB" is checked against real EDASM
+		// output: the session recorded in asm/asm.org, addresses and
+		// all.
+		{ed, "MSB", []string{
+			"         ASC   \"ABC\"",
+			"         MSB   OFF",
+			"         ASC   \"ABC\"",
+			"         MSB   ON",
+			"         ASC   \"ABC\"",
+		}, nil, "", []membuf.Piece{{0x0000, h("414243" + "414243" + "c1c2c3")}}, true},
+		{ed, "MSB and DCI", []string{
+			"         ORG $0800",
+			"         MSB ON",
+			"         DCI \"AB\"",
+			"         MSB OFF",
+			"         DCI \"AB\"",
+		}, nil, "", []membuf.Piece{{0x0800, h("c142" + "41c2")}}, true},
+		{ed, "Data", []string{
+			"         ORG $1000",
+			"LABEL    DFB 1,<LABEL,>LABEL",
+			"         DW $1234,LABEL",
+			"         DDB $1234",
+			"         STR \"HI\"",
+			"         DS 2",
+		}, nil, "", []membuf.Piece{{0x1000, h("010010" + "34120010" + "1234" + "024849" + "0000")}}, true},
+		{ed, "Equates and operators", []string{
+			"         ORG $1000",
+			"TEN      EQU 10",
+			"         DFB TEN+2*3,TEN&$0C,TEN!5,TEN^$FF",
+			"         LDA #TEN",
+			"         LDA TEN",
+			"         LDA #/$1234",
+		}, nil, "", []membuf.Piece{{0x1000, h("24080ff5" + "a90a" + "a50a" + "a912")}}, true},
+		{ed, "Do, else, fin", []string{
+			"         ORG $1000",
+			"FLAG     EQU 1",
+			"         DO FLAG",
+			"         LDA #1",
+			"         ELSE",
+			"         LDA #2",
+			"         FIN",
+			"         DO FLAG-1",
+			"         LDA #3",
+			"         ELSE",
+			"         LDA #4",
+			"         FIN",
+		}, nil, "", []membuf.Piece{{0x1000, h("a901a904")}}, true},
+		{ed, "Include and chain", []string{
+			"         ORG $1000",
+			"         INCLUDE SRC/DEFS",
+			"         STA SCREEN",
+			"         LDA #1",
+			"         CHN SRC/PART2 ; the rest",
+			"         LDA #3",
+		}, map[string][]string{
+			"SRC/DEFS":  {"SCREEN   EQU $0400"},
+			"SRC/PART2": {"         LDA #2"},
+		}, "", []membuf.Piece{{0x1000, h("8d0004" + "a901" + "a902")}}, true},
+		{ed, "Listing controls", []string{
+			"         ORG $1000",
+			"         SBTL \"MAIN PROGRAM\"",
+			"         PAGE",
+			"         SKP 2",
+			"         LDA #1 ; comment",
+		}, nil, "", []membuf.Piece{{0x1000, h("a901")}}, true},
+
+		// as65: examples after those in Klaus Dormann's functional
+		// tests, and the as65 docs.
+		{aa, "Equates and precedence", []string{
+			"intdis  equ %00000100",
+			"fao     equ $30",
+			"m8i     equ $ff&~intdis",
+			"flag = 3",
+			"        org $1000",
+			"        db  2 + 3 * 4, 1 << 2 + 1, 6 & 3 | 8, 10 % 4",
+			"        db  1 = 1, 1 != 1, 2 < 1 || 1, !0",
+			"        cmp #(1|fao)&m8i",
+			"        eor #1|fao",
+			"        cpx #('A'+1)",
+			"        db  flag",
+		}, nil, "", []membuf.Piece{{0x1000, h("0e080a02" + "01000101" + "c931" + "4931" + "e042" + "03")}}, true},
+		{aa, "Low and high bytes", []string{
+			"zp1     equ $10",
+			"zp2     equ $30",
+			"        org $1234",
+			"start   lda #lo start",
+			"        lda #hi start",
+			"        eor #lo(~4)",
+			"        adc (lo zp1-zp2,x)",
+		}, nil, "", []membuf.Piece{{0x1234, h("a934a912" + "49fb" + "61e0")}}, true},
+		{aa, "Current location", []string{
+			"        org $1000",
+			"target  = *+1",
+			"        lda #0",
+			"        beq *+4",
+			"        jmp *",
+			"        sta target",
+		}, nil, "", []membuf.Piece{{0x1000, h("a900f0024c04108d0110")}}, true},
+		{aa, "Data", []string{
+			"        org $1000",
+			"label   db  $c3,lo $1202,0",
+			`        db  "Hi\r\n",0`,
+			"        dw  label, $1234",
+			"        ds  3",
+			"        fcb 1",
+			"        fdb 2",
+		}, nil, "", []membuf.Piece{{0x1000, h("c30200" + "48690d0a00" + "00103412" + "000000" + "01" + "0200")}}, true},
+		{aa, "Character map", []string{
+			"        org $1000",
+			`        cmap "AB", $c1`,
+			"        cmap 'Z', 0",
+			`        db  "ABZC"`,
+			"        cmap",
+			`        db  "AZ"`,
+		}, nil, "", []membuf.Piece{{0x1000, h("c1c2004341" + "5a")}}, true},
+		{aa, "If, else, endif", []string{
+			"I_flag = 3",
+			"        org $1000",
+			"        if I_flag = 1",
+			"        sei",
+			"        else",
+			"        cli",
+			"        endif",
+			"    if I_flag != 1",
+			"        if I_flag = 3",
+			"        nop",
+			"        endif",
+			"    endif",
+			"        ifdef I_flag",
+			"        db 1",
+			"        endif",
+			"        ifndef I_flag",
+			"        db 2",
+			"        endif",
+		}, nil, "", []membuf.Piece{{0x1000, h("58ea01")}}, true},
+		{aa, "Macros", []string{
+			"trap_ne macro",
+			"        bne *           ;failed not equal (non zero)",
+			"        endm",
+			"load_flag   macro",
+			"            lda #\\1",
+			"            endm",
+			"set_a   macro",
+			"        load_flag \\2",
+			"        pha",
+			"        lda #\\1",
+			"        plp",
+			"        endm",
+			"        org $1000",
+			"        set_a $ff,0",
+			"        trap_ne",
+		}, nil, "", []membuf.Piece{{0x1000, h("a90048a9ff28" + "d0fe")}}, true},
+		{aa, "Segments", []string{
+			"        data",
+			"        org $a",
+			"zp1     db  $c3",
+			"zpt     ds  2",
+			"        bss",
+			"        org $200",
+			"abst    ds  5",
+			"        code",
+			"        org $1000",
+			"        lda zp1",
+			"        sta abst",
+		}, nil, "", []membuf.Piece{{0x000a, h("c30000")}, {0x1000, h("a50a8d0002")}}, true},
+		{aa, "Include and case", []string{
+			`        INCLUDE "defs.inc"`,
+			"        ORG $1000",
+			"        STA screen",
+			"        Lda #1",
+			"        asl a",
+		}, map[string][]string{
+			"defs.inc": {"screen = $0400"},
+		}, "", []membuf.Piece{{0x1000, h("8d0004a9010a")}}, true},
 	}
 
 	for i, tt := range tests {
 		if !tt.active {
 			continue
 		}
-		a := tt.af()
+		a := tt.af(o)
 		if tt.b == "" && len(tt.ps) == 0 {
 			t.Fatalf(`%d("%s" - %s): test case must specify bytes or pieces`, i, tt.name, a.Flavor)
 		}
@@ -351,3 +1075,162 @@ func TestMultiline(t *testing.T) {
 		}
 	}
 }
+
+func TestMultilineErrors(t *testing.T) {
+	tests := []struct {
+		af   asmFactory          // assembler factory
+		i    []string            // main file: lines
+		ii   map[string][]string // other files: lines
+		want string              // error text, expected
+	}{
+		{ss, []string{" !BYTE 1"}, nil, "expecting instruction, found '!'"},
+		{ed, []string{" LDA #1", " --^"}, nil, "expecting instruction, found '-'"},
+		{mm, []string{" NOP", " ERR *-$8000"}, nil, "error condition is true"},
+		{mm, []string{" NOP", " NOP", " ERR \\$8001"}, nil, "error condition is true"},
+		{ac, []string{"}"}, nil, "without open block"},
+		{ac, []string{"!for i, 3 {", "\tnop"}, nil, "end of file"},
+		{ac, []string{"\t!byte sin(1)"}, nil, "not supported"},
+		{ac, []string{"\t!byte 2 ^ $7fffffffff"}, nil, "power overflows"},
+		{ac, []string{"@x\tnop"}, nil, "without previous label"},
+		{ac, []string{`!to "out", atari`}, nil, "unknown format"},
+		{ca, []string{".endproc"}, nil, "without matching start"},
+		{ca, []string{".scope", ".endproc"}, nil, "without matching start"},
+		{ca, []string{"@x:\tnop"}, nil, "without previous label"},
+		{ca, []string{".elseif 1"}, nil, "outside ifdef"},
+		{ed, []string{" INCLUDE"}, nil, "expects a filename"},
+		{ed, []string{" NOP", " REL"}, nil, "REL must come before any code or ORG"},
+		{ed, []string{" ORG $1000", " REL"}, nil, "REL must come before any code or ORG"},
+		{ed, []string{" REL", " ORG $1000"}, nil, "ORG not allowed in REL code"},
+		{ed, []string{" ENTRY START", "START NOP"}, nil, "ENTRY without REL"},
+		{ed, []string{" EXTRN EXT1,EXT2"}, nil, "EXTRN without REL"},
+		{ed, []string{" REL", " EXTRN EXT", "X EQU EXT+1"}, nil, "equates can't refer to EXTRN symbols"},
+		{ed, []string{" FIN"}, nil, "outside ifdef"},
+		{aa, []string{` db "abc`}, nil, "expected closing quote"},
+		{aa, []string{` db "\q"`}, nil, "unknown escape"},
+		{aa, []string{" cmap 'a'"}, nil, "cmap expects"},
+		{aa, []string{" lda"}, nil, "with no arguments"},
+	}
+
+	for i, tt := range tests {
+		_, err := assemble(tt.af, tt.i, tt.ii)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%d(%q): want error containing %q; got %v", i, tt.i, tt.want, err)
+		}
+	}
+}
+
+func TestSegmentOrigins(t *testing.T) {
+	o := lines.NewTestOpener()
+	a := asm.NewAssembler(as65.New(opcodes.SetSweet16), o)
+	a.SegmentOrigins = map[string]uint16{"code": 0x1000, "data": 0x2000}
+	o["TESTFILE"] = strings.Join([]string{
+		"start dw tbl",
+		" data",
+		"tbl dw start",
+		" code",
+		" dw start",
+		" bss",
+		"buf ds 2",
+		" data",
+		" dw buf",
+	}, "\n")
+	if err := a.Load("TESTFILE", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Pass2(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := a.Membuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bss isn't placed, so starts at the default origin.
+	want := []membuf.Piece{
+		{0x1000, h("00200010")},
+		{0x2000, h("00100008")},
+	}
+	if got := m.Pieces(); !reflect.DeepEqual(got, want) {
+		t.Errorf("m.Pieces()=%v; want %v", got, want)
+	}
+}
+
+func TestTargetListing(t *testing.T) {
+	o := lines.NewTestOpener()
+	a := asm.NewAssembler(scma.New(opcodes.SetSweet16), o)
+	o["TESTFILE"] = strings.Join([]string{
+		" .OR $D000",
+		" .TA $4000",
+		"L1 JMP L1",
+		"L2 .EQ $12",
+	}, "\n")
+	if err := a.Load("TESTFILE", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Pass2(); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := a.GenerateListing(&b, 3); err != nil {
+		t.Fatal(err)
+	}
+	listing := b.String()
+	for _, want := range []string{
+		"d000/4000: 4c 00 d0    L1 JMP L1\n",
+		"     0012=             L2 .EQ $12\n",
+	} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("want listing to contain %q; got listing:\n%s", want, listing)
+		}
+	}
+}
+
+// TestEquateListing checks that listings show equates' values, marked
+// with "=", in each flavor.
+func TestEquateListing(t *testing.T) {
+	tests := []struct {
+		af asmFactory
+		i  []string // lines: an equate, then an op using it
+	}{
+		{ss, []string{"ZP .EQ $12", " LDA ZP"}},
+		{ra, []string{"ZP EQU $12", " LDA ZP"}},
+		{mm, []string{"ZP = $12", " LDA ZP"}},
+		{ed, []string{"ZP EQU $12", " LDA ZP"}},
+		{aa, []string{"zp equ $12", " lda zp"}},
+		{aa, []string{"zp = $12", " lda zp"}},
+		{ac, []string{"zp = $12", "\tlda zp"}},
+		{ca, []string{"zp = $12", "\tlda zp"}},
+	}
+	for i, tt := range tests {
+		a, err := assemble(tt.af, tt.i, nil)
+		if err != nil {
+			t.Errorf("%d(%q): %v", i, tt.i, err)
+			continue
+		}
+		var b bytes.Buffer
+		if err := a.GenerateListing(&b, 3); err != nil {
+			t.Errorf("%d(%q): %v", i, tt.i, err)
+			continue
+		}
+		org := a.Flavor.DefaultOrigin()
+		want := fmt.Sprintf("0012=             %s\n%04x: a5 12       %s\n", tt.i[0], org, tt.i[1])
+		if got := b.String(); got != want {
+			t.Errorf("%d(%q): want listing\n%s; got\n%s", i, tt.i, want, got)
+		}
+	}
+}
+
+func TestMacroDepth(t *testing.T) {
+	o := lines.NewTestOpener()
+	a := asm.NewAssembler(as65.New(opcodes.SetSweet16), o)
+	a.MacroDepth = 5
+	o["TESTFILE"] = strings.Join([]string{
+		"forever macro",
+		" forever",
+		" endm",
+		" forever",
+	}, "\n")
+	err := a.Load("TESTFILE", 0)
+	if err == nil || !strings.Contains(err.Error(), "more than 5 deep") {
+		t.Errorf("want macro depth error; got %v", err)
+	}
+}
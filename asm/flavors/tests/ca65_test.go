@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm/lines"
+	"github.com/zellyn/go6502/asm/membuf"
+)
+
+func TestCa65Segments(t *testing.T) {
+	o := lines.NewTestOpener()
+	o["TESTFILE"] = strings.Join([]string{
+		".segment \"CODE\"",
+		"\tlda buf",
+		".bss",
+		"buf:\t.res 2",
+		".code",
+		"\tsta buf+1",
+		".segment \"RODATA\"",
+		"\t.byte 1",
+	}, "\n")
+	a := ca(o)
+	a.SegmentOrigins = map[string]uint16{"code": 0x1000, "bss": 0x2000, "rodata": 0x3000}
+	if err := a.Load("TESTFILE", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Pass2(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := a.Membuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []membuf.Piece{{0x1000, h("ad00208d0120")}, {0x3000, h("01")}}
+	if got := m.Pieces(); !reflect.DeepEqual(got, want) {
+		t.Errorf("m.Pieces()=%v; want %v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm/flavors/edasm"
+)
+
+func TestEdasmListing(t *testing.T) {
+	a, err := assemble(ed, []string{
+		"         ORG $1000",
+		"         LST OFF",
+		"         NOP ;hidden",
+		"         LST ON",
+		"         NOP ;shown",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := a.GenerateListing(&b, 3); err != nil {
+		t.Fatal(err)
+	}
+	listing := b.String()
+	if strings.Contains(listing, "hidden") {
+		t.Errorf("want LST OFF to hide lines; got listing:\n%s", listing)
+	}
+	if !strings.Contains(listing, "shown") {
+		t.Errorf("want LST ON to show lines; got listing:\n%s", listing)
+	}
+}
+
+// TestEdasmRecordedListing checks addresses and bytes against a listing from
+// EDASM itself: the session recorded in asm/asm.org. The source is
+// what follows each listing line's line number.
+func TestEdasmRecordedListing(t *testing.T) {
+	recorded := []string{
+		`0000:41 42 43        1           ASC   "ABC"`,
+		`0003:                2           MSB   OFF`,
+		`0003:41 42 43        3           ASC   "ABC"`,
+		`0006:                4           MSB   ON`,
+		`0006:C1 C2 C3        5           ASC   "ABC"`,
+	}
+	var source []string
+	for _, r := range recorded {
+		source = append(source, r[22:])
+	}
+	a, err := assemble(ed, source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := a.GenerateListing(&b, 3); err != nil {
+		t.Fatal(err)
+	}
+	rows := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(rows) != len(recorded) {
+		t.Fatalf("want %d listing rows; got listing:\n%s", len(recorded), b.String())
+	}
+	for i, row := range rows {
+		got := strings.ToUpper(strings.Replace(strings.TrimSpace(strings.TrimSuffix(row, source[i])), ": ", ":", 1))
+		if want := strings.TrimSpace(recorded[i][:13]); got != want {
+			t.Errorf("row %d: want %q; got %q", i+1, want, got)
+		}
+	}
+}
+
+func TestEdasmRel(t *testing.T) {
+	a, err := assemble(ed, []string{
+		"         REL",
+		"         ENTRY START",
+		"         EXTRN COUT",
+		"SIX      EQU 6",
+		"START    LDA MSG",
+		"         JSR COUT+SIX",
+		"         LDA #<MSG",
+		"         LDX #>MSG",
+		"         LDY SIX",
+		"         BNE START",
+		"MSG      DW START,MSG-START",
+		"         DDB MSG",
+		"         DFB >MSG",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := a.Flavor.(*edasm.Edasm).Rel(a.Ctx, a.Insts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := h("1500" + // code length
+		"ad0e00" + "200600" + "a90e" + "a200" + "a406" + "d0f2" + // code
+		"00000e00" + "000e" + "00" +
+		"88010000" + "98040001" + "08070000" + "4809000e" + // RLD
+		"880e0000" + "a8120000" + "4814000e" + "00" +
+		"d3d4c1d254" + "08" + "0000" + // ESD: START
+		"c3cfd554" + "10" + "0100" + "00") // COUT
+	if !bytes.Equal(got, want) {
+		t.Errorf("want\n%x; got\n%x", want, got)
+	}
+}
+
+func TestEdasmRelErrors(t *testing.T) {
+	for _, tt := range []struct {
+		i    []string
+		want string
+	}{
+		{[]string{" REL", "L NOP", " DW L*2"}, "not relocatable"},
+		{[]string{" REL", " EXTRN E", " DW -E"}, "not relocatable"},
+		{[]string{" REL", " ENTRY NOWHERE"}, "ENTRY symbol NOWHERE is never defined"},
+	} {
+		a, err := assemble(ed, tt.i, nil)
+		if err != nil {
+			t.Errorf("%q: %v", tt.i, err)
+			continue
+		}
+		_, err = a.Flavor.(*edasm.Edasm).Rel(a.Ctx, a.Insts)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: want error containing %q; got %v", tt.i, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm"
+)
+
+func TestMerlinSavedFiles(t *testing.T) {
+	a, err := assemble(mm, []string{
+		"         ORG $300",
+		"         NOP",
+		"         SAV ONE",
+		"         ORG $400",
+		"         RTS",
+		"         RTS",
+		"         SAV TWO",
+		"         DSK THREE",
+		"         ORG $500",
+		"         BRK",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := a.SavedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []asm.SavedFile{
+		{"ONE", 0x300, h("ea")},
+		{"TWO", 0x400, h("6060")},
+		{"THREE", 0x500, h("00")},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("a.SavedFiles()=%v; want %v", files, want)
+	}
+}
+
+func TestMerlinListing(t *testing.T) {
+	a, err := assemble(mm, []string{
+		"M1       MAC",
+		"         NOP ;in macro",
+		"         EOM",
+		"         LST OFF",
+		"         NOP ;hidden",
+		"         LST ON",
+		"         M1",
+		"         EXP OFF",
+		"         M1",
+		"         EXP ONLY",
+		"         M1",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := a.GenerateListing(&b, 3); err != nil {
+		t.Fatal(err)
+	}
+	listing := b.String()
+	if strings.Contains(listing, "hidden") {
+		t.Errorf("want LST OFF to hide lines; got listing:\n%s", listing)
+	}
+	if got := strings.Count(listing, "in macro"); got != 2 {
+		t.Errorf("want 2 macro lines listed; got %d in listing:\n%s", got, listing)
+	}
+	if got := strings.Count(listing, "EOM"); got != 1 {
+		t.Errorf("want 1 macro end listed; got %d in listing:\n%s", got, listing)
+	}
+}
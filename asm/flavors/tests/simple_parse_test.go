@@ -33,8 +33,9 @@ func TestSimpleCommonFunctions(t *testing.T) {
 		{aa, " beq $2347", "{beq $2347}", "f000"},
 		{aa, " dw $1234", "{data/wle $1234}", "3412"},
 		{aa, " ds 5", "{data/bz $0005}", "0000000000"},
-		// TODO(zellyn): make this work.
-		// {aa, ` db "\aError\r\n",0`, "{data/b xyzzy}", "4572726f720d0a00"},
+		{aa, " bss", `{seg "bss"}`, ""},
+		{aa, " code", `{seg "code"}`, ""},
+		{aa, ` db "\aError\r\n",0`, "{data/b $0007,$0045,$0072,$0072,$006f,$0072,$000d,$000a,$0000}", "074572726f720d0a00"},
 		{aa, " jmp $1234", "{jmp/abs $1234}", "4c3412"},
 		{aa, " jmp ($1234)", "{jmp/ind $1234}", "6c3412"},
 		{aa, " lda #$12", "{lda/imm (lsb $0012)}", "a912"},
@@ -45,20 +46,20 @@ func TestSimpleCommonFunctions(t *testing.T) {
 		{aa, " lda ($12),y", "{lda/indy $0012}", "b112"},
 		{aa, " lda ($12,x)", "{lda/indx $0012}", "a112"},
 		{aa, " ldx $12,y", "{ldx/zpy $0012}", "b612"},
-		// {aa, " org $D000", "{org $d000}", ""},
+		{aa, " org $D000", "{org $d000}", ""},
 		{aa, " rol $12", "{rol/zp $0012}", "2612"},
 		{aa, " rol $1234", "{rol/abs $1234}", "2e3412"},
 		{aa, " rol a", "{rol}", "2a"},
 		{aa, " sta $1234,y", "{sta/absy $1234}", "993412"},
 		{aa, "; Comment", "{-}", ""},
 		{aa, "Label", "{- 'Label'}", ""},
-		// {aa, ` include "FILE.NAME"`, "{inc 'FILE.NAME'}", ""},
-		// {aa, ` title "Title here"`, "{-}", ""},
-		// {ss, " .TA *-1234", "{target (- * $04d2)}", ""},
+		{aa, ` include "FILE.NAME"`, "{inc 'FILE.NAME'}", ""},
+		{aa, ` title "Title here"`, "{-}", ""},
 		{mm, " <<<", `{endm}`, ""},
 		{mm, " >>> M1,$42 ;$43", `{call M1 {"$42"}}`, ""},
 		{mm, " >>> M1.$42", `{call M1 {"$42"}}`, ""},
 		{mm, " >>> M1/$42;$43", `{call M1 {"$42", "$43"}}`, ""},
+		{mm, " --^", "{endlup}", ""},
 		{mm, " BEQ $2343", "{BEQ $2343}", "f0fc"},
 		{mm, " BEQ $2345", "{BEQ $2345}", "f0fe"},
 		{mm, " BEQ $2347", "{BEQ $2347}", "f000"},
@@ -68,11 +69,17 @@ func TestSimpleCommonFunctions(t *testing.T) {
 		{mm, " DDB $12,$34,$1234", "{data/wbe $0012,$0034,$1234}", "001200341234"},
 		{mm, " DFB $12,$34,$1234", "{data/b $0012,$0034,$1234}", "123434"},
 		{mm, " DFB $34,100,$81A2-$77C4,%1011,>$81A2-$77C4", "{data/b $0034,$0064,(- $81a2 $77c4),$000b,(msb (- $81a2 $77c4))}", "3464de0b09"},
-		{mm, " DSK OUTFILE", "{-}", ""},
+		{mm, " DS 2,$FF", "{data/b $0002,$00ff}", "ffff"},
+		{mm, " DS 3", "{data/b $0003}", "000000"},
+		{mm, " DSK OUTFILE", `{save "OUTFILE"}`, ""},
+		{mm, " DUM $1000", "{dum $1000}", ""},
 		{mm, " EOM", `{endm}`, ""},
+		{mm, " ERR *-$2345", "{err (- * $2345)}", ""},
+		{mm, " FLS 'AB'", "{data/b}", "4142"},
 		{mm, " HEX 00,01,FF,AB", "{data/b}", "0001ffab"},
 		{mm, " HEX 0001FFAB", "{data/b}", "0001ffab"},
 		{mm, " INCW $42;$43", `{call INCW {"$42", "$43"}}`, ""},
+		{mm, " INV 'AB'", "{data/b}", "0102"},
 		{mm, " JMP $1234", "{JMP/abs $1234}", "4c3412"},
 		{mm, " JMP ($1234)", "{JMP/ind $1234}", "6c3412"},
 		{mm, " LDA #$12", "{LDA/imm (lsb $0012)}", "a912"},
@@ -90,15 +97,21 @@ func TestSimpleCommonFunctions(t *testing.T) {
 		{mm, " LDA@ $12", "{LDA/abs $0012}", "ad1200"},
 		{mm, " LDAX $12", "{LDA/abs $0012}", "ad1200"},
 		{mm, " LDX $12,Y", "{LDX/zpy $0012}", "b612"},
+		{mm, " LUP 4", "{lup $0004}", ""},
 		{mm, " ORG $D000", "{org $d000}", ""},
 		{mm, " PMC M1($42", `{call M1 {"$42"}}`, ""},
 		{mm, " PMC M1-$42", `{call M1 {"$42"}}`, ""},
+		{mm, " OBJ $4000", "{target $4000}", ""},
 		{mm, " PUT !FILE.NAME", "{inc 'FILE.NAME'}", ""},
+		{mm, ` REV "AB"`, "{data/b}", "c2c1"},
 		{mm, " ROL $12", "{ROL/zp $0012}", "2612"},
 		{mm, " ROL $1234", "{ROL/abs $1234}", "2e3412"},
 		{mm, " ROL", "{ROL}", "2a"},
-		{mm, " SAV OUTFILE", "{-}", ""},
+		{mm, " SAV OUTFILE", `{save "OUTFILE"}`, ""},
 		{mm, " STA $1234,Y", "{STA/absy $1234}", "993412"},
+		{mm, " STR 'AB'", "{data/b}", "024142"},
+		{mm, " STRL 'AB'", "{data/b}", "02004142"},
+		{mm, " VAR 1;2", "{- $0001,$0002}", ""},
 		{mm, "* Comment", "{-}", ""},
 		{mm, "ABC = $800", "{= 'ABC' $0800}", ""},
 		{mm, "L1 = 'A.2", "{= 'L1' (| $0041 $0002)}", ""},
@@ -106,6 +119,7 @@ func TestSimpleCommonFunctions(t *testing.T) {
 		{mm, "L1 = 1234+%10111", "{= 'L1' (+ $04d2 $0017)}", ""},
 		{mm, "L1 = 2*L2+$231", "{= 'L1' (+ (* $0002 L2) $0231)}", ""},
 		{mm, "L1 = L2!'A'", "{= 'L1' (^ L2 $0041)}", ""},
+		{mm, "]LOOP = 3", "{= ']LOOP' $0003}", ""},
 		{mm, "L1 = L2&$7F", "{= 'L1' (& L2 $007f)}", ""},
 		{mm, "L1 = L2-L3", "{= 'L1' (- L2 L3)}", ""},
 		{mm, "L1 = L2.%10000000", "{= 'L1' (| L2 $0080)}", ""},
@@ -179,6 +193,7 @@ func TestSimpleCommonFunctions(t *testing.T) {
 		{ss, " .IN S.DEFS", "{inc 'S.DEFS'}", ""},
 		{ss, " .MA MacroName", `{macro "MacroName"}`, ""},
 		{ss, " .OR $D000", "{org $d000}", ""},
+		{ss, " .TA *-1234", "{target (- * $04d2)}", ""},
 		{ss, " .TF OUT.BIN", "{-}", ""},
 		{ss, " .TI 76,Title here", "{-}", ""},
 		{ss, " BEQ $2343", "{BEQ $2343}", "f0fc"},
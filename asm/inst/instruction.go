@@ -1,7 +1,6 @@
 package inst
 
 import (
-	"errors"
 	"fmt"
 	"strings"
 
@@ -34,6 +33,13 @@ const (
 	TypeEnd        // End assembly
 	TypeSetting    // An on/off setting toggle
 	TypeDirective  // An assembler directive that was consumed
+	TypeDummy      // Start of dummy section: labels get addresses, but no bytes are stored
+	TypeDummyEnd   // End of dummy section
+	TypeLoopStart  // Start of a block of lines to repeat
+	TypeLoopEnd    // End of a block of lines to repeat
+	TypeError      // Conditional error: fails assembly if its expression is non-zero
+	TypeChecksum   // Checksum byte: the exclusive-or of the bytes before it
+	TypeSave       // Save output to a file
 )
 
 type Variant int
@@ -41,22 +47,36 @@ type Variant int
 // Variants for instructions. These tell the instruction how to
 // interpret the raw data that comes in on the first or second pass.
 const (
-	VarUnknown     = Variant(iota)
-	VarBytes       // Data: expressions, but forced to one byte per
-	VarMixed       // Bytes or words (LE), depending on individual expression widths
-	VarWordsLe     // Data: expressions, but forced to one word per, little-endian
-	VarWordsBe     // Data: expressions, but forced to one word per, big-endian
-	VarBytesZero   // Data: a run of zeros
-	VarAscii       // Data: from ASCII strings, high bit clear
-	VarAsciiFlip   // Data: from ASCII strings, high bit clear, except last char
-	VarAsciiHi     // Data: from ASCII strings, high bit set
-	VarAsciiHiFlip // Data: from ASCII strings, high bit set, except last char
-	VarRelative    // For branches: a one-byte relative address
-	VarEquNormal   // Equ: a normal equate
-	VarEquPageZero // Equ: a page-zero equate
-	VarOpByte      // An op with a one-byte argument
-	VarOpWord      // An op with a one-word argument
-	VarOpBranch    // An op with a one-byte relative address argument
+	VarUnknown          = Variant(iota)
+	VarBytes            // Data: expressions, but forced to one byte per
+	VarMixed            // Bytes or words (LE), depending on individual expression widths
+	VarWordsLe          // Data: expressions, but forced to one word per, little-endian
+	VarWordsBe          // Data: expressions, but forced to one word per, big-endian
+	VarBytesZero        // Data: a run of zeros
+	VarAscii            // Data: from ASCII strings, high bit clear
+	VarAsciiFlip        // Data: from ASCII strings, high bit clear, except last char
+	VarAsciiHi          // Data: from ASCII strings, high bit set
+	VarAsciiHiFlip      // Data: from ASCII strings, high bit set, except last char
+	VarRelative         // For branches: a one-byte relative address
+	VarEquNormal        // Equ: a normal equate
+	VarEquPageZero      // Equ: a page-zero equate
+	VarOpByte           // An op with a one-byte argument
+	VarOpWord           // An op with a one-word argument
+	VarOpBranch         // An op with a one-byte relative address argument
+	VarOpLong           // An op with a three-byte (long) argument
+	VarOpBranchLong     // An op with a two-byte relative address argument
+	VarOpBlockMove      // An op with two bank-byte arguments: source, then destination
+	VarOrgTarget        // Org: also sets the target address, ending any separate one
+	VarOrgPseudo        // Org: assemble for its address, but keep storing bytes where they were going
+	VarOrgPseudoEnd     // Org: go back to assembling for where bytes are stored
+	VarSegmentBss       // Segment: reserves addresses, but stores no bytes
+	VarSaveBefore       // Save: the output since the previous save
+	VarSaveAfter        // Save: the output up to the next save
+	VarSaveAfterAddr    // Save: like VarSaveAfter, starting with the load address
+	VarSaveAfterAddrLen // Save: like VarSaveAfter, starting with the load address and length
+	VarIncludeBinary    // Include: the bytes of the file, from Exprs[0], up to Exprs[1] of them if given
+	VarElseIf           // Ifdef else: a branch taken if no earlier one was, and Exprs[0] is true
+	VarIncludeChain     // Include: continue with the file, instead of the rest of this one
 )
 
 type I struct {
@@ -74,6 +94,9 @@ type I struct {
 	DeclaredLine uint16      // Line number listed in file
 	Line         *lines.Line // Line object for this line
 	Addr         uint16      // Current memory address
+	Target       uint16      // Where the bytes are stored: usually Addr
+	Reserve      bool        // Addresses are reserved, but bytes not stored (bss)
+	Unlisted     bool        // Left out of listings
 	Var          Variant     // Variant of instruction type
 
 	ModeStr string // Mode description, for debug printing
@@ -130,6 +153,20 @@ func (i I) TypeString() string {
 		return "end"
 	case TypeSetting:
 		return "set"
+	case TypeDummy:
+		return "dum"
+	case TypeDummyEnd:
+		return "dend"
+	case TypeLoopStart:
+		return "lup"
+	case TypeLoopEnd:
+		return "endlup"
+	case TypeError:
+		return "err"
+	case TypeChecksum:
+		return "chk"
+	case TypeSave:
+		return "save"
 	case TypeOp:
 		if i.ModeStr != "" {
 			return i.Command + "/" + i.ModeStr
@@ -177,6 +214,8 @@ func (i *I) Compute(c context.Context) error {
 		return i.computeOp(c)
 	case TypeData:
 		return i.computeData(c)
+	case TypeError:
+		return i.computeError(c)
 	}
 
 	// Everything else is already final
@@ -234,6 +273,18 @@ func (i *I) computeData(c context.Context) error {
 	return nil
 }
 
+func (i *I) computeError(c context.Context) error {
+	val, err := i.Exprs[0].Eval(c, i.Line)
+	if err != nil {
+		return err
+	}
+	if val != 0 {
+		return i.Errorf("%s: error condition is true: %s", i.Command, i.Exprs[0])
+	}
+	i.Final = true
+	return nil
+}
+
 func (i *I) computeBlock(c context.Context, final bool) (bool, error) {
 	val, err := i.Exprs[0].Eval(c, i.Line)
 	if err == nil {
@@ -255,8 +306,6 @@ func (i *I) computeMustKnow(c context.Context) error {
 	}
 	i.Value = val
 	switch i.Type {
-	case TypeTarget:
-		return errors.New("Target not implemented yet.")
 	case TypeOrg:
 		c.SetAddr(uint16(val))
 	case TypeEqu:
@@ -280,6 +329,27 @@ func (i *I) computeOp(c context.Context) error {
 
 	// If we got here, we got an actual value.
 
+	switch i.Var {
+	case VarOpLong:
+		i.Data = []byte{i.Op, byte(val), byte(val >> 8), byte(val >> 16)}
+		i.Final = true
+		return nil
+	case VarOpBranchLong:
+		offset := val - (int64(c.GetAddr()) + 3)
+		i.Data = []byte{i.Op, byte(offset), byte(offset >> 8)}
+		i.Final = true
+		return nil
+	case VarOpBlockMove:
+		dst, err := i.Exprs[1].Eval(c, i.Line)
+		if err != nil {
+			return err
+		}
+		// The destination bank comes first in the object code.
+		i.Data = []byte{i.Op, byte(dst), byte(val)}
+		i.Final = true
+		return nil
+	}
+
 	// It's a branch
 	if i.Var == VarOpBranch {
 		curr := c.GetAddr()
@@ -116,3 +116,40 @@ func NewSimpleLineSource(context Context, ls []string, prefix int) LineSource {
 		prefix:  prefix,
 	}
 }
+
+// RepeatLineSource is a LineSource that repeats a block of lines.
+type RepeatLineSource struct {
+	SimpleLineSource
+	count int
+	i     int         // current repetition
+	start func(i int) // called before each repetition
+}
+
+func (rls *RepeatLineSource) Next() (line Line, done bool, err error) {
+	if rls.curr >= rls.size {
+		rls.curr = 0
+		rls.i++
+	}
+	if rls.i >= rls.count || rls.size == 0 {
+		return Line{}, true, nil
+	}
+	if rls.curr == 0 && rls.start != nil {
+		rls.start(rls.i)
+	}
+	return rls.SimpleLineSource.Next()
+}
+
+// NewRepeatLineSource returns a LineSource that repeats ls count
+// times, calling start, if it's not nil, before each repetition.
+func NewRepeatLineSource(context Context, ls []string, count int, prefix int, start func(i int)) LineSource {
+	return &RepeatLineSource{
+		SimpleLineSource: SimpleLineSource{
+			context: context,
+			lines:   ls,
+			size:    len(ls),
+			prefix:  prefix,
+		},
+		count: count,
+		start: start,
+	}
+}
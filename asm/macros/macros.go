@@ -1,24 +1,100 @@
 package macros
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/zellyn/go6502/asm/flavors"
 	"github.com/zellyn/go6502/asm/inst"
 	"github.com/zellyn/go6502/asm/lines"
 )
 
 type M struct {
-	Name   string
-	Args   []string
-	Lines  []string
-	Locals map[string]bool // labels that should be scoped to macro invocation
+	Name     string
+	Args     []string          // named parameters, if any
+	Defaults map[string]string // default values of named parameters
+	Lines    []string
+	Locals   map[string]bool // labels that should be scoped to macro invocation
+}
+
+// New creates a macro with the given parameters, each a name, or
+// "name=default".
+func New(name string, params []string) M {
+	m := M{Name: name}
+	for _, p := range params {
+		parts := strings.SplitN(p, "=", 2)
+		m.Args = append(m.Args, parts[0])
+		if len(parts) == 2 {
+			if m.Defaults == nil {
+				m.Defaults = make(map[string]string)
+			}
+			m.Defaults[parts[0]] = parts[1]
+		}
+	}
+	return m
+}
+
+func (m M) isArg(name string) bool {
+	for _, a := range m.Args {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Bind matches a call's arguments to the macro's parameters. Each
+// argument is positional, or "name=value" for a named parameter, and
+// parameters not given take their default values. It returns the
+// values by position, and by name, and the number of arguments
+// actually passed. Only positions up to the last value are returned;
+// missing values in between are empty.
+func (m M) Bind(callArgs []string) (args []string, kwargs map[string]string, passed int, err error) {
+	kwargs = make(map[string]string)
+	for _, a := range callArgs {
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) == 2 && m.isArg(parts[0]) {
+			if _, ok := kwargs[parts[0]]; ok {
+				return nil, nil, 0, fmt.Errorf("argument %q given twice", parts[0])
+			}
+			kwargs[parts[0]] = parts[1]
+			continue
+		}
+		args = append(args, a)
+	}
+	for i, name := range m.Args {
+		value, named := kwargs[name]
+		switch {
+		case named && i < len(args):
+			return nil, nil, 0, fmt.Errorf("argument %q given both by position and by name", name)
+		case named:
+		case i < len(args):
+			value = args[i]
+		default:
+			d, ok := m.Defaults[name]
+			if !ok {
+				continue
+			}
+			value = d
+		}
+		kwargs[name] = value
+		for len(args) <= i {
+			args = append(args, "")
+		}
+		args[i] = value
+	}
+	return args, kwargs, len(callArgs), nil
 }
 
 func (m M) LineSource(flavor flavors.F, in inst.I, macroCall int, prefix int) (lines.LineSource, error) {
 	var ls []string
 	context := lines.Context{Filename: "macro:" + m.Name, Parent: in.Line, MacroCall: macroCall, MacroLocals: m.Locals}
+	args, kwargs, passed, err := m.Bind(in.MacroArgs)
+	if err != nil {
+		return nil, err
+	}
 	for _, line := range m.Lines {
-		// TODO(zellyn): implement named macro args
-		subbed, err := flavor.ReplaceMacroArgs(line, in.MacroArgs, nil)
+		subbed, err := flavor.ReplaceMacroArgs(line, args, kwargs, m.Args, passed)
 		if err != nil {
 			return nil, in.Errorf("error in macro %s: %v", m.Name, err)
 		}
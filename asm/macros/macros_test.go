@@ -0,0 +1,40 @@
+package macros
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	m := New("M", []string{"a", "b=2", "c"})
+	for _, tt := range []struct {
+		call   []string
+		args   []string
+		kwargs map[string]string
+		passed int
+		err    bool
+	}{
+		{nil, []string{"", "2"}, map[string]string{"b": "2"}, 0, false},
+		{[]string{"1"}, []string{"1", "2"}, map[string]string{"a": "1", "b": "2"}, 1, false},
+		{[]string{"c=3", "1"}, []string{"1", "2", "3"}, map[string]string{"a": "1", "b": "2", "c": "3"}, 2, false},
+		{[]string{"b=", "1"}, []string{"1", ""}, map[string]string{"a": "1", "b": ""}, 2, false},
+		{[]string{"x=y"}, []string{"x=y", "2"}, map[string]string{"a": "x=y", "b": "2"}, 1, false},
+		{[]string{"1", "a=1"}, nil, nil, 0, true},
+		{[]string{"c=1", "c=2"}, nil, nil, 0, true},
+	} {
+		args, kwargs, passed, err := m.Bind(tt.call)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Bind(%q): want error", tt.call)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Bind(%q): %v", tt.call, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.args) || !reflect.DeepEqual(kwargs, tt.kwargs) || passed != tt.passed {
+			t.Errorf("Bind(%q) = %q, %q, %d; want %q, %q, %d", tt.call, args, kwargs, passed, tt.args, tt.kwargs, tt.passed)
+		}
+	}
+}
@@ -24,10 +24,29 @@ const (
 	MODE_INDIRECT_Y
 	MODE_INDIRECT_X
 	MODE_A
+
+	// 65C02
+	MODE_ZP_INDIRECT    // (zp)
+	MODE_ABS_INDIRECT_X // (abs,X)
+
+	// 65816
+	MODE_ABS_LONG             // long
+	MODE_ABS_LONG_X           // long,X
+	MODE_INDIRECT_LONG        // [dp]
+	MODE_INDIRECT_LONG_Y      // [dp],Y
+	MODE_ABS_INDIRECT_LONG    // [abs]
+	MODE_STACK_REL            // sr,S
+	MODE_STACK_REL_INDIRECT_Y // (sr,S),Y
+	MODE_RELATIVE_LONG        // two-byte relative address
+	MODE_BLOCK_MOVE           // two bank bytes
 )
 
-// Logical OR of the three indirect modes
-const MODE_INDIRECT_ANY AddressingMode = MODE_INDIRECT | MODE_INDIRECT_X | MODE_INDIRECT_Y
+// Logical OR of the indirect modes written with parentheses
+const MODE_INDIRECT_ANY AddressingMode = MODE_INDIRECT | MODE_INDIRECT_X | MODE_INDIRECT_Y |
+	MODE_ZP_INDIRECT | MODE_ABS_INDIRECT_X | MODE_STACK_REL_INDIRECT_Y
+
+// Logical OR of the indirect modes written with square brackets
+const MODE_INDIRECT_LONG_ANY AddressingMode = MODE_INDIRECT_LONG | MODE_INDIRECT_LONG_Y | MODE_ABS_INDIRECT_LONG
 
 // Opcode read/write semantics: does the opcode read, write, or
 // rmw. Useful to distinguish between instructions further than just
@@ -56,6 +75,19 @@ var ModeLengths = map[AddressingMode]int{
 	MODE_INDIRECT_Y: 2,
 	MODE_INDIRECT_X: 2,
 	MODE_A:          1,
+
+	MODE_ZP_INDIRECT:    2,
+	MODE_ABS_INDIRECT_X: 3,
+
+	MODE_ABS_LONG:             4,
+	MODE_ABS_LONG_X:           4,
+	MODE_INDIRECT_LONG:        2,
+	MODE_INDIRECT_LONG_Y:      2,
+	MODE_ABS_INDIRECT_LONG:    3,
+	MODE_STACK_REL:            2,
+	MODE_STACK_REL_INDIRECT_Y: 2,
+	MODE_RELATIVE_LONG:        3,
+	MODE_BLOCK_MOVE:           3,
 }
 
 // Opcode stores information about instructions.
@@ -260,6 +292,142 @@ var Opcodes = map[byte]Opcode{
 	0xFE: {"INC", MODE_ABS_X, RW_RMW},
 }
 
+// Opcodes65C02 lists the opcodes the 65C02 adds to the 6502's. (The
+// Rockwell bit instructions are not included.) Only the assembler
+// uses them, so far.
+var Opcodes65C02 = map[byte]Opcode{
+	0x12: {"ORA", MODE_ZP_INDIRECT, RW_R},
+	0x32: {"AND", MODE_ZP_INDIRECT, RW_R},
+	0x52: {"EOR", MODE_ZP_INDIRECT, RW_R},
+	0x72: {"ADC", MODE_ZP_INDIRECT, RW_R},
+	0x92: {"STA", MODE_ZP_INDIRECT, RW_W},
+	0xB2: {"LDA", MODE_ZP_INDIRECT, RW_R},
+	0xD2: {"CMP", MODE_ZP_INDIRECT, RW_R},
+	0xF2: {"SBC", MODE_ZP_INDIRECT, RW_R},
+
+	0x89: {"BIT", MODE_IMMEDIATE, RW_R},
+	0x34: {"BIT", MODE_ZP_X, RW_R},
+	0x3C: {"BIT", MODE_ABS_X, RW_R},
+
+	0x1A: {"INC", MODE_A, RW_RMW},
+	0x3A: {"DEC", MODE_A, RW_RMW},
+
+	0x7C: {"JMP", MODE_ABS_INDIRECT_X, RW_X},
+	0x80: {"BRA", MODE_RELATIVE, RW_X},
+
+	0xDA: {"PHX", MODE_IMPLIED, RW_X},
+	0x5A: {"PHY", MODE_IMPLIED, RW_X},
+	0xFA: {"PLX", MODE_IMPLIED, RW_X},
+	0x7A: {"PLY", MODE_IMPLIED, RW_X},
+
+	0x64: {"STZ", MODE_ZP, RW_W},
+	0x74: {"STZ", MODE_ZP_X, RW_W},
+	0x9C: {"STZ", MODE_ABSOLUTE, RW_W},
+	0x9E: {"STZ", MODE_ABS_X, RW_W},
+
+	0x14: {"TRB", MODE_ZP, RW_RMW},
+	0x1C: {"TRB", MODE_ABSOLUTE, RW_RMW},
+	0x04: {"TSB", MODE_ZP, RW_RMW},
+	0x0C: {"TSB", MODE_ABSOLUTE, RW_RMW},
+}
+
+// Opcodes65816 lists the opcodes the 65816 adds to the 65C02's.
+var Opcodes65816 = map[byte]Opcode{
+	0x0F: {"ORA", MODE_ABS_LONG, RW_R},
+	0x2F: {"AND", MODE_ABS_LONG, RW_R},
+	0x4F: {"EOR", MODE_ABS_LONG, RW_R},
+	0x6F: {"ADC", MODE_ABS_LONG, RW_R},
+	0x8F: {"STA", MODE_ABS_LONG, RW_W},
+	0xAF: {"LDA", MODE_ABS_LONG, RW_R},
+	0xCF: {"CMP", MODE_ABS_LONG, RW_R},
+	0xEF: {"SBC", MODE_ABS_LONG, RW_R},
+
+	0x1F: {"ORA", MODE_ABS_LONG_X, RW_R},
+	0x3F: {"AND", MODE_ABS_LONG_X, RW_R},
+	0x5F: {"EOR", MODE_ABS_LONG_X, RW_R},
+	0x7F: {"ADC", MODE_ABS_LONG_X, RW_R},
+	0x9F: {"STA", MODE_ABS_LONG_X, RW_W},
+	0xBF: {"LDA", MODE_ABS_LONG_X, RW_R},
+	0xDF: {"CMP", MODE_ABS_LONG_X, RW_R},
+	0xFF: {"SBC", MODE_ABS_LONG_X, RW_R},
+
+	0x07: {"ORA", MODE_INDIRECT_LONG, RW_R},
+	0x27: {"AND", MODE_INDIRECT_LONG, RW_R},
+	0x47: {"EOR", MODE_INDIRECT_LONG, RW_R},
+	0x67: {"ADC", MODE_INDIRECT_LONG, RW_R},
+	0x87: {"STA", MODE_INDIRECT_LONG, RW_W},
+	0xA7: {"LDA", MODE_INDIRECT_LONG, RW_R},
+	0xC7: {"CMP", MODE_INDIRECT_LONG, RW_R},
+	0xE7: {"SBC", MODE_INDIRECT_LONG, RW_R},
+
+	0x17: {"ORA", MODE_INDIRECT_LONG_Y, RW_R},
+	0x37: {"AND", MODE_INDIRECT_LONG_Y, RW_R},
+	0x57: {"EOR", MODE_INDIRECT_LONG_Y, RW_R},
+	0x77: {"ADC", MODE_INDIRECT_LONG_Y, RW_R},
+	0x97: {"STA", MODE_INDIRECT_LONG_Y, RW_W},
+	0xB7: {"LDA", MODE_INDIRECT_LONG_Y, RW_R},
+	0xD7: {"CMP", MODE_INDIRECT_LONG_Y, RW_R},
+	0xF7: {"SBC", MODE_INDIRECT_LONG_Y, RW_R},
+
+	0x03: {"ORA", MODE_STACK_REL, RW_R},
+	0x23: {"AND", MODE_STACK_REL, RW_R},
+	0x43: {"EOR", MODE_STACK_REL, RW_R},
+	0x63: {"ADC", MODE_STACK_REL, RW_R},
+	0x83: {"STA", MODE_STACK_REL, RW_W},
+	0xA3: {"LDA", MODE_STACK_REL, RW_R},
+	0xC3: {"CMP", MODE_STACK_REL, RW_R},
+	0xE3: {"SBC", MODE_STACK_REL, RW_R},
+
+	0x13: {"ORA", MODE_STACK_REL_INDIRECT_Y, RW_R},
+	0x33: {"AND", MODE_STACK_REL_INDIRECT_Y, RW_R},
+	0x53: {"EOR", MODE_STACK_REL_INDIRECT_Y, RW_R},
+	0x73: {"ADC", MODE_STACK_REL_INDIRECT_Y, RW_R},
+	0x93: {"STA", MODE_STACK_REL_INDIRECT_Y, RW_W},
+	0xB3: {"LDA", MODE_STACK_REL_INDIRECT_Y, RW_R},
+	0xD3: {"CMP", MODE_STACK_REL_INDIRECT_Y, RW_R},
+	0xF3: {"SBC", MODE_STACK_REL_INDIRECT_Y, RW_R},
+
+	0x82: {"BRL", MODE_RELATIVE_LONG, RW_X},
+	0x62: {"PER", MODE_RELATIVE_LONG, RW_X},
+	0x5C: {"JML", MODE_ABS_LONG, RW_X},
+	0xDC: {"JML", MODE_ABS_INDIRECT_LONG, RW_X},
+	0x22: {"JSL", MODE_ABS_LONG, RW_X},
+	0xFC: {"JSR", MODE_ABS_INDIRECT_X, RW_X},
+	0x54: {"MVN", MODE_BLOCK_MOVE, RW_X},
+	0x44: {"MVP", MODE_BLOCK_MOVE, RW_X},
+
+	0xF4: {"PEA", MODE_ABSOLUTE, RW_X},
+	0xD4: {"PEI", MODE_ZP_INDIRECT, RW_X},
+	0x02: {"COP", MODE_IMMEDIATE, RW_X},
+	0x42: {"WDM", MODE_IMMEDIATE, RW_X},
+	0xC2: {"REP", MODE_IMMEDIATE, RW_X},
+	0xE2: {"SEP", MODE_IMMEDIATE, RW_X},
+
+	0x8B: {"PHB", MODE_IMPLIED, RW_X},
+	0x0B: {"PHD", MODE_IMPLIED, RW_X},
+	0x4B: {"PHK", MODE_IMPLIED, RW_X},
+	0xAB: {"PLB", MODE_IMPLIED, RW_X},
+	0x2B: {"PLD", MODE_IMPLIED, RW_X},
+	0x6B: {"RTL", MODE_IMPLIED, RW_X},
+	0xDB: {"STP", MODE_IMPLIED, RW_X},
+	0xCB: {"WAI", MODE_IMPLIED, RW_X},
+	0x5B: {"TCD", MODE_IMPLIED, RW_X},
+	0x1B: {"TCS", MODE_IMPLIED, RW_X},
+	0x7B: {"TDC", MODE_IMPLIED, RW_X},
+	0x3B: {"TSC", MODE_IMPLIED, RW_X},
+	0x9B: {"TXY", MODE_IMPLIED, RW_X},
+	0xBB: {"TYX", MODE_IMPLIED, RW_X},
+	0xEB: {"XBA", MODE_IMPLIED, RW_X},
+	0xFB: {"XCE", MODE_IMPLIED, RW_X},
+}
+
+// longAliases are the 65816's long jumps under their short names:
+// "JMP >addr" is JML.
+var longAliases = map[string]string{
+	"JML": "JMP",
+	"JSL": "JSR",
+}
+
 // Information for lookup of opcodes by name and mode -------------------------
 
 type OpInfo struct {
@@ -276,18 +444,37 @@ type OpSummary struct {
 type Set uint16
 
 const (
-	SetUnknown Set = iota
-	SetSweet16
+	SetUnknown Set = 0
+	SetSweet16 Set = 1 << (iota - 1)
+	Set65C02       // 65C02 opcodes
+	Set65816       // 65816 opcodes: implies Set65C02
 )
 
+// ByName returns summaries of the opcodes in the given sets, by name.
 func ByName(sets Set) map[string]OpSummary {
 	m := make(map[string]OpSummary)
-	for b, oc := range Opcodes {
+	add := func(name string, b byte, oc Opcode) {
 		info := OpInfo{oc.Mode, ModeLengths[oc.Mode], b}
-		summary := m[oc.Name]
+		summary := m[name]
 		summary.Modes |= oc.Mode
 		summary.Ops = append(summary.Ops, info)
-		m[oc.Name] = summary
+		m[name] = summary
+	}
+	for b, oc := range Opcodes {
+		add(oc.Name, b, oc)
+	}
+	if sets&(Set65C02|Set65816) != 0 {
+		for b, oc := range Opcodes65C02 {
+			add(oc.Name, b, oc)
+		}
+	}
+	if sets&Set65816 != 0 {
+		for b, oc := range Opcodes65816 {
+			add(oc.Name, b, oc)
+			if alias, ok := longAliases[oc.Name]; ok {
+				add(alias, b, oc)
+			}
+		}
 	}
 
 	return m
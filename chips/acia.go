@@ -0,0 +1,274 @@
+package chips
+
+import "io"
+
+// ACIA registers.
+const (
+	ACIA_DATA    = iota // Transmit/receive data
+	ACIA_STATUS         // Status; writing performs a programmed reset
+	ACIA_COMMAND        // Command register
+	ACIA_CONTROL        // Control register
+)
+
+// ACIA status register bits.
+const (
+	ACIA_PARITY_ERROR = 1 << iota
+	ACIA_FRAMING_ERROR
+	ACIA_OVERRUN
+	ACIA_RDRF // Receive data register full
+	ACIA_TDRE // Transmit data register empty
+	ACIA_DCD  // Data carrier detect (high means no carrier)
+	ACIA_DSR  // Data set ready (high means not ready)
+	ACIA_IRQ  // Interrupt occurred
+)
+
+// Command register bits.
+const (
+	cmdDTR     = 0x01 // Data terminal ready: enables the receiver
+	cmdNoRxIRQ = 0x02 // Disable receiver interrupts
+	cmdTxMask  = 0x0C // Transmitter control
+	cmdTxIRQ   = 0x04 // Transmitter control value enabling interrupts
+	cmdEcho    = 0x10 // Echo received characters
+	cmdParity  = 0x20 // Parity enabled
+)
+
+// acia baud rates, indexed by the low four bits of the control
+// register. Zero selects the external 16x clock, which we treat as
+// 115200 baud.
+var aciaBaudRates = [16]float64{
+	115200, 50, 75, 109.92, 134.58, 150, 300, 600,
+	1200, 1800, 2400, 3600, 4800, 7200, 9600, 19200,
+}
+
+// DefaultClockHz is the cpu clock assumed by an ACIA whose ClockHz is
+// zero.
+const DefaultClockHz = 1000000
+
+// ACIA emulates a MOS 6551 Asynchronous Communications Interface
+// Adapter: a serial port. Characters take as long to send and
+// receive as the programmed baud rate and format dictate.
+//
+// If the ACIA is given an io.Reader, received characters are read
+// from it; a new one is only taken once the last has been read by the
+// cpu, as if by hardware flow control, so none are lost. Characters
+// can also be received with Receive. If Out is set, transmitted
+// characters are written to it.
+type ACIA struct {
+	IRQ     func(asserted bool) // interrupt output; may be nil
+	Out     io.Writer
+	ClockHz float64 // cpu clock, for baud rate timing
+
+	in chan byte // bytes read from the input Reader
+
+	status, command, control byte
+	rdr, tdr                 byte
+	txBusy                   bool // a character is being shifted out
+	txShift                  byte
+	txCycles                 int // cycles until the current character is sent
+	rxCycles                 int // cycles until another character can arrive
+	irq                      bool
+}
+
+// NewACIA returns an ACIA reading from in and writing to out, either
+// of which may be nil.
+func NewACIA(in io.Reader, out io.Writer) *ACIA {
+	a := &ACIA{Out: out}
+	if in != nil {
+		ch := make(chan byte)
+		a.in = ch
+		go func() {
+			var b [1]byte
+			for {
+				n, err := in.Read(b[:])
+				if n > 0 {
+					ch <- b[0]
+				}
+				if err != nil {
+					close(ch)
+					return
+				}
+			}
+		}()
+	}
+	a.Reset()
+	return a
+}
+
+// Reset performs a hardware reset.
+func (a *ACIA) Reset() {
+	a.status = ACIA_TDRE
+	a.command = 0
+	a.control = 0
+	a.txBusy = false
+	a.setIRQ(false)
+}
+
+// frameCycles returns the number of cycles one character takes on
+// the wire at the current baud rate and format.
+func (a *ACIA) frameCycles() int {
+	bits := 1 + 8 - int(a.control>>5&3) + 1 // start, data, stop
+	if a.command&cmdParity != 0 {
+		bits++
+	}
+	if a.control&0x80 != 0 {
+		bits++
+	}
+	hz := a.ClockHz
+	if hz == 0 {
+		hz = DefaultClockHz
+	}
+	return int(hz * float64(bits) / aciaBaudRates[a.control&0xF])
+}
+
+// raise records an interrupt, and asserts the IRQ output, if
+// interrupts are enabled at all.
+func (a *ACIA) raise() {
+	if a.command&cmdDTR == 0 {
+		return
+	}
+	a.status |= ACIA_IRQ
+	a.setIRQ(true)
+}
+
+func (a *ACIA) setIRQ(irq bool) {
+	if irq != a.irq {
+		a.irq = irq
+		if a.IRQ != nil {
+			a.IRQ(irq)
+		}
+	}
+}
+
+// txIRQ returns true if transmitter interrupts are enabled.
+func (a *ACIA) txIRQ() bool {
+	return a.command&cmdTxMask == cmdTxIRQ
+}
+
+// Peek returns a register's value without side effects. Satisfies
+// cpu.Peeker.
+func (a *ACIA) Peek(address uint16) byte {
+	switch address & 3 {
+	case ACIA_DATA:
+		return a.rdr
+	case ACIA_STATUS:
+		return a.status
+	case ACIA_COMMAND:
+		return a.command
+	}
+	return a.control
+}
+
+// Read reads a register. Satisfies cpu.Memory. Reading data clears
+// the receive flag and errors; reading status clears the interrupt
+// flag, and releases the IRQ output.
+func (a *ACIA) Read(address uint16) byte {
+	value := a.Peek(address)
+	switch address & 3 {
+	case ACIA_DATA:
+		a.status &^= ACIA_RDRF | ACIA_OVERRUN | ACIA_FRAMING_ERROR | ACIA_PARITY_ERROR
+	case ACIA_STATUS:
+		a.status &^= ACIA_IRQ
+		a.setIRQ(false)
+	}
+	return value
+}
+
+// Write writes a register. Satisfies cpu.Memory.
+func (a *ACIA) Write(address uint16, value byte) {
+	switch address & 3 {
+	case ACIA_DATA:
+		a.tdr = value
+		a.status &^= ACIA_TDRE
+		a.startTx()
+	case ACIA_STATUS:
+		// Programmed reset.
+		a.command &= 0xE0
+		a.status &^= ACIA_OVERRUN | ACIA_IRQ
+		a.setIRQ(false)
+	case ACIA_COMMAND:
+		wasTxIRQ := a.txIRQ()
+		a.command = value
+		if a.command&cmdDTR == 0 {
+			a.status &^= ACIA_IRQ
+			a.setIRQ(false)
+		} else if a.txIRQ() && !wasTxIRQ && a.status&ACIA_TDRE != 0 {
+			a.raise()
+		}
+	case ACIA_CONTROL:
+		a.control = value
+	}
+}
+
+// startTx moves the transmit data register to the shift register, if
+// it is free.
+func (a *ACIA) startTx() {
+	if a.txBusy || a.status&ACIA_TDRE != 0 {
+		return
+	}
+	a.txShift = a.tdr
+	a.txBusy = true
+	a.txCycles = a.frameCycles()
+	a.status |= ACIA_TDRE
+	if a.txIRQ() {
+		a.raise()
+	}
+}
+
+// transmit sends a character to the output.
+func (a *ACIA) transmit(b byte) {
+	if a.Out != nil {
+		a.Out.Write([]byte{b})
+	}
+}
+
+// Receive delivers a character to the receiver, as if it had just
+// arrived over the wire. If the last character hasn't been read yet,
+// it is lost, and the overrun flag is set.
+func (a *ACIA) Receive(b byte) {
+	if a.command&cmdDTR == 0 {
+		return
+	}
+	if a.status&ACIA_RDRF != 0 {
+		a.status |= ACIA_OVERRUN
+		return
+	}
+	a.rdr = b
+	a.status |= ACIA_RDRF
+	if a.command&cmdEcho != 0 {
+		a.transmit(b)
+	}
+	if a.command&cmdNoRxIRQ == 0 {
+		a.raise()
+	}
+}
+
+// Tick advances the ACIA by one cycle, finishing transmissions, and
+// receiving characters from the input Reader.
+func (a *ACIA) Tick() {
+	if a.txBusy {
+		a.txCycles--
+		if a.txCycles <= 0 {
+			a.txBusy = false
+			a.transmit(a.txShift)
+			a.startTx()
+		}
+	}
+
+	if a.rxCycles > 0 {
+		a.rxCycles--
+		return
+	}
+	if a.in == nil || a.command&cmdDTR == 0 || a.status&ACIA_RDRF != 0 {
+		return
+	}
+	select {
+	case b, ok := <-a.in:
+		if !ok {
+			a.in = nil
+			return
+		}
+		a.Receive(b)
+		a.rxCycles = a.frameCycles()
+	default:
+	}
+}
@@ -0,0 +1,82 @@
+package chips
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestACIATransmit(t *testing.T) {
+	var out bytes.Buffer
+	a := NewACIA(nil, &out)
+	irqs := 0
+	a.IRQ = func(asserted bool) {
+		if asserted {
+			irqs++
+		}
+	}
+	a.Write(ACIA_CONTROL, 0x1F) // 19200 baud, 8 bits, 1 stop bit
+	a.Write(ACIA_COMMAND, 0x0B) // DTR, no receiver or transmitter interrupts
+	a.Write(ACIA_DATA, 'H')
+	if a.Peek(ACIA_STATUS)&ACIA_TDRE == 0 {
+		t.Error("want first character to go straight to the shift register")
+	}
+	a.Write(ACIA_DATA, 'I')
+	if a.Peek(ACIA_STATUS)&ACIA_TDRE != 0 {
+		t.Error("want transmit register full")
+	}
+	// 10 bits at 19200 baud, at 1MHz, is 520 cycles.
+	n := ticksUntil(t, a.Tick, func() bool { return out.Len() == 2 })
+	if n != 1040 {
+		t.Errorf("want two characters in 1040 cycles; got %d", n)
+	}
+	if out.String() != "HI" {
+		t.Errorf("want HI; got %q", out.String())
+	}
+	if irqs != 0 {
+		t.Errorf("want no interrupts; got %d", irqs)
+	}
+
+	// Enabling transmitter interrupts with TDRE set interrupts.
+	a.Write(ACIA_COMMAND, 0x07)
+	if irqs != 1 || a.Peek(ACIA_STATUS)&ACIA_IRQ == 0 {
+		t.Error("want transmitter interrupt")
+	}
+}
+
+func TestACIAReceive(t *testing.T) {
+	a := NewACIA(strings.NewReader("OK"), nil)
+	irq := false
+	a.IRQ = func(asserted bool) { irq = asserted }
+	a.Write(ACIA_CONTROL, 0x1F)
+	a.Write(ACIA_COMMAND, 0x09) // DTR, receiver interrupts
+	var got []byte
+	for len(got) < 2 {
+		// The Reader is read by another goroutine, so give it a
+		// chance to run.
+		ticksUntil(t, func() {
+			a.Tick()
+			runtime.Gosched()
+		}, func() bool { return irq })
+		if a.Read(ACIA_STATUS)&(ACIA_RDRF|ACIA_IRQ) != ACIA_RDRF|ACIA_IRQ {
+			t.Fatal("want RDRF and IRQ status")
+		}
+		if irq {
+			t.Fatal("want reading status to release IRQ")
+		}
+		got = append(got, a.Read(ACIA_DATA))
+	}
+	if string(got) != "OK" {
+		t.Errorf("want OK; got %q", got)
+	}
+
+	a.Receive('1')
+	a.Receive('2')
+	if s := a.Read(ACIA_STATUS); s&ACIA_OVERRUN == 0 {
+		t.Errorf("want overrun; got status $%02X", s)
+	}
+	if d := a.Read(ACIA_DATA); d != '1' {
+		t.Errorf("want first character kept; got %q", d)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Package chips emulates peripheral chips: the MOS 6522 VIA, 6551 ACIA,
+Motorola 6820 PIA, and General Instrument AY-3-8910 PSG.
+
+Apart from the PSG, which is driven through its own bus by another
+chip, chips are memory-mapped: each implements cpu.Memory (and cpu.Peeker),
+decoding its registers from the low bits of the address, so it can be
+placed anywhere with a Bus. Chips that count time have a Tick method
+to call once per cycle. Each reports interrupts through a func(bool),
+such as a Cpu's SetIRQ, or an input of a Line shared with other chips.
+*/
+package chips
+
+import "github.com/zellyn/go6502/cpu"
+
+// Line is a wired-OR interrupt line: it is asserted whenever any of
+// its inputs is.
+type Line struct {
+	out    func(asserted bool)
+	inputs []bool
+}
+
+// NewLine returns a Line that drives out, eg. a Cpu's SetIRQ.
+func NewLine(out func(asserted bool)) *Line {
+	return &Line{out: out}
+}
+
+// Input returns a new input to the line.
+func (l *Line) Input() func(asserted bool) {
+	i := len(l.inputs)
+	l.inputs = append(l.inputs, false)
+	return func(asserted bool) {
+		l.inputs[i] = asserted
+		l.out(l.Asserted())
+	}
+}
+
+// Asserted returns true if any input is asserted.
+func (l *Line) Asserted() bool {
+	for _, in := range l.inputs {
+		if in {
+			return true
+		}
+	}
+	return false
+}
+
+// mapping is a device mapped into a range of addresses.
+type mapping struct {
+	start, end uint16 // inclusive
+	m          cpu.Memory
+}
+
+// Bus is a cpu.Memory that sends accesses to mapped devices, and all
+// other accesses to RAM.
+type Bus struct {
+	RAM      cpu.Memory
+	mappings []mapping
+}
+
+// Map maps a device into the addresses from start to end, inclusive.
+// Later mappings take precedence.
+func (b *Bus) Map(start, end uint16, m cpu.Memory) {
+	b.mappings = append([]mapping{{start, end, m}}, b.mappings...)
+}
+
+func (b *Bus) lookup(address uint16) cpu.Memory {
+	for _, mp := range b.mappings {
+		if address >= mp.start && address <= mp.end {
+			return mp.m
+		}
+	}
+	return b.RAM
+}
+
+func (b *Bus) Read(address uint16) byte {
+	return b.lookup(address).Read(address)
+}
+
+func (b *Bus) Peek(address uint16) byte {
+	return cpu.Peek(b.lookup(address), address)
+}
+
+func (b *Bus) Write(address uint16, value byte) {
+	b.lookup(address).Write(address, value)
+}
@@ -0,0 +1,237 @@
+package chips
+
+// PIA registers, selected by the low two address bits. Registers 0
+// and 2 are the data direction registers instead, when PIA_DATA is
+// clear in the port's control register.
+const (
+	PIA_PA  = iota // Port A data (or data direction) register
+	PIA_CRA        // Control register A
+	PIA_PB         // Port B data (or data direction) register
+	PIA_CRB        // Control register B
+)
+
+// PIA control register bits.
+const (
+	PIA_C1_IRQ    = 0x01 // C1 interrupt enable
+	PIA_C1_RISING = 0x02 // C1 active on rising, rather than falling, edge
+	PIA_DATA      = 0x04 // Select the data register, rather than the DDR
+	PIA_C2_IRQ    = 0x08 // C2 input: interrupt enable
+	PIA_C2_RISING = 0x10 // C2 input: active on rising edge
+	PIA_C2_OUTPUT = 0x20 // C2 is an output
+	PIA_IRQ2      = 0x40 // C2 interrupt flag; read-only
+	PIA_IRQ1      = 0x80 // C1 interrupt flag; read-only
+)
+
+// piaPort is one of the PIA's two ports.
+type piaPort struct {
+	or, ddr, cr byte
+	pins        Pins
+	c1, c2      bool // control line levels
+	irq         bool
+}
+
+// input returns the level on the port's pins. Unconnected pins float
+// high.
+func (p *piaPort) input() byte {
+	if p.pins == nil {
+		return 0xFF
+	}
+	return p.pins.Input()
+}
+
+func (p *piaPort) output() {
+	if p.pins != nil {
+		p.pins.Output(p.or, p.ddr)
+	}
+}
+
+// PIA emulates a Motorola 6820 (or 6821, or MOS 6520) Peripheral
+// Interface Adapter: two 8-bit ports, each with two control lines
+// that can generate interrupts or handshake. It has no timers, so no
+// Tick method.
+type PIA struct {
+	// IRQA and IRQB are the two interrupt outputs; either may be nil.
+	// They are often inputs of the same Line.
+	IRQA, IRQB func(asserted bool)
+	// CA2 and CB2 are called when the chip drives those lines as
+	// outputs; may be nil.
+	CA2, CB2 func(level bool)
+
+	a, b piaPort
+}
+
+// NewPIA returns a PIA connected to the given port pins, either of
+// which may be nil.
+func NewPIA(a, b Pins) *PIA {
+	p := &PIA{}
+	p.a.pins, p.b.pins = a, b
+	p.Reset()
+	return p
+}
+
+// Reset performs a hardware reset: all registers are cleared.
+func (p *PIA) Reset() {
+	for _, port := range []*piaPort{&p.a, &p.b} {
+		port.or, port.ddr, port.cr = 0, 0, 0
+		port.c1, port.c2 = true, true
+		port.output()
+	}
+	p.updateIRQ(false)
+	p.updateIRQ(true)
+}
+
+func (p *PIA) port(portB bool) *piaPort {
+	if portB {
+		return &p.b
+	}
+	return &p.a
+}
+
+func (p *PIA) updateIRQ(portB bool) {
+	port, f := &p.a, p.IRQA
+	if portB {
+		port, f = &p.b, p.IRQB
+	}
+	cr := port.cr
+	irq := cr&PIA_IRQ1 != 0 && cr&PIA_C1_IRQ != 0 ||
+		cr&PIA_IRQ2 != 0 && cr&(PIA_C2_OUTPUT|PIA_C2_IRQ) == PIA_C2_IRQ
+	if irq != port.irq {
+		port.irq = irq
+		if f != nil {
+			f(irq)
+		}
+	}
+}
+
+// setC2 drives CA2 or CB2 as an output.
+func (p *PIA) setC2(portB bool, level bool) {
+	port, f := &p.a, p.CA2
+	if portB {
+		port, f = &p.b, p.CB2
+	}
+	if port.c2 == level {
+		return
+	}
+	port.c2 = level
+	if f != nil {
+		f(level)
+	}
+}
+
+// portAccess handles the handshaking side effects of reading port A,
+// or writing port B: in handshake mode C2 goes low until the next
+// active C1 edge; in pulse mode it goes low for a cycle.
+func (p *PIA) portAccess(portB bool) {
+	switch p.port(portB).cr & 0x38 {
+	case PIA_C2_OUTPUT: // handshake
+		p.setC2(portB, false)
+	case PIA_C2_OUTPUT | 0x08: // pulse
+		p.setC2(portB, false)
+		p.setC2(portB, true)
+	}
+}
+
+// Peek returns a register's value without side effects. Satisfies
+// cpu.Peeker.
+func (p *PIA) Peek(address uint16) byte {
+	port := p.port(address&2 != 0)
+	if address&1 != 0 {
+		return port.cr
+	}
+	if port.cr&PIA_DATA == 0 {
+		return port.ddr
+	}
+	return port.or&port.ddr | port.input()&^port.ddr
+}
+
+// Read reads a register. Satisfies cpu.Memory. Reading a data register
+// clears the port's interrupt flags.
+func (p *PIA) Read(address uint16) byte {
+	value := p.Peek(address)
+	portB := address&2 != 0
+	port := p.port(portB)
+	if address&1 == 0 && port.cr&PIA_DATA != 0 {
+		port.cr &^= PIA_IRQ1 | PIA_IRQ2
+		p.updateIRQ(portB)
+		if !portB {
+			p.portAccess(portB)
+		}
+	}
+	return value
+}
+
+// Write writes a register. Satisfies cpu.Memory.
+func (p *PIA) Write(address uint16, value byte) {
+	portB := address&2 != 0
+	port := p.port(portB)
+	if address&1 != 0 {
+		port.cr = port.cr&(PIA_IRQ1|PIA_IRQ2) | value&0x3F
+		switch port.cr & 0x38 {
+		case PIA_C2_OUTPUT | 0x10: // manual: low
+			p.setC2(portB, false)
+		case PIA_C2_OUTPUT | 0x18: // manual: high
+			p.setC2(portB, true)
+		}
+		p.updateIRQ(portB)
+		return
+	}
+	if port.cr&PIA_DATA == 0 {
+		port.ddr = value
+	} else {
+		port.or = value
+		if portB {
+			p.portAccess(portB)
+		}
+	}
+	port.output()
+}
+
+// setC1 handles a change on CA1 or CB1.
+func (p *PIA) setC1(portB bool, level bool) {
+	port := p.port(portB)
+	old := port.c1
+	port.c1 = level
+	if old == level || level != (port.cr&PIA_C1_RISING != 0) {
+		return
+	}
+	port.cr |= PIA_IRQ1
+	if port.cr&0x38 == PIA_C2_OUTPUT { // handshake complete
+		p.setC2(portB, true)
+	}
+	p.updateIRQ(portB)
+}
+
+// setC2Input handles a change on CA2 or CB2, if it is an input.
+func (p *PIA) setC2Input(portB bool, level bool) {
+	port := p.port(portB)
+	if port.cr&PIA_C2_OUTPUT != 0 {
+		return
+	}
+	old := port.c2
+	port.c2 = level
+	if old == level || level != (port.cr&PIA_C2_RISING != 0) {
+		return
+	}
+	port.cr |= PIA_IRQ2
+	p.updateIRQ(portB)
+}
+
+// SetCA1 sets the level of the CA1 input.
+func (p *PIA) SetCA1(level bool) {
+	p.setC1(false, level)
+}
+
+// SetCB1 sets the level of the CB1 input.
+func (p *PIA) SetCB1(level bool) {
+	p.setC1(true, level)
+}
+
+// SetCA2 sets the level of CA2, if it is an input.
+func (p *PIA) SetCA2(level bool) {
+	p.setC2Input(false, level)
+}
+
+// SetCB2 sets the level of CB2, if it is an input.
+func (p *PIA) SetCB2(level bool) {
+	p.setC2Input(true, level)
+}
@@ -0,0 +1,90 @@
+package chips
+
+import "testing"
+
+func TestPIAPorts(t *testing.T) {
+	a, b := &pins{in: 0xA5}, &pins{}
+	p := NewPIA(a, b)
+	// After reset, the data direction registers are selected.
+	p.Write(PIA_PB, 0x7F)
+	if b.ddr != 0x7F {
+		t.Errorf("want DDRB=$7F; got $%02X", b.ddr)
+	}
+	p.Write(PIA_CRB, PIA_DATA)
+	p.Write(PIA_PB, 0x41)
+	if b.out != 0x41 {
+		t.Errorf("want ORB=$41; got $%02X", b.out)
+	}
+	b.in = 0x80
+	if got := p.Read(PIA_PB); got != 0xC1 {
+		t.Errorf("want input bit 7 and output bits 0-6: $C1; got $%02X", got)
+	}
+	p.Write(PIA_CRA, PIA_DATA)
+	if got := p.Read(PIA_PA); got != 0xA5 {
+		t.Errorf("want port A input $A5; got $%02X", got)
+	}
+}
+
+func TestPIAInterrupts(t *testing.T) {
+	p := NewPIA(nil, nil)
+	irq := false
+	p.IRQA = func(asserted bool) { irq = asserted }
+	p.Write(PIA_CRA, PIA_DATA|PIA_C1_RISING|PIA_C1_IRQ)
+	p.SetCA1(false)
+	if p.Peek(PIA_CRA)&PIA_IRQ1 != 0 {
+		t.Error("want falling edge to be ignored")
+	}
+	p.SetCA1(true)
+	if p.Peek(PIA_CRA)&PIA_IRQ1 == 0 || !irq {
+		t.Error("want rising edge to set IRQA1 and assert IRQA")
+	}
+	p.Write(PIA_CRA, PIA_DATA|PIA_C1_RISING|PIA_C1_IRQ)
+	if p.Peek(PIA_CRA)&PIA_IRQ1 == 0 {
+		t.Error("want interrupt flags to be read-only")
+	}
+	p.Read(PIA_PA)
+	if p.Peek(PIA_CRA)&PIA_IRQ1 != 0 || irq {
+		t.Error("want reading port A to clear the interrupt")
+	}
+
+	// CA2 as an input.
+	p.Write(PIA_CRA, PIA_DATA|PIA_C2_IRQ)
+	p.SetCA2(false)
+	if p.Peek(PIA_CRA)&PIA_IRQ2 == 0 || !irq {
+		t.Error("want falling CA2 to set IRQA2 and assert IRQA")
+	}
+}
+
+func TestPIAHandshake(t *testing.T) {
+	p := NewPIA(nil, nil)
+	var cb2 []bool
+	p.CB2 = func(level bool) { cb2 = append(cb2, level) }
+	// The Apple-1 display setup: handshake on CB2, acknowledged by a
+	// rising edge on CB1.
+	p.Write(PIA_CRB, 0xA7)
+	p.Write(PIA_PB, 'A')
+	if len(cb2) != 1 || cb2[0] {
+		t.Fatalf("want writing port B to take CB2 low; got %v", cb2)
+	}
+	p.SetCB1(false)
+	p.SetCB1(true)
+	if len(cb2) != 2 || !cb2[1] {
+		t.Fatalf("want CB1 to take CB2 high again; got %v", cb2)
+	}
+
+	// Pulse mode.
+	cb2 = nil
+	p.Write(PIA_CRB, PIA_DATA|PIA_C2_OUTPUT|0x08)
+	p.Write(PIA_PB, 'B')
+	if len(cb2) != 2 || cb2[0] || !cb2[1] {
+		t.Errorf("want a low pulse on CB2; got %v", cb2)
+	}
+
+	// Manual output.
+	cb2 = nil
+	p.Write(PIA_CRB, PIA_DATA|PIA_C2_OUTPUT|0x10)
+	p.Write(PIA_CRB, PIA_DATA|PIA_C2_OUTPUT|0x18)
+	if len(cb2) != 2 || cb2[0] || !cb2[1] {
+		t.Errorf("want CB2 to follow control register bit 3; got %v", cb2)
+	}
+}
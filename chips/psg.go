@@ -0,0 +1,224 @@
+package chips
+
+import "math"
+
+// PSG registers.
+const (
+	PSG_A_FINE     = iota // Channel A tone period, low 8 bits
+	PSG_A_COARSE          // Channel A tone period, high 4 bits
+	PSG_B_FINE            // Channel B tone period, low 8 bits
+	PSG_B_COARSE          // Channel B tone period, high 4 bits
+	PSG_C_FINE            // Channel C tone period, low 8 bits
+	PSG_C_COARSE          // Channel C tone period, high 4 bits
+	PSG_NOISE             // Noise period, 5 bits
+	PSG_MIXER             // Tone and noise disables, and I/O port directions
+	PSG_A_AMP             // Channel A amplitude: 4 bits, or bit 4 for the envelope
+	PSG_B_AMP             // Channel B amplitude
+	PSG_C_AMP             // Channel C amplitude
+	PSG_ENV_FINE          // Envelope period, low 8 bits
+	PSG_ENV_COARSE        // Envelope period, high 8 bits
+	PSG_ENV_SHAPE         // Envelope shape; writing restarts the envelope
+	PSG_PORT_A            // I/O port A
+	PSG_PORT_B            // I/O port B
+)
+
+// Envelope shape bits.
+const (
+	PSG_ENV_HOLD      = 1 << iota // Hold the level at the end of the first cycle
+	PSG_ENV_ALTERNATE             // Reverse direction each cycle
+	PSG_ENV_ATTACK                // Count up, rather than down
+	PSG_ENV_CONTINUE              // Keep going after the first cycle
+)
+
+// psgMasks holds the bits of each register that exist: the others
+// read as zero.
+var psgMasks = [16]byte{
+	0xFF, 0x0F, 0xFF, 0x0F, 0xFF, 0x0F, 0x1F, 0xFF,
+	0x1F, 0x1F, 0x1F, 0xFF, 0xFF, 0x0F, 0xFF, 0xFF,
+}
+
+// psgLevels holds the output level for each amplitude: 3dB apart,
+// with 0 silent.
+var psgLevels [16]float64
+
+func init() {
+	for i := 1; i < 16; i++ {
+		psgLevels[i] = math.Pow(2, float64(i-15)/2)
+	}
+}
+
+// PSG emulates a General Instrument AY-3-8910 Programmable Sound
+// Generator: three square-wave tone channels, a noise generator, and
+// an envelope generator that can control each channel's volume.
+//
+// The chip is driven through its bus: Latch selects a register, which
+// WriteData and ReadData then access. Tick is called once per input
+// clock cycle; tones are the clock divided by 16 times their period.
+type PSG struct {
+	regs  [16]byte
+	latch byte // selected register
+
+	prescale int     // input clocks, 0-15
+	tone     [3]int  // tone counters
+	toneOut  [3]bool // tone outputs
+	noise    int     // noise counter
+	lfsr     uint32  // noise shift register
+	env      int     // envelope counter
+	step     int     // envelope step, counting down from 15
+	attack   int     // 15 to invert the step, 0 otherwise
+	hold     bool
+	alt      bool
+	holding  bool
+}
+
+// NewPSG returns a PSG, reset.
+func NewPSG() *PSG {
+	p := &PSG{}
+	p.Reset()
+	return p
+}
+
+// Reset clears all the registers, silencing the chip.
+func (p *PSG) Reset() {
+	*p = PSG{lfsr: 1}
+	p.startEnvelope()
+}
+
+// Latch selects the register for the following reads and writes.
+// Addresses above 15 select nothing.
+func (p *PSG) Latch(reg byte) {
+	p.latch = reg
+}
+
+// WriteData writes the selected register.
+func (p *PSG) WriteData(value byte) {
+	if p.latch > 15 {
+		return
+	}
+	p.regs[p.latch] = value & psgMasks[p.latch]
+	if p.latch == PSG_ENV_SHAPE {
+		p.startEnvelope()
+	}
+}
+
+// ReadData reads the selected register.
+func (p *PSG) ReadData() byte {
+	if p.latch > 15 {
+		return 0xFF
+	}
+	return p.regs[p.latch]
+}
+
+// Register returns a register's value.
+func (p *PSG) Register(reg int) byte {
+	return p.regs[reg]
+}
+
+// startEnvelope restarts the envelope with the current shape. Shapes
+// without PSG_ENV_CONTINUE run once, then drop to silence: that is, they
+// hold, alternating if they attacked.
+func (p *PSG) startEnvelope() {
+	shape := p.regs[PSG_ENV_SHAPE]
+	p.step, p.env, p.holding = 15, 0, false
+	p.attack = 0
+	if shape&PSG_ENV_ATTACK != 0 {
+		p.attack = 15
+	}
+	p.hold = shape&PSG_ENV_HOLD != 0
+	p.alt = shape&PSG_ENV_ALTERNATE != 0
+	if shape&PSG_ENV_CONTINUE == 0 {
+		p.hold, p.alt = true, p.attack != 0
+	}
+}
+
+// period returns a 12- or 16-bit period from a pair of registers. A
+// period of zero acts as one.
+func (p *PSG) period(fine int) int {
+	n := int(p.regs[fine]) | int(p.regs[fine+1])<<8
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// Tick advances the chip by one input clock cycle. Tone counters count
+// at an eighth of the clock, flipping their output each period; the
+// noise and envelope generators step at a sixteenth.
+func (p *PSG) Tick() {
+	p.prescale = (p.prescale + 1) & 15
+	if p.prescale&7 != 0 {
+		return
+	}
+	for i := range p.tone {
+		p.tone[i]++
+		if p.tone[i] >= p.period(PSG_A_FINE+2*i) {
+			p.tone[i] = 0
+			p.toneOut[i] = !p.toneOut[i]
+		}
+	}
+	if p.prescale != 0 {
+		return
+	}
+
+	period := int(p.regs[PSG_NOISE])
+	if period == 0 {
+		period = 1
+	}
+	p.noise++
+	if p.noise >= period {
+		p.noise = 0
+		// A 17-bit shift register, tapped at bits 0 and 3.
+		p.lfsr = p.lfsr>>1 | ((p.lfsr^p.lfsr>>3)&1)<<16
+	}
+
+	p.env++
+	if p.env >= p.period(PSG_ENV_FINE) {
+		p.env = 0
+		p.stepEnvelope()
+	}
+}
+
+// stepEnvelope moves the envelope on a step, starting a new cycle or
+// holding at the end of one.
+func (p *PSG) stepEnvelope() {
+	if p.holding {
+		return
+	}
+	p.step--
+	if p.step >= 0 {
+		return
+	}
+	if p.alt {
+		p.attack ^= 15
+	}
+	if p.hold {
+		p.step, p.holding = 0, true
+	} else {
+		p.step = 15
+	}
+}
+
+// Envelope returns the envelope's current amplitude, 0-15.
+func (p *PSG) Envelope() int {
+	return p.step ^ p.attack
+}
+
+// Channel returns a channel's (0-2) output level, from 0 to 1.
+func (p *PSG) Channel(i int) float64 {
+	mixer := p.regs[PSG_MIXER]
+	tone := p.toneOut[i] || mixer&(1<<uint(i)) != 0
+	noise := p.lfsr&1 != 0 || mixer&(8<<uint(i)) != 0
+	if !tone || !noise {
+		return 0
+	}
+	amp := p.regs[PSG_A_AMP+i]
+	if amp&0x10 != 0 {
+		return psgLevels[p.Envelope()]
+	}
+	return psgLevels[amp]
+}
+
+// Output returns the three channels' outputs mixed, from 0 to 1.
+func (p *PSG) Output() float64 {
+	return (p.Channel(0) + p.Channel(1) + p.Channel(2)) / 3
+}
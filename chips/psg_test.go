@@ -0,0 +1,116 @@
+package chips
+
+import "testing"
+
+// write sets a PSG register.
+func (p *PSG) write(reg, value byte) {
+	p.Latch(reg)
+	p.WriteData(value)
+}
+
+func TestPSGRegisters(t *testing.T) {
+	p := NewPSG()
+	p.write(PSG_A_COARSE, 0xFF)
+	if got := p.ReadData(); got != 0x0F {
+		t.Errorf("want coarse tone period masked to $0F; got $%02X", got)
+	}
+	p.write(PSG_PORT_B, 0xA5)
+	if got := p.ReadData(); got != 0xA5 {
+		t.Errorf("want port B $A5; got $%02X", got)
+	}
+	p.write(16, 0x12)
+	if got := p.ReadData(); got != 0xFF {
+		t.Errorf("want unselected read $FF; got $%02X", got)
+	}
+	p.Reset()
+	if p.Register(PSG_PORT_B) != 0 {
+		t.Error("want Reset to clear registers")
+	}
+}
+
+func TestPSGTone(t *testing.T) {
+	p := NewPSG()
+	p.write(PSG_A_FINE, 100)
+	p.write(PSG_MIXER, 0x3E) // tone A only
+	p.write(PSG_A_AMP, 15)
+	// A period of 100 is 1600 clocks: 800 high, 800 low.
+	flips, last := 0, p.Channel(0)
+	for i := 0; i < 16000; i++ {
+		p.Tick()
+		if out := p.Channel(0); out != last {
+			flips++
+			last = out
+		}
+	}
+	if flips != 20 {
+		t.Errorf("want 20 flips in 16000 clocks; got %d", flips)
+	}
+	if p.Channel(1) != 0 || p.Channel(2) != 0 {
+		t.Error("want silent channels B and C, at amplitude 0")
+	}
+	p.write(PSG_A_AMP, 7)
+	if p.Output() != 0 && p.Output() != psgLevels[7]/3 {
+		t.Errorf("want output 0 or %g; got %g", psgLevels[7]/3, p.Output())
+	}
+	// Disabling both tone and noise leaves a steady level.
+	p.write(PSG_MIXER, 0x3F)
+	if p.Channel(0) != psgLevels[7] {
+		t.Errorf("want steady level %g; got %g", psgLevels[7], p.Channel(0))
+	}
+}
+
+func TestPSGNoise(t *testing.T) {
+	p := NewPSG()
+	p.write(PSG_MIXER, 0x37) // noise A only
+	p.write(PSG_A_AMP, 15)
+	p.write(PSG_NOISE, 1)
+	high := 0
+	for i := 0; i < 16*1000; i++ {
+		p.Tick()
+		if p.Channel(0) != 0 {
+			high++
+		}
+	}
+	if high < 16*400 || high > 16*600 {
+		t.Errorf("want noise high about half the time; got %d of %d", high, 16*1000)
+	}
+}
+
+func TestPSGEnvelope(t *testing.T) {
+	// Envelope levels at the start of each of the first three cycles,
+	// and just before their ends.
+	for _, tt := range []struct {
+		shape  byte
+		levels [6]int
+	}{
+		{0x0, [6]int{15, 1, 0, 0, 0, 0}},     // \___
+		{0x4, [6]int{0, 14, 0, 0, 0, 0}},     // /___
+		{0x8, [6]int{15, 1, 15, 1, 15, 1}},   // \\\\
+		{0x9, [6]int{15, 1, 0, 0, 0, 0}},     // \___
+		{0xA, [6]int{15, 1, 0, 14, 15, 1}},   // \/\/
+		{0xB, [6]int{15, 1, 15, 15, 15, 15}}, // \~~~
+		{0xC, [6]int{0, 14, 0, 14, 0, 14}},   // ////
+		{0xD, [6]int{0, 14, 15, 15, 15, 15}}, // /~~~
+		{0xE, [6]int{0, 14, 15, 1, 0, 14}},   // /\/\
+		{0xF, [6]int{0, 14, 0, 0, 0, 0}},     // /___
+	} {
+		p := NewPSG()
+		p.write(PSG_ENV_FINE, 1)
+		p.write(PSG_ENV_SHAPE, tt.shape)
+		// With a period of 1, each step is 16 clocks.
+		var got [6]int
+		for cycle := 0; cycle < 3; cycle++ {
+			got[2*cycle] = p.Envelope()
+			for i := 0; i < 16*14; i++ {
+				p.Tick()
+			}
+			got[2*cycle+1] = p.Envelope()
+			for i := 0; i < 16*2; i++ {
+				p.Tick()
+			}
+		}
+		if got != tt.levels {
+			t.Errorf("shape $%X: want %v; got %v", tt.shape, tt.levels, got)
+		}
+	}
+}
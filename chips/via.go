@@ -0,0 +1,541 @@
+package chips
+
+// VIA registers.
+const (
+	VIA_ORB    = iota // Output/input register B
+	VIA_ORA           // Output/input register A, with handshake
+	VIA_DDRB          // Data direction register B
+	VIA_DDRA          // Data direction register A
+	VIA_T1CL          // Timer 1 counter low
+	VIA_T1CH          // Timer 1 counter high
+	VIA_T1LL          // Timer 1 latch low
+	VIA_T1LH          // Timer 1 latch high
+	VIA_T2CL          // Timer 2 counter low
+	VIA_T2CH          // Timer 2 counter high
+	VIA_SR            // Shift register
+	VIA_ACR           // Auxiliary control register
+	VIA_PCR           // Peripheral control register
+	VIA_IFR           // Interrupt flag register
+	VIA_IER           // Interrupt enable register
+	VIA_ORA_NH        // Output/input register A, no handshake
+)
+
+// VIA interrupt flags.
+const (
+	VIA_INT_CA2 = 1 << iota
+	VIA_INT_CA1
+	VIA_INT_SR
+	VIA_INT_CB2
+	VIA_INT_CB1
+	VIA_INT_T2
+	VIA_INT_T1
+	VIA_INT_ANY
+)
+
+// Auxiliary control register bits.
+const (
+	acrPALatch   = 0x01
+	acrPBLatch   = 0x02
+	acrSRMode    = 0x1C
+	acrT2Count   = 0x20
+	acrT1FreeRun = 0x40
+	acrT1PB7     = 0x80
+)
+
+// Shift register modes (ACR bits 2-4).
+const (
+	srDisabled = iota << 2
+	srInT2
+	srInPhi2
+	srInExt
+	srOutFreeT2
+	srOutT2
+	srOutPhi2
+	srOutExt
+)
+
+// Pins connects a VIA or PIA port to the outside world.
+type Pins interface {
+	// Input returns the levels driven onto the port's pins.
+	Input() byte
+	// Output is called when the port's output register or data
+	// direction register changes. Bits whose ddr bit is 1 are
+	// outputs.
+	Output(value, ddr byte)
+}
+
+// viaPort is one of the VIA's two ports.
+type viaPort struct {
+	or, ddr byte
+	latched byte // input latched on the active C1 edge
+	pins    Pins
+	c1, c2  bool // control line levels
+}
+
+// input returns the level on the port's pins. Unconnected pins float
+// high.
+func (p *viaPort) input() byte {
+	if p.pins == nil {
+		return 0xFF
+	}
+	return p.pins.Input()
+}
+
+func (p *viaPort) output() {
+	if p.pins != nil {
+		p.pins.Output(p.or, p.ddr)
+	}
+}
+
+// VIA emulates a MOS 6522 Versatile Interface Adapter: two 8-bit
+// ports with handshaking control lines, two 16-bit timers, and a
+// shift register.
+type VIA struct {
+	IRQ func(asserted bool) // interrupt output; may be nil
+	// CA2 and CB2 are called when the chip drives those lines as
+	// outputs; may be nil.
+	CA2, CB2 func(level bool)
+
+	a, b viaPort
+
+	t1Counter, t1Latch uint16
+	t1Armed            bool // interrupt on next underflow
+	t1Reload           bool // reload the counter on the next cycle
+	pb7                bool // timer 1 output on PB7
+
+	t2Counter uint16
+	t2LatchLo byte
+	t2Armed   bool
+
+	sr      byte
+	srCount int  // bits shifted so far; 8 means stopped
+	srPhase bool // shift clock phase: shift on every other clock
+
+	acr, pcr, ifr, ier byte
+	irq                bool
+}
+
+// NewVIA returns a VIA connected to the given port pins, either of
+// which may be nil.
+func NewVIA(a, b Pins) *VIA {
+	v := &VIA{}
+	v.a.pins, v.b.pins = a, b
+	v.Reset()
+	return v
+}
+
+// Reset performs a hardware reset: all registers except the timers
+// and shift register are cleared.
+func (v *VIA) Reset() {
+	v.a.or, v.a.ddr, v.b.or, v.b.ddr = 0, 0, 0, 0
+	v.acr, v.pcr, v.ifr, v.ier = 0, 0, 0, 0
+	v.t1Armed, v.t2Armed = false, false
+	v.srCount = 8
+	v.a.c1, v.a.c2, v.b.c1, v.b.c2 = true, true, true, true
+	v.a.output()
+	v.b.output()
+	v.updateIRQ()
+}
+
+// setFlags sets interrupt flags, and updates the IRQ output.
+func (v *VIA) setFlags(flags byte) {
+	v.ifr |= flags
+	v.updateIRQ()
+}
+
+// clearFlags clears interrupt flags, and updates the IRQ output.
+func (v *VIA) clearFlags(flags byte) {
+	v.ifr &^= flags
+	v.updateIRQ()
+}
+
+func (v *VIA) updateIRQ() {
+	irq := v.ifr&v.ier&0x7F != 0
+	if irq {
+		v.ifr |= VIA_INT_ANY
+	} else {
+		v.ifr &^= VIA_INT_ANY
+	}
+	if irq != v.irq {
+		v.irq = irq
+		if v.IRQ != nil {
+			v.IRQ(irq)
+		}
+	}
+}
+
+// c2Mode returns the 3-bit control mode for CA2 (port A) or CB2
+// (port B) from the PCR.
+func (v *VIA) c2Mode(portB bool) byte {
+	if portB {
+		return (v.pcr >> 5) & 7
+	}
+	return (v.pcr >> 1) & 7
+}
+
+// c2Independent returns true if the C2 line is an input whose
+// interrupt flag is not cleared by accessing the port.
+func (v *VIA) c2Independent(portB bool) bool {
+	m := v.c2Mode(portB)
+	return m == 1 || m == 3
+}
+
+// setC2 drives CA2 or CB2 as an output.
+func (v *VIA) setC2(portB bool, level bool) {
+	p, f := &v.a, v.CA2
+	if portB {
+		p, f = &v.b, v.CB2
+	}
+	if p.c2 == level {
+		return
+	}
+	p.c2 = level
+	if f != nil {
+		f(level)
+	}
+}
+
+// portAccess handles the handshaking side effects of reading or
+// writing ORA or ORB.
+func (v *VIA) portAccess(portB bool, write bool) {
+	c1, c2 := byte(VIA_INT_CA1), byte(VIA_INT_CA2)
+	if portB {
+		c1, c2 = VIA_INT_CB1, VIA_INT_CB2
+	}
+	flags := c1
+	if !v.c2Independent(portB) {
+		flags |= c2
+	}
+	v.clearFlags(flags)
+	// Handshake and pulse output modes drop C2 on an access. Port B
+	// only does this on writes.
+	if m := v.c2Mode(portB); (m == 4 || m == 5) && (write || !portB) {
+		v.setC2(portB, false)
+		if m == 5 {
+			v.setC2(portB, true)
+		}
+	}
+}
+
+// readPort returns the value read from a port: output bits come from
+// the output register, and input bits from the pins, or the latch if
+// latching is enabled.
+func (v *VIA) readPort(portB bool) byte {
+	p, latch := &v.a, v.acr&acrPALatch != 0
+	if portB {
+		p, latch = &v.b, v.acr&acrPBLatch != 0
+	}
+	in := p.input()
+	if latch {
+		in = p.latched
+	}
+	value := (p.or & p.ddr) | (in &^ p.ddr)
+	if portB && v.acr&acrT1PB7 != 0 {
+		value &^= 0x80
+		if v.pb7 {
+			value |= 0x80
+		}
+	}
+	return value
+}
+
+// Peek returns a register's value without side effects. Satisfies
+// cpu.Peeker.
+func (v *VIA) Peek(address uint16) byte {
+	switch address & 0xF {
+	case VIA_ORB:
+		return v.readPort(true)
+	case VIA_ORA, VIA_ORA_NH:
+		return v.readPort(false)
+	case VIA_DDRB:
+		return v.b.ddr
+	case VIA_DDRA:
+		return v.a.ddr
+	case VIA_T1CL:
+		return byte(v.t1Counter)
+	case VIA_T1CH:
+		return byte(v.t1Counter >> 8)
+	case VIA_T1LL:
+		return byte(v.t1Latch)
+	case VIA_T1LH:
+		return byte(v.t1Latch >> 8)
+	case VIA_T2CL:
+		return byte(v.t2Counter)
+	case VIA_T2CH:
+		return byte(v.t2Counter >> 8)
+	case VIA_SR:
+		return v.sr
+	case VIA_ACR:
+		return v.acr
+	case VIA_PCR:
+		return v.pcr
+	case VIA_IFR:
+		return v.ifr
+	case VIA_IER:
+		return v.ier | 0x80
+	}
+	panic("unreachable")
+}
+
+// Read reads a register. Satisfies cpu.Memory.
+func (v *VIA) Read(address uint16) byte {
+	value := v.Peek(address)
+	switch address & 0xF {
+	case VIA_ORB:
+		v.portAccess(true, false)
+	case VIA_ORA:
+		v.portAccess(false, false)
+	case VIA_T1CL:
+		v.clearFlags(VIA_INT_T1)
+	case VIA_T2CL:
+		v.clearFlags(VIA_INT_T2)
+	case VIA_SR:
+		v.startShift()
+	}
+	return value
+}
+
+// Write writes a register. Satisfies cpu.Memory.
+func (v *VIA) Write(address uint16, value byte) {
+	switch address & 0xF {
+	case VIA_ORB:
+		v.b.or = value
+		v.b.output()
+		v.portAccess(true, true)
+	case VIA_ORA, VIA_ORA_NH:
+		v.a.or = value
+		v.a.output()
+		if address&0xF == VIA_ORA {
+			v.portAccess(false, true)
+		}
+	case VIA_DDRB:
+		v.b.ddr = value
+		v.b.output()
+	case VIA_DDRA:
+		v.a.ddr = value
+		v.a.output()
+	case VIA_T1CL, VIA_T1LL:
+		v.t1Latch = v.t1Latch&0xFF00 | uint16(value)
+	case VIA_T1CH:
+		v.t1Latch = v.t1Latch&0x00FF | uint16(value)<<8
+		v.t1Counter = v.t1Latch
+		v.t1Armed = true
+		v.t1Reload = false
+		v.clearFlags(VIA_INT_T1)
+		if v.acr&acrT1PB7 != 0 {
+			v.pb7 = false
+		}
+	case VIA_T1LH:
+		v.t1Latch = v.t1Latch&0x00FF | uint16(value)<<8
+		v.clearFlags(VIA_INT_T1)
+	case VIA_T2CL:
+		v.t2LatchLo = value
+	case VIA_T2CH:
+		v.t2Counter = uint16(value)<<8 | uint16(v.t2LatchLo)
+		v.t2Armed = true
+		v.clearFlags(VIA_INT_T2)
+	case VIA_SR:
+		v.sr = value
+		v.startShift()
+	case VIA_ACR:
+		if value&acrT1PB7 != 0 && v.acr&acrT1PB7 == 0 {
+			v.pb7 = true
+		}
+		v.acr = value
+	case VIA_PCR:
+		v.pcr = value
+		for _, portB := range []bool{false, true} {
+			switch v.c2Mode(portB) {
+			case 6:
+				v.setC2(portB, false)
+			case 4, 5, 7:
+				v.setC2(portB, true)
+			}
+		}
+	case VIA_IFR:
+		v.clearFlags(value & 0x7F)
+	case VIA_IER:
+		if value&0x80 != 0 {
+			v.ier |= value & 0x7F
+		} else {
+			v.ier &^= value & 0x7F
+		}
+		v.updateIRQ()
+	}
+}
+
+// startShift starts shifting eight bits, after the shift register is
+// read or written.
+func (v *VIA) startShift() {
+	v.clearFlags(VIA_INT_SR)
+	if v.acr&acrSRMode != srDisabled {
+		v.srCount = 0
+		v.srPhase = false
+	}
+}
+
+// shiftClock is called for each edge of the shift clock; a bit is
+// shifted on every second one.
+func (v *VIA) shiftClock() {
+	mode := v.acr & acrSRMode
+	if v.srCount >= 8 && mode != srOutFreeT2 {
+		return
+	}
+	v.srPhase = !v.srPhase
+	if v.srPhase {
+		return
+	}
+	v.shift()
+}
+
+// shift shifts one bit in from CB2, or out to it.
+func (v *VIA) shift() {
+	mode := v.acr & acrSRMode
+	if mode >= srOutFreeT2 {
+		bit := v.sr >> 7
+		v.sr = v.sr<<1 | bit
+		v.setC2(true, bit != 0)
+	} else {
+		bit := byte(0)
+		if v.b.c2 {
+			bit = 1
+		}
+		v.sr = v.sr<<1 | bit
+	}
+	if mode == srOutFreeT2 {
+		return
+	}
+	v.srCount++
+	if v.srCount == 8 {
+		v.setFlags(VIA_INT_SR)
+	}
+}
+
+// Tick advances the VIA by one cycle, counting down the timers, and
+// clocking the shift register.
+func (v *VIA) Tick() {
+	// Timer 1.
+	if v.t1Reload {
+		v.t1Counter = v.t1Latch
+		v.t1Reload = false
+	} else {
+		v.t1Counter--
+		if v.t1Counter == 0xFFFF {
+			if v.t1Armed {
+				v.setFlags(VIA_INT_T1)
+				v.pb7 = !v.pb7
+				if v.acr&acrT1FreeRun == 0 {
+					v.t1Armed = false
+				}
+			}
+			if v.acr&acrT1FreeRun != 0 {
+				v.t1Reload = true
+			}
+		}
+	}
+
+	// Timer 2, unless it's counting PB6 pulses. In the shift
+	// register's timer 2 modes, its low byte clocks the shift
+	// register, reloading from the latch each time it runs out.
+	mode := v.acr & acrSRMode
+	srT2 := mode == srInT2 || mode == srOutFreeT2 || mode == srOutT2
+	if v.acr&acrT2Count == 0 {
+		if srT2 {
+			lo := byte(v.t2Counter)
+			if lo == 0 {
+				v.t2Counter = v.t2Counter&0xFF00 | uint16(v.t2LatchLo)
+				v.shiftClock()
+			} else {
+				v.t2Counter--
+			}
+		} else {
+			v.t2Counter--
+			if v.t2Counter == 0xFFFF && v.t2Armed {
+				v.t2Armed = false
+				v.setFlags(VIA_INT_T2)
+			}
+		}
+	}
+
+	if mode == srInPhi2 || mode == srOutPhi2 {
+		v.shiftClock()
+	}
+}
+
+// PulsePB6 counts a pulse on PB6, when timer 2 is in pulse-counting
+// mode.
+func (v *VIA) PulsePB6() {
+	if v.acr&acrT2Count == 0 {
+		return
+	}
+	v.t2Counter--
+	if v.t2Counter == 0 && v.t2Armed {
+		v.t2Armed = false
+		v.setFlags(VIA_INT_T2)
+	}
+}
+
+// setC1 handles a change on CA1 or CB1: an active edge latches the
+// port's input, and sets the interrupt flag.
+func (v *VIA) setC1(portB bool, level bool) {
+	p, flag, positive, latch := &v.a, byte(VIA_INT_CA1), v.pcr&0x01 != 0, v.acr&acrPALatch != 0
+	if portB {
+		p, flag, positive, latch = &v.b, VIA_INT_CB1, v.pcr&0x10 != 0, v.acr&acrPBLatch != 0
+	}
+	old := p.c1
+	p.c1 = level
+	if old == level || level != positive {
+		return
+	}
+	if latch {
+		p.latched = p.input()
+	}
+	v.setFlags(flag)
+	// Handshake mode raises C2 on the active C1 edge.
+	if v.c2Mode(portB) == 4 {
+		v.setC2(portB, true)
+	}
+}
+
+// SetCA1 sets the level of the CA1 input.
+func (v *VIA) SetCA1(level bool) {
+	v.setC1(false, level)
+}
+
+// SetCB1 sets the level of the CB1 line. In the external shift
+// register modes, it also clocks the shift register.
+func (v *VIA) SetCB1(level bool) {
+	if mode := v.acr & acrSRMode; (mode == srInExt || mode == srOutExt) && level && !v.b.c1 && v.srCount < 8 {
+		v.shift()
+	}
+	v.setC1(true, level)
+}
+
+// setC2Input handles a change on CA2 or CB2, when used as an input.
+func (v *VIA) setC2Input(portB bool, level bool) {
+	p, flag := &v.a, byte(VIA_INT_CA2)
+	if portB {
+		p, flag = &v.b, VIA_INT_CB2
+	}
+	old := p.c2
+	p.c2 = level
+	m := v.c2Mode(portB)
+	if m >= 4 || old == level {
+		return
+	}
+	positive := m == 2 || m == 3
+	if level == positive {
+		v.setFlags(flag)
+	}
+}
+
+// SetCA2 sets the level of the CA2 input.
+func (v *VIA) SetCA2(level bool) {
+	v.setC2Input(false, level)
+}
+
+// SetCB2 sets the level of the CB2 input. It is also the shift
+// register's input.
+func (v *VIA) SetCB2(level bool) {
+	v.setC2Input(true, level)
+}
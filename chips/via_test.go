@@ -0,0 +1,194 @@
+package chips
+
+import (
+	"testing"
+
+	"github.com/zellyn/go6502/cpu"
+)
+
+// pins is a test port.
+type pins struct {
+	in       byte
+	out, ddr byte
+}
+
+func (p *pins) Input() byte {
+	return p.in
+}
+
+func (p *pins) Output(value, ddr byte) {
+	p.out, p.ddr = value, ddr
+}
+
+// ticksUntil ticks until cond is true, returning the number of ticks.
+func ticksUntil(t *testing.T, tick func(), cond func() bool) int {
+	for i := 1; i <= 100000; i++ {
+		tick()
+		if cond() {
+			return i
+		}
+	}
+	t.Fatal("condition never became true")
+	return 0
+}
+
+func TestVIATimer1(t *testing.T) {
+	v := NewVIA(nil, nil)
+	irq := false
+	v.IRQ = func(asserted bool) { irq = asserted }
+	v.Write(VIA_IER, 0x80|VIA_INT_T1)
+	v.Write(VIA_T1CL, 10)
+	v.Write(VIA_T1CH, 0)
+	fired := func() bool { return v.Peek(VIA_IFR)&VIA_INT_T1 != 0 }
+	if n := ticksUntil(t, v.Tick, fired); n != 11 {
+		t.Errorf("one-shot: want interrupt after 11 cycles; got %d", n)
+	}
+	if !irq || v.Peek(VIA_IFR)&VIA_INT_ANY == 0 {
+		t.Error("want IRQ asserted")
+	}
+	v.Read(VIA_T1CL)
+	if irq || fired() {
+		t.Error("want reading T1C-L to clear the interrupt")
+	}
+	for i := 0; i < 100000; i++ {
+		v.Tick()
+	}
+	if fired() {
+		t.Error("want one-shot to fire only once")
+	}
+
+	// Free-running: period is N+2.
+	v.Write(VIA_ACR, acrT1FreeRun)
+	v.Write(VIA_T1CH, 0)
+	ticksUntil(t, v.Tick, fired)
+	v.Read(VIA_T1CL)
+	if n := ticksUntil(t, v.Tick, fired); n != 12 {
+		t.Errorf("free-running: want period of 12 cycles; got %d", n)
+	}
+}
+
+func TestVIATimer2(t *testing.T) {
+	v := NewVIA(nil, nil)
+	v.Write(VIA_T2CL, 0x34)
+	v.Write(VIA_T2CH, 0x12)
+	fired := func() bool { return v.Peek(VIA_IFR)&VIA_INT_T2 != 0 }
+	if n := ticksUntil(t, v.Tick, fired); n != 0x1235 {
+		t.Errorf("want interrupt after $1235 cycles; got $%X", n)
+	}
+
+	v.Write(VIA_ACR, acrT2Count)
+	v.Write(VIA_T2CL, 3)
+	v.Write(VIA_T2CH, 0)
+	if n := ticksUntil(t, v.PulsePB6, fired); n != 3 {
+		t.Errorf("want interrupt after 3 pulses; got %d", n)
+	}
+}
+
+func TestVIAPorts(t *testing.T) {
+	a, b := &pins{in: 0x5A}, &pins{in: 0xFF}
+	v := NewVIA(a, b)
+	v.Write(VIA_DDRA, 0xF0)
+	v.Write(VIA_ORA, 0x33)
+	if a.out != 0x33 || a.ddr != 0xF0 {
+		t.Errorf("want output $33/$F0; got $%02X/$%02X", a.out, a.ddr)
+	}
+	if got := v.Read(VIA_ORA); got != 0x3A {
+		t.Errorf("want outputs from ORA and inputs from pins ($3A); got $%02X", got)
+	}
+
+	// Latch port B on a positive CB1 edge.
+	v.Write(VIA_ACR, acrPBLatch)
+	v.Write(VIA_PCR, 0x10)
+	v.SetCB1(false)
+	b.in = 0x12
+	v.SetCB1(true)
+	b.in = 0x34
+	if v.Peek(VIA_IFR)&VIA_INT_CB1 == 0 {
+		t.Error("want CB1 interrupt flag")
+	}
+	if got := v.Read(VIA_ORB); got != 0x12 {
+		t.Errorf("want latched input $12; got $%02X", got)
+	}
+	if v.Peek(VIA_IFR)&VIA_INT_CB1 != 0 {
+		t.Error("want reading ORB to clear CB1 flag")
+	}
+
+	// CA2 pulse output mode.
+	var ca2 []bool
+	v.CA2 = func(level bool) { ca2 = append(ca2, level) }
+	v.Write(VIA_PCR, 0x0A)
+	v.Write(VIA_ORA, 0)
+	if len(ca2) != 2 || ca2[0] || !ca2[1] {
+		t.Errorf("want CA2 pulse; got %v", ca2)
+	}
+}
+
+func TestVIAShiftOut(t *testing.T) {
+	v := NewVIA(nil, nil)
+	var bits []bool
+	v.CB2 = func(level bool) { bits = append(bits, level) }
+	v.Write(VIA_ACR, srOutPhi2)
+	v.Write(VIA_SR, 0xA5)
+	n := ticksUntil(t, v.Tick, func() bool { return v.Peek(VIA_IFR)&VIA_INT_SR != 0 })
+	if n != 16 {
+		t.Errorf("want 8 bits shifted in 16 cycles; got %d", n)
+	}
+	// CB2 starts high, and follows the bits of 10100101.
+	if want := 6; len(bits) != want {
+		t.Errorf("want %d CB2 changes; got %v", want, bits)
+	}
+	if v.Peek(VIA_SR) != 0xA5 {
+		t.Errorf("want shift register to rotate back to $A5; got $%02X", v.Peek(VIA_SR))
+	}
+}
+
+type ram [65536]byte
+
+func (m *ram) Read(address uint16) byte {
+	return m[address]
+}
+
+func (m *ram) Write(address uint16, value byte) {
+	m[address] = value
+}
+
+// TestVIAInterruptsCpu runs a program that takes free-running timer 1
+// interrupts.
+func TestVIAInterruptsCpu(t *testing.T) {
+	var m ram
+	bus := &Bus{RAM: &m}
+	v := NewVIA(nil, nil)
+	bus.Map(0xC000, 0xC00F, v)
+	code := []byte{
+		0xA9, 0x40, // LDA #$40     ; free-running
+		0x8D, 0x0B, 0xC0, // STA $C00B
+		0xA9, 0xC0, // LDA #$C0     ; enable T1 interrupts
+		0x8D, 0x0E, 0xC0, // STA $C00E
+		0xA9, 0x64, // LDA #100
+		0x8D, 0x04, 0xC0, // STA $C004
+		0xA9, 0x00, // LDA #0
+		0x8D, 0x05, 0xC0, // STA $C005
+		0x58,             // CLI
+		0x4C, 0x15, 0x08, // JMP *
+		// IRQ handler at $0818
+		0xAD, 0x04, 0xC0, // LDA $C004  ; acknowledge
+		0xE6, 0x10, // INC $10
+		0x40, // RTI
+	}
+	copy(m[0x0800:], code)
+	m[0xFFFE], m[0xFFFF] = 0x18, 0x08
+	c := cpu.NewCPU(bus, v.Tick, cpu.VERSION_6502)
+	c.SetIRQ(false)
+	v.IRQ = c.SetIRQ
+	c.Reset()
+	c.SetPC(0x0800)
+	for i := 0; i < 1000; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Roughly 1000 steps of 3 cycles, with an interrupt every 102.
+	if m[0x10] < 20 || m[0x10] > 40 {
+		t.Errorf("want about 30 interrupts; got %d", m[0x10])
+	}
+}
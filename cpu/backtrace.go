@@ -0,0 +1,159 @@
+package cpu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zellyn/go6502/asm"
+)
+
+// FrameKind says how a shadow stack frame was entered.
+type FrameKind int
+
+const (
+	FRAME_JSR FrameKind = iota // Subroutine call
+	FRAME_BRK                  // BRK instruction
+	FRAME_IRQ                  // Interrupt request
+	FRAME_NMI                  // Non-maskable interrupt
+)
+
+// maxFrames bounds the shadow stack, in case code abandons frames in
+// ways we can't see (eg. resetting the stack pointer lower than it
+// was).
+const maxFrames = 256
+
+// Frame is a single entry on the shadow call stack.
+type Frame struct {
+	Kind   FrameKind
+	Caller uint16 // Address of the JSR or BRK instruction, or the interrupted one
+	Target uint16 // Address control was transferred to
+	Return uint16 // Address execution will return to
+	SP     byte   // Stack pointer just after the frame was pushed
+}
+
+func (k FrameKind) String() string {
+	switch k {
+	case FRAME_JSR:
+		return "JSR"
+	case FRAME_BRK:
+		return "BRK"
+	case FRAME_IRQ:
+		return "IRQ"
+	case FRAME_NMI:
+		return "NMI"
+	}
+	return "?"
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s $%04X from $%04X", f.Kind, f.Target, f.Caller)
+}
+
+// popped returns true if the stack pointer has moved back up past
+// the frame, meaning its return address is no longer on the stack.
+// The subtraction wraps, so this also works across $0100/$01FF.
+func (f Frame) popped(sp byte) bool {
+	return int8(sp-f.SP) > 0
+}
+
+// trackFrames updates the shadow call stack after an instruction
+// has executed. Rather than trusting RTS and RTI to pair up with JSR
+// and BRK, frames are discarded whenever the stack pointer moves
+// above them: that way stack tricks like PHA/PHA/RTS dispatch,
+// PLA/PLA to abandon a call, or TXS all leave a sensible stack
+// behind.
+func (c *cpu) trackFrames(opcode byte) {
+	for len(c.frames) > 0 && c.frames[len(c.frames)-1].popped(c.r.SP) {
+		c.frames = c.frames[:len(c.frames)-1]
+	}
+	var f Frame
+	switch opcode {
+	case 0x20: // JSR
+		f = Frame{Kind: FRAME_JSR, Caller: c.oldPC, Target: c.r.PC, Return: c.oldPC + 3}
+	case 0x00: // BRK
+		f = Frame{Kind: FRAME_BRK, Caller: c.oldPC, Target: c.r.PC, Return: c.oldPC + 2}
+	default:
+		return
+	}
+	c.pushFrame(f)
+}
+
+// pushFrame pushes a frame onto the shadow call stack.
+func (c *cpu) pushFrame(f Frame) {
+	f.SP = c.r.SP
+	if len(c.frames) == maxFrames {
+		copy(c.frames, c.frames[1:])
+		c.frames = c.frames[:maxFrames-1]
+	}
+	c.frames = append(c.frames, f)
+}
+
+// Backtrace returns a copy of the shadow call stack, innermost frame
+// first.
+func (c *cpu) Backtrace() []Frame {
+	bt := make([]Frame, len(c.frames))
+	for i, f := range c.frames {
+		bt[len(c.frames)-1-i] = f
+	}
+	return bt
+}
+
+// AddrResolver turns an address into a human-readable description,
+// or "" if it knows nothing about the address.
+type AddrResolver func(addr uint16) string
+
+// SymbolResolver returns an AddrResolver that looks addresses up in
+// a symbol table. Addresses up to lookback bytes past a symbol are
+// returned as "SYMBOL+n".
+func SymbolResolver(s asm.Symbols, lookback int) AddrResolver {
+	return func(addr uint16) string {
+		return s.Name(int(addr), lookback)
+	}
+}
+
+// SourceResolver returns an AddrResolver that finds the source line
+// an assembler placed at a given address.
+func SourceResolver(a *asm.Assembler) AddrResolver {
+	return func(addr uint16) string {
+		if l := a.LineForAddr(addr); l != nil {
+			return l.String()
+		}
+		return ""
+	}
+}
+
+// FormatBacktrace formats a backtrace, one line per frame, starting
+// with the current location pc. If resolve is non-nil, it is used to
+// annotate each address.
+func FormatBacktrace(pc uint16, frames []Frame, resolve AddrResolver) string {
+	describe := func(addr uint16) string {
+		s := fmt.Sprintf("$%04X", addr)
+		if resolve != nil {
+			if name := resolve(addr); name != "" {
+				s += " " + name
+			}
+		}
+		return s
+	}
+	lines := []string{fmt.Sprintf("#0  %s", describe(pc))}
+	for i, f := range frames {
+		lines = append(lines, fmt.Sprintf("#%-2d %s  (%s)", i+1, describe(f.Caller), f.Kind))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// OpcodeError is returned by Step when it meets an opcode it cannot
+// execute. It records how execution got there.
+type OpcodeError struct {
+	PC        uint16 // Address of the bad opcode
+	Opcode    byte
+	Backtrace []Frame
+}
+
+func (e OpcodeError) Error() string {
+	s := fmt.Sprintf("Unknown opcode at location $%04X: $%02X", e.PC, e.Opcode)
+	for _, f := range e.Backtrace {
+		s += fmt.Sprintf("; called by %s", f)
+	}
+	return s
+}
@@ -1,15 +1,11 @@
 package cpu
 
-// BUG(zellyn): Implement IRQ, NMI.
-
-// BUG(zellyn): implement interrupts, and 6502/65C02
+// BUG(zellyn): implement 6502/65C02
 // decimal-mode-clearing and BRK-skipping quirks.  See
 // http://en.wikipedia.org/wiki/MOS_Technology_6502#Bugs_and_quirks.
 
 import (
 	"fmt"
-
-	"github.com/zellyn/go6502/asm"
 )
 
 // Chip versions.
@@ -25,17 +21,29 @@ type Cpu interface {
 	Reset()
 	Step() error
 	SetPC(uint16)
+	SetA(byte)
+	SetX(byte)
+	SetY(byte)
+	SetP(byte)
+	SetSP(byte)
 	A() byte
 	X() byte
 	Y() byte
 	PC() uint16
 	P() byte // [NV-BDIZC]
 	SP() byte
-	// BUG(zellyn): Add signaling of interrupts.
+	SetIRQ(asserted bool)
+	SetNMI(asserted bool)
 	Print(bool)
+	Backtrace() []Frame
+	Journal(size int)
+	StepBack() error
+	ReverseContinue(stop func(pc uint16) bool) error
+	LastWrite(address uint16) (Write, bool)
 }
 
-// Memory interface, for all memory access.
+// Memory interface, for all memory access. Implementations whose
+// reads have side effects should also implement Peeker.
 type Memory interface {
 	Read(uint16) byte
 	Write(uint16, byte)
@@ -81,6 +89,11 @@ type cpu struct {
 	oldPC   uint16
 	version CpuVersion
 	print   bool
+	frames  []Frame  // shadow call stack
+	journal *journal // history for stepping backwards, or nil
+	irq     bool     // IRQ line asserted
+	nmi     bool     // NMI line asserted
+	nmiEdge bool     // NMI asserted since last serviced
 }
 
 // Create and return a new Cpu object with the given memory, ticker, and of the given version.
@@ -118,6 +131,8 @@ func (c *cpu) readWord(address uint16) uint16 {
 func (c *cpu) Reset() {
 	c.r.SP = 0
 	c.r.PC = c.readWord(RESET_VECTOR)
+	c.frames = c.frames[:0]
+	c.nmiEdge = false
 	c.r.P |= FLAG_I // Turn interrupts off
 	switch c.version {
 	case VERSION_6502:
@@ -131,7 +146,7 @@ func (c *cpu) Reset() {
 
 // status prints out the current CPU instruction and register status.
 func status(c *cpu, m Memory) string {
-	bytes, text, _ := asm.Disasm(c.PC(), m.Read(c.PC()), m.Read(c.PC()+1), m.Read(c.PC()+2), nil, 3)
+	bytes, text, _ := Disasm(m, c.PC(), nil, 3)
 	return fmt.Sprintf("$%04X: %-8s  %-11s  A=$%02X X=$%02X Y=$%02X SP=$%02X P=$%08b",
 		c.PC(), bytes, text, c.A(), c.X(), c.Y(), c.SP(), c.P())
 }
@@ -141,17 +156,35 @@ func (c *cpu) Step() error {
 	if c.print {
 		fmt.Println(status(c, c.m))
 	}
+	r, oldPC, nmiEdge := c.r, c.oldPC, c.nmiEdge
 	c.oldPC = c.r.PC
+	if kind, vector, ok := c.pendingInterrupt(); ok {
+		if c.journal != nil {
+			c.journal.begin(c, r, oldPC, nmiEdge, 0x00)
+		}
+		c.interrupt(kind, vector)
+		if c.journal != nil {
+			c.journal.end()
+		}
+		return nil
+	}
 	i := c.m.Read(c.r.PC)
 	c.r.PC++
 	c.t()
 
 	if f, ok := Opcodes[i]; ok {
+		if c.journal != nil {
+			c.journal.begin(c, r, oldPC, nmiEdge, i)
+		}
 		f(c)
+		c.trackFrames(i)
+		if c.journal != nil {
+			c.journal.end()
+		}
 		return nil
 	}
 
-	return fmt.Errorf("Unknown opcode at location $%04X: $%02X", c.r.PC, i)
+	return OpcodeError{PC: c.oldPC, Opcode: i, Backtrace: c.Backtrace()}
 }
 
 // Set the program counter.
@@ -159,6 +192,27 @@ func (c *cpu) SetPC(address uint16) {
 	c.r.PC = address
 }
 
+// Set the A, X, and Y registers.
+func (c *cpu) SetA(value byte) {
+	c.r.A = value
+}
+func (c *cpu) SetX(value byte) {
+	c.r.X = value
+}
+func (c *cpu) SetY(value byte) {
+	c.r.Y = value
+}
+
+// Set the status register. The unused and B flags always read as 1.
+func (c *cpu) SetP(value byte) {
+	c.r.P = value | FLAG_UNUSED | FLAG_B
+}
+
+// Set the stack pointer.
+func (c *cpu) SetSP(value byte) {
+	c.r.SP = value
+}
+
 func (c *cpu) Print(print bool) {
 	c.print = print
 }
@@ -0,0 +1,64 @@
+package cpu
+
+// SetIRQ sets the state of the (level-triggered) IRQ line. While it
+// is asserted and the I flag is clear, the cpu takes an interrupt
+// before each instruction. Devices sharing the line must combine
+// their states themselves.
+func (c *cpu) SetIRQ(asserted bool) {
+	c.irq = asserted
+}
+
+// SetNMI sets the state of the (edge-triggered) NMI line. Asserting
+// it, when it wasn't already asserted, causes an interrupt before the
+// next instruction.
+func (c *cpu) SetNMI(asserted bool) {
+	if asserted && !c.nmi {
+		c.nmiEdge = true
+	}
+	c.nmi = asserted
+}
+
+// pendingInterrupt returns the kind and vector of the interrupt to
+// take before the next instruction, if any. NMI has priority.
+func (c *cpu) pendingInterrupt() (FrameKind, uint16, bool) {
+	if c.nmiEdge {
+		c.nmiEdge = false
+		return FRAME_NMI, NMI_VECTOR, true
+	}
+	if c.irq && c.r.P&FLAG_I == 0 {
+		return FRAME_IRQ, IRQ_VECTOR, true
+	}
+	return 0, 0, false
+}
+
+// interrupt performs the seven-cycle interrupt sequence: like BRK,
+// but without the B flag, and returning to the interrupted
+// instruction itself.
+func (c *cpu) interrupt(kind FrameKind, vector uint16) {
+	// T0, T1
+	c.m.Read(c.r.PC)
+	c.t()
+	c.m.Read(c.r.PC)
+	c.t()
+	// T2
+	c.m.Write(0x100+uint16(c.r.SP), byte(c.r.PC>>8))
+	c.r.SP--
+	c.t()
+	// T3
+	c.m.Write(0x100+uint16(c.r.SP), byte(c.r.PC&0xff))
+	c.r.SP--
+	c.t()
+	// T4
+	c.m.Write(0x100+uint16(c.r.SP), c.r.P&^FLAG_B)
+	c.r.SP--
+	c.r.P |= FLAG_I
+	c.t()
+	// T5
+	addr := uint16(c.m.Read(vector))
+	c.t()
+	// T6
+	addr |= (uint16(c.m.Read(vector+1)) << 8)
+	c.t()
+	c.pushFrame(Frame{Kind: kind, Caller: c.r.PC, Target: addr, Return: c.r.PC})
+	c.r.PC = addr
+}
@@ -0,0 +1,173 @@
+package cpu
+
+import "errors"
+
+// ErrJournalEmpty is returned when asked to step back further than
+// the journal remembers.
+var ErrJournalEmpty = errors.New("journal empty: cannot step back any further")
+
+// Write describes a single journaled memory write.
+type Write struct {
+	PC   uint16 // Address of the instruction that did the write
+	Addr uint16 // Address written to
+	Old  byte   // Value before the write
+	New  byte   // Value written
+	Age  int    // How many instructions ago: 0 is the most recent
+}
+
+type journalWrite struct {
+	addr uint16
+	old  byte
+	new  byte
+}
+
+// journalEntry holds everything needed to undo one instruction.
+type journalEntry struct {
+	r       registers
+	oldPC   uint16
+	nmiEdge bool // whether an NMI was pending
+	writes  []journalWrite
+	frames  []Frame // copy of the shadow stack, if the instruction could change it
+	saved   bool    // whether frames was saved
+}
+
+// journal is a bounded ring buffer of journalEntries.
+type journal struct {
+	entries []journalEntry
+	next    int // index of the next entry to fill
+	count   int // number of valid entries
+	current *journalEntry
+}
+
+// journalMemory wraps a Memory, journaling every write.
+type journalMemory struct {
+	m Memory
+	j *journal
+}
+
+func (jm journalMemory) Read(address uint16) byte {
+	return jm.m.Read(address)
+}
+
+func (jm journalMemory) Peek(address uint16) byte {
+	return Peek(jm.m, address)
+}
+
+func (jm journalMemory) Write(address uint16, value byte) {
+	if e := jm.j.current; e != nil {
+		e.writes = append(e.writes, journalWrite{addr: address, old: Peek(jm.m, address), new: value})
+	}
+	jm.m.Write(address, value)
+}
+
+// stackOpcodes are the instructions that can change the shadow call
+// stack, and so need it saved in the journal.
+var stackOpcodes = map[byte]bool{
+	0x00: true, // BRK
+	0x20: true, // JSR
+	0x28: true, // PLP
+	0x40: true, // RTI
+	0x60: true, // RTS
+	0x68: true, // PLA
+	0x9A: true, // TXS
+}
+
+// Journal turns on journaling of the last size instructions, so they
+// can be stepped backwards. A size of zero turns journaling off.
+func (c *cpu) Journal(size int) {
+	if jm, ok := c.m.(journalMemory); ok {
+		c.m = jm.m
+	}
+	c.journal = nil
+	if size <= 0 {
+		return
+	}
+	c.journal = &journal{entries: make([]journalEntry, size)}
+	c.m = journalMemory{m: c.m, j: c.journal}
+}
+
+// begin starts a new journal entry for the instruction about to be
+// executed, overwriting the oldest entry if the journal is full. It
+// is given the register and NMI state from before the opcode was
+// fetched.
+func (j *journal) begin(c *cpu, r registers, oldPC uint16, nmiEdge bool, opcode byte) {
+	e := &j.entries[j.next]
+	e.r = r
+	e.oldPC = oldPC
+	e.nmiEdge = nmiEdge
+	e.writes = e.writes[:0]
+	e.saved = stackOpcodes[opcode]
+	if e.saved {
+		e.frames = append(e.frames[:0], c.frames...)
+	}
+	j.current = e
+	j.next = (j.next + 1) % len(j.entries)
+	if j.count < len(j.entries) {
+		j.count++
+	}
+}
+
+// end finishes the current journal entry.
+func (j *journal) end() {
+	j.current = nil
+}
+
+// entry returns the journal entry age instructions ago.
+func (j *journal) entry(age int) *journalEntry {
+	i := (j.next - 1 - age + 2*len(j.entries)) % len(j.entries)
+	return &j.entries[i]
+}
+
+// StepBack undoes the most recent instruction, restoring registers,
+// memory, and any pending NMI. Note that the Ticker is not un-ticked.
+func (c *cpu) StepBack() error {
+	j := c.journal
+	if j == nil || j.count == 0 {
+		return ErrJournalEmpty
+	}
+	e := j.entry(0)
+	m := c.m.(journalMemory).m
+	for i := len(e.writes) - 1; i >= 0; i-- {
+		m.Write(e.writes[i].addr, e.writes[i].old)
+	}
+	c.r = e.r
+	c.oldPC = e.oldPC
+	c.nmiEdge = e.nmiEdge
+	if e.saved {
+		c.frames = append(c.frames[:0], e.frames...)
+	}
+	j.next = (j.next - 1 + len(j.entries)) % len(j.entries)
+	j.count--
+	return nil
+}
+
+// ReverseContinue steps backwards at least once, and then until stop
+// returns true for the program counter, or the journal runs out.
+func (c *cpu) ReverseContinue(stop func(pc uint16) bool) error {
+	for {
+		if err := c.StepBack(); err != nil {
+			return err
+		}
+		if stop(c.r.PC) {
+			return nil
+		}
+	}
+}
+
+// LastWrite searches the journal for the most recent write to the
+// given address.
+func (c *cpu) LastWrite(address uint16) (Write, bool) {
+	j := c.journal
+	if j == nil {
+		return Write{}, false
+	}
+	for age := 0; age < j.count; age++ {
+		e := j.entry(age)
+		for i := len(e.writes) - 1; i >= 0; i-- {
+			if w := e.writes[i]; w.addr == address {
+				return Write{PC: e.r.PC, Addr: w.addr, Old: w.old, New: w.new, Age: age}, true
+			}
+		}
+	}
+	return Write{}, false
+}
@@ -0,0 +1,48 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zellyn/go6502/asm"
+)
+
+// Peeker is an optional extension to the Memory interface, for
+// memory (and memory-mapped devices) that can be read without side
+// effects. Reading $C000 or $C030 on an Apple II, for instance,
+// changes the state of the machine; peeking at them must not.
+type Peeker interface {
+	Peek(uint16) byte
+}
+
+// Peek reads a byte of memory without side effects if the memory
+// implements Peeker, and with a normal Read otherwise.
+func Peek(m Memory, address uint16) byte {
+	if p, ok := m.(Peeker); ok {
+		return p.Peek(address)
+	}
+	return m.Read(address)
+}
+
+// Disasm disassembles the instruction at the given address, using
+// Peek to avoid disturbing the machine. It returns the formatted
+// bytes, the formatted instruction, and the length.
+func Disasm(m Memory, address uint16, s asm.Symbols, lookback int) (string, string, int) {
+	return asm.Disasm(address, Peek(m, address), Peek(m, address+1), Peek(m, address+2), s, lookback)
+}
+
+// Dump writes a hex dump of length bytes of memory starting at the
+// given address, sixteen bytes to a line, using Peek to avoid
+// disturbing the machine.
+func Dump(w io.Writer, m Memory, address uint16, length int) error {
+	for i := 0; i < length; i += 16 {
+		line := fmt.Sprintf("$%04X:", address+uint16(i))
+		for j := i; j < i+16 && j < length; j++ {
+			line += fmt.Sprintf(" %02X", Peek(m, address+uint16(j)))
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
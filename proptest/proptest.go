@@ -0,0 +1,352 @@
+/*
+Package proptest checks 6502 routines against reference functions
+written in Go. A Harness calls a routine with generated inputs in
+registers or memory, compares what it returns with the Go model, and
+shrinks any failing input to a minimal case before reporting it.
+
+Harnesses can generate their own random inputs (Quick), or be driven
+by Go's fuzzer (Fuzz).
+*/
+package proptest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/cpu"
+)
+
+// LocKind says where a value lives.
+type LocKind int
+
+const (
+	LOC_A   LocKind = iota // Accumulator
+	LOC_X                  // X register
+	LOC_Y                  // Y register
+	LOC_C                  // Carry flag: 0 or 1
+	LOC_MEM                // Memory, little-endian
+)
+
+// Loc is the location of an input or output value.
+type Loc struct {
+	Kind LocKind
+	Addr uint16 // for LOC_MEM
+	Size int    // bytes, for LOC_MEM
+}
+
+// Register and flag locations.
+var (
+	A     = Loc{Kind: LOC_A}
+	X     = Loc{Kind: LOC_X}
+	Y     = Loc{Kind: LOC_Y}
+	Carry = Loc{Kind: LOC_C}
+)
+
+// Mem returns the location of a size-byte little-endian value at addr.
+func Mem(addr uint16, size int) Loc {
+	return Loc{Kind: LOC_MEM, Addr: addr, Size: size}
+}
+
+// Byte returns the location of a single byte at addr.
+func Byte(addr uint16) Loc {
+	return Mem(addr, 1)
+}
+
+func (l Loc) String() string {
+	switch l.Kind {
+	case LOC_A:
+		return "A"
+	case LOC_X:
+		return "X"
+	case LOC_Y:
+		return "Y"
+	case LOC_C:
+		return "C"
+	}
+	return fmt.Sprintf("$%04X/%d", l.Addr, l.Size)
+}
+
+// bits returns the width of values at the location.
+func (l Loc) bits() uint {
+	switch l.Kind {
+	case LOC_C:
+		return 1
+	case LOC_MEM:
+		return uint(l.Size) * 8
+	}
+	return 8
+}
+
+func (l Loc) mask(v uint64) uint64 {
+	if b := l.bits(); b < 64 {
+		return v & (1<<b - 1)
+	}
+	return v
+}
+
+// returnAddr is where the routine returns to: the harness pushes
+// pushedAddr (returnAddr-1, wrapped) before calling it, like a JSR
+// would.
+const (
+	returnAddr = 0x0000
+	pushedAddr = 0xFFFF
+)
+
+// DefaultMaxSteps is the number of instructions a routine may run
+// before the harness gives up on it.
+const DefaultMaxSteps = 100000
+
+// Harness calls a routine in a 64K memory image.
+type Harness struct {
+	Image    [65536]byte
+	Entry    uint16
+	In       []Loc
+	Out      []Loc
+	Model    func(in []uint64) []uint64 // Go reference: outputs for the given inputs
+	MaxSteps int                        // 0 means DefaultMaxSteps
+	Version  cpu.CpuVersion
+}
+
+// New returns a Harness for the routine at label, with the
+// assembler's output as the memory image. The assembler must have
+// completed its second pass.
+func New(a *asm.Assembler, label string) (*Harness, error) {
+	addr, ok := a.Ctx.Get(label)
+	if !ok {
+		return nil, fmt.Errorf("unknown label: %q", label)
+	}
+	mb, err := a.Membuf()
+	if err != nil {
+		return nil, err
+	}
+	h := &Harness{Entry: uint16(addr), Version: cpu.VERSION_6502}
+	for _, p := range mb.Pieces() {
+		copy(h.Image[p.Addr:], p.Data)
+	}
+	return h, nil
+}
+
+type image [65536]byte
+
+func (m *image) Read(address uint16) byte {
+	return m[address]
+}
+
+func (m *image) Write(address uint16, value byte) {
+	m[address] = value
+}
+
+// Call runs the routine with the given inputs, returning its outputs,
+// and how many cycles it took.
+func (h *Harness) Call(in []uint64) (out []uint64, cycles uint64, err error) {
+	if len(in) != len(h.In) {
+		return nil, 0, fmt.Errorf("want %d inputs; got %d", len(h.In), len(in))
+	}
+	m := image(h.Image)
+	c := cpu.NewCPU(&m, func() { cycles++ }, h.Version)
+	c.Reset()
+	c.SetSP(0xFD)
+	m[0x1FF] = byte(pushedAddr >> 8)
+	m[0x1FE] = byte(pushedAddr & 0xFF)
+	c.SetPC(h.Entry)
+	for i, l := range h.In {
+		v := l.mask(in[i])
+		switch l.Kind {
+		case LOC_A:
+			c.SetA(byte(v))
+		case LOC_X:
+			c.SetX(byte(v))
+		case LOC_Y:
+			c.SetY(byte(v))
+		case LOC_C:
+			c.SetP(c.P()&^cpu.FLAG_C | byte(v))
+		case LOC_MEM:
+			for j := 0; j < l.Size; j++ {
+				m[l.Addr+uint16(j)] = byte(v >> (8 * uint(j)))
+			}
+		}
+	}
+
+	maxSteps := h.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = DefaultMaxSteps
+	}
+	steps := 0
+	for c.PC() != returnAddr {
+		if steps == maxSteps {
+			return nil, 0, fmt.Errorf("routine did not return within %d steps", maxSteps)
+		}
+		if err := c.Step(); err != nil {
+			return nil, 0, err
+		}
+		steps++
+	}
+
+	for _, l := range h.Out {
+		var v uint64
+		switch l.Kind {
+		case LOC_A:
+			v = uint64(c.A())
+		case LOC_X:
+			v = uint64(c.X())
+		case LOC_Y:
+			v = uint64(c.Y())
+		case LOC_C:
+			v = uint64(c.P() & cpu.FLAG_C)
+		case LOC_MEM:
+			for j := l.Size - 1; j >= 0; j-- {
+				v = v<<8 | uint64(m[l.Addr+uint16(j)])
+			}
+		}
+		out = append(out, v)
+	}
+	return out, cycles, nil
+}
+
+// fails returns a description of how the routine and model disagree
+// on the given inputs, or "" if they agree.
+func (h *Harness) fails(in []uint64) string {
+	got, _, err := h.Call(in)
+	if err != nil {
+		return err.Error()
+	}
+	want := h.Model(in)
+	var diffs []string
+	for i, l := range h.Out {
+		if w := l.mask(want[i]); got[i] != w {
+			diffs = append(diffs, fmt.Sprintf("%s: want $%X; got $%X", l, w, got[i]))
+		}
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// maxShrinks bounds the work done shrinking a failing input.
+const maxShrinks = 1000
+
+// Shrink returns a minimal input that still fails, starting from a
+// failing input. It repeatedly tries replacing each value with a
+// simpler one (zero, half, one less, one fewer set bit), keeping any
+// replacement that still fails.
+func (h *Harness) Shrink(in []uint64) []uint64 {
+	in = append([]uint64(nil), in...)
+	tries := 0
+	for progress := true; progress && tries < maxShrinks; {
+		progress = false
+		for i := range in {
+			v := in[i]
+			for _, s := range []uint64{0, v >> 1, v - 1, v & (v - 1)} {
+				if v == 0 || s >= v {
+					continue
+				}
+				tries++
+				in[i] = s
+				if h.fails(in) != "" {
+					progress = true
+					break
+				}
+				in[i] = v
+			}
+		}
+	}
+	return in
+}
+
+func (h *Harness) formatInputs(in []uint64) string {
+	var s []string
+	for i, l := range h.In {
+		s = append(s, fmt.Sprintf("%s=$%X", l, l.mask(in[i])))
+	}
+	return strings.Join(s, " ")
+}
+
+// Check calls the routine and model with the given inputs, and fails
+// the test with a shrunk counterexample if they disagree.
+func (h *Harness) Check(t testing.TB, in []uint64) {
+	t.Helper()
+	masked := make([]uint64, len(in))
+	for i, l := range h.In {
+		masked[i] = l.mask(in[i])
+	}
+	if h.fails(masked) == "" {
+		return
+	}
+	min := h.Shrink(masked)
+	t.Fatalf("routine at $%04X disagrees with model for %s: %s (shrunk from %s)",
+		h.Entry, h.formatInputs(min), h.fails(min), h.formatInputs(masked))
+}
+
+// edges are interesting values tried for every input.
+var edges = []uint64{0, 1, 2, 0x7F, 0x80, 0xFF, 0x100, 0x7FFF, 0x8000, 0xFFFF, ^uint64(0)}
+
+// Quick checks every combination of edge values for up to two
+// inputs at a time, and then n random inputs from the given seed.
+func (h *Harness) Quick(t testing.TB, n int, seed int64) {
+	t.Helper()
+	in := make([]uint64, len(h.In))
+	for i := range h.In {
+		for j := i; j < len(h.In); j++ {
+			for _, ei := range edges {
+				for _, ej := range edges {
+					for k := range in {
+						in[k] = 0
+					}
+					in[i], in[j] = ei, ej
+					h.Check(t, in)
+				}
+			}
+		}
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for ; n > 0; n-- {
+		for k := range in {
+			in[k] = uint64(rng.Int63())<<1 ^ uint64(rng.Int63())
+		}
+		h.Check(t, in)
+	}
+}
+
+// decode turns fuzzer bytes into inputs, taking each input's bytes
+// in turn, and padding with zeros.
+func (h *Harness) decode(data []byte) []uint64 {
+	in := make([]uint64, len(h.In))
+	for i, l := range h.In {
+		n := int(l.bits()+7) / 8
+		for j := 0; j < n; j++ {
+			if len(data) > 0 {
+				in[i] |= uint64(data[0]) << (8 * uint(j))
+				data = data[1:]
+			}
+		}
+	}
+	return in
+}
+
+// encode is the inverse of decode.
+func (h *Harness) encode(in []uint64) []byte {
+	var data []byte
+	for i, l := range h.In {
+		n := int(l.bits()+7) / 8
+		for j := 0; j < n; j++ {
+			data = append(data, byte(in[i]>>(8*uint(j))))
+		}
+	}
+	return data
+}
+
+// Fuzz seeds the fuzzer with edge values, and checks the routine
+// against the model for every input it generates.
+func (h *Harness) Fuzz(f *testing.F) {
+	for _, e := range edges {
+		in := make([]uint64, len(h.In))
+		for i := range in {
+			in[i] = e
+		}
+		f.Add(h.encode(in))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h.Check(t, h.decode(data))
+	})
+}
@@ -0,0 +1,65 @@
+package proptest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm/asmtest"
+)
+
+// multiply is an 8x8->16 bit shift-and-add multiply. The CLC is
+// replaced to make a buggy version.
+const multiply = `
+NUM1    .EQ $F0
+NUM2    .EQ $F1
+RES     .EQ $F2
+        .OR $0800
+MUL     LDA #0
+        LDX #8
+.1      LSR NUM1
+        BCC .2
+        CLC
+        ADC NUM2
+.2      ROR
+        ROR RES
+        DEX
+        BNE .1
+        STA RES+1
+        RTS
+`
+
+func newMultiply(t testing.TB, source string) *Harness {
+	a := asmtest.Assemble(t, source)
+	h, err := New(a, "MUL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.In = []Loc{Byte(0xF0), Byte(0xF1)}
+	h.Out = []Loc{Mem(0xF2, 2)}
+	h.Model = func(in []uint64) []uint64 {
+		return []uint64{in[0] * in[1]}
+	}
+	return h
+}
+
+func TestMultiply(t *testing.T) {
+	h := newMultiply(t, multiply)
+	h.Quick(t, 1000, 1)
+}
+
+func TestShrink(t *testing.T) {
+	h := newMultiply(t, strings.Replace(multiply, "CLC", "NOP", 1))
+	in := []uint64{0xD7, 0x9B}
+	if h.fails(in) == "" {
+		t.Fatalf("want buggy multiply to fail for %v", in)
+	}
+	got := h.Shrink(in)
+	want := []uint64{1, 0}
+	if got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("want shrunk input %v; got %v", want, got)
+	}
+}
+
+func FuzzMultiply(f *testing.F) {
+	newMultiply(f, multiply).Fuzz(f)
+}
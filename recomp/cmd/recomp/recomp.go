@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/recomp"
+)
+
+var infile = flag.String("in", "", "input binary file")
+var outfile = flag.String("out", "", "output Go file")
+var origin = flag.String("org", "0800", "load address of the binary, in hex")
+var entries = flag.String("entry", "", "comma-separated entry points, in hex (default: the load address)")
+var symfile = flag.String("symbols", "", "symbol file: lines of hex address and name")
+var pkg = flag.String("package", "recompiled", "package name for the generated code")
+
+func die(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func parseAddr(s string) uint16 {
+	a, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(s), "$"), 16, 16)
+	if err != nil {
+		die("bad address %q: %v", s, err)
+	}
+	return uint16(a)
+}
+
+func readSymbols(filename string) asm.Symbols {
+	f, err := os.Open(filename)
+	if err != nil {
+		die("%v", err)
+	}
+	defer f.Close()
+	syms := asm.Symbols{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			die("bad symbol line: %q", scanner.Text())
+		}
+		syms[int(parseAddr(fields[0]))] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		die("%v", err)
+	}
+	return syms
+}
+
+func main() {
+	flag.Parse()
+	if *infile == "" {
+		die("no input file specified")
+	}
+	if *outfile == "" {
+		die("no output file specified")
+	}
+	code, err := ioutil.ReadFile(*infile)
+	if err != nil {
+		die("%v", err)
+	}
+	p := recomp.Program{Code: code, Origin: parseAddr(*origin)}
+	if *entries == "" {
+		p.Entries = []uint16{p.Origin}
+	} else {
+		for _, e := range strings.Split(*entries, ",") {
+			p.Entries = append(p.Entries, parseAddr(e))
+		}
+	}
+	if *symfile != "" {
+		p.Symbols = readSymbols(*symfile)
+	}
+	src, err := recomp.Generate(p, *pkg, *infile)
+	if err != nil {
+		die("%v", err)
+	}
+	if err := ioutil.WriteFile(*outfile, src, 0666); err != nil {
+		die("%v", err)
+	}
+}
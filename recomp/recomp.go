@@ -0,0 +1,494 @@
+/*
+Package recomp statically recompiles 6502 binaries into Go source.
+
+Starting from the given entry points, it follows the control flow of
+the binary to find basic blocks, and turns each one into a Go method
+that runs against a cpu.Memory, ticking the cpu.Ticker exactly as many
+times as the interpreter would. Instructions it cannot recompile (BRK,
+RTI, unknown opcodes), code reached only through computed jumps, and
+blocks that have been written to at run time, are handed to the cpu
+interpreter instead, via the recomp/rt runtime package.
+
+Recompiled code does not reproduce the interpreter's dummy reads and
+writes, and ticks all of an instruction's cycles at its start, so
+memory-mapped devices that are sensitive to those will behave
+differently.
+*/
+package recomp
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+
+	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/asm/opcodes"
+)
+
+// Program describes a binary to recompile.
+type Program struct {
+	Code    []byte
+	Origin  uint16      // Load address of Code
+	Entries []uint16    // Entry points
+	Symbols asm.Symbols // Optional symbols, for naming and comments
+}
+
+// instr is a single decoded instruction.
+type instr struct {
+	addr uint16
+	op   opcodes.Opcode
+	b    [3]byte // opcode and operand bytes
+	size int
+}
+
+// operand returns the instruction's 8- or 16-bit operand.
+func (in instr) operand() uint16 {
+	if in.size == 3 {
+		return uint16(in.b[1]) | uint16(in.b[2])<<8
+	}
+	return uint16(in.b[1])
+}
+
+// next returns the address of the following instruction.
+func (in instr) next() uint16 {
+	return in.addr + uint16(in.size)
+}
+
+// branchTarget returns the destination of a relative branch.
+func (in instr) branchTarget() uint16 {
+	return in.next() + uint16(int8(in.b[1]))
+}
+
+// interpreted lists instructions always left to the interpreter.
+var interpreted = map[string]bool{
+	"BRK": true,
+	"RTI": true,
+}
+
+// ends returns true if the instruction ends a basic block.
+func (in instr) ends() bool {
+	switch in.op.Name {
+	case "JMP", "JSR", "RTS":
+		return true
+	}
+	return in.op.Mode == opcodes.MODE_RELATIVE
+}
+
+// Block is a recompiled basic block.
+type Block struct {
+	Start, End uint16 // End is exclusive
+	instrs     []instr
+}
+
+// recompiler holds the state of a recompilation.
+type recompiler struct {
+	p       Program
+	leaders map[uint16]bool
+	blocks  []Block
+}
+
+func (r *recompiler) inCode(addr uint16, size int) bool {
+	start := int(addr) - int(r.p.Origin)
+	return start >= 0 && start+size <= len(r.p.Code)
+}
+
+// decode decodes the instruction at addr, returning false if it can't
+// be recompiled.
+func (r *recompiler) decode(addr uint16) (instr, bool) {
+	if !r.inCode(addr, 1) {
+		return instr{}, false
+	}
+	b := r.p.Code[int(addr)-int(r.p.Origin)]
+	op, ok := opcodes.Opcodes[b]
+	if !ok || interpreted[op.Name] {
+		return instr{}, false
+	}
+	in := instr{addr: addr, op: op, size: opcodes.ModeLengths[op.Mode]}
+	if !r.inCode(addr, in.size) {
+		return instr{}, false
+	}
+	copy(in.b[:], r.p.Code[int(addr)-int(r.p.Origin):int(addr)-int(r.p.Origin)+in.size])
+	return in, true
+}
+
+// findLeaders follows control flow from the entry points, marking
+// every address that starts a basic block.
+func (r *recompiler) findLeaders() {
+	r.leaders = make(map[uint16]bool)
+	seen := make(map[uint16]bool)
+	work := append([]uint16(nil), r.p.Entries...)
+	for _, e := range r.p.Entries {
+		r.leaders[e] = true
+	}
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for !seen[addr] {
+			seen[addr] = true
+			in, ok := r.decode(addr)
+			if !ok {
+				break
+			}
+			var targets []uint16
+			switch {
+			case in.op.Mode == opcodes.MODE_RELATIVE:
+				targets = []uint16{in.branchTarget(), in.next()}
+			case in.op.Name == "JSR":
+				targets = []uint16{in.operand(), in.next()}
+			case in.op.Name == "JMP" && in.op.Mode == opcodes.MODE_ABSOLUTE:
+				targets = []uint16{in.operand()}
+			}
+			for _, t := range targets {
+				r.leaders[t] = true
+				work = append(work, t)
+			}
+			if in.ends() {
+				break
+			}
+			addr = in.next()
+		}
+	}
+}
+
+// buildBlocks decodes the basic block starting at each leader.
+func (r *recompiler) buildBlocks() {
+	var starts []int
+	for l := range r.leaders {
+		starts = append(starts, int(l))
+	}
+	sort.Ints(starts)
+	for _, s := range starts {
+		b := Block{Start: uint16(s)}
+		addr := b.Start
+		for {
+			in, ok := r.decode(addr)
+			if !ok {
+				break
+			}
+			b.instrs = append(b.instrs, in)
+			addr = in.next()
+			if in.ends() || r.leaders[addr] {
+				break
+			}
+		}
+		if len(b.instrs) > 0 {
+			b.End = addr
+			r.blocks = append(r.blocks, b)
+		}
+	}
+}
+
+// isCode returns true if addr is inside a recompiled block.
+func (r *recompiler) isCode(addr uint16) bool {
+	for _, b := range r.blocks {
+		if addr >= b.Start && addr < b.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocks returns the basic blocks that would be recompiled.
+func (p Program) Blocks() []Block {
+	r := &recompiler{p: p}
+	r.findLeaders()
+	r.buildBlocks()
+	return r.blocks
+}
+
+// Generate returns gofmt-ed Go source for the program, in the given
+// package. The source defines a Program type, a New function to
+// create one, and a Run method.
+func Generate(p Program, pkg string, source string) ([]byte, error) {
+	if len(p.Entries) == 0 {
+		return nil, fmt.Errorf("no entry points given")
+	}
+	r := &recompiler{p: p}
+	r.findLeaders()
+	r.buildBlocks()
+
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "// Code generated by recomp from %s; DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&w, "package %s\n\n", pkg)
+	fmt.Fprintf(&w, "import (\n\t\"github.com/zellyn/go6502/cpu\"\n\t\"github.com/zellyn/go6502/recomp/rt\"\n)\n\n")
+	fmt.Fprintf(&w, "// Program is the recompiled program.\ntype Program struct {\n\t*rt.Machine\n}\n\n")
+	fmt.Fprintf(&w, "// New returns a Program that runs against the given memory and ticker.\n")
+	fmt.Fprintf(&w, "func New(m cpu.Memory, t cpu.Ticker) *Program {\n\tp := &Program{rt.NewMachine(m, t)}\n")
+	for _, b := range r.blocks {
+		fmt.Fprintf(&w, "\tp.AddBlock(0x%04X, 0x%04X)\n", b.Start, b.End)
+	}
+	fmt.Fprintf(&w, "\treturn p\n}\n\n")
+
+	fmt.Fprintf(&w, "// Run runs from PC until stop returns true, or the interpreter\n")
+	fmt.Fprintf(&w, "// returns an error. Recompiled blocks only check stop at their\n")
+	fmt.Fprintf(&w, "// start.\n")
+	fmt.Fprintf(&w, "func (p *Program) Run(stop func(pc uint16) bool) error {\n")
+	fmt.Fprintf(&w, "\tfor p.Err == nil && !stop(p.PC) {\n")
+	fmt.Fprintf(&w, "\t\tif !p.Clean(p.PC) {\n\t\t\tp.Interpret(stop)\n\t\t\tcontinue\n\t\t}\n")
+	fmt.Fprintf(&w, "\t\tswitch p.PC {\n")
+	for _, b := range r.blocks {
+		fmt.Fprintf(&w, "\t\tcase 0x%04X:\n\t\t\tp.PC = p.%s()\n", b.Start, r.blockName(b.Start))
+	}
+	fmt.Fprintf(&w, "\t\t}\n\t}\n\treturn p.Err\n}\n")
+
+	for _, b := range r.blocks {
+		r.genBlock(&w, b)
+	}
+
+	out, err := format.Source(w.Bytes())
+	if err != nil {
+		return w.Bytes(), fmt.Errorf("error formatting generated code: %v", err)
+	}
+	return out, nil
+}
+
+// blockName returns the name of the method for the block at addr.
+func (r *recompiler) blockName(addr uint16) string {
+	return fmt.Sprintf("b%04X", addr)
+}
+
+func (r *recompiler) genBlock(w *bytes.Buffer, b Block) {
+	fmt.Fprintf(w, "\n// $%04X-$%04X", b.Start, b.End-1)
+	if name := r.p.Symbols.Name(int(b.Start), 0); name != "" {
+		fmt.Fprintf(w, " %s", name)
+	}
+	fmt.Fprintf(w, "\nfunc (p *Program) %s() uint16 {\n", r.blockName(b.Start))
+	for _, in := range b.instrs {
+		_, text, _ := asm.Disasm(in.addr, in.b[0], in.b[1], in.b[2], r.p.Symbols, 0)
+		fmt.Fprintf(w, "\t// $%04X: %s\n", in.addr, strings.TrimSpace(text))
+		fmt.Fprintf(w, "\tp.Ticks(%d)\n", cycles(in))
+		for _, line := range r.genInstr(in) {
+			fmt.Fprintf(w, "\t%s\n", line)
+		}
+	}
+	last := b.instrs[len(b.instrs)-1]
+	if !last.ends() || last.op.Mode == opcodes.MODE_RELATIVE {
+		fmt.Fprintf(w, "\treturn 0x%04X\n", b.End)
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+var writes = map[string]bool{"STA": true, "STX": true, "STY": true}
+var rmws = map[string]bool{"ASL": true, "LSR": true, "ROL": true, "ROR": true, "INC": true, "DEC": true}
+
+// cycles returns the base cycle count of an instruction, not
+// counting page crossings or taken branches.
+func cycles(in instr) int {
+	name := in.op.Name
+	switch in.op.Mode {
+	case opcodes.MODE_IMPLIED:
+		switch name {
+		case "PHA", "PHP":
+			return 3
+		case "PLA", "PLP":
+			return 4
+		case "RTS":
+			return 6
+		}
+		return 2
+	case opcodes.MODE_A, opcodes.MODE_IMMEDIATE, opcodes.MODE_RELATIVE:
+		return 2
+	case opcodes.MODE_ZP:
+		if rmws[name] {
+			return 5
+		}
+		return 3
+	case opcodes.MODE_ZP_X, opcodes.MODE_ZP_Y:
+		if rmws[name] {
+			return 6
+		}
+		return 4
+	case opcodes.MODE_ABSOLUTE:
+		switch {
+		case name == "JMP":
+			return 3
+		case name == "JSR", rmws[name]:
+			return 6
+		}
+		return 4
+	case opcodes.MODE_ABS_X, opcodes.MODE_ABS_Y:
+		switch {
+		case rmws[name]:
+			return 7
+		case writes[name]:
+			return 5
+		}
+		return 4
+	case opcodes.MODE_INDIRECT_X:
+		return 6
+	case opcodes.MODE_INDIRECT_Y:
+		if writes[name] {
+			return 6
+		}
+		return 5
+	case opcodes.MODE_INDIRECT:
+		return 5
+	}
+	panic(fmt.Sprintf("unknown addressing mode for %s", name))
+}
+
+// address returns a Go expression for the effective address of an
+// instruction, and whether it is a constant. Reads with indexed modes
+// add a cycle when they cross a page.
+func address(in instr) (string, bool) {
+	read := !writes[in.op.Name] && !rmws[in.op.Name]
+	switch in.op.Mode {
+	case opcodes.MODE_ZP, opcodes.MODE_ABSOLUTE:
+		return fmt.Sprintf("0x%04X", in.operand()), true
+	case opcodes.MODE_ZP_X:
+		return fmt.Sprintf("uint16(0x%02X + p.X)", in.operand()), false
+	case opcodes.MODE_ZP_Y:
+		return fmt.Sprintf("uint16(0x%02X + p.Y)", in.operand()), false
+	case opcodes.MODE_ABS_X, opcodes.MODE_ABS_Y:
+		reg := "p.X"
+		if in.op.Mode == opcodes.MODE_ABS_Y {
+			reg = "p.Y"
+		}
+		if read {
+			return fmt.Sprintf("p.Cross(0x%04X, %s)", in.operand(), reg), false
+		}
+		return fmt.Sprintf("0x%04X + uint16(%s)", in.operand(), reg), false
+	case opcodes.MODE_INDIRECT_X:
+		return fmt.Sprintf("p.ZPWord(0x%02X + p.X)", in.operand()), false
+	case opcodes.MODE_INDIRECT_Y:
+		if read {
+			return fmt.Sprintf("p.Cross(p.ZPWord(0x%02X), p.Y)", in.operand()), false
+		}
+		return fmt.Sprintf("p.ZPWord(0x%02X) + uint16(p.Y)", in.operand()), false
+	}
+	panic(fmt.Sprintf("no address for %s mode %d", in.op.Name, in.op.Mode))
+}
+
+// store returns Go statements writing value to addr. Writes that
+// could hit recompiled code leave the block if they do.
+func (r *recompiler) store(in instr, addr string, constant bool, value string) []string {
+	if constant && !r.isCode(in.operand()) {
+		return []string{fmt.Sprintf("p.Write(%s, %s)", addr, value)}
+	}
+	return []string{fmt.Sprintf("if p.Store(%s, %s) {", addr, value), fmt.Sprintf("\treturn 0x%04X", in.next()), "}"}
+}
+
+// flags maps flag instructions to their flag, and branches to their
+// flag and the value that makes them branch.
+var flags = map[string]string{
+	"CLC": "C", "SEC": "C", "BCC": "C", "BCS": "C",
+	"CLD": "D", "SED": "D",
+	"CLI": "I", "SEI": "I",
+	"CLV": "V", "BVC": "V", "BVS": "V",
+	"BEQ": "Z", "BNE": "Z",
+	"BMI": "N", "BPL": "N",
+}
+
+var branchIfSet = map[string]bool{"BCS": true, "BVS": true, "BEQ": true, "BMI": true}
+
+var registers = map[byte]string{'A': "p.A", 'X': "p.X", 'Y': "p.Y"}
+
+// genInstr returns Go statements for a single instruction.
+func (r *recompiler) genInstr(in instr) []string {
+	name := in.op.Name
+	mode := in.op.Mode
+
+	// Operand value, for reading instructions.
+	value := func() string {
+		if mode == opcodes.MODE_IMMEDIATE {
+			return fmt.Sprintf("0x%02X", in.operand())
+		}
+		addr, _ := address(in)
+		return fmt.Sprintf("p.Read(%s)", addr)
+	}
+
+	switch name {
+	case "LDA", "LDX", "LDY":
+		return []string{fmt.Sprintf("%s = p.NZ(%s)", registers[name[2]], value())}
+	case "STA", "STX", "STY":
+		addr, constant := address(in)
+		return r.store(in, addr, constant, registers[name[2]])
+	case "ADC", "SBC", "BIT":
+		return []string{fmt.Sprintf("p.%s(%s)", name, value())}
+	case "AND":
+		return []string{fmt.Sprintf("p.A = p.NZ(p.A & %s)", value())}
+	case "ORA":
+		return []string{fmt.Sprintf("p.A = p.NZ(p.A | %s)", value())}
+	case "EOR":
+		return []string{fmt.Sprintf("p.A = p.NZ(p.A ^ %s)", value())}
+	case "CMP":
+		return []string{fmt.Sprintf("p.Compare(p.A, %s)", value())}
+	case "CPX", "CPY":
+		return []string{fmt.Sprintf("p.Compare(%s, %s)", registers[name[2]], value())}
+	case "ASL", "LSR", "ROL", "ROR", "INC", "DEC":
+		op := "p." + name + "(%s)"
+		switch name {
+		case "INC":
+			op = "p.NZ(%s + 1)"
+		case "DEC":
+			op = "p.NZ(%s - 1)"
+		}
+		if mode == opcodes.MODE_A {
+			return []string{"p.A = " + fmt.Sprintf(op, "p.A")}
+		}
+		addr, constant := address(in)
+		lines := []string{"{", fmt.Sprintf("\ta := uint16(%s)", addr), "\tv := " + fmt.Sprintf(op, "p.Read(a)")}
+		for _, l := range r.store(in, "a", constant, "v") {
+			lines = append(lines, "\t"+l)
+		}
+		return append(lines, "}")
+	case "INX", "INY":
+		return []string{fmt.Sprintf("%s = p.NZ(%s + 1)", registers[name[2]], registers[name[2]])}
+	case "DEX", "DEY":
+		return []string{fmt.Sprintf("%s = p.NZ(%s - 1)", registers[name[2]], registers[name[2]])}
+	case "TAX", "TAY", "TXA", "TYA":
+		return []string{fmt.Sprintf("%s = p.NZ(%s)", registers[name[2]], registers[name[1]])}
+	case "TSX":
+		return []string{"p.X = p.NZ(p.SP)"}
+	case "TXS":
+		return []string{"p.SP = p.X"}
+	case "CLC", "CLD", "CLI", "CLV":
+		return []string{fmt.Sprintf("p.P &^= cpu.FLAG_%s", flags[name])}
+	case "SEC", "SED", "SEI":
+		return []string{fmt.Sprintf("p.P |= cpu.FLAG_%s", flags[name])}
+	case "PHA":
+		return []string{"p.Push(p.A)"}
+	case "PHP":
+		return []string{"p.Push(p.P)"}
+	case "PLA":
+		return []string{"p.A = p.NZ(p.Pull())"}
+	case "PLP":
+		return []string{"p.P = p.Pull() | cpu.FLAG_UNUSED | cpu.FLAG_B"}
+	case "NOP":
+		return nil
+	case "JMP":
+		if mode == opcodes.MODE_INDIRECT {
+			return []string{fmt.Sprintf("return p.IndirectJump(0x%04X)", in.operand())}
+		}
+		return []string{fmt.Sprintf("return 0x%04X", in.operand())}
+	case "JSR":
+		ret := in.next() - 1
+		return []string{
+			fmt.Sprintf("p.Push(0x%02X)", ret>>8),
+			fmt.Sprintf("p.Push(0x%02X)", ret&0xFF),
+			fmt.Sprintf("return 0x%04X", in.operand()),
+		}
+	case "RTS":
+		return []string{"return p.PullWord() + 1"}
+	}
+	if mode == opcodes.MODE_RELATIVE {
+		cond := "== 0"
+		if branchIfSet[name] {
+			cond = "!= 0"
+		}
+		target := in.branchTarget()
+		taken := 1
+		if (target^in.next())&0xFF00 != 0 {
+			taken = 2
+		}
+		return []string{
+			fmt.Sprintf("if p.P&cpu.FLAG_%s %s {", flags[name], cond),
+			fmt.Sprintf("\tp.Ticks(%d)", taken),
+			fmt.Sprintf("\treturn 0x%04X", target),
+			"}",
+		}
+	}
+	panic(fmt.Sprintf("cannot recompile %s", name))
+}
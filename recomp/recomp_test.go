@@ -0,0 +1,219 @@
+package recomp
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/asm/asmtest"
+)
+
+// program exercises loops, subroutines, decimal mode, page-crossing
+// reads, self-modifying code, computed jumps, and BRK.
+const program = `
+        .OR $0800
+START   LDX #$FF
+        TXS
+        CLD
+        LDA #$00
+        STA $10
+        STA $11
+        TAY
+LOOP    TYA
+        CLC
+        ADC $10
+        STA $10
+        BCC .1
+        INC $11
+.1      INY
+        CPY #100
+        BNE LOOP
+        JSR DECML
+        JSR DISP
+        LDX #$00
+COPY    LDA $20F0,X
+        STA $3000,X
+        ASL $3000,X
+        INX
+        CPX #$20
+        BNE COPY
+        LDA #$42
+        STA SMC+1
+SMC     LDA #$00
+        STA $12
+        BRK
+        .HS EA
+        JMP (VEC)
+DONE    JMP $0F00
+DECML   SED
+        LDA #$19
+        CLC
+        ADC #$28
+        STA $14
+        SBC #$09
+        STA $15
+        CLD
+        RTS
+DISP    LDA /TARGET-1
+        PHA
+        LDA #TARGET-1
+        PHA
+        RTS
+TARGET  LDA #$99
+        STA $16
+        RTS
+IRQ     INC $13
+        RTI
+VEC     .DA DONE
+`
+
+func assemble(t *testing.T) (*asm.Assembler, []byte) {
+	a := asmtest.Assemble(t, program)
+	code, err := a.RawBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, code
+}
+
+func label(t *testing.T, a *asm.Assembler, name string) uint16 {
+	v, ok := a.Ctx.Get(name)
+	if !ok {
+		t.Fatalf("no label %q", name)
+	}
+	return uint16(v)
+}
+
+func TestBlocks(t *testing.T) {
+	a, code := assemble(t)
+	p := Program{Code: code, Origin: 0x0800, Entries: []uint16{0x0800}}
+	starts := map[uint16]bool{}
+	for _, b := range p.Blocks() {
+		starts[b.Start] = true
+	}
+	for _, l := range []string{"START", "LOOP", "DECML", "DISP", "COPY"} {
+		if !starts[label(t, a, l)] {
+			t.Errorf("want a block at %s", l)
+		}
+	}
+	// Only reachable by RTS dispatch, JMP indirect, or BRK.
+	for _, l := range []string{"TARGET", "DONE", "IRQ"} {
+		if starts[label(t, a, l)] {
+			t.Errorf("want no block at %s", l)
+		}
+	}
+}
+
+// harness runs the interpreter and the recompiled program side by
+// side on the same binary, and compares the results.
+const harness = `package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zellyn/go6502/cpu"
+)
+
+type k64 [65536]byte
+
+func (m *k64) Read(a uint16) byte     { return m[a] }
+func (m *k64) Write(a uint16, v byte) { m[a] = v }
+
+var code = []byte{%s}
+
+func load(m *k64) {
+	copy(m[0x0800:], code)
+	m[0xFFFE], m[0xFFFF] = 0x%02X, 0x%02X
+}
+
+func main() {
+	stop := func(pc uint16) bool { return pc == 0x0F00 }
+
+	var m1 k64
+	load(&m1)
+	var c1 int
+	c := cpu.NewCPU(&m1, func() { c1++ }, cpu.VERSION_6502)
+	c.SetPC(0x0800)
+	for !stop(c.PC()) {
+		if err := c.Step(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var m2 k64
+	load(&m2)
+	var c2 int
+	p := New(&m2, func() { c2++ })
+	p.PC = 0x0800
+	if err := p.Run(stop); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if got, want := fmt.Sprintf("A=%%02X X=%%02X Y=%%02X P=%%02X SP=%%02X", p.A, p.X, p.Y, p.P, p.SP),
+		fmt.Sprintf("A=%%02X X=%%02X Y=%%02X P=%%02X SP=%%02X", c.A(), c.X(), c.Y(), c.P(), c.SP()); got != want {
+		fmt.Printf("registers: want %%s; got %%s\n", want, got)
+	}
+	if c1 != c2 {
+		fmt.Printf("cycles: want %%d; got %%d\n", c1, c2)
+	}
+	for i := range m1 {
+		if m1[i] != m2[i] {
+			fmt.Printf("memory at $%%04X: want $%%02X; got $%%02X\n", i, m1[i], m2[i])
+		}
+	}
+}
+`
+
+// TestRecompiled generates Go for the test program, runs it with the
+// go tool, and compares it with the interpreter.
+func TestRecompiled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go run in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go tool")
+	}
+	if _, err := build.Import("github.com/zellyn/go6502/recomp/rt", "", build.FindOnly); err != nil {
+		t.Skip("go6502 is not in GOPATH")
+	}
+
+	a, code := assemble(t)
+	src, err := Generate(Program{Code: code, Origin: 0x0800, Entries: []uint16{0x0800}}, "main", "test")
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	var hex []string
+	for _, b := range code {
+		hex = append(hex, fmt.Sprintf("0x%02X", b))
+	}
+	irq := label(t, a, "IRQ")
+	main := fmt.Sprintf(harness, strings.Join(hex, ", "), irq&0xFF, irq>>8)
+
+	dir, err := ioutil.TempDir("", "recomp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string][]byte{"recompiled.go": src, "main.go": []byte(main)} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("go", "run", "main.go", "recompiled.go")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=off", "GOPATH="+build.Default.GOPATH)
+	out, err := cmd.CombinedOutput()
+	if err != nil || len(bytes.TrimSpace(out)) > 0 {
+		t.Errorf("recompiled program differs from interpreter (%v):\n%s", err, out)
+	}
+}
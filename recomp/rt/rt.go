@@ -0,0 +1,324 @@
+/*
+Package rt is the runtime support for Go code generated by the recomp
+package. A Machine holds the 6502 registers, provides the helpers
+generated code uses for instruction semantics, and falls back to the
+cpu interpreter for code that couldn't be recompiled, or that has been
+modified since it was.
+
+The helpers mirror the cpu package's semantics exactly, including its
+decimal mode behavior, so recompiled and interpreted code can be
+mixed freely.
+*/
+package rt
+
+import "github.com/zellyn/go6502/cpu"
+
+// Code byte states.
+const (
+	byteData  = iota // Not recompiled
+	byteCode         // Recompiled, unmodified
+	byteDirty        // Recompiled, but written to since
+)
+
+// Machine is the state of a recompiled program.
+type Machine struct {
+	A, X, Y, P, SP byte
+	PC             uint16
+	Mem            cpu.Memory
+	T              cpu.Ticker
+	Err            error // Set if the interpreter fails
+
+	blocks   map[uint16]uint16 // start address -> end address (exclusive)
+	code     [65536]byte       // byte states
+	anyDirty bool
+	interp   cpu.Cpu
+}
+
+// NewMachine returns a Machine using the given memory and ticker.
+func NewMachine(m cpu.Memory, t cpu.Ticker) *Machine {
+	mc := &Machine{Mem: m, T: t, P: cpu.FLAG_UNUSED | cpu.FLAG_B, blocks: make(map[uint16]uint16)}
+	mc.interp = cpu.NewCPU(mc, t, cpu.VERSION_6502)
+	return mc
+}
+
+// AddBlock records that the bytes from start up to (but not
+// including) end have been recompiled into a block.
+func (m *Machine) AddBlock(start, end uint16) {
+	m.blocks[start] = end
+	for a := start; a != end; a++ {
+		m.code[a] = byteCode
+	}
+}
+
+// Clean returns true if pc is the start of a recompiled block none of
+// whose bytes have been written to.
+func (m *Machine) Clean(pc uint16) bool {
+	end, ok := m.blocks[pc]
+	if !ok {
+		return false
+	}
+	if !m.anyDirty {
+		return true
+	}
+	for a := pc; a != end; a++ {
+		if m.code[a] == byteDirty {
+			return false
+		}
+	}
+	return true
+}
+
+// Reset performs a reset, just like cpu.Reset.
+func (m *Machine) Reset() {
+	m.interp.Reset()
+	m.load()
+}
+
+// save copies registers to the interpreter.
+func (m *Machine) save() {
+	m.interp.SetA(m.A)
+	m.interp.SetX(m.X)
+	m.interp.SetY(m.Y)
+	m.interp.SetP(m.P)
+	m.interp.SetSP(m.SP)
+	m.interp.SetPC(m.PC)
+}
+
+// load copies registers back from the interpreter.
+func (m *Machine) load() {
+	m.A = m.interp.A()
+	m.X = m.interp.X()
+	m.Y = m.interp.Y()
+	m.P = m.interp.P()
+	m.SP = m.interp.SP()
+	m.PC = m.interp.PC()
+}
+
+// Interpret runs the interpreter from PC for at least one
+// instruction, and until it reaches a clean recompiled block or stop
+// returns true.
+func (m *Machine) Interpret(stop func(pc uint16) bool) {
+	m.save()
+	for {
+		if err := m.interp.Step(); err != nil {
+			m.Err = err
+			break
+		}
+		pc := m.interp.PC()
+		if stop(pc) || m.Clean(pc) {
+			break
+		}
+	}
+	m.load()
+}
+
+// Read reads memory. Satisfies cpu.Memory.
+func (m *Machine) Read(address uint16) byte {
+	return m.Mem.Read(address)
+}
+
+// Peek reads memory without side effects. Satisfies cpu.Peeker.
+func (m *Machine) Peek(address uint16) byte {
+	return cpu.Peek(m.Mem, address)
+}
+
+// Write writes memory, noting writes to recompiled code. Satisfies
+// cpu.Memory.
+func (m *Machine) Write(address uint16, value byte) {
+	m.Store(address, value)
+}
+
+// Store writes memory, and returns true if the write hit recompiled
+// code: generated code must then leave its block, since the rest of
+// it may be stale.
+func (m *Machine) Store(address uint16, value byte) bool {
+	m.Mem.Write(address, value)
+	if m.code[address] == byteData {
+		return false
+	}
+	m.code[address] = byteDirty
+	m.anyDirty = true
+	return true
+}
+
+// Ticks calls the ticker n times.
+func (m *Machine) Ticks(n int) {
+	for i := 0; i < n; i++ {
+		m.T()
+	}
+}
+
+// Cross returns base+index, ticking once more if that crosses a page
+// boundary.
+func (m *Machine) Cross(base uint16, index byte) uint16 {
+	addr := base + uint16(index)
+	if (addr^base)&0xFF00 != 0 {
+		m.T()
+	}
+	return addr
+}
+
+// ZPWord reads a little-endian word from the zero page, wrapping
+// around within it.
+func (m *Machine) ZPWord(address byte) uint16 {
+	return uint16(m.Read(uint16(address))) | uint16(m.Read(uint16(address+1)))<<8
+}
+
+// IndirectJump returns the target of JMP (address), including the
+// 6502's bug of not carrying into the high byte.
+func (m *Machine) IndirectJump(address uint16) uint16 {
+	hi := address + 1
+	if address&0xFF == 0xFF {
+		hi = address & 0xFF00
+	}
+	return uint16(m.Read(address)) | uint16(m.Read(hi))<<8
+}
+
+// Push pushes a byte onto the stack.
+func (m *Machine) Push(value byte) {
+	m.Write(0x100+uint16(m.SP), value)
+	m.SP--
+}
+
+// Pull pulls a byte from the stack.
+func (m *Machine) Pull() byte {
+	m.SP++
+	return m.Read(0x100 + uint16(m.SP))
+}
+
+// PullWord pulls a little-endian word from the stack.
+func (m *Machine) PullWord() uint16 {
+	lo := uint16(m.Pull())
+	return lo | uint16(m.Pull())<<8
+}
+
+// NZ sets the N and Z flags from value, and returns it.
+func (m *Machine) NZ(value byte) byte {
+	m.P = (m.P &^ cpu.FLAG_N) | (value & cpu.FLAG_N)
+	if value == 0 {
+		m.P |= cpu.FLAG_Z
+	} else {
+		m.P &^= cpu.FLAG_Z
+	}
+	return value
+}
+
+// Compare sets flags as CMP, CPX, and CPY do.
+func (m *Machine) Compare(register, value byte) {
+	m.P &^= cpu.FLAG_C
+	if register >= value {
+		m.P |= cpu.FLAG_C
+	}
+	m.NZ(register - value)
+}
+
+// BIT performs the BIT instruction.
+func (m *Machine) BIT(value byte) {
+	if m.A&value == 0 {
+		m.P |= cpu.FLAG_Z
+	} else {
+		m.P &^= cpu.FLAG_Z
+	}
+	m.P = (m.P &^ cpu.FLAG_NV) | (value & cpu.FLAG_NV)
+}
+
+// ASL returns value shifted left, setting flags.
+func (m *Machine) ASL(value byte) byte {
+	m.P = (m.P &^ cpu.FLAG_C) | (value >> 7)
+	return m.NZ(value << 1)
+}
+
+// LSR returns value shifted right, setting flags.
+func (m *Machine) LSR(value byte) byte {
+	m.P = (m.P &^ cpu.FLAG_C) | (value & cpu.FLAG_C)
+	return m.NZ(value >> 1)
+}
+
+// ROL returns value rotated left through carry, setting flags.
+func (m *Machine) ROL(value byte) byte {
+	result := value<<1 | (m.P & cpu.FLAG_C)
+	m.P = (m.P &^ cpu.FLAG_C) | (value >> 7)
+	return m.NZ(result)
+}
+
+// ROR returns value rotated right through carry, setting flags.
+func (m *Machine) ROR(value byte) byte {
+	result := (value >> 1) | (m.P << 7)
+	m.P = (m.P &^ cpu.FLAG_C) | (value & cpu.FLAG_C)
+	return m.NZ(result)
+}
+
+// ADC performs add-with-carry.
+func (m *Machine) ADC(value byte) {
+	if m.P&cpu.FLAG_D != 0 {
+		m.adcDecimal(value)
+		return
+	}
+	m.A = m.addBinary(value)
+}
+
+// SBC performs subtract-with-carry.
+func (m *Machine) SBC(value byte) {
+	if m.P&cpu.FLAG_D != 0 {
+		m.sbcDecimal(value)
+		return
+	}
+	m.A = m.addBinary(^value)
+}
+
+// addBinary returns A+value+carry, setting flags.
+func (m *Machine) addBinary(value byte) byte {
+	result16 := uint16(m.A) + uint16(value) + uint16(m.P&cpu.FLAG_C)
+	result := byte(result16)
+	m.P &^= (cpu.FLAG_C | cpu.FLAG_V)
+	m.P |= uint8(result16 >> 8)
+	if (m.A^result)&(value^result)&0x80 != 0 {
+		m.P |= cpu.FLAG_V
+	}
+	return m.NZ(result)
+}
+
+// adcDecimal performs 6502 decimal-mode add-with-carry. See
+// http://www.6502.org/tutorials/decimal_mode.html#A
+func (m *Machine) adcDecimal(value byte) {
+	bin := m.A + value + (m.P & cpu.FLAG_C)
+	al := (m.A & 0x0F) + (value & 0x0F) + (m.P & cpu.FLAG_C)
+	if al >= 0x0A {
+		al = ((al + 0x06) & 0x0F) + 0x10
+	}
+	a := uint16(m.A&0xF0) + uint16(value&0xF0) + uint16(al)
+	if a >= 0xA0 {
+		a += 0x60
+	}
+	aNV := int16(int8(m.A&0xF0)) + int16(int8(value&0xF0)) + int16(int8(al))
+	m.P &^= (cpu.FLAG_V | cpu.FLAG_N | cpu.FLAG_Z | cpu.FLAG_C)
+	if byte(aNV&0xFF)&cpu.FLAG_N != 0 {
+		m.P |= cpu.FLAG_N
+	}
+	if aNV < -128 || aNV > 127 {
+		m.P |= cpu.FLAG_V
+	}
+	if a >= 0x100 {
+		m.P |= cpu.FLAG_C
+	}
+	if bin == 0 {
+		m.P |= cpu.FLAG_Z
+	}
+	m.A = byte(a)
+}
+
+// sbcDecimal performs 6502 decimal-mode subtract-with-carry: flags
+// come from the binary subtraction.
+func (m *Machine) sbcDecimal(value byte) {
+	carry := m.P & cpu.FLAG_C
+	m.addBinary(^value)
+	al := int16(m.A&0x0F) - int16(value&0x0F) + int16(carry) - 1
+	if al < 0 {
+		al = ((al - 0x06) & 0x0F) - 0x10
+	}
+	a := int16(m.A&0xF0) - int16(value&0xF0) + al
+	if a < 0 {
+		a = a - 0x60
+	}
+	m.A = byte(a)
+}
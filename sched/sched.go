@@ -0,0 +1,167 @@
+/*
+Package sched provides a cycle-based event scheduler for devices.
+
+A Scheduler's Tick method is a cpu.Ticker. Devices ask it to call them
+back after some number of cycles, rather than counting ticks
+themselves; the scheduler keeps pending events in a priority queue, so
+each tick only compares the cycle count with the time of the next
+event.
+*/
+package sched
+
+import (
+	"container/heap"
+	"math"
+)
+
+// never is the time of the next event when there isn't one.
+const never = math.MaxUint64
+
+// Event is a scheduled callback.
+type Event struct {
+	at    uint64
+	seq   uint64 // breaks ties, so events at the same time fire in order
+	index int    // position in the queue, or -1 if not queued
+	f     func()
+	s     *Scheduler
+}
+
+// At returns the cycle the event is due to fire at.
+func (e *Event) At() uint64 {
+	return e.at
+}
+
+// Pending returns true if the event hasn't fired or been cancelled.
+func (e *Event) Pending() bool {
+	return e.index >= 0
+}
+
+// Cancel removes the event, if it is still pending.
+func (e *Event) Cancel() {
+	if e.index < 0 {
+		return
+	}
+	heap.Remove(&e.s.queue, e.index)
+	e.s.update()
+}
+
+// Reschedule moves the event to fire delay cycles from now, whether
+// or not it is still pending.
+func (e *Event) Reschedule(delay uint64) {
+	s := e.s
+	e.at = s.now + delay
+	e.seq = s.seq
+	s.seq++
+	if e.index >= 0 {
+		heap.Fix(&s.queue, e.index)
+	} else {
+		heap.Push(&s.queue, e)
+	}
+	s.update()
+}
+
+// queue is a heap of events, soonest first.
+type queue []*Event
+
+func (q queue) Len() int { return len(q) }
+
+func (q queue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q queue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *queue) Push(x interface{}) {
+	e := x.(*Event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *queue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Scheduler counts cycles and fires events when they are due.
+type Scheduler struct {
+	now   uint64
+	next  uint64 // time of the soonest event
+	seq   uint64
+	queue queue
+}
+
+// New returns a new Scheduler, at cycle zero.
+func New() *Scheduler {
+	return &Scheduler{next: never}
+}
+
+// Now returns the number of cycles ticked so far.
+func (s *Scheduler) Now() uint64 {
+	return s.now
+}
+
+// update recomputes the time of the next event.
+func (s *Scheduler) update() {
+	if len(s.queue) == 0 {
+		s.next = never
+		return
+	}
+	s.next = s.queue[0].at
+}
+
+// Schedule arranges for f to be called delay cycles from now. A delay
+// of zero fires on the next tick, or straight away if called from
+// inside another event's callback.
+func (s *Scheduler) Schedule(delay uint64, f func()) *Event {
+	e := &Event{f: f, s: s, index: -1}
+	e.Reschedule(delay)
+	return e
+}
+
+// Every arranges for f to be called every period cycles, starting
+// period cycles from now. Cancel the returned event to stop it. A
+// period of zero would fire forever within one tick, so it panics.
+func (s *Scheduler) Every(period uint64, f func()) *Event {
+	if period == 0 {
+		panic("sched: Every called with a period of zero")
+	}
+	var e *Event
+	e = s.Schedule(period, func() {
+		e.Reschedule(period)
+		f()
+	})
+	return e
+}
+
+// Tick advances by one cycle, firing any events that are due. It
+// satisfies cpu.Ticker.
+func (s *Scheduler) Tick() {
+	s.now++
+	if s.now < s.next {
+		return
+	}
+	for len(s.queue) > 0 && s.queue[0].at <= s.now {
+		e := heap.Pop(&s.queue).(*Event)
+		s.update()
+		e.f()
+	}
+	s.update()
+}
+
+// Ticks advances by n cycles, firing events as they come due.
+func (s *Scheduler) Ticks(n uint64) {
+	for ; n > 0; n-- {
+		s.Tick()
+	}
+}
@@ -0,0 +1,79 @@
+package sched
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zellyn/go6502/cpu"
+)
+
+var _ cpu.Ticker = New().Tick
+
+func TestOrder(t *testing.T) {
+	s := New()
+	var got []string
+	log := func(name string) func() {
+		return func() { got = append(got, name) }
+	}
+	s.Schedule(5, log("b"))
+	s.Schedule(3, log("a"))
+	s.Schedule(5, log("c"))
+	cancelled := s.Schedule(4, log("cancelled"))
+	s.Schedule(0, log("now"))
+	cancelled.Cancel()
+	if cancelled.Pending() {
+		t.Error("cancelled event still pending")
+	}
+
+	s.Ticks(4)
+	if want := []string{"now", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after 4 cycles: want %v; got %v", want, got)
+	}
+	s.Tick()
+	if want := []string{"now", "a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after 5 cycles: want %v; got %v", want, got)
+	}
+}
+
+func TestEvery(t *testing.T) {
+	s := New()
+	var fired []uint64
+	e := s.Every(10, func() { fired = append(fired, s.Now()) })
+	s.Ticks(35)
+	e.Cancel()
+	s.Ticks(100)
+	if want := []uint64{10, 20, 30}; !reflect.DeepEqual(fired, want) {
+		t.Errorf("want %v; got %v", want, fired)
+	}
+}
+
+func TestEveryZero(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("want Every(0) to panic")
+		}
+	}()
+	New().Every(0, func() {})
+}
+
+func TestReschedule(t *testing.T) {
+	s := New()
+	fired := uint64(0)
+	e := s.Schedule(10, func() { fired = s.Now() })
+	s.Ticks(5)
+	e.Reschedule(10)
+	s.Ticks(20)
+	if fired != 15 {
+		t.Errorf("want rescheduled event at 15; got %d", fired)
+	}
+	// Events can schedule more events, even for the same cycle.
+	var chain []uint64
+	s.Schedule(1, func() {
+		chain = append(chain, s.Now())
+		s.Schedule(0, func() { chain = append(chain, s.Now()) })
+	})
+	s.Ticks(3)
+	if want := []uint64{26, 26}; !reflect.DeepEqual(chain, want) {
+		t.Errorf("want %v; got %v", want, chain)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zellyn/go6502/cpu"
+	"github.com/zellyn/go6502/superopt"
+)
+
+var ref = flag.String("ref", "", "reference code, in hex")
+var outRegs = flag.String("out", "A", "registers whose output matters: any of A,X,Y")
+var inFlags = flag.String("inflags", "", "flags whose input matters: any of N,V,D,I,Z,C")
+var outFlags = flag.String("outflags", "", "flags whose output matters: any of N,V,D,I,Z,C")
+var zp = flag.String("zp", "", "zero-page locations used as inputs and outputs, in hex")
+var scratch = flag.String("scratch", "", "zero-page locations usable as temporaries, in hex")
+var consts = flag.String("consts", "", "immediate operands to try, in hex (default 00017F80FF)")
+var maxLen = flag.Int("max", 3, "maximum number of instructions")
+var goal = flag.String("goal", "size", "what to minimize: size/speed")
+var vectors = flag.Int("vectors", 1000, "number of random test vectors")
+
+var flagBits = map[rune]byte{
+	'N': cpu.FLAG_N,
+	'V': cpu.FLAG_V,
+	'D': cpu.FLAG_D,
+	'I': cpu.FLAG_I,
+	'Z': cpu.FLAG_Z,
+	'C': cpu.FLAG_C,
+}
+
+var regBits = map[rune]superopt.Regs{
+	'A': superopt.RegA,
+	'X': superopt.RegX,
+	'Y': superopt.RegY,
+}
+
+func die(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func parseFlags(s string) byte {
+	var b byte
+	for _, r := range strings.ToUpper(s) {
+		bit, ok := flagBits[r]
+		if !ok {
+			die("unknown flag: %q", r)
+		}
+		b |= bit
+	}
+	return b
+}
+
+func parseHex(name, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		die("bad hex for -%s: %v", name, err)
+	}
+	return b
+}
+
+func main() {
+	flag.Parse()
+	if *ref == "" {
+		die("no reference code specified")
+	}
+
+	spec := superopt.Spec{
+		InFlags:  parseFlags(*inFlags),
+		OutFlags: parseFlags(*outFlags),
+		ZP:       parseHex("zp", *zp),
+	}
+	for _, r := range strings.ToUpper(*outRegs) {
+		bit, ok := regBits[r]
+		if !ok {
+			die("unknown register: %q", r)
+		}
+		spec.Out |= bit
+	}
+	spec.Ref = superopt.RefCode(parseHex("ref", *ref), spec.ZP)
+
+	opts := superopt.Options{
+		MaxLen:  *maxLen,
+		Scratch: parseHex("scratch", *scratch),
+		Vectors: *vectors,
+	}
+	if *consts != "" {
+		opts.Consts = parseHex("consts", *consts)
+	}
+	switch *goal {
+	case "size":
+		opts.Goal = superopt.GoalSize
+	case "speed":
+		opts.Goal = superopt.GoalSpeed
+	default:
+		die("goal must be size or speed; got %q", *goal)
+	}
+
+	r, err := superopt.Search(spec, opts)
+	if err != nil {
+		die("%v", err)
+	}
+	fmt.Println(r.Listing())
+	fmt.Printf("; %d bytes, %d cycles\n", r.Bytes, r.Cycles)
+}
@@ -0,0 +1,420 @@
+/*
+Package superopt searches for the shortest or fastest sequence of 6502
+instructions that behaves the same as a specification: either a
+reference snippet of 6502 code, or a Go function over the registers,
+flags, and some zero-page locations.
+
+Candidates are built from the opcodes.Opcodes table, and checked by
+running them on many cpu instances concurrently, against edge-case and
+random inputs. Passing those tests is strong evidence, but not a
+proof, of equivalence.
+*/
+package superopt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/asm/opcodes"
+	"github.com/zellyn/go6502/cpu"
+)
+
+// Regs is a set of registers.
+type Regs uint
+
+const (
+	RegA Regs = 1 << iota
+	RegX
+	RegY
+)
+
+// Goal says what we are minimizing.
+type Goal int
+
+const (
+	GoalSize  Goal = iota // Fewest bytes
+	GoalSpeed             // Fewest cycles
+)
+
+// Where candidate code is placed in memory.
+const codeAddr = 0x0400
+
+// Flags that are meaningful as inputs and outputs.
+const allFlags = cpu.FLAG_N | cpu.FLAG_V | cpu.FLAG_D | cpu.FLAG_I | cpu.FLAG_Z | cpu.FLAG_C
+
+// State is the machine state a specification talks about.
+type State struct {
+	A, X, Y, P byte
+	ZP         []byte // values of Spec.ZP locations, in order
+}
+
+func (s State) clone() State {
+	s.ZP = append([]byte(nil), s.ZP...)
+	return s
+}
+
+// Spec describes what a sequence of instructions must do.
+type Spec struct {
+	Out      Regs           // registers whose output values must match
+	InFlags  byte           // flags whose input values matter; D is clear otherwise
+	OutFlags byte           // flags whose output values must match
+	ZP       []byte         // zero-page locations that are inputs and outputs
+	Ref      func(s *State) // reference implementation
+}
+
+// RefCode returns a Spec.Ref function that runs a reference snippet
+// of 6502 code. The snippet must not branch out of itself.
+func RefCode(code []byte, zp []byte) func(s *State) {
+	return func(s *State) {
+		w := newWorker(zp, nil)
+		w.load(code)
+		w.run(*s, len(code)*8)
+		*s = w.state()
+	}
+}
+
+// Options control a search.
+type Options struct {
+	Goal    Goal
+	MaxLen  int    // maximum number of instructions to try
+	Consts  []byte // immediate operands to try
+	Scratch []byte // zero-page locations candidates may use as temporaries
+	Vectors int    // number of random test vectors, in addition to edge cases
+	Workers int    // number of concurrent workers; 0 means one per CPU
+	Seed    int64  // random seed for test vectors
+}
+
+// DefaultConsts are the immediate operands tried if none are given.
+var DefaultConsts = []byte{0x00, 0x01, 0x7F, 0x80, 0xFF}
+
+// Result is the best sequence found.
+type Result struct {
+	Code   []byte
+	Bytes  int
+	Cycles int
+}
+
+// Listing returns a disassembly of the result.
+func (r Result) Listing() string {
+	var ls []string
+	for i := 0; i < len(r.Code); {
+		b := [3]byte{}
+		copy(b[:], r.Code[i:])
+		_, text, length := asm.Disasm(uint16(codeAddr+i), b[0], b[1], b[2], nil, 0)
+		ls = append(ls, strings.TrimSpace(text))
+		i += length
+	}
+	return strings.Join(ls, "\n")
+}
+
+// ErrNotFound is returned when no sequence up to Options.MaxLen
+// matches the specification.
+var ErrNotFound = errors.New("no equivalent sequence found")
+
+// testVector is an input state, and the expected output.
+type testVector struct {
+	in, out State
+}
+
+// Search finds the best sequence matching the specification.
+func Search(spec Spec, opts Options) (Result, error) {
+	if spec.Ref == nil {
+		return Result{}, errors.New("spec has no reference implementation")
+	}
+	if opts.MaxLen <= 0 {
+		return Result{}, fmt.Errorf("MaxLen must be positive; got %d", opts.MaxLen)
+	}
+	if opts.Consts == nil {
+		opts.Consts = DefaultConsts
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	vectors := makeVectors(spec, opts)
+	alphabet := buildAlphabet(spec, opts)
+
+	var best *Result
+	for n := 1; n <= opts.MaxLen; n++ {
+		if best != nil && lowerBound(opts.Goal, n) >= best.primary(opts.Goal) {
+			break
+		}
+		if r := searchLen(spec, opts, vectors, alphabet, n, best); r != nil {
+			best = r
+		}
+	}
+	if best == nil {
+		return Result{}, ErrNotFound
+	}
+	return *best, nil
+}
+
+// lowerBound is the smallest possible cost of an n-instruction sequence.
+func lowerBound(g Goal, n int) int {
+	if g == GoalSpeed {
+		return 2 * n
+	}
+	return n
+}
+
+// primary returns the cost being minimized.
+func (r Result) primary(g Goal) int {
+	if g == GoalSpeed {
+		return r.Cycles
+	}
+	return r.Bytes
+}
+
+// cost returns the cost being minimized, with ties broken by the
+// other cost.
+func cost(g Goal, r Result) int {
+	if g == GoalSpeed {
+		return r.Cycles*16 + r.Bytes // break ties on size
+	}
+	return r.Bytes*256 + r.Cycles // break ties on speed
+}
+
+// better returns true if r beats other. Equal costs are broken by
+// comparing the code, so results don't depend on worker scheduling.
+func (r Result) better(g Goal, other Result) bool {
+	if c, o := cost(g, r), cost(g, other); c != o {
+		return c < o
+	}
+	return bytes.Compare(r.Code, other.Code) < 0
+}
+
+// searchLen tries every sequence of exactly n instructions, returning
+// the best one that beats best, or nil.
+func searchLen(spec Spec, opts Options, vectors []testVector, alphabet [][]byte, n int, best *Result) *Result {
+	candidates := make(chan []byte, 1024)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := newWorker(spec.ZP, opts.Scratch)
+			for code := range candidates {
+				cycles, ok := w.check(spec, vectors, code, n)
+				if !ok {
+					continue
+				}
+				r := Result{Code: code, Bytes: len(code), Cycles: cycles}
+				mu.Lock()
+				if best == nil || r.better(opts.Goal, *best) {
+					best = &r
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	before := best
+	seq := make([]int, n)
+	for {
+		var code []byte
+		for _, i := range seq {
+			code = append(code, alphabet[i]...)
+		}
+		candidates <- code
+		// Next sequence: count in base len(alphabet).
+		i := n - 1
+		for ; i >= 0; i-- {
+			seq[i]++
+			if seq[i] < len(alphabet) {
+				break
+			}
+			seq[i] = 0
+		}
+		if i < 0 {
+			break
+		}
+	}
+	close(candidates)
+	wg.Wait()
+	if best == before {
+		return nil
+	}
+	return best
+}
+
+// skipped lists instructions never worth trying: control flow, stack
+// manipulation, and things that only affect interrupts or decimal mode.
+var skipped = map[string]bool{
+	"BRK": true, "JMP": true, "JSR": true, "RTS": true, "RTI": true,
+	"PHA": true, "PHP": true, "PLA": true, "PLP": true, "TSX": true, "TXS": true,
+	"CLI": true, "SEI": true, "SED": true, "NOP": true,
+}
+
+// buildAlphabet returns every single instruction (opcode plus
+// operand) a candidate can be built from.
+func buildAlphabet(spec Spec, opts Options) [][]byte {
+	zps := append(append([]byte(nil), spec.ZP...), opts.Scratch...)
+	var alphabet [][]byte
+	for b := 0; b < 256; b++ {
+		op, ok := opcodes.Opcodes[byte(b)]
+		if !ok || skipped[op.Name] {
+			continue
+		}
+		switch op.Mode {
+		case opcodes.MODE_IMPLIED, opcodes.MODE_A:
+			alphabet = append(alphabet, []byte{byte(b)})
+		case opcodes.MODE_IMMEDIATE:
+			for _, c := range opts.Consts {
+				alphabet = append(alphabet, []byte{byte(b), c})
+			}
+		case opcodes.MODE_ZP:
+			for _, z := range zps {
+				alphabet = append(alphabet, []byte{byte(b), z})
+			}
+		}
+	}
+	return alphabet
+}
+
+// makeVectors builds edge-case and random inputs, and runs the
+// reference implementation on each to get the expected outputs.
+func makeVectors(spec Spec, opts Options) []testVector {
+	rng := rand.New(rand.NewSource(opts.Seed))
+	edges := []byte{0x00, 0x01, 0x7F, 0x80, 0xFE, 0xFF}
+	var ins []State
+	random := func() State {
+		s := State{A: byte(rng.Int()), X: byte(rng.Int()), Y: byte(rng.Int()), P: byte(rng.Int())}
+		for range spec.ZP {
+			s.ZP = append(s.ZP, byte(rng.Int()))
+		}
+		// Decimal mode changes ADC and SBC, so it's off unless it's an input.
+		if spec.InFlags&cpu.FLAG_D == 0 {
+			s.P &^= cpu.FLAG_D
+		}
+		return s
+	}
+	for _, e := range edges {
+		for _, c := range []byte{0, cpu.FLAG_C} {
+			s := random()
+			s.A, s.X, s.Y = e, e, e
+			s.P = s.P&^cpu.FLAG_C | c
+			for i := range s.ZP {
+				s.ZP[i] = e
+			}
+			ins = append(ins, s)
+		}
+	}
+	for i := 0; i < opts.Vectors; i++ {
+		ins = append(ins, random())
+	}
+	vectors := make([]testVector, len(ins))
+	for i, in := range ins {
+		out := in.clone()
+		spec.Ref(&out)
+		vectors[i] = testVector{in: in, out: out}
+	}
+	return vectors
+}
+
+// k64 is 64K of plain memory. Satisfies the cpu.Memory interface.
+type k64 [65536]byte
+
+func (m *k64) Read(address uint16) byte {
+	return m[address]
+}
+func (m *k64) Write(address uint16, value byte) {
+	m[address] = value
+}
+
+// worker owns a cpu instance, and checks candidates on it.
+type worker struct {
+	m       k64
+	c       cpu.Cpu
+	cycles  int
+	codeLen int
+	zp      []byte
+	scratch []byte
+}
+
+func newWorker(zp, scratch []byte) *worker {
+	w := &worker{zp: zp, scratch: scratch}
+	w.c = cpu.NewCPU(&w.m, func() { w.cycles++ }, cpu.VERSION_6502)
+	return w
+}
+
+func (w *worker) load(code []byte) {
+	copy(w.m[codeAddr:], code)
+	w.codeLen = len(code)
+}
+
+// run sets up the input state and runs until execution leaves the
+// loaded code (or maxSteps steps have run).
+func (w *worker) run(s State, maxSteps int) {
+	for i, z := range w.zp {
+		w.m[z] = s.ZP[i]
+	}
+	for _, z := range w.scratch {
+		w.m[z] = 0
+	}
+	w.c.SetA(s.A)
+	w.c.SetX(s.X)
+	w.c.SetY(s.Y)
+	w.c.SetP(s.P)
+	w.c.SetSP(0xFF)
+	w.c.SetPC(codeAddr)
+	w.cycles = 0
+	for i := 0; i < maxSteps; i++ {
+		if pc := int(w.c.PC()); pc < codeAddr || pc >= codeAddr+w.codeLen {
+			return
+		}
+		if w.c.Step() != nil {
+			return
+		}
+	}
+}
+
+func (w *worker) state() State {
+	s := State{A: w.c.A(), X: w.c.X(), Y: w.c.Y(), P: w.c.P()}
+	for _, z := range w.zp {
+		s.ZP = append(s.ZP, w.m[z])
+	}
+	return s
+}
+
+// check runs a candidate against every test vector, returning its
+// cycle count and whether it matched them all.
+func (w *worker) check(spec Spec, vectors []testVector, code []byte, n int) (int, bool) {
+	w.load(code)
+	cycles := 0
+	for _, v := range vectors {
+		w.run(v.in, n)
+		got := w.state()
+		if !matches(spec, got, v.out) {
+			return 0, false
+		}
+		cycles = w.cycles
+	}
+	return cycles, true
+}
+
+func matches(spec Spec, got, want State) bool {
+	if spec.Out&RegA != 0 && got.A != want.A {
+		return false
+	}
+	if spec.Out&RegX != 0 && got.X != want.X {
+		return false
+	}
+	if spec.Out&RegY != 0 && got.Y != want.Y {
+		return false
+	}
+	if (got.P^want.P)&spec.OutFlags&allFlags != 0 {
+		return false
+	}
+	for i := range got.ZP {
+		if got.ZP[i] != want.ZP[i] {
+			return false
+		}
+	}
+	return true
+}
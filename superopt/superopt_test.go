@@ -0,0 +1,63 @@
+package superopt
+
+import (
+	"testing"
+
+	"github.com/zellyn/go6502/cpu"
+)
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+		goal Goal
+		want string
+	}{
+		{
+			name: "double A, from Go",
+			spec: Spec{Out: RegA, Ref: func(s *State) { s.A <<= 1 }},
+			want: "ASL",
+		},
+		{
+			name: "double A, from code",
+			spec: Spec{
+				Out:      RegA,
+				OutFlags: cpu.FLAG_N | cpu.FLAG_Z,
+				// STA $F0; CLC; ADC $F0
+				Ref: RefCode([]byte{0x85, 0xF0, 0x18, 0x65, 0xF0}, nil),
+			},
+			want: "ASL",
+		},
+		{
+			name: "copy A to X and Y",
+			spec: Spec{
+				Out: RegA | RegX | RegY,
+				Ref: func(s *State) { s.X, s.Y = s.A, s.A },
+			},
+			want: "TAY\nTAX",
+		},
+		{
+			name: "clear A",
+			spec: Spec{Out: RegA, Ref: func(s *State) { s.A = 0 }},
+			want: "AND #$00",
+		},
+	}
+
+	for _, tt := range tests {
+		r, err := Search(tt.spec, Options{Goal: tt.goal, MaxLen: 2, Vectors: 50})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := r.Listing(); got != tt.want {
+			t.Errorf("%s: want:\n%s\ngot:\n%s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	spec := Spec{Out: RegA, Ref: func(s *State) { s.A = s.A*3 + s.X*5 }}
+	if _, err := Search(spec, Options{MaxLen: 1}); err != ErrNotFound {
+		t.Errorf("want ErrNotFound; got %v", err)
+	}
+}
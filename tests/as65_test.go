@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/asm/flavors/as65"
+	"github.com/zellyn/go6502/asm/lines"
+	"github.com/zellyn/go6502/asm/opcodes"
+)
+
+// Assemble the as65 sources of the test binaries, and check we get
+// the same bytes as as65 itself did. Klaus Dormann's 65C02 extended
+// opcodes and interrupt tests belong here too, once their sources and
+// binaries are checked in.
+func TestAs65Sources(t *testing.T) {
+	tests := []struct {
+		name string // source and binary, without extension
+		addr uint32 // address of the first byte
+	}{
+		{"6502_functional_test", 0x000a},
+		{"decimal_mode", 0x1000},
+	}
+
+	for _, tt := range tests {
+		want, err := ioutil.ReadFile(tt.name + ".bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := asm.NewAssembler(as65.New(opcodes.SetUnknown), lines.OsOpener{})
+		if err := a.Load(tt.name+".a65", 0); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if err := a.Pass2(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		m, err := a.Membuf()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		// as65 fills gaps with $ff.
+		p := m.Piece(0xff)
+		if p.Addr != tt.addr {
+			t.Errorf("%s: output starts at $%04x; want $%04x", tt.name, p.Addr, tt.addr)
+			continue
+		}
+		if !bytes.Equal(p.Data, want) {
+			for i := range want {
+				if i >= len(p.Data) || p.Data[i] != want[i] {
+					t.Errorf("%s: output differs from %s.bin, starting at $%04x", tt.name, tt.name, int(tt.addr)+i)
+					break
+				}
+			}
+			if len(p.Data) > len(want) {
+				t.Errorf("%s: got %d bytes; want %d", tt.name, len(p.Data), len(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zellyn/go6502/asm"
+	"github.com/zellyn/go6502/asm/asmtest"
+	"github.com/zellyn/go6502/cpu"
+)
+
+// loadAndRun loads the assembler's output into memory and runs from
+// $0800 until Step returns an error.
+func loadAndRun(t *testing.T, a *asm.Assembler) (cpu.Cpu, error) {
+	var m K64
+	var cc CycleCount
+	copy(m[:], asmtest.Image(t, a, 0, len(m)))
+	c := cpu.NewCPU(&m, cc.Tick, cpu.VERSION_6502)
+	c.Reset()
+	c.SetPC(0x0800)
+	for i := 0; i < 1000; i++ {
+		if err := c.Step(); err != nil {
+			return c, err
+		}
+	}
+	t.Fatal("Program didn't hit a bad opcode")
+	return nil, nil
+}
+
+func TestBacktrace(t *testing.T) {
+	a := asmtest.Assemble(t, `
+START   LDX #$FF
+        TXS
+        JSR FIRST
+        .HS 02
+FIRST   JSR DISP
+        RTS
+DISP    LDA /SECOND-1
+        PHA
+        LDA #SECOND-1
+        PHA
+        RTS
+SECOND  JSR THIRD
+        RTS
+THIRD   NOP
+        .HS 02
+`)
+	c, err := loadAndRun(t, a)
+	oe, ok := err.(cpu.OpcodeError)
+	if !ok {
+		t.Fatalf("want OpcodeError; got %v", err)
+	}
+	syms := asm.Symbols{0x0800: "START", 0x0807: "FIRST", 0x080B: "DISP", 0x0812: "SECOND", 0x0816: "THIRD"}
+	want := []struct {
+		kind   cpu.FrameKind
+		caller string
+	}{
+		{cpu.FRAME_JSR, "SECOND"},
+		{cpu.FRAME_JSR, "FIRST"},
+		{cpu.FRAME_JSR, "START+3"},
+	}
+	bt := c.Backtrace()
+	if len(oe.Backtrace) != len(bt) {
+		t.Errorf("error backtrace has %d frames; Backtrace() has %d", len(oe.Backtrace), len(bt))
+	}
+	if len(bt) != len(want) {
+		t.Fatalf("want %d frames; got %d:\n%s", len(want), len(bt), cpu.FormatBacktrace(c.PC(), bt, nil))
+	}
+	for i, w := range want {
+		if bt[i].Kind != w.kind {
+			t.Errorf("frame %d: want kind %s; got %s", i, w.kind, bt[i].Kind)
+		}
+		if got := syms.Name(int(bt[i].Caller), 3); got != w.caller {
+			t.Errorf("frame %d: want caller %s; got %s", i, w.caller, got)
+		}
+	}
+	if oe.PC != 0x0817 {
+		t.Errorf("want error at $0817; got $%04X", oe.PC)
+	}
+
+	s := cpu.FormatBacktrace(oe.PC, oe.Backtrace, cpu.SourceResolver(a))
+	if !strings.Contains(s, "SECOND  JSR THIRD") {
+		t.Errorf("want source lines in backtrace; got:\n%s", s)
+	}
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/zellyn/go6502/asm/asmtest"
+	"github.com/zellyn/go6502/cpu"
+)
+
+func TestInterrupts(t *testing.T) {
+	a := asmtest.Assemble(t, `
+START   LDX #$FF
+        TXS
+        CLI
+LOOP    INY
+        JMP LOOP
+IRQ     INC $10
+        RTI
+NMI     INC $11
+        RTI
+`)
+	var m K64
+	var cc CycleCount
+	copy(m[:], asmtest.Image(t, a, 0, len(m)))
+	m[0xFFFE], m[0xFFFF] = 0x08, 0x08 // IRQ
+	m[0xFFFA], m[0xFFFB] = 0x0B, 0x08 // NMI
+	c := cpu.NewCPU(&m, cc.Tick, cpu.VERSION_6502)
+	c.Reset()
+	c.SetPC(0x0800)
+	step := func() {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Masked until CLI.
+	c.SetIRQ(true)
+	step()
+	step()
+	if c.PC() != 0x0803 {
+		t.Fatalf("want IRQ masked before CLI; PC=$%04X", c.PC())
+	}
+	step()
+	before := cc
+	step()
+	if c.PC() != 0x0808 {
+		t.Fatalf("want IRQ taken after CLI; PC=$%04X", c.PC())
+	}
+	if cc-before != 7 {
+		t.Errorf("want IRQ to take 7 cycles; got %d", cc-before)
+	}
+	if p := m[0x100+uint16(c.SP())+1]; p&cpu.FLAG_B != 0 || p&cpu.FLAG_I != 0 {
+		t.Errorf("want pushed P without B and I flags; got $%02X", p)
+	}
+	if bt := c.Backtrace(); len(bt) != 1 || bt[0].Kind != cpu.FRAME_IRQ || bt[0].Caller != 0x0804 {
+		t.Errorf("want one IRQ frame from $0804; got %v", bt)
+	}
+	step() // INC $10
+	c.SetIRQ(false)
+	step() // RTI
+	if c.PC() != 0x0804 {
+		t.Errorf("want RTI back to $0804; got $%04X", c.PC())
+	}
+
+	// NMI is edge-triggered, and ignores the I flag.
+	c.SetP(c.P() | cpu.FLAG_I)
+	c.SetNMI(true)
+	for i := 0; i < 20; i++ {
+		step()
+	}
+	if m[0x10] != 1 || m[0x11] != 1 {
+		t.Errorf("want one IRQ and one NMI; got %d and %d", m[0x10], m[0x11])
+	}
+}
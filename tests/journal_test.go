@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/zellyn/go6502/asm/asmtest"
+	"github.com/zellyn/go6502/cpu"
+)
+
+func TestJournal(t *testing.T) {
+	a := asmtest.Assemble(t, `
+START   LDA #$34
+        STA $70
+        LDA #$12
+        STA $71
+        LDX #0
+LOOP    INC $70
+        INX
+        CPX #3
+        BNE LOOP
+        STA $72
+        .HS 02
+`)
+	var m K64
+	var cc CycleCount
+	copy(m[:], asmtest.Image(t, a, 0, len(m)))
+	c := cpu.NewCPU(&m, cc.Tick, cpu.VERSION_6502)
+	c.Reset()
+	c.SetPC(0x0800)
+	c.Journal(100)
+	steps := 0
+	for c.Step() == nil {
+		steps++
+	}
+	if m[0x70] != 0x37 || m[0x72] != 0x12 {
+		t.Fatalf("want $70=$37, $72=$12; got $%02X, $%02X", m[0x70], m[0x72])
+	}
+
+	// Who last wrote $70? The INC in the loop, three instructions
+	// before the loop exited.
+	w, ok := c.LastWrite(0x70)
+	if !ok {
+		t.Fatal("LastWrite($70) found nothing")
+	}
+	if w.PC != 0x080A || w.Old != 0x36 || w.New != 0x37 {
+		t.Errorf("LastWrite($70) = %+v; want PC=$080A, Old=$36, New=$37", w)
+	}
+
+	// Step back over STA $72.
+	if err := c.StepBack(); err != nil {
+		t.Fatal(err)
+	}
+	if c.PC() != 0x0811 || m[0x72] != 0 {
+		t.Errorf("after StepBack: PC=$%04X $72=$%02X; want $0811, $00", c.PC(), m[0x72])
+	}
+
+	// Reverse-continue to the top of the loop: X should be 2.
+	if err := c.ReverseContinue(func(pc uint16) bool { return pc == 0x080A }); err != nil {
+		t.Fatal(err)
+	}
+	if c.X() != 2 || m[0x70] != 0x36 {
+		t.Errorf("after ReverseContinue: X=%d $70=$%02X; want 2, $36", c.X(), m[0x70])
+	}
+
+	// Run all the way back to the start.
+	err := c.ReverseContinue(func(uint16) bool { return false })
+	if err != cpu.ErrJournalEmpty {
+		t.Errorf("want ErrJournalEmpty; got %v", err)
+	}
+	if c.PC() != 0x0800 || m[0x70] != 0 || m[0x71] != 0 {
+		t.Errorf("after rewinding: PC=$%04X $70=$%02X $71=$%02X; want $0800, $00, $00", c.PC(), m[0x70], m[0x71])
+	}
+
+	// And forwards again.
+	for c.Step() == nil {
+		steps--
+	}
+	if steps != 0 || m[0x70] != 0x37 {
+		t.Errorf("replay: steps off by %d, $70=$%02X", steps, m[0x70])
+	}
+}
+
+func TestJournalNMI(t *testing.T) {
+	a := asmtest.Assemble(t, `
+START   INY
+        INY
+        .HS 02
+NMI     INC $11
+        RTI
+`)
+	var m K64
+	var cc CycleCount
+	copy(m[:], asmtest.Image(t, a, 0, len(m)))
+	m[0xFFFA], m[0xFFFB] = 0x03, 0x08 // NMI
+	c := cpu.NewCPU(&m, cc.Tick, cpu.VERSION_6502)
+	c.Reset()
+	c.SetPC(0x0800)
+	c.Journal(100)
+	step := func() {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	step() // INY
+	c.SetNMI(true)
+	step() // NMI
+	if c.PC() != 0x0803 {
+		t.Fatalf("want NMI taken; PC=$%04X", c.PC())
+	}
+
+	// Stepping back over the interrupt leaves it pending again.
+	if err := c.StepBack(); err != nil {
+		t.Fatal(err)
+	}
+	if c.PC() != 0x0801 {
+		t.Fatalf("after StepBack: PC=$%04X; want $0801", c.PC())
+	}
+	step()
+	if c.PC() != 0x0803 {
+		t.Errorf("want NMI taken again after StepBack; PC=$%04X", c.PC())
+	}
+	step() // INC $11
+	step() // RTI
+	step() // INY
+	if m[0x11] != 1 || c.Y() != 2 {
+		t.Errorf("want one NMI and two INYs; got $11=%d Y=%d", m[0x11], c.Y())
+	}
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zellyn/go6502/cpu"
+)
+
+// strobeMemory is a K64 with a keyboard-strobe-like soft switch at
+// $C010: reading it clears the high bit of $C000.
+type strobeMemory struct {
+	K64
+	reads int
+}
+
+func (m *strobeMemory) Read(address uint16) byte {
+	m.reads++
+	if address == 0xC010 {
+		m.K64[0xC000] &= 0x7F
+	}
+	return m.K64[address]
+}
+
+func (m *strobeMemory) Peek(address uint16) byte {
+	return m.K64[address]
+}
+
+func TestPeek(t *testing.T) {
+	var m strobeMemory
+	var cc CycleCount
+	m.K64[0xC000] = 0xC1
+	copy(m.K64[0x0800:], []byte{0xAD, 0x10, 0xC0}) // LDA $C010
+
+	if got := cpu.Peek(&m, 0xC010); got != 0 || m.K64[0xC000] != 0xC1 {
+		t.Errorf("Peek disturbed memory")
+	}
+	_, text, _ := cpu.Disasm(&m, 0x0800, nil, 0)
+	if text != "LDA $C010" {
+		t.Errorf(`want "LDA $C010"; got %q`, text)
+	}
+	var b bytes.Buffer
+	if err := cpu.Dump(&b, &m, 0xC000, 0x11); err != nil {
+		t.Fatal(err)
+	}
+	want := "$C000: C1 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00\n$C010: 00\n"
+	if b.String() != want {
+		t.Errorf("Dump: want\n%s; got\n%s", want, b.String())
+	}
+	if m.reads != 0 || m.K64[0xC000] != 0xC1 {
+		t.Fatalf("want no reads; got %d", m.reads)
+	}
+
+	// Journaling a write must not read the old value with Read.
+	copy(m.K64[0x0800:], []byte{0x8D, 0x10, 0xC0}) // STA $C010
+	c := cpu.NewCPU(&m, cc.Tick, cpu.VERSION_6502)
+	c.Reset()
+	c.SetPC(0x0800)
+	c.Journal(10)
+	if err := c.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if m.K64[0xC000] != 0xC1 {
+		t.Errorf("journaling STA $C010 triggered the strobe")
+	}
+}
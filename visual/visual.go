@@ -95,6 +95,35 @@ func (c *cpu) SetPC(uint16) {
 	panic("Not implemented")
 }
 
+func (c *cpu) SetA(byte) {
+	panic("Not implemented")
+}
+
+func (c *cpu) SetX(byte) {
+	panic("Not implemented")
+}
+
+func (c *cpu) SetY(byte) {
+	panic("Not implemented")
+}
+
+func (c *cpu) SetP(byte) {
+	panic("Not implemented")
+}
+
+func (c *cpu) SetSP(byte) {
+	panic("Not implemented")
+}
+
+// The IRQ and NMI pins are active low.
+func (c *cpu) SetIRQ(asserted bool) {
+	c.setNode(NODE_irq, !asserted)
+}
+
+func (c *cpu) SetNMI(asserted bool) {
+	c.setNode(NODE_nmi, !asserted)
+}
+
 /************************************/
 /* Interfacing and extracting state */
 /************************************/
@@ -542,6 +571,26 @@ func (c *cpu) Print(bool) {
 	panic("not implemented")
 }
 
+func (c *cpu) Backtrace() []icpu.Frame {
+	panic("not implemented")
+}
+
+func (c *cpu) Journal(int) {
+	panic("not implemented")
+}
+
+func (c *cpu) StepBack() error {
+	panic("not implemented")
+}
+
+func (c *cpu) ReverseContinue(func(uint16) bool) error {
+	panic("not implemented")
+}
+
+func (c *cpu) LastWrite(uint16) (icpu.Write, bool) {
+	panic("not implemented")
+}
+
 func (c *cpu) stabilizeChip() {
 	for i := uint(0); i < c.nodes; i++ {
 		c.listOutAdd(i)
@@ -0,0 +1,140 @@
+/*
+Package wav reads and writes WAV files holding uncompressed (PCM)
+audio: enough for cassette tapes and rendered sound.
+
+Audio is written as 16-bit mono. Reading also accepts 8-bit samples
+and more than one channel, which are mixed down to mono.
+*/
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Sound is mono audio, as 16-bit signed samples.
+type Sound struct {
+	Rate    int // samples per second
+	Samples []int16
+}
+
+// formatPCM is the WAVE_FORMAT_PCM format tag.
+const formatPCM = 1
+
+// fmtChunk is the size and contents of a PCM fmt chunk.
+type fmtChunk struct {
+	Size          uint32
+	Format        uint16
+	Channels      uint16
+	Rate          uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// Write writes a sound as a 16-bit mono WAV file.
+func Write(w io.Writer, s Sound) error {
+	n := 2 * len(s.Samples)
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+n))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, fmtChunk{
+		Size:          16,
+		Format:        formatPCM,
+		Channels:      1,
+		Rate:          uint32(s.Rate),
+		ByteRate:      uint32(2 * s.Rate),
+		BlockAlign:    2,
+		BitsPerSample: 16,
+	})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(n))
+	binary.Write(&buf, binary.LittleEndian, s.Samples)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Read reads a PCM WAV file of 8- or 16-bit samples, mixing multiple
+// channels down to mono.
+func Read(r io.Reader) (Sound, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Sound{}, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return Sound{}, errors.New("not a WAV file")
+	}
+	var channels, bits int
+	var s Sound
+	var samples []byte
+	found := false
+	for data = data[12:]; len(data) >= 8; {
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			// Some writers don't fill in the data chunk's size when
+			// streaming: take what there is.
+			size = len(data)
+		}
+		chunk := data[:size]
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return Sound{}, fmt.Errorf("WAV fmt chunk too short: %d bytes", size)
+			}
+			if format := binary.LittleEndian.Uint16(chunk[0:2]); format != formatPCM {
+				return Sound{}, fmt.Errorf("unsupported WAV format %d: want PCM", format)
+			}
+			channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			s.Rate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			found = true
+		case "data":
+			samples = chunk
+		}
+		// Chunks are padded to an even length.
+		size += size & 1
+		if size > len(data) {
+			break
+		}
+		data = data[size:]
+	}
+	if !found {
+		return Sound{}, errors.New("WAV file has no fmt chunk")
+	}
+	if channels < 1 {
+		return Sound{}, fmt.Errorf("WAV file has %d channels", channels)
+	}
+	if bits != 8 && bits != 16 {
+		return Sound{}, fmt.Errorf("unsupported WAV sample size: %d bits", bits)
+	}
+	frame := channels * bits / 8
+	s.Samples = make([]int16, len(samples)/frame)
+	for i := range s.Samples {
+		sum := 0
+		for c := 0; c < channels; c++ {
+			j := i*frame + c*bits/8
+			if bits == 8 {
+				sum += (int(samples[j]) - 0x80) << 8
+			} else {
+				sum += int(int16(binary.LittleEndian.Uint16(samples[j:])))
+			}
+		}
+		s.Samples[i] = int16(sum / channels)
+	}
+	return s, nil
+}
+
+// Duration returns the length of the sound, in seconds.
+func (s Sound) Duration() float64 {
+	if s.Rate == 0 {
+		return 0
+	}
+	return float64(len(s.Samples)) / float64(s.Rate)
+}
@@ -0,0 +1,55 @@
+package wav
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	s := Sound{Rate: 22050, Samples: []int16{0, 1, -1, 32767, -32768, 1000}}
+	var buf bytes.Buffer
+	if err := Write(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 44+2*len(s.Samples) {
+		t.Errorf("want %d bytes; got %d", 44+2*len(s.Samples), buf.Len())
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("want %v; got %v", s, got)
+	}
+}
+
+func TestRead8BitStereo(t *testing.T) {
+	// A hand-built file: 8-bit stereo, with an extra chunk before the
+	// data.
+	f := []byte("RIFF\x00\x00\x00\x00WAVE" +
+		"fmt \x10\x00\x00\x00\x01\x00\x02\x00\x40\x1f\x00\x00\x80\x3e\x00\x00\x02\x00\x08\x00" +
+		"LIST\x03\x00\x00\x00abc\x00" +
+		"data\x06\x00\x00\x00\x80\x80\xff\xff\x00\x80")
+	got, err := Read(bytes.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Sound{Rate: 8000, Samples: []int16{0, 0x7F00, -0x4000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, f := range []string{
+		"",
+		"RIFF\x00\x00\x00\x00AVI ",
+		"RIFF\x00\x00\x00\x00WAVEdata\x00\x00\x00\x00",
+		"RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00\x03\x00\x01\x00\x40\x1f\x00\x00\x00\x7d\x00\x00\x04\x00\x20\x00",
+	} {
+		if _, err := Read(bytes.NewReader([]byte(f))); err == nil {
+			t.Errorf("%q: want error", f)
+		}
+	}
+}